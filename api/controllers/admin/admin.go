@@ -0,0 +1,403 @@
+package admin
+
+import (
+	"context"
+	"encoding/hex"
+	"github.com/gocql/gocql"
+	"github.com/gorilla/mux"
+	"github.com/owen-d/beacon-api/api/controllers/audit"
+	"github.com/owen-d/beacon-api/lib/apierr"
+	auditlog "github.com/owen-d/beacon-api/lib/audit"
+	"github.com/owen-d/beacon-api/lib/auth/jwt"
+	"github.com/owen-d/beacon-api/lib/auth/tokens"
+	"github.com/owen-d/beacon-api/lib/cass"
+	"github.com/owen-d/beacon-api/lib/route"
+	"github.com/owen-d/beacon-api/lib/validator"
+	"github.com/urfave/negroni"
+	"net/http"
+)
+
+type AdminRoutes interface {
+	SearchUsers(http.ResponseWriter, *http.Request, http.HandlerFunc)
+	FetchUser(http.ResponseWriter, *http.Request, http.HandlerFunc)
+	Suspend(http.ResponseWriter, *http.Request, http.HandlerFunc)
+	Unsuspend(http.ResponseWriter, *http.Request, http.HandlerFunc)
+	Impersonate(http.ResponseWriter, *http.Request, http.HandlerFunc)
+	FetchAudit(http.ResponseWriter, *http.Request, http.HandlerFunc)
+	FetchBeaconOwner(http.ResponseWriter, *http.Request, http.HandlerFunc)
+	TransferBeacon(http.ResponseWriter, *http.Request, http.HandlerFunc)
+}
+
+// AdminMethods is operator tooling. Every route requires an admin, & every change is audited against the user or owner it affected.
+type AdminMethods struct {
+	Auth       jwt.Authenticator
+	CassClient cass.Client
+	Tokens     *tokens.Issuer
+	Audit      *auditlog.Recorder
+	// Events lists a user's audit events, admin actions included
+	Events *audit.AuditMethods
+}
+
+// UserDetail is everything support needs to identify a user
+type UserDetail struct {
+	User       *cass.User       `json:"user"`
+	Status     *cass.UserStatus `json:"status"`
+	Identities []*cass.Identity `json:"identities"`
+}
+
+// IncomingAction is the body of the suspension & impersonation routes. A reason is always required.
+type IncomingAction struct {
+	Reason string `json:"reason" validate:"required,max=500"`
+}
+
+// Validate fulfills the validator.JSONValidator interface
+func (self *IncomingAction) Validate(r *http.Request) *validator.RequestErr {
+	return validator.Decode(r, self)
+}
+
+// IncomingTransfer moves a beacon to the user or org UserId
+type IncomingTransfer struct {
+	Reason string      `json:"reason" validate:"required,max=500"`
+	UserId *gocql.UUID `json:"user_id"`
+}
+
+// Validate fulfills the validator.JSONValidator interface
+func (self *IncomingTransfer) Validate(r *http.Request) *validator.RequestErr {
+	return validator.Decode(r, self)
+}
+
+// reason is recorded as the after state of every admin event
+type reason struct {
+	Reason string `json:"reason"`
+}
+
+// RequireAdmin is middleware which must follow an Authenticator
+func (self *AdminMethods) RequireAdmin(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	bindings := r.Context().Value(jwt.JWTNamespace).(*jwt.Bindings)
+
+	if !bindings.Admin {
+		err := &validator.RequestErr{Status: http.StatusForbidden, Message: "admin required"}
+		err.Flush(rw)
+		return
+	}
+
+	next(rw, r)
+}
+
+// SearchUsers finds a user by ?email= or ?id=
+func (self *AdminMethods) SearchUsers(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	params := r.URL.Query()
+	query := &cass.User{Email: params.Get("email")}
+
+	if rawId := params.Get("id"); rawId != "" {
+		id, parseErr := gocql.ParseUUID(rawId)
+		if parseErr != nil {
+			err := &validator.RequestErr{Status: 400, Message: "invalid id"}
+			err.Flush(rw)
+			return
+		}
+		query.Id = &id
+	}
+
+	if query.Id == nil && query.Email == "" {
+		err := &validator.RequestErr{Status: 400, Message: "email or id required"}
+		err.Flush(rw)
+		return
+	}
+
+	self.writeDetail(r.Context(), rw, query)
+}
+
+func (self *AdminMethods) FetchUser(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	userId, ok := parseUserId(rw, r)
+	if !ok {
+		return
+	}
+
+	self.writeDetail(r.Context(), rw, &cass.User{Id: userId})
+}
+
+// Suspend blocks every request made by the user, whatever credentials they present
+func (self *AdminMethods) Suspend(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	bindings := r.Context().Value(jwt.JWTNamespace).(*jwt.Bindings)
+	incoming, userId, ok := self.action(rw, r)
+	if !ok {
+		return
+	}
+
+	if userId.String() == bindings.UserId.String() {
+		err := &validator.RequestErr{Status: http.StatusConflict, Message: "cannot suspend yourself"}
+		err.Flush(rw)
+		return
+	}
+
+	if res := self.CassClient.SuspendUser(r.Context(), userId, bindings.UserId, incoming.Reason); res.Err != nil {
+		err := apierr.From(res.Err)
+		err.Flush(rw)
+		return
+	}
+	self.Audit.RecordFor(r, userId, auditlog.AdminSuspend, userId.String(), nil, &reason{incoming.Reason})
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (self *AdminMethods) Unsuspend(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	incoming, userId, ok := self.action(rw, r)
+	if !ok {
+		return
+	}
+
+	if res := self.CassClient.UnsuspendUser(r.Context(), userId); res.Err != nil {
+		err := apierr.From(res.Err)
+		err.Flush(rw)
+		return
+	}
+	self.Audit.RecordFor(r, userId, auditlog.AdminUnsuspend, userId.String(), nil, &reason{incoming.Reason})
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// Impersonate issues a short lived, unrefreshable access token acting as the user. Admins may not be impersonated.
+func (self *AdminMethods) Impersonate(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	bindings := r.Context().Value(jwt.JWTNamespace).(*jwt.Bindings)
+	incoming, userId, ok := self.action(rw, r)
+	if !ok {
+		return
+	}
+
+	status, statusErr := self.CassClient.FetchUserStatus(r.Context(), userId)
+	if statusErr != nil {
+		err := apierr.From(statusErr)
+		err.Flush(rw)
+		return
+	}
+	if status.Admin {
+		err := &validator.RequestErr{Status: http.StatusForbidden, Message: "admins may not be impersonated"}
+		err.Flush(rw)
+		return
+	}
+
+	pair, issueErr := self.Tokens.Impersonate(userId, bindings.UserId)
+	if issueErr != nil {
+		err := apierr.From(issueErr)
+		err.Flush(rw)
+		return
+	}
+	self.Audit.RecordFor(r, userId, auditlog.AdminImpersonate, userId.String(), nil, &reason{incoming.Reason})
+
+	writeJSON(rw, http.StatusCreated, pair)
+}
+
+// FetchAudit lists a user's audit events, including the admin actions taken against them. It accepts the filters of /v1/audit.
+func (self *AdminMethods) FetchAudit(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	userId, ok := parseUserId(rw, r)
+	if !ok {
+		return
+	}
+
+	rw.Header().Set("Cache-Control", "no-store")
+	self.Events.WriteEvents(rw, r, userId)
+}
+
+// FetchBeaconOwner finds which user or org owns a beacon, by its hex encoded name
+func (self *AdminMethods) FetchBeaconOwner(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	bkn, ok := self.fetchBeacon(rw, r)
+	if !ok {
+		return
+	}
+
+	writeJSON(rw, http.StatusOK, bkn)
+}
+
+// TransferBeacon moves an undeployed beacon to another owner
+func (self *AdminMethods) TransferBeacon(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	incoming := &IncomingTransfer{}
+	if err := incoming.Validate(r); err != nil {
+		err.Flush(rw)
+		return
+	}
+	if incoming.UserId == nil {
+		err := &validator.RequestErr{Status: 400, Message: "user_id required"}
+		err.Flush(rw)
+		return
+	}
+
+	bkn, ok := self.fetchBeacon(rw, r)
+	if !ok {
+		return
+	}
+
+	res := self.CassClient.TransferBeacons(r.Context(), []*cass.Beacon{bkn}, incoming.UserId)
+	if res.Err == cass.ErrNotOwned {
+		err := &validator.RequestErr{Status: http.StatusNotFound, Message: "beacon not found"}
+		err.Flush(rw)
+		return
+	}
+	if res.Err != nil {
+		apierr.From(res.Err).Flush(rw)
+		return
+	}
+
+	// recorded against both owners, so either's audit shows the beacon's history
+	target := hex.EncodeToString(bkn.Name)
+	for _, owner := range []*gocql.UUID{bkn.UserId, incoming.UserId} {
+		self.Audit.RecordFor(r, owner, auditlog.AdminTransfer, target, &transfer{UserId: bkn.UserId}, &transfer{UserId: incoming.UserId, Reason: incoming.Reason})
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// transfer summarizes a beacon's owner before & after an admin transfer
+type transfer struct {
+	UserId *gocql.UUID `json:"user_id"`
+	Reason string      `json:"reason,omitempty"`
+}
+
+func (self *AdminMethods) writeDetail(ctx context.Context, rw http.ResponseWriter, query *cass.User) {
+	user, fetchErr := self.CassClient.FetchUser(ctx, query)
+	if fetchErr == gocql.ErrNotFound {
+		err := &validator.RequestErr{Status: http.StatusNotFound, Message: "user not found"}
+		err.Flush(rw)
+		return
+	}
+	if fetchErr != nil {
+		err := apierr.From(fetchErr)
+		err.Flush(rw)
+		return
+	}
+
+	status, statusErr := self.CassClient.FetchUserStatus(ctx, user.Id)
+	if statusErr != nil {
+		err := apierr.From(statusErr)
+		err.Flush(rw)
+		return
+	}
+
+	idents, identsErr := self.CassClient.FetchUserIdentities(ctx, user.Id)
+	if identsErr != nil {
+		err := apierr.From(identsErr)
+		err.Flush(rw)
+		return
+	}
+
+	writeJSON(rw, http.StatusOK, &UserDetail{User: user, Status: status, Identities: idents})
+}
+
+// action parses the body & target of a user action
+func (self *AdminMethods) action(rw http.ResponseWriter, r *http.Request) (*IncomingAction, *gocql.UUID, bool) {
+	incoming := &IncomingAction{}
+	if err := incoming.Validate(r); err != nil {
+		err.Flush(rw)
+		return nil, nil, false
+	}
+
+	userId, ok := parseUserId(rw, r)
+	return incoming, userId, ok
+}
+
+func (self *AdminMethods) fetchBeacon(rw http.ResponseWriter, r *http.Request) (*cass.Beacon, bool) {
+	name, decodeErr := hex.DecodeString(mux.Vars(r)["name"])
+	if decodeErr != nil {
+		err := &validator.RequestErr{Status: http.StatusNotFound, Message: "beacon not found"}
+		err.Flush(rw)
+		return nil, false
+	}
+
+	bkn, fetchErr := self.CassClient.FetchBeaconOwner(r.Context(), name)
+	if fetchErr == gocql.ErrNotFound {
+		err := &validator.RequestErr{Status: http.StatusNotFound, Message: "beacon not found"}
+		err.Flush(rw)
+		return nil, false
+	}
+	if fetchErr != nil {
+		err := apierr.From(fetchErr)
+		err.Flush(rw)
+		return nil, false
+	}
+
+	return bkn, true
+}
+
+func parseUserId(rw http.ResponseWriter, r *http.Request) (*gocql.UUID, bool) {
+	userId, parseErr := gocql.ParseUUID(mux.Vars(r)["id"])
+	if parseErr != nil {
+		err := &validator.RequestErr{Status: http.StatusNotFound, Message: "user not found"}
+		err.Flush(rw)
+		return nil, false
+	}
+	return &userId, true
+}
+
+// writeJSON keeps admin responses out of caches
+func writeJSON(rw http.ResponseWriter, status int, v interface{}) {
+	rw.Header().Set("Cache-Control", "no-store")
+	apierr.WriteJSON(rw, status, v)
+}
+
+func (self *AdminMethods) Router() *route.Router {
+	endpoints := []*route.Endpoint{
+		&route.Endpoint{
+			Method:   http.MethodGet,
+			Handlers: []negroni.Handler{negroni.HandlerFunc(self.SearchUsers)},
+			Response: &UserDetail{},
+			SubPath:  "/users",
+		},
+		&route.Endpoint{
+			Method:   http.MethodGet,
+			Handlers: []negroni.Handler{negroni.HandlerFunc(self.FetchUser)},
+			Response: &UserDetail{},
+			SubPath:  "/users/{id}",
+		},
+		&route.Endpoint{
+			Method:   http.MethodPost,
+			Handlers: []negroni.Handler{negroni.HandlerFunc(self.Suspend)},
+			Request:  &IncomingAction{},
+			Response: route.NoContent,
+			SubPath:  "/users/{id}/suspension",
+		},
+		&route.Endpoint{
+			Method:   http.MethodDelete,
+			Handlers: []negroni.Handler{negroni.HandlerFunc(self.Unsuspend)},
+			Request:  &IncomingAction{},
+			Response: route.NoContent,
+			SubPath:  "/users/{id}/suspension",
+		},
+		&route.Endpoint{
+			Method:    http.MethodPost,
+			Handlers:  []negroni.Handler{negroni.HandlerFunc(self.Impersonate)},
+			Request:   &IncomingAction{},
+			Response:  &tokens.Pair{},
+			Sensitive: true,
+			Status:    http.StatusCreated,
+			SubPath:   "/users/{id}/impersonate",
+		},
+		&route.Endpoint{
+			Method:   http.MethodGet,
+			Handlers: []negroni.Handler{negroni.HandlerFunc(self.FetchAudit)},
+			Response: &audit.EventsResponse{},
+			SubPath:  "/users/{id}/audit",
+		},
+		&route.Endpoint{
+			Method:   http.MethodGet,
+			Handlers: []negroni.Handler{negroni.HandlerFunc(self.FetchBeaconOwner)},
+			Response: &cass.Beacon{},
+			SubPath:  "/beacons/{name}",
+		},
+		&route.Endpoint{
+			Method:   http.MethodPost,
+			Handlers: []negroni.Handler{negroni.HandlerFunc(self.TransferBeacon)},
+			Request:  &IncomingTransfer{},
+			Response: route.NoContent,
+			SubPath:  "/beacons/{name}/transfer",
+		},
+	}
+
+	r := route.Router{
+		Path:              "/admin",
+		DefaultMiddleware: []negroni.Handler{negroni.HandlerFunc(self.Auth.Validate), negroni.HandlerFunc(self.RequireAdmin)},
+		Endpoints:         endpoints,
+		Name:              "adminRouter",
+	}
+
+	return &r
+}
@@ -0,0 +1,165 @@
+package apikeys
+
+import (
+	"encoding/json"
+	"github.com/gocql/gocql"
+	"github.com/gorilla/mux"
+	"github.com/owen-d/beacon-api/lib/apierr"
+	"github.com/owen-d/beacon-api/lib/audit"
+	"github.com/owen-d/beacon-api/lib/auth/apikey"
+	"github.com/owen-d/beacon-api/lib/auth/jwt"
+	"github.com/owen-d/beacon-api/lib/cass"
+	"github.com/owen-d/beacon-api/lib/route"
+	"github.com/owen-d/beacon-api/lib/validator"
+	"github.com/urfave/negroni"
+	"net/http"
+	"time"
+)
+
+type APIKeyRoutes interface {
+	FetchAPIKeys(http.ResponseWriter, *http.Request, http.HandlerFunc)
+	CreateAPIKey(http.ResponseWriter, *http.Request, http.HandlerFunc)
+	RevokeAPIKey(http.ResponseWriter, *http.Request, http.HandlerFunc)
+}
+
+type APIKeyMethods struct {
+	// keys may only be managed by a signed in user, never by another key
+	Auth       jwt.Authenticator
+	CassClient cass.Client
+	Audit      *audit.Recorder
+}
+
+type APIKeysResponse struct {
+	APIKeys []*cass.APIKey `json:"api_keys"`
+}
+
+// CreatedAPIKey is the only time a key's plaintext is returned
+type CreatedAPIKey struct {
+	*cass.APIKey
+	Key string `json:"key"`
+}
+
+type IncomingAPIKey struct {
+	Name     string `json:"name" validate:"required,max=100"`
+	ReadOnly bool   `json:"read_only"`
+}
+
+// Validate fulfills the validator.JSONValidator interface
+func (self *IncomingAPIKey) Validate(r *http.Request) *validator.RequestErr {
+	return validator.Decode(r, self)
+}
+
+// FetchAPIKeys lists a user's keys, without their secrets
+func (self *APIKeyMethods) FetchAPIKeys(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	bindings := r.Context().Value(jwt.JWTNamespace).(*jwt.Bindings)
+
+	keys, fetchErr := self.CassClient.FetchAPIKeys(r.Context(), bindings.UserId)
+	if fetchErr != nil {
+		err := apierr.From(fetchErr)
+		err.Flush(rw)
+		return
+	}
+
+	data, _ := json.Marshal(&APIKeysResponse{APIKeys: keys})
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(data)
+}
+
+// CreateAPIKey generates a named key, returning its plaintext once
+func (self *APIKeyMethods) CreateAPIKey(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	incoming := &IncomingAPIKey{}
+	if err := incoming.Validate(r); err != nil {
+		err.Flush(rw)
+		return
+	}
+
+	bindings := r.Context().Value(jwt.JWTNamespace).(*jwt.Bindings)
+
+	plaintext, hash, genErr := apikey.Generate()
+	if genErr != nil {
+		err := apierr.From(genErr)
+		err.Flush(rw)
+		return
+	}
+
+	key := &cass.APIKey{
+		Hash:      hash,
+		UserId:    bindings.UserId,
+		Name:      incoming.Name,
+		ReadOnly:  incoming.ReadOnly,
+		CreatedAt: time.Now(),
+	}
+
+	// the name is reserved conditionally, so concurrent requests can't both create it
+	if res := self.CassClient.CreateAPIKey(r.Context(), key); res.Err == cass.ErrConflict {
+		err := &validator.RequestErr{Status: http.StatusConflict, Message: "api key already exists: " + incoming.Name}
+		err.Flush(rw)
+		return
+	} else if res.Err != nil {
+		err := apierr.From(res.Err)
+		err.Flush(rw)
+		return
+	}
+
+	self.Audit.Record(r, audit.APIKeyCreate, key.Name, nil, key)
+
+	data, _ := json.Marshal(&CreatedAPIKey{APIKey: key, Key: plaintext})
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusCreated)
+	rw.Write(data)
+}
+
+// RevokeAPIKey deletes a key by name
+func (self *APIKeyMethods) RevokeAPIKey(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	bindings := r.Context().Value(jwt.JWTNamespace).(*jwt.Bindings)
+	name := mux.Vars(r)["name"]
+
+	res := self.CassClient.RevokeAPIKey(r.Context(), bindings.UserId, name)
+	if res.Err == gocql.ErrNotFound {
+		err := &validator.RequestErr{Status: http.StatusNotFound, Message: "no such api key: " + name}
+		err.Flush(rw)
+		return
+	}
+	if res.Err != nil {
+		err := apierr.From(res.Err)
+		err.Flush(rw)
+		return
+	}
+
+	self.Audit.Record(r, audit.APIKeyRevoke, name, nil, nil)
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (self *APIKeyMethods) Router() *route.Router {
+	endpoints := []*route.Endpoint{
+		&route.Endpoint{
+			Method:   http.MethodGet,
+			Handlers: []negroni.Handler{negroni.HandlerFunc(self.FetchAPIKeys)},
+			Response: &APIKeysResponse{},
+		},
+		&route.Endpoint{
+			Method:    http.MethodPost,
+			Handlers:  []negroni.Handler{negroni.HandlerFunc(jwt.NoImpersonation), negroni.HandlerFunc(self.CreateAPIKey)},
+			Request:   &IncomingAPIKey{},
+			Response:  &CreatedAPIKey{},
+			Sensitive: true,
+			Status:    http.StatusCreated,
+		},
+		&route.Endpoint{
+			Method:   http.MethodDelete,
+			Handlers: []negroni.Handler{negroni.HandlerFunc(self.RevokeAPIKey)},
+			Response: route.NoContent,
+			SubPath:  "/{name}",
+		},
+	}
+
+	r := route.Router{
+		Path:              "/apikeys",
+		Endpoints:         endpoints,
+		DefaultMiddleware: []negroni.Handler{negroni.HandlerFunc(self.Auth.Validate)},
+		Name:              "apiKeysRouter",
+	}
+
+	return &r
+}
@@ -0,0 +1,204 @@
+package audit
+
+import (
+	"context"
+	"github.com/gocql/gocql"
+	"github.com/owen-d/beacon-api/lib/apierr"
+	"github.com/owen-d/beacon-api/lib/auth/jwt"
+	"github.com/owen-d/beacon-api/lib/cass"
+	"github.com/owen-d/beacon-api/lib/route"
+	"github.com/owen-d/beacon-api/lib/validator"
+	"github.com/urfave/negroni"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultWindow = time.Hour * 24 * 7
+	// each day is a partition, so the window bounds the number of queries
+	maxWindow    = time.Hour * 24 * 31
+	defaultLimit = 100
+)
+
+type AuditRoutes interface {
+	FetchEvents(http.ResponseWriter, *http.Request, http.HandlerFunc)
+}
+
+type AuditMethods struct {
+	Auth       jwt.Authenticator
+	CassClient cass.Client
+}
+
+type EventsResponse struct {
+	Events []*cass.AuditEvent `json:"events"`
+	// NextPageToken is sent as page_token, along with the same filter, to list older events. It's omitted on the last page.
+	NextPageToken string `json:"next_page_token,omitempty"`
+}
+
+// Filter narrows the events listed. Zero values match everything.
+type Filter struct {
+	Since   time.Time
+	Until   time.Time
+	Action  string
+	Target  string
+	ActorId *gocql.UUID
+	Limit   int
+	// After resumes listing after this event, from a previous page
+	After *gocql.UUID
+}
+
+// ParseFilter reads the since, until (RFC3339), action, target, actor_id, limit & page_token query params
+func ParseFilter(r *http.Request, now time.Time) (*Filter, *validator.RequestErr) {
+	params := r.URL.Query()
+	filter := &Filter{
+		Until:  now,
+		Action: params.Get("action"),
+		Target: params.Get("target"),
+		Limit:  defaultLimit,
+	}
+
+	if rawUntil := params.Get("until"); rawUntil != "" {
+		parsed, parseErr := time.Parse(time.RFC3339, rawUntil)
+		if parseErr != nil {
+			return nil, &validator.RequestErr{Status: 400, Message: "until must be an RFC3339 time"}
+		}
+		filter.Until = parsed
+	}
+
+	filter.Since = filter.Until.Add(-defaultWindow)
+	if rawSince := params.Get("since"); rawSince != "" {
+		parsed, parseErr := time.Parse(time.RFC3339, rawSince)
+		if parseErr != nil || parsed.After(filter.Until) || filter.Until.Sub(parsed) > maxWindow {
+			return nil, &validator.RequestErr{Status: 400, Message: "since must be an RFC3339 time within 31 days before until"}
+		}
+		filter.Since = parsed
+	}
+
+	if rawActor := params.Get("actor_id"); rawActor != "" {
+		actorId, parseErr := gocql.ParseUUID(rawActor)
+		if parseErr != nil {
+			return nil, &validator.RequestErr{Status: 400, Message: "invalid actor_id"}
+		}
+		filter.ActorId = &actorId
+	}
+
+	if rawLimit := params.Get("limit"); rawLimit != "" {
+		limit, parseErr := strconv.Atoi(rawLimit)
+		if parseErr != nil || limit < 1 || limit > cass.DefaultLimit {
+			return nil, &validator.RequestErr{Status: 400, Message: "limit must be between 1 & 250"}
+		}
+		filter.Limit = limit
+	}
+
+	if rawToken := params.Get("page_token"); rawToken != "" {
+		after, parseErr := gocql.ParseUUID(rawToken)
+		if parseErr != nil || after.Version() != 1 {
+			return nil, &validator.RequestErr{Status: 400, Message: "invalid page_token"}
+		}
+		filter.After = &after
+	}
+
+	return filter, nil
+}
+
+// Matches reports whether an event passes the filter
+func (self *Filter) Matches(e *cass.AuditEvent) bool {
+	if e.At.Before(self.Since) || e.At.After(self.Until) {
+		return false
+	}
+	if self.Action != "" && e.Action != self.Action {
+		return false
+	}
+	if self.Target != "" && e.Target != self.Target {
+		return false
+	}
+	if self.ActorId != nil && (e.ActorId == nil || e.ActorId.String() != self.ActorId.String()) {
+		return false
+	}
+	return true
+}
+
+// FetchEvents lists the owner's audit events, newest first
+func (self *AuditMethods) FetchEvents(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	bindings := r.Context().Value(jwt.JWTNamespace).(*jwt.Bindings)
+	self.WriteEvents(rw, r, bindings.OwnerId)
+}
+
+// WriteEvents lists the audit events of any owner, newest first, filtered by the request's query params. Callers must authorize the owner.
+func (self *AuditMethods) WriteEvents(rw http.ResponseWriter, r *http.Request, ownerId *gocql.UUID) {
+	filter, invalid := ParseFilter(r, time.Now())
+	if invalid != nil {
+		invalid.Flush(rw)
+		return
+	}
+
+	events, nextPage, fetchErr := self.listEvents(r.Context(), ownerId, filter)
+	if fetchErr != nil {
+		err := apierr.From(fetchErr)
+		err.Flush(rw)
+		return
+	}
+
+	res := EventsResponse{Events: events}
+	if nextPage != nil {
+		res.NextPageToken = nextPage.String()
+	}
+	apierr.WriteJSON(rw, http.StatusOK, res)
+}
+
+// listEvents walks partitions from the newest day back, a page at a time, until the filter's limit is reached.
+// It returns the last event listed when more may follow.
+func (self *AuditMethods) listEvents(ctx context.Context, ownerId *gocql.UUID, filter *Filter) ([]*cass.AuditEvent, *gocql.UUID, error) {
+	events := make([]*cass.AuditEvent, 0)
+
+	day, after := cass.AuditDay(filter.Until), filter.After
+	if after != nil {
+		day = cass.AuditDay(after.Time())
+	}
+
+	for ; !day.Before(cass.AuditDay(filter.Since)); day, after = day.Add(-time.Hour*24), nil {
+		for {
+			page, fetchErr := self.CassClient.FetchEvents(ctx, ownerId, day, after, cass.DefaultLimit)
+			if fetchErr != nil {
+				return nil, nil, fetchErr
+			}
+
+			for _, e := range page {
+				if !filter.Matches(e) {
+					continue
+				}
+				events = append(events, e)
+				if len(events) == filter.Limit {
+					return events, e.Id, nil
+				}
+			}
+
+			if len(page) < cass.DefaultLimit {
+				break
+			}
+			after = page[len(page)-1].Id
+		}
+	}
+
+	return events, nil, nil
+}
+
+func (self *AuditMethods) Router() *route.Router {
+	endpoints := []*route.Endpoint{
+		&route.Endpoint{
+			Method:   http.MethodGet,
+			Handlers: []negroni.Handler{negroni.HandlerFunc(self.FetchEvents)},
+			Response: &EventsResponse{},
+		},
+	}
+
+	r := route.Router{
+		Path:              "/audit",
+		Endpoints:         endpoints,
+		DefaultMiddleware: []negroni.Handler{negroni.HandlerFunc(self.Auth.Validate)},
+		Name:              "auditRouter",
+	}
+
+	return &r
+}
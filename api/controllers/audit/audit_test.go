@@ -0,0 +1,117 @@
+package audit
+
+import (
+	"context"
+	"github.com/gocql/gocql"
+	"github.com/owen-d/beacon-api/lib/cass"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseFilter(t *testing.T) {
+	now := time.Date(2018, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	filter, err := ParseFilter(httptest.NewRequest("GET", "/v1/audit", nil), now)
+	if err != nil || !filter.Until.Equal(now) || !filter.Since.Equal(now.Add(-defaultWindow)) || filter.Limit != defaultLimit {
+		t.Error("unexpected default filter:", filter, err)
+	}
+
+	invalid := []string{
+		"since=yesterday",
+		"since=2018-03-11T00:00:00Z",
+		"since=2018-01-01T00:00:00Z",
+		"actor_id=me",
+		"limit=0",
+		"limit=1000",
+		"page_token=latest",
+	}
+	for _, query := range invalid {
+		if _, err := ParseFilter(httptest.NewRequest("GET", "/v1/audit?"+query, nil), now); err == nil {
+			t.Error("expected invalid filter:", query)
+		}
+	}
+}
+
+func TestFilterMatches(t *testing.T) {
+	now := time.Now()
+	actor, other := gocql.TimeUUID(), gocql.TimeUUID()
+	e := &cass.AuditEvent{ActorId: &actor, Action: "message.update", Target: "welcome", At: now}
+
+	cases := []struct {
+		filter   *Filter
+		expected bool
+	}{
+		{&Filter{Since: now.Add(-time.Hour), Until: now.Add(time.Hour)}, true},
+		{&Filter{Since: now.Add(time.Minute), Until: now.Add(time.Hour)}, false},
+		{&Filter{Since: now.Add(-time.Hour), Until: now.Add(time.Hour), Action: "message.update", Target: "welcome", ActorId: &actor}, true},
+		{&Filter{Since: now.Add(-time.Hour), Until: now.Add(time.Hour), Action: "message.create"}, false},
+		{&Filter{Since: now.Add(-time.Hour), Until: now.Add(time.Hour), Target: "other"}, false},
+		{&Filter{Since: now.Add(-time.Hour), Until: now.Add(time.Hour), ActorId: &other}, false},
+	}
+
+	for i, c := range cases {
+		if res := c.filter.Matches(e); res != c.expected {
+			t.Error("case", i, "expected", c.expected)
+		}
+	}
+}
+
+// events serves a day's events from memory, newest first, in place of cassandra
+type events struct {
+	cass.Client
+	byDay map[time.Time][]*cass.AuditEvent
+}
+
+func (self *events) FetchEvents(ctx context.Context, ownerId *gocql.UUID, day time.Time, before *gocql.UUID, limit int) ([]*cass.AuditEvent, error) {
+	res := make([]*cass.AuditEvent, 0)
+	for _, e := range self.byDay[cass.AuditDay(day)] {
+		if before != nil && !e.At.Before(before.Time()) {
+			continue
+		}
+		if len(res) < limit {
+			res = append(res, e)
+		}
+	}
+	return res, nil
+}
+
+func TestListEventsPaging(t *testing.T) {
+	now := time.Date(2018, 3, 10, 12, 0, 0, 0, time.UTC)
+	store := &events{byDay: map[time.Time][]*cass.AuditEvent{}}
+	// more events on one day than a single query returns, spread over two days
+	for i := 0; i < cass.DefaultLimit+50; i++ {
+		at := now.Add(-time.Duration(i) * time.Minute * 10)
+		id := gocql.UUIDFromTime(at)
+		day := cass.AuditDay(at)
+		store.byDay[day] = append(store.byDay[day], &cass.AuditEvent{Id: &id, Action: "message.update", At: id.Time()})
+	}
+
+	methods := &AuditMethods{CassClient: store}
+	filter := &Filter{Since: now.Add(-defaultWindow), Until: now, Limit: 100}
+
+	seen := map[string]bool{}
+	for pages := 0; ; pages++ {
+		page, next, err := methods.listEvents(context.Background(), nil, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range page {
+			if seen[e.Id.String()] {
+				t.Fatal("event listed twice:", e.Id)
+			}
+			seen[e.Id.String()] = true
+		}
+		if next == nil {
+			break
+		}
+		if pages > 10 {
+			t.Fatal("paging did not terminate")
+		}
+		filter.After = next
+	}
+
+	if len(seen) != cass.DefaultLimit+50 {
+		t.Errorf("expected every event to be listed once, got %d", len(seen))
+	}
+}
@@ -0,0 +1,282 @@
+package auth
+
+import (
+	"encoding/json"
+	"github.com/gocql/gocql"
+	"github.com/gorilla/mux"
+	"github.com/owen-d/beacon-api/lib/apierr"
+	"github.com/owen-d/beacon-api/lib/audit"
+	"github.com/owen-d/beacon-api/lib/auth/jwt"
+	"github.com/owen-d/beacon-api/lib/auth/session"
+	"github.com/owen-d/beacon-api/lib/auth/tokens"
+	"github.com/owen-d/beacon-api/lib/cass"
+	"github.com/owen-d/beacon-api/lib/route"
+	"github.com/owen-d/beacon-api/lib/validator"
+	"github.com/urfave/negroni"
+	"net/http"
+	"strconv"
+)
+
+type AuthRoutes interface {
+	Refresh(http.ResponseWriter, *http.Request, http.HandlerFunc)
+	Logout(http.ResponseWriter, *http.Request, http.HandlerFunc)
+	StartSession(http.ResponseWriter, *http.Request, http.HandlerFunc)
+	FetchSession(http.ResponseWriter, *http.Request, http.HandlerFunc)
+	FetchIdentities(http.ResponseWriter, *http.Request, http.HandlerFunc)
+	UnlinkIdentity(http.ResponseWriter, *http.Request, http.HandlerFunc)
+}
+
+type AuthMethods struct {
+	JWTDecoder jwt.Decoder
+	// Auth accepts session cookies in addition to jwts
+	Auth       jwt.Authenticator
+	Tokens     *tokens.Issuer
+	CassClient cass.Client
+	// Sessions is optional; session endpoints are only routed when it is set
+	Sessions *session.Manager
+	Audit    *audit.Recorder
+}
+
+type SessionResponse struct {
+	CSRFToken string `json:"csrf_token"`
+}
+
+type IdentitiesResponse struct {
+	Identities []*cass.Identity `json:"identities"`
+}
+
+type IncomingRefresh struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Validate fulfills the validator.JSONValidator interface
+func (self *IncomingRefresh) Validate(r *http.Request) *validator.RequestErr {
+	return validator.Decode(r, self)
+}
+
+// Refresh exchanges a refresh token for a new access & refresh token
+func (self *AuthMethods) Refresh(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	incoming := &IncomingRefresh{}
+	if err := incoming.Validate(r); err != nil {
+		err.Flush(rw)
+		return
+	}
+
+	if incoming.RefreshToken == "" {
+		err := &validator.RequestErr{Status: 400, Message: "refresh_token required"}
+		err.Flush(rw)
+		return
+	}
+
+	pair, refreshErr := self.Tokens.Refresh(r.Context(), incoming.RefreshToken)
+	if refreshErr == tokens.ErrInvalidRefreshToken {
+		err := &validator.RequestErr{Status: http.StatusUnauthorized, Message: refreshErr.Error()}
+		err.Flush(rw)
+		return
+	}
+	if refreshErr != nil {
+		err := apierr.From(refreshErr)
+		err.Flush(rw)
+		return
+	}
+
+	data, _ := json.Marshal(pair)
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(data)
+}
+
+// Logout ends the request's session, or revokes the presented access token & optionally the refresh token in the body
+func (self *AuthMethods) Logout(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	bindings := r.Context().Value(jwt.JWTNamespace).(*jwt.Bindings)
+
+	if bindings.Session != nil {
+		if endErr := self.Sessions.End(rw, r); endErr != nil {
+			err := apierr.From(endErr)
+			err.Flush(rw)
+			return
+		}
+		self.Audit.Record(r, audit.Logout, "session", nil, nil)
+		rw.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	incoming := &IncomingRefresh{}
+	if err := incoming.Validate(r); err != nil && r.ContentLength != 0 {
+		err.Flush(rw)
+		return
+	}
+
+	if revokeErr := self.Tokens.Revoke(r.Context(), bindings, incoming.RefreshToken); revokeErr != nil {
+		err := apierr.From(revokeErr)
+		err.Flush(rw)
+		return
+	}
+	self.Audit.Record(r, audit.Logout, "token", nil, nil)
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// StartSession trades a jwt for a session cookie, for web apps which would rather not hold tokens in javascript
+func (self *AuthMethods) StartSession(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	bindings := r.Context().Value(jwt.JWTNamespace).(*jwt.Bindings)
+
+	csrf, startErr := self.Sessions.Start(r.Context(), rw, bindings.UserId)
+	if startErr != nil {
+		err := apierr.From(startErr)
+		err.Flush(rw)
+		return
+	}
+
+	self.Audit.Record(r, audit.SessionStart, "session", nil, nil)
+
+	data, _ := json.Marshal(&SessionResponse{csrf})
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusCreated)
+	rw.Write(data)
+}
+
+// FetchSession returns the session's csrf token, i.e. for a frontend which cannot read the csrf cookie after a reload
+func (self *AuthMethods) FetchSession(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	s, fetchErr := self.Sessions.Fetch(r)
+	if fetchErr != nil {
+		err := apierr.From(fetchErr)
+		err.Flush(rw)
+		return
+	}
+	if s == nil {
+		err := &validator.RequestErr{Status: http.StatusUnauthorized, Message: "no session"}
+		err.Flush(rw)
+		return
+	}
+
+	data, _ := json.Marshal(&SessionResponse{s.CSRFToken})
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(data)
+}
+
+// FetchIdentities lists the providers the user may sign in with
+func (self *AuthMethods) FetchIdentities(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	bindings := r.Context().Value(jwt.JWTNamespace).(*jwt.Bindings)
+
+	idents, fetchErr := self.CassClient.FetchUserIdentities(r.Context(), bindings.UserId)
+	if fetchErr != nil {
+		err := apierr.From(fetchErr)
+		err.Flush(rw)
+		return
+	}
+
+	data, _ := json.Marshal(&IdentitiesResponse{idents})
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(data)
+}
+
+// UnlinkIdentity removes one of the user's identities, so long as another remains to sign in with
+func (self *AuthMethods) UnlinkIdentity(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	bindings := r.Context().Value(jwt.JWTNamespace).(*jwt.Bindings)
+	reqVars := mux.Vars(r)
+
+	providerId, parseErr := strconv.ParseUint(reqVars["provider_id"], 10, 8)
+	if parseErr != nil {
+		err := &validator.RequestErr{Status: http.StatusNotFound, Message: "identity not found"}
+		err.Flush(rw)
+		return
+	}
+
+	idents, fetchErr := self.CassClient.FetchUserIdentities(r.Context(), bindings.UserId)
+	if fetchErr != nil {
+		err := apierr.From(fetchErr)
+		err.Flush(rw)
+		return
+	}
+
+	// the count is checked again as the identity is unlinked, so concurrent requests can't remove every identity
+	res := self.CassClient.UnlinkIdentity(r.Context(), &cass.Identity{
+		ProviderId: cass.ProviderId(providerId),
+		Subject:    reqVars["subject"],
+		UserId:     bindings.UserId,
+	}, len(idents))
+	if res.Err == cass.ErrConflict {
+		err := &validator.RequestErr{Status: http.StatusConflict, Message: "cannot unlink the only identity"}
+		err.Flush(rw)
+		return
+	}
+	if res.Err == gocql.ErrNotFound {
+		err := &validator.RequestErr{Status: http.StatusNotFound, Message: "identity not found"}
+		err.Flush(rw)
+		return
+	}
+	if res.Err != nil {
+		err := apierr.From(res.Err)
+		err.Flush(rw)
+		return
+	}
+
+	self.Audit.Record(r, audit.UnlinkIdentity, reqVars["provider_id"]+"/"+reqVars["subject"], nil, nil)
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (self *AuthMethods) Router() *route.Router {
+	endpoints := []*route.Endpoint{
+		&route.Endpoint{
+			Method:    http.MethodPost,
+			Handlers:  []negroni.Handler{negroni.HandlerFunc(self.Refresh)},
+			Request:   &IncomingRefresh{},
+			Response:  &tokens.Pair{},
+			Sensitive: true,
+			SubPath:   "/refresh",
+		},
+		&route.Endpoint{
+			Method: http.MethodPost,
+			// refreshing happens once the access token has expired, so only logout requires one
+			Handlers: []negroni.Handler{negroni.HandlerFunc(self.Auth.Validate), negroni.HandlerFunc(self.Logout)},
+			Request:  &IncomingRefresh{},
+			Response: route.NoContent,
+			SubPath:  "/logout",
+		},
+		&route.Endpoint{
+			Method:   http.MethodGet,
+			Handlers: []negroni.Handler{negroni.HandlerFunc(self.Auth.Validate), negroni.HandlerFunc(self.FetchIdentities)},
+			Response: &IdentitiesResponse{},
+			SubPath:  "/identities",
+		},
+		&route.Endpoint{
+			Method:   http.MethodDelete,
+			Handlers: []negroni.Handler{negroni.HandlerFunc(self.Auth.Validate), negroni.HandlerFunc(self.UnlinkIdentity)},
+			Response: route.NoContent,
+			SubPath:  "/identities/{provider_id}/{subject}",
+		},
+	}
+
+	if self.Sessions != nil {
+		endpoints = append(endpoints,
+			&route.Endpoint{
+				Method: http.MethodPost,
+				// sessions are only started from a jwt, never from another session
+				Handlers:  []negroni.Handler{negroni.HandlerFunc(self.JWTDecoder.Validate), negroni.HandlerFunc(jwt.NoImpersonation), negroni.HandlerFunc(self.StartSession)},
+				Request:   route.NoBody,
+				Response:  &SessionResponse{},
+				Sensitive: true,
+				Status:    http.StatusCreated,
+				SubPath:   "/session",
+			},
+			&route.Endpoint{
+				Method:   http.MethodGet,
+				Handlers: []negroni.Handler{negroni.HandlerFunc(self.FetchSession)},
+				Response: &SessionResponse{},
+				SubPath:  "/session",
+			},
+		)
+	}
+
+	r := route.Router{
+		Path:      "/auth",
+		Endpoints: endpoints,
+		Name:      "authRouter",
+	}
+
+	return &r
+}
@@ -1,15 +1,20 @@
 package beacons
 
 import (
+	"context"
+	"encoding/hex"
 	"encoding/json"
 	"github.com/gocql/gocql"
+	"github.com/owen-d/beacon-api/lib/apierr"
+	"github.com/owen-d/beacon-api/lib/audit"
 	"github.com/owen-d/beacon-api/lib/auth/jwt"
+	"github.com/owen-d/beacon-api/lib/auth/orgs"
 	"github.com/owen-d/beacon-api/lib/beaconclient"
 	"github.com/owen-d/beacon-api/lib/cass"
+	"github.com/owen-d/beacon-api/lib/ratelimit"
 	"github.com/owen-d/beacon-api/lib/route"
 	"github.com/owen-d/beacon-api/lib/validator"
 	"github.com/urfave/negroni"
-	"io/ioutil"
 	"net/http"
 )
 
@@ -22,48 +27,62 @@ type BeaconRoutes interface {
 }
 
 type BeaconMethods struct {
-	JWTDecoder   jwt.Decoder
+	Auth         jwt.Authenticator
 	BeaconClient beaconclient.Client
 	CassClient   cass.Client
+	Audit        *audit.Recorder
+	DeployLimit  *ratelimit.Limiter
 }
 
 type BeaconResponse struct {
 	Beacons []*cass.Beacon `json:"beacons"`
 }
 
-type IncBeacons struct {
-	Beacons []*cass.Beacon `json:"beacons"`
+// DeploymentErrors lists the beacons which failed to update in the proximity api. Failures respond with an apierr.Error instead, so it is empty on success.
+type DeploymentErrors struct {
+	Errors []*apierr.ItemError `json:"errors"`
 }
 
-func (self *IncBeacons) Validate(r *http.Request) ([]*cass.Beacon, *validator.RequestErr) {
-	jsonBody, readErr := ioutil.ReadAll(r.Body)
+// IncomingBeacon is a beacon as clients send it. Its owner is always taken from the request's credentials.
+type IncomingBeacon struct {
+	UserId     *gocql.UUID `json:"user_id"`
+	Name       string      `json:"name" validate:"required,hex"`
+	DeployName string      `json:"deploy_name" validate:"max=100"`
+}
 
-	if readErr != nil {
-		return nil, &validator.RequestErr{400, "invalid json"}
-	}
+// ToCass coerces a beacon into the cassandra lib version, owned by ownerId
+func (self *IncomingBeacon) ToCass(ownerId *gocql.UUID) *cass.Beacon {
+	// the name has been validated as hex
+	name, _ := hex.DecodeString(self.Name)
+	return &cass.Beacon{UserId: ownerId, DeployName: self.DeployName, Name: name}
+}
 
-	unmarshalErr := json.Unmarshal(jsonBody, self)
+type IncBeacons struct {
+	Beacons []*IncomingBeacon `json:"beacons" validate:"required,max=250"`
+}
 
-	if unmarshalErr != nil {
-		return nil, &validator.RequestErr{Status: 400, Message: "invalid json"}
+func (self *IncBeacons) Validate(r *http.Request) ([]*cass.Beacon, *validator.RequestErr) {
+	if err := validator.Decode(r, self); err != nil {
+		return nil, err
 	}
 
 	bindings := r.Context().Value(jwt.JWTNamespace).(*jwt.Bindings)
 
 	// potentially overwrite malicious userId
+	bkns := make([]*cass.Beacon, 0, len(self.Beacons))
 	for _, bkn := range self.Beacons {
-		bkn.UserId = bindings.UserId
+		bkns = append(bkns, bkn.ToCass(bindings.OwnerId))
 	}
 
-	return self.Beacons, nil
+	return bkns, nil
 }
 
 func (self *BeaconMethods) GetBeacons(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
 	bindings := r.Context().Value(jwt.JWTNamespace).(*jwt.Bindings)
-	beacons, fetchErr := self.CassClient.FetchUserBeacons(bindings.UserId)
+	beacons, fetchErr := self.CassClient.FetchUserBeacons(r.Context(), bindings.OwnerId)
 
 	if fetchErr != nil {
-		err := &validator.RequestErr{Status: 500, Message: fetchErr.Error()}
+		err := apierr.From(fetchErr)
 		err.Flush(rw)
 		return
 	}
@@ -103,51 +122,68 @@ func (self *BeaconMethods) ChangeDeployments(rw http.ResponseWriter, r *http.Req
 		}
 	}
 
-	removalRes := self.CassClient.RemoveBeaconsDeployments(removals)
-	additionRes := self.CassClient.UpdateBeacons(additions)
+	// the audit records each beacon's deployment before & after the change
+	bindings := r.Context().Value(jwt.JWTNamespace).(*jwt.Bindings)
+	owned, fetchErr := self.CassClient.FetchUserBeacons(r.Context(), bindings.OwnerId)
+	if fetchErr != nil {
+		apierr.From(fetchErr).Flush(rw)
+		return
+	}
+	deployedTo := make(map[string]string, len(owned))
+	for _, bkn := range owned {
+		deployedTo[hex.EncodeToString(bkn.Name)] = bkn.DeployName
+	}
+	before, after := make(map[string]string, len(bkns)), make(map[string]string, len(bkns))
+	for _, bkn := range bkns {
+		name := hex.EncodeToString(bkn.Name)
+		before[name] = deployedTo[name]
+		after[name] = bkn.DeployName
+	}
+
+	removalRes := self.CassClient.RemoveBeaconsDeployments(r.Context(), removals)
+	additionRes := self.CassClient.UpdateBeacons(r.Context(), additions)
 
 	if removalRes.Err != nil {
-		(&validator.RequestErr{Status: 500, Message: removalRes.Err.Error()}).Flush(rw)
+		apierr.From(removalRes.Err).Flush(rw)
 		return
 	}
 
 	if additionRes.Err != nil {
-		(&validator.RequestErr{Status: 500, Message: additionRes.Err.Error()}).Flush(rw)
+		apierr.From(additionRes.Err).Flush(rw)
 		return
 	}
 
+	self.Audit.Record(r, audit.BeaconsDeploy, "beacons", before, after)
+
 	// iterate over affected beacons & update proximity api.
-	bindings := r.Context().Value(jwt.JWTNamespace).(*jwt.Bindings)
-	errCh := self.handleDeploymentGroups(bindings.UserId, deploymentGrps)
+	errCh := self.handleDeploymentGroups(r.Context(), bindings.OwnerId, deploymentGrps)
 	errs := <-errCh
 
-	rw.Header().Set("Content-Type", "application/json")
-
-	if len(errs) != 0 {
-		rw.WriteHeader(http.StatusInternalServerError)
-	} else {
-		rw.WriteHeader(http.StatusOK)
+	if err := apierr.Bulk("some beacons failed to update", errs); err != nil {
+		err.Flush(rw)
+		return
 	}
 
-	data, _ := json.Marshal(struct {
-		Errors []error `json:"errors"`
-	}{errs})
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+
+	data, _ := json.Marshal(DeploymentErrors{errs})
 
 	rw.Write(data)
 }
 
-func (self *BeaconMethods) handleDeploymentGroups(userId *gocql.UUID, depGrps map[string][]*cass.Beacon) chan []error {
-	errCh := make(chan []error)
+func (self *BeaconMethods) handleDeploymentGroups(ctx context.Context, userId *gocql.UUID, depGrps map[string][]*cass.Beacon) chan []*apierr.ItemError {
+	errCh := make(chan []*apierr.ItemError)
 	keyLength := 0
 
 	for depName, bkns := range depGrps {
 		keyLength++
 
-		go func(depName string, bkns []*cass.Beacon, errCh chan<- []error) {
+		go func(depName string, bkns []*cass.Beacon, errCh chan<- []*apierr.ItemError) {
 			// fetch metadata & then msg
-			match, matchErr := self.CassClient.FetchDeploymentMetadata(userId, depName)
+			match, matchErr := self.CassClient.FetchDeploymentMetadata(ctx, userId, depName)
 			if matchErr != nil {
-				errCh <- []error{matchErr}
+				errCh <- []*apierr.ItemError{apierr.Item(depName, matchErr)}
 				return
 			}
 
@@ -156,13 +192,13 @@ func (self *BeaconMethods) handleDeploymentGroups(userId *gocql.UUID, depGrps ma
 				return
 			}
 
-			msg, msgErr := self.CassClient.FetchMessage(&cass.Message{
+			msg, msgErr := self.CassClient.FetchMessage(ctx, &cass.Message{
 				UserId: userId,
 				Name:   match.MessageName,
 			})
 
 			if msgErr != nil {
-				errCh <- []error{msgErr}
+				errCh <- []*apierr.ItemError{apierr.Item(depName, msgErr)}
 				return
 			}
 
@@ -177,11 +213,11 @@ func (self *BeaconMethods) handleDeploymentGroups(userId *gocql.UUID, depGrps ma
 				bknNames = append(bknNames, bkn.Name)
 			}
 
-			results := self.BeaconClient.DeclarativeAttach(bknNames, attachment)
-			resultsErrs := make([]error, 0)
+			results := self.BeaconClient.DeclarativeAttach(ctx, bknNames, attachment)
+			resultsErrs := make([]*apierr.ItemError, 0)
 			for _, attachRes := range results {
 				if attachRes.Err != nil {
-					resultsErrs = append(resultsErrs, attachRes.Err)
+					resultsErrs = append(resultsErrs, apierr.Item(attachRes.Name, attachRes.Err))
 				}
 			}
 			errCh <- resultsErrs
@@ -190,9 +226,9 @@ func (self *BeaconMethods) handleDeploymentGroups(userId *gocql.UUID, depGrps ma
 		}(depName, bkns, errCh)
 	}
 
-	resCh := make(chan []error)
+	resCh := make(chan []*apierr.ItemError)
 	go func() {
-		accumulatedErrs := make([]error, 0)
+		accumulatedErrs := make([]*apierr.ItemError, 0)
 		for i := 0; i < keyLength; i++ {
 			newErrs := <-errCh
 			accumulatedErrs = append(accumulatedErrs, newErrs...)
@@ -209,18 +245,23 @@ func (self *BeaconMethods) Router() *route.Router {
 		&route.Endpoint{
 			Method:   "GET",
 			Handlers: []negroni.Handler{negroni.HandlerFunc(self.GetBeacons)},
+			Response: &BeaconResponse{},
 		},
 		&route.Endpoint{
 			Method:   http.MethodPut,
-			Handlers: []negroni.Handler{negroni.HandlerFunc(self.ChangeDeployments)},
-			SubPath:  "/deployments",
+			Handlers: []negroni.Handler{negroni.HandlerFunc(orgs.Require(orgs.Editor)), negroni.HandlerFunc(self.ChangeDeployments)},
+			Request:  &IncBeacons{},
+			// each change fans out to the proximity api
+			RateLimit: self.DeployLimit,
+			Response:  &DeploymentErrors{},
+			SubPath:   "/deployments",
 		},
 	}
 
 	r := route.Router{
 		Path:              "/beacons",
 		Endpoints:         endpoints,
-		DefaultMiddleware: []negroni.Handler{negroni.HandlerFunc(self.JWTDecoder.Validate)},
+		DefaultMiddleware: []negroni.Handler{negroni.HandlerFunc(self.Auth.Validate)},
 		Name:              "beaconRouter",
 	}
 
@@ -1,65 +1,172 @@
 package deployments
 
 import (
+	"context"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"github.com/gocql/gocql"
 	"github.com/gorilla/mux"
 	"github.com/owen-d/beacon-api/api/controllers/beacons"
+	"github.com/owen-d/beacon-api/api/controllers/messages"
+	"github.com/owen-d/beacon-api/lib/apierr"
+	"github.com/owen-d/beacon-api/lib/audit"
 	"github.com/owen-d/beacon-api/lib/auth/jwt"
+	"github.com/owen-d/beacon-api/lib/auth/orgs"
 	"github.com/owen-d/beacon-api/lib/beaconclient"
 	"github.com/owen-d/beacon-api/lib/cass"
+	"github.com/owen-d/beacon-api/lib/ratelimit"
+	"github.com/owen-d/beacon-api/lib/redirect"
 	"github.com/owen-d/beacon-api/lib/route"
+	"github.com/owen-d/beacon-api/lib/schedule"
 	"github.com/owen-d/beacon-api/lib/validator"
 	"github.com/urfave/negroni"
-	"io/ioutil"
 	"net/http"
+	"time"
+)
+
+const (
+	defaultTransitionsWindow = time.Hour * 24 * 7
+	maxTransitionsWindow     = time.Hour * 24 * 31
+	// passerby & interactions rows expire after ~3 months, so reports are bounded regardless
+	defaultStatsWindow = time.Hour * 24 * 30
 )
 
 type DeploymentRoutes interface {
 	PostDeployment(http.ResponseWriter, *http.Request, http.HandlerFunc)
 	FetchDeploymentsMetadata(http.ResponseWriter, *http.Request, http.HandlerFunc)
 	FetchDeploymentBeacons(http.ResponseWriter, *http.Request, http.HandlerFunc)
+	FetchDeploymentTransitions(http.ResponseWriter, *http.Request, http.HandlerFunc)
+	FetchDeploymentStats(http.ResponseWriter, *http.Request, http.HandlerFunc)
 }
 
 type DeploymentMethods struct {
-	JWTDecoder   jwt.Decoder
+	Auth         jwt.Authenticator
 	BeaconClient beaconclient.Client
 	CassClient   cass.Client
+	Content      *validator.ContentRules
+	Audit        *audit.Recorder
+	DeployLimit  *ratelimit.Limiter
+}
+
+// summary is the audited form of a deployment
+type summary struct {
+	MessageName string   `json:"message_name"`
+	Beacons     []string `json:"beacons"`
+	Rules       int      `json:"rules,omitempty"`
+	Variants    int      `json:"variants,omitempty"`
+	Scheduled   bool     `json:"scheduled,omitempty"`
+}
+
+func summarize(dep *cass.Deployment) *summary {
+	res := &summary{
+		MessageName: dep.MessageName,
+		Beacons:     make([]string, 0, len(dep.BeaconNames)),
+		Rules:       len(dep.Rules),
+		Variants:    len(dep.Variants),
+		Scheduled:   dep.Schedule != nil,
+	}
+	if dep.Message != nil {
+		res.MessageName = dep.Message.Name
+	}
+	for _, name := range dep.BeaconNames {
+		res.Beacons = append(res.Beacons, hex.EncodeToString(name))
+	}
+	return res
 }
 
 type DeploymentsResponse struct {
 	Deployments []*cass.Deployment `json:"deployments"`
 }
 
-type IncomingDeployment struct{ *cass.Deployment }
+type TransitionsResponse struct {
+	Transitions []*schedule.Transition `json:"transitions"`
+}
 
-func NewIncomingDep() *IncomingDeployment {
-	return &IncomingDeployment{&cass.Deployment{}}
+type StatsResponse struct {
+	Since    time.Time            `json:"since"`
+	Variants []*cass.VariantStats `json:"variants"`
+}
+
+// IncomingDeployment is a deployment as clients send it, converted to a cass.Deployment once valid.
+// It holds no cass types, whose custom decoding would escape the strict decoder.
+type IncomingDeployment struct {
+	UserId      *gocql.UUID               `json:"-"`
+	Name        string                    `json:"name" validate:"required,max=100"`
+	MessageName string                    `json:"message_name" validate:"max=100"`
+	Message     *messages.IncomingMessage `json:"message"`
+	BeaconNames []string                  `json:"beacon_names" validate:"required,max=250,hex"`
+	Rules       []*redirect.Rule          `json:"rules"`
+	Schedule    *schedule.Schedule        `json:"schedule"`
+	Variants    []*redirect.Variant       `json:"variants"`
 }
 
 // Validate fulfills the validator.JSONValidator interface
 func (self *IncomingDeployment) Validate(r *http.Request) *validator.RequestErr {
-	// validate deployment
-	jsonBody, readErr := ioutil.ReadAll(r.Body)
-	if readErr != nil {
-		return &validator.RequestErr{400, "invalid json"}
-	}
-
-	unmarshalErr := json.Unmarshal(jsonBody, self)
-	if unmarshalErr != nil {
-		return &validator.RequestErr{Status: 400}
+	if err := validator.Decode(r, self); err != nil {
+		return err
 	}
 
 	//assign userId into deployment (forcefully overwrite a potentially malicious userId)
 	bindings := r.Context().Value(jwt.JWTNamespace).(*jwt.Bindings)
 
-	self.UserId = bindings.UserId
+	self.UserId = bindings.OwnerId
 	return nil
 }
 
+// Check ensures the deployment names its message & any content it links to may be broadcast by a beacon
+func (self *IncomingDeployment) Check(rules *validator.ContentRules) *validator.RequestErr {
+	errs := make([]*validator.FieldError, 0)
+
+	if self.Message == nil && self.MessageName == "" {
+		errs = append(errs, &validator.FieldError{Field: "message_name", Message: "new deployments must specify message or message_name"})
+	}
+
+	if self.Message != nil {
+		errs = append(errs,
+			rules.CheckTitle("message.title", self.Message.Title),
+			rules.CheckUrl("message.url", self.Message.Url),
+		)
+	}
+
+	for i, rule := range self.Rules {
+		errs = append(errs, rules.CheckUrl(fmt.Sprintf("rules[%d].target", i), rule.Target))
+	}
+
+	return validator.Collect("invalid deployment", errs...)
+}
+
+// ToCass coerces a deployment into the cassandra lib version
+func (self *IncomingDeployment) ToCass() *cass.Deployment {
+	bNames := make([][]byte, 0, len(self.BeaconNames))
+	for _, name := range self.BeaconNames {
+		// names have been validated as hex
+		decoded, _ := hex.DecodeString(name)
+		bNames = append(bNames, decoded)
+	}
+
+	var msg *cass.Message
+	if self.Message != nil {
+		msg, _ = self.Message.ToCass()
+		msg.UserId = self.UserId
+	}
+
+	return &cass.Deployment{
+		UserId:      self.UserId,
+		DeployName:  self.Name,
+		MessageName: self.MessageName,
+		Message:     msg,
+		BeaconNames: bNames,
+		Rules:       self.Rules,
+		Schedule:    self.Schedule,
+		Variants:    self.Variants,
+	}
+}
+
 // PostDeployment is middleware which creates a deployment (composed of its parts) in cassandra
 func (self *DeploymentMethods) PostDeployment(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
 
-	deployment := NewIncomingDep()
+	deployment := &IncomingDeployment{}
 
 	if invalid := deployment.Validate(r); invalid != nil {
 		invalid.Flush(rw)
@@ -67,19 +174,33 @@ func (self *DeploymentMethods) PostDeployment(rw http.ResponseWriter, r *http.Re
 		return
 	}
 
-	// deployment wraps type cass.Deployment
-	cassDep := deployment.Deployment
+	if invalid := deployment.Check(self.Content); invalid != nil {
+		invalid.Flush(rw)
+		next(rw, r)
+		return
+	}
+
+	cassDep := deployment.ToCass()
 
-	if cassDep.Message == nil && cassDep.MessageName == "" {
-		err := &validator.RequestErr{400, "new deployments must specify message or message_name"}
+	if invalid := self.validateOptions(r.Context(), cassDep); invalid != nil {
+		invalid.Flush(rw)
+		next(rw, r)
+		return
+	}
+
+	before, beforeErr := self.currentSummary(r.Context(), cassDep)
+	if beforeErr != nil {
+		err := apierr.From(beforeErr)
 		err.Flush(rw)
 		next(rw, r)
 		return
 	}
-	// insert deployment to cassandra (acts as upsert)
-	res := self.CassClient.PostDeployment(cassDep)
+
+	// insert deployment to cassandra (acts as upsert). The writes aren't cancelled by the client going away,
+	// so the message, beacons & metadata are never left half updated; each query is still bound by QueryTimeout.
+	res := self.CassClient.PostDeployment(context.WithoutCancel(r.Context()), cassDep)
 	if res.Err != nil {
-		err := &validator.RequestErr{500, res.Err.Error()}
+		err := apierr.From(res.Err)
 		err.Flush(rw)
 		next(rw, r)
 		return
@@ -90,7 +211,9 @@ func (self *DeploymentMethods) PostDeployment(rw http.ResponseWriter, r *http.Re
 		Title: cassDep.Message.Title,
 	}
 
-	attachmentResults := self.BeaconClient.DeclarativeAttach(cassDep.BeaconNames, attachment)
+	attachmentResults := self.BeaconClient.DeclarativeAttach(r.Context(), cassDep.BeaconNames, attachment)
+
+	self.Audit.Record(r, audit.DeploymentPut, cassDep.DeployName, before, summarize(cassDep))
 
 	rw.WriteHeader(http.StatusCreated)
 
@@ -98,13 +221,72 @@ func (self *DeploymentMethods) PostDeployment(rw http.ResponseWriter, r *http.Re
 	rw.Write(data)
 }
 
+// currentSummary describes a deployment as it stands before being overwritten, or nil if it doesn't exist yet
+func (self *DeploymentMethods) currentSummary(ctx context.Context, dep *cass.Deployment) (*summary, error) {
+	existing, fetchErr := self.CassClient.FetchDeploymentMetadata(ctx, dep.UserId, dep.DeployName)
+	if fetchErr == gocql.ErrNotFound {
+		return nil, nil
+	}
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+
+	beacons, beaconsErr := self.CassClient.FetchDeploymentBeacons(ctx, existing)
+	if beaconsErr != nil {
+		return nil, beaconsErr
+	}
+	for _, bkn := range beacons {
+		existing.BeaconNames = append(existing.BeaconNames, bkn.Name)
+	}
+
+	return summarize(existing), nil
+}
+
+// validateOptions ensures a deployment's optional rules, schedule & variants are well formed & only reference existing messages
+func (self *DeploymentMethods) validateOptions(ctx context.Context, dep *cass.Deployment) *validator.RequestErr {
+	referenced := make([]string, 0)
+
+	for _, rule := range dep.Rules {
+		if ruleErr := rule.Validate(); ruleErr != nil {
+			return &validator.RequestErr{Status: 400, Message: ruleErr.Error()}
+		}
+	}
+
+	if dep.Schedule != nil {
+		if schedErr := dep.Schedule.Validate(); schedErr != nil {
+			return &validator.RequestErr{Status: 400, Message: schedErr.Error()}
+		}
+		referenced = append(referenced, dep.Schedule.MessageNames()...)
+	}
+
+	if len(dep.Variants) != 0 {
+		if variantsErr := redirect.ValidateVariants(dep.Variants); variantsErr != nil {
+			return &validator.RequestErr{Status: 400, Message: variantsErr.Error()}
+		}
+		for _, v := range dep.Variants {
+			referenced = append(referenced, v.MessageName)
+		}
+	}
+
+	for _, mName := range referenced {
+		_, fetchErr := self.CassClient.FetchMessage(ctx, &cass.Message{UserId: dep.UserId, Name: mName})
+		if fetchErr == gocql.ErrNotFound {
+			return &validator.RequestErr{Status: 400, Message: "deployment references unknown message: " + mName}
+		}
+		if fetchErr != nil {
+			return apierr.From(fetchErr)
+		}
+	}
+	return nil
+}
+
 func (self *DeploymentMethods) FetchDeploymentsMetadata(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
 	bindings := r.Context().Value(jwt.JWTNamespace).(*jwt.Bindings)
 
-	mds, fetchErr := self.CassClient.FetchDeploymentsMetadata(bindings.UserId)
+	mds, fetchErr := self.CassClient.FetchDeploymentsMetadata(r.Context(), bindings.OwnerId)
 
 	if fetchErr != nil {
-		err := &validator.RequestErr{Status: 500, Message: fetchErr.Error()}
+		err := apierr.From(fetchErr)
 		err.Flush(rw)
 		return
 	}
@@ -130,14 +312,14 @@ func (self *DeploymentMethods) FetchDeploymentBeacons(rw http.ResponseWriter, r
 	}
 
 	dep := &cass.Deployment{
-		UserId:     bindings.UserId,
+		UserId:     bindings.OwnerId,
 		DeployName: name,
 	}
 
-	bkns, fetchErr := self.CassClient.FetchDeploymentBeacons(dep)
+	bkns, fetchErr := self.CassClient.FetchDeploymentBeacons(r.Context(), dep)
 
 	if fetchErr != nil {
-		err := &validator.RequestErr{Status: 500, Message: fetchErr.Error()}
+		err := apierr.From(fetchErr)
 		err.Flush(rw)
 		return
 	}
@@ -151,29 +333,131 @@ func (self *DeploymentMethods) FetchDeploymentBeacons(rw http.ResponseWriter, r
 
 }
 
+// FetchDeploymentTransitions lists the upcoming message changes of a scheduled deployment. An optional `until` query param (RFC3339) bounds the listing.
+func (self *DeploymentMethods) FetchDeploymentTransitions(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	bindings := r.Context().Value(jwt.JWTNamespace).(*jwt.Bindings)
+	name := mux.Vars(r)["name"]
+
+	now := time.Now()
+	until := now.Add(defaultTransitionsWindow)
+	if rawUntil := r.URL.Query().Get("until"); rawUntil != "" {
+		parsed, parseErr := time.Parse(time.RFC3339, rawUntil)
+		if parseErr != nil || parsed.Before(now) || parsed.Sub(now) > maxTransitionsWindow {
+			err := &validator.RequestErr{Status: 400, Message: "until must be an RFC3339 time within the next 31 days"}
+			err.Flush(rw)
+			return
+		}
+		until = parsed
+	}
+
+	meta, fetchErr := self.CassClient.FetchDeploymentMetadata(r.Context(), bindings.OwnerId, name)
+	if fetchErr == gocql.ErrNotFound {
+		err := &validator.RequestErr{Status: 404}
+		err.Flush(rw)
+		return
+	}
+	if fetchErr != nil {
+		err := apierr.From(fetchErr)
+		err.Flush(rw)
+		return
+	}
+
+	transitions := make([]*schedule.Transition, 0)
+	if meta.Schedule != nil {
+		var schedErr error
+		transitions, schedErr = meta.Schedule.Transitions(meta.MessageName, now, until)
+		if schedErr != nil {
+			err := apierr.From(schedErr)
+			err.Flush(rw)
+			return
+		}
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+
+	data, _ := json.Marshal(TransitionsResponse{transitions})
+
+	rw.Write(data)
+}
+
+// FetchDeploymentStats reports views, clicks & click-through rate per message served by a deployment. An optional `since` query param (RFC3339) bounds the report.
+func (self *DeploymentMethods) FetchDeploymentStats(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	bindings := r.Context().Value(jwt.JWTNamespace).(*jwt.Bindings)
+
+	since := time.Now().Add(-defaultStatsWindow)
+	if rawSince := r.URL.Query().Get("since"); rawSince != "" {
+		parsed, parseErr := time.Parse(time.RFC3339, rawSince)
+		if parseErr != nil {
+			err := &validator.RequestErr{Status: 400, Message: "since must be an RFC3339 time"}
+			err.Flush(rw)
+			return
+		}
+		since = parsed
+	}
+
+	dep := &cass.Deployment{
+		UserId:     bindings.OwnerId,
+		DeployName: mux.Vars(r)["name"],
+	}
+
+	stats, fetchErr := self.CassClient.FetchDeploymentStats(r.Context(), dep, since)
+	if fetchErr != nil {
+		err := apierr.From(fetchErr)
+		err.Flush(rw)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+
+	data, _ := json.Marshal(StatsResponse{since, stats})
+
+	rw.Write(data)
+}
+
 // Router instantiates a Router object from the related lib
 func (self *DeploymentMethods) Router() *route.Router {
 	endpoints := []*route.Endpoint{
 		&route.Endpoint{
 			Method:   "GET",
 			Handlers: []negroni.Handler{negroni.HandlerFunc(self.FetchDeploymentsMetadata)},
+			Response: &DeploymentsResponse{},
 		},
 		&route.Endpoint{
 			Method:   "POST",
-			Handlers: []negroni.Handler{negroni.HandlerFunc(self.PostDeployment)},
+			Handlers: []negroni.Handler{negroni.HandlerFunc(orgs.Require(orgs.Editor)), negroni.HandlerFunc(self.PostDeployment)},
+			Request:  &IncomingDeployment{},
+			// each deployment fans out to the proximity api
+			RateLimit: self.DeployLimit,
+			Response:  []*beaconclient.AttachmentResult{},
+			Status:    http.StatusCreated,
 		},
 		// /:id routes
 		&route.Endpoint{
 			Method:   "GET",
 			Handlers: []negroni.Handler{negroni.HandlerFunc(self.FetchDeploymentBeacons)},
+			Response: &beacons.BeaconResponse{},
 			SubPath:  "/{name}/beacons",
 		},
+		&route.Endpoint{
+			Method:   "GET",
+			Handlers: []negroni.Handler{negroni.HandlerFunc(self.FetchDeploymentTransitions)},
+			Response: &TransitionsResponse{},
+			SubPath:  "/{name}/transitions",
+		},
+		&route.Endpoint{
+			Method:   "GET",
+			Handlers: []negroni.Handler{negroni.HandlerFunc(self.FetchDeploymentStats)},
+			Response: &StatsResponse{},
+			SubPath:  "/{name}/stats",
+		},
 	}
 
 	r := route.Router{
 		Path:              "/deployments",
 		Endpoints:         endpoints,
-		DefaultMiddleware: []negroni.Handler{negroni.HandlerFunc(self.JWTDecoder.Validate)},
+		DefaultMiddleware: []negroni.Handler{negroni.HandlerFunc(self.Auth.Validate)},
 		Name:              "deploymentsRouter",
 	}
 
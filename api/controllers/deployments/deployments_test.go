@@ -0,0 +1,39 @@
+package deployments
+
+import (
+	"context"
+	"github.com/gocql/gocql"
+	"github.com/owen-d/beacon-api/lib/auth/jwt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecodeDeployment(t *testing.T) {
+	ownerId := gocql.TimeUUID()
+	cases := []struct {
+		body  string
+		valid bool
+	}{
+		{`{"name": "dep", "beacon_names": ["ab"], "message": {"name": "msg", "title": "hi", "url": "https://example.com"}}`, true},
+		// nested fields are decoded as strictly as top level ones
+		{`{"name": "dep", "beacon_names": ["ab"], "message": {"name": "msg", "deployments": ["other"]}}`, false},
+		{`{"name": "dep", "beacon_names": ["ab"], "message": {"name": "msg", "UserId": "` + gocql.TimeUUID().String() + `"}}`, false},
+		{`{"name": "dep", "beacon_names": ["ab"], "message_name": "msg", "rules": [{"target": "https://example.com", "unknown": 1}]}`, false},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodPost, "/v1/deployments", strings.NewReader(c.body))
+		r = r.WithContext(context.WithValue(r.Context(), jwt.JWTNamespace, &jwt.Bindings{OwnerId: &ownerId}))
+
+		dep := &IncomingDeployment{}
+		err := dep.Validate(r)
+		if (err == nil) != c.valid {
+			t.Errorf("%s: expected valid: %v, got %v", c.body, c.valid, err)
+		}
+		if err == nil && dep.ToCass().Message.UserId != &ownerId {
+			t.Errorf("%s: expected the message to belong to the owner", c.body)
+		}
+	}
+}
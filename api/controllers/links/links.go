@@ -0,0 +1,229 @@
+package links
+
+import (
+	"encoding/hex"
+	"github.com/gocql/gocql"
+	"github.com/gorilla/mux"
+	"github.com/owen-d/beacon-api/lib/cass"
+	"github.com/owen-d/beacon-api/lib/redirect"
+	"github.com/owen-d/beacon-api/lib/route"
+	"github.com/urfave/negroni"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	linkPrefix = "https://our.sharecro.ws/bkn/"
+	// variantCookie keeps a visitor on the same variant of a split deployment
+	variantCookie       = "sc_variant"
+	variantCookieMaxAge = 60 * 60 * 24 * 30
+)
+
+var (
+	previewTemplate = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<meta property="og:type" content="website">
+<meta property="og:title" content="{{.Title}}">
+<meta property="og:url" content="{{.PageUrl}}">
+<meta property="og:site_name" content="sharecrows">
+<meta name="twitter:card" content="summary">
+<meta name="twitter:title" content="{{.Title}}">
+<meta name="twitter:url" content="{{.PageUrl}}">
+<link rel="canonical" href="{{.PageUrl}}">
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Host}}</p>
+<a href="{{.TapUrl}}">Open</a>
+</body>
+</html>
+`))
+
+	notFoundTemplate = template.Must(template.New("notFound").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Nothing here</title>
+</head>
+<body>
+<h1>Nothing here</h1>
+<p>This beacon isn't broadcasting anything right now.</p>
+</body>
+</html>
+`))
+)
+
+type LinkRoutes interface {
+	Preview(http.ResponseWriter, *http.Request, http.HandlerFunc)
+	TapThrough(http.ResponseWriter, *http.Request, http.HandlerFunc)
+}
+
+// LinkMethods serves the public pages behind the short links attached to beacons. They require no authentication.
+type LinkMethods struct {
+	CassClient cass.Client
+}
+
+type previewData struct {
+	Title   string
+	Host    string
+	PageUrl string
+	TapUrl  string
+}
+
+// resolve matches the {name} path var to a deployed link. A nil link signals the 404 page has already been written.
+func (self *LinkMethods) resolve(rw http.ResponseWriter, r *http.Request) *cass.Link {
+	shortName, decodeErr := hex.DecodeString(mux.Vars(r)["name"])
+	if decodeErr != nil || len(shortName) != cass.ShortNameLength {
+		flushNotFound(rw)
+		return nil
+	}
+
+	link, fetchErr := self.CassClient.FetchLink(r.Context(), shortName)
+	if fetchErr == gocql.ErrNotFound || (fetchErr == nil && link.Message == nil) {
+		flushNotFound(rw)
+		return nil
+	}
+
+	if fetchErr != nil {
+		slog.ErrorContext(r.Context(), "failed to resolve link", "error", fetchErr)
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return nil
+	}
+
+	return link
+}
+
+// Preview renders the page nearby pulls for a beacon's metadata, recording a passerby event
+func (self *LinkMethods) Preview(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	link := self.resolve(rw, r)
+	if link == nil {
+		return
+	}
+
+	msg := self.serve(rw, r, link)
+
+	res := self.CassClient.RecordPasserby(r.Context(), newEvent(link, msg))
+	if res.Err != nil {
+		slog.ErrorContext(r.Context(), "failed to record passerby", "error", res.Err)
+	}
+
+	pageUrl := linkPrefix + hex.EncodeToString(link.ShortName)
+	data := &previewData{
+		Title:   msg.Title,
+		Host:    hostOf(msg.Url),
+		PageUrl: pageUrl,
+		TapUrl:  pageUrl + "/go",
+	}
+
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.WriteHeader(http.StatusOK)
+	previewTemplate.Execute(rw, data)
+}
+
+// TapThrough records an interaction & redirects the passerby according to the deployment's redirect rules, falling back to the served message's url
+func (self *LinkMethods) TapThrough(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	link := self.resolve(rw, r)
+	if link == nil {
+		return
+	}
+
+	msg := self.serve(rw, r, link)
+
+	res := self.CassClient.RecordInteraction(r.Context(), newEvent(link, msg))
+	if res.Err != nil {
+		slog.ErrorContext(r.Context(), "failed to record interaction", "error", res.Err)
+	}
+
+	target := redirect.Evaluate(link.Deployment.Rules, r, time.Now(), msg.Url)
+	http.Redirect(rw, r, target, http.StatusFound)
+}
+
+// serve picks the message a visitor sees. Split deployments choose a variant, kept sticky via a cookie scoped to the link. Otherwise the deployed message is used.
+func (self *LinkMethods) serve(rw http.ResponseWriter, r *http.Request, link *cass.Link) *cass.Message {
+	if len(link.Deployment.Variants) == 0 {
+		return link.Message
+	}
+
+	var sticky string
+	if cookie, cookieErr := r.Cookie(variantCookie); cookieErr == nil {
+		sticky, _ = url.QueryUnescape(cookie.Value)
+	}
+
+	variant := redirect.ChooseVariant(link.Deployment.Variants, sticky)
+	if variant == nil {
+		return link.Message
+	}
+
+	msg, fetchErr := self.CassClient.FetchMessage(r.Context(), &cass.Message{UserId: link.Beacon.UserId, Name: variant.MessageName})
+	if fetchErr != nil {
+		slog.WarnContext(r.Context(), "failed to fetch variant, serving deployed message", "error", fetchErr)
+		return link.Message
+	}
+
+	http.SetCookie(rw, &http.Cookie{
+		Name:     variantCookie,
+		Value:    url.QueryEscape(variant.MessageName),
+		Path:     "/bkn/" + hex.EncodeToString(link.ShortName),
+		MaxAge:   variantCookieMaxAge,
+		HttpOnly: true,
+	})
+	return msg
+}
+
+func newEvent(link *cass.Link, served *cass.Message) *cass.Event {
+	return &cass.Event{
+		UserId:     link.Beacon.UserId,
+		DeployName: link.Beacon.DeployName,
+		BeaconName: link.Beacon.Name,
+		Variant:    served.Name,
+		Moment:     time.Now(),
+	}
+}
+
+// hostOf displays the destination of a message, so passersby know where a tap will take them
+func hostOf(rawUrl string) string {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil {
+		return ""
+	}
+	return parsed.Host
+}
+
+func flushNotFound(rw http.ResponseWriter) {
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.WriteHeader(http.StatusNotFound)
+	notFoundTemplate.Execute(rw, nil)
+}
+
+// Router instantiates a Router object from the related lib
+func (self *LinkMethods) Router() *route.Router {
+	endpoints := []*route.Endpoint{
+		&route.Endpoint{
+			Method:   http.MethodGet,
+			Handlers: []negroni.Handler{negroni.HandlerFunc(self.Preview)},
+			Response: route.Page,
+			SubPath:  "/{name}",
+		},
+		&route.Endpoint{
+			Method:   http.MethodGet,
+			Handlers: []negroni.Handler{negroni.HandlerFunc(self.TapThrough)},
+			Response: route.Redirect,
+			SubPath:  "/{name}/go",
+		},
+	}
+
+	r := route.Router{
+		Path:      "/bkn",
+		Endpoints: endpoints,
+		Name:      "linksRouter",
+	}
+
+	return &r
+}
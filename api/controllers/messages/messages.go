@@ -1,14 +1,17 @@
 package messages
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/gocql/gocql"
+	"github.com/owen-d/beacon-api/lib/apierr"
+	"github.com/owen-d/beacon-api/lib/audit"
 	"github.com/owen-d/beacon-api/lib/auth/jwt"
+	"github.com/owen-d/beacon-api/lib/auth/orgs"
 	"github.com/owen-d/beacon-api/lib/cass"
 	"github.com/owen-d/beacon-api/lib/route"
 	"github.com/owen-d/beacon-api/lib/validator"
 	"github.com/urfave/negroni"
-	"io/ioutil"
 	"net/http"
 )
 
@@ -20,8 +23,10 @@ type MessagesRoutes interface {
 }
 
 type MessageMethods struct {
-	JWTDecoder jwt.Decoder
+	Auth       jwt.Authenticator
 	CassClient cass.Client
+	Content    *validator.ContentRules
+	Audit      *audit.Recorder
 }
 
 type MessagesResponse struct {
@@ -29,32 +34,33 @@ type MessagesResponse struct {
 }
 
 type IncomingMessage struct {
-	UserId *gocql.UUID `json:-`
-	Name   string      `cql:"name" json:"name"`
+	UserId *gocql.UUID `json:"-"`
+	Name   string      `cql:"name" json:"name" validate:"required,max=100"`
 	Title  string      `cql:"title" json:"title"`
 	Url    string      `cql:"url" json:"url"`
 }
 
 // Validate fulfills the validator.JSONValidator interface
 func (self *IncomingMessage) Validate(r *http.Request) *validator.RequestErr {
-	// validate msg
-	jsonBody, readErr := ioutil.ReadAll(r.Body)
-	if readErr != nil {
-		return &validator.RequestErr{400, "invalid json"}
-	}
-
-	unmarshalErr := json.Unmarshal(jsonBody, self)
-	if unmarshalErr != nil {
-		return &validator.RequestErr{Status: 400}
+	if err := validator.Decode(r, self); err != nil {
+		return err
 	}
 
 	//assign userId into msg (forcefully overwrite a potentially malicious userId)
 	bindings := r.Context().Value(jwt.JWTNamespace).(*jwt.Bindings)
 
-	self.UserId = bindings.UserId
+	self.UserId = bindings.OwnerId
 	return nil
 }
 
+// Check ensures the message's content may be broadcast by a beacon
+func (self *IncomingMessage) Check(rules *validator.ContentRules) *validator.RequestErr {
+	return validator.Collect("invalid message",
+		rules.CheckTitle("title", self.Title),
+		rules.CheckUrl("url", self.Url),
+	)
+}
+
 // ToCass coerces a msg into the cassandra lib version
 func (self *IncomingMessage) ToCass() (*cass.Message, error) {
 
@@ -80,31 +86,40 @@ func (self *MessageMethods) UpdateMessage(rw http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	if invalid := msg.Check(self.Content); invalid != nil {
+		invalid.Flush(rw)
+		next(rw, r)
+		return
+	}
+
 	cassMsg, castErr := msg.ToCass()
 
 	if castErr != nil {
-		err := &validator.RequestErr{500, castErr.Error()}
+		err := apierr.From(castErr)
 		err.Flush(rw)
 		next(rw, r)
 		return
 	}
 
-	if cassMsg.Name == "" {
-		err := &validator.RequestErr{http.StatusBadRequest, "invalid name"}
+	before, beforeErr := self.currentMessage(r.Context(), cassMsg)
+	if beforeErr != nil {
+		err := apierr.From(beforeErr)
 		err.Flush(rw)
 		next(rw, r)
 		return
 	}
 
 	// update row
-	res := self.CassClient.UpdateMessage(cassMsg, nil)
+	res := self.CassClient.UpdateMessage(r.Context(), cassMsg, nil)
 
 	if res.Err != nil {
-		err := &validator.RequestErr{500, res.Err.Error()}
+		err := apierr.From(res.Err)
 		err.Flush(rw)
 		next(rw, r)
 		return
 	}
+
+	self.Audit.Record(r, audit.MessageUpdate, cassMsg.Name, before, cassMsg)
 }
 
 func (self *MessageMethods) PostMessage(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
@@ -116,33 +131,57 @@ func (self *MessageMethods) PostMessage(rw http.ResponseWriter, r *http.Request,
 		return
 	}
 
+	if invalid := msg.Check(self.Content); invalid != nil {
+		invalid.Flush(rw)
+		next(rw, r)
+		return
+	}
+
 	cassMsg, castErr := msg.ToCass()
 
 	if castErr != nil {
-		err := &validator.RequestErr{500, castErr.Error()}
+		err := apierr.From(castErr)
+		err.Flush(rw)
+		next(rw, r)
+		return
+	}
+
+	before, beforeErr := self.currentMessage(r.Context(), cassMsg)
+	if beforeErr != nil {
+		err := apierr.From(beforeErr)
 		err.Flush(rw)
 		next(rw, r)
 		return
 	}
 
 	// insert msg to cassandra (acts as upsert)
-	res := self.CassClient.CreateMessage(cassMsg, nil)
+	res := self.CassClient.CreateMessage(r.Context(), cassMsg, nil)
 	if res.Err != nil {
-		err := &validator.RequestErr{500, res.Err.Error()}
+		err := apierr.From(res.Err)
 		err.Flush(rw)
 		next(rw, r)
 		return
 	}
 
+	self.Audit.Record(r, audit.MessageCreate, cassMsg.Name, before, cassMsg)
+}
+
+// currentMessage fetches a message before it is overwritten, or nil if it doesn't exist yet
+func (self *MessageMethods) currentMessage(ctx context.Context, m *cass.Message) (*cass.Message, error) {
+	existing, fetchErr := self.CassClient.FetchMessage(ctx, m)
+	if fetchErr == gocql.ErrNotFound {
+		return nil, nil
+	}
+	return existing, fetchErr
 }
 
 func (self *MessageMethods) FetchMessages(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
 	bindings := r.Context().Value(jwt.JWTNamespace).(*jwt.Bindings)
 
-	msgs, fetchErr := self.CassClient.FetchMessages(bindings.UserId, cass.DefaultLimit)
+	msgs, fetchErr := self.CassClient.FetchMessages(r.Context(), bindings.OwnerId, cass.DefaultLimit)
 
 	if fetchErr != nil {
-		err := &validator.RequestErr{Status: 500, Message: fetchErr.Error()}
+		err := apierr.From(fetchErr)
 		err.Flush(rw)
 		return
 	}
@@ -162,21 +201,26 @@ func (self *MessageMethods) Router() *route.Router {
 		&route.Endpoint{
 			Method:   "GET",
 			Handlers: []negroni.Handler{negroni.HandlerFunc(self.FetchMessages)},
+			Response: &MessagesResponse{},
 		},
 		&route.Endpoint{
 			Method:   "POST",
-			Handlers: []negroni.Handler{negroni.HandlerFunc(self.PostMessage)},
+			Handlers: []negroni.Handler{negroni.HandlerFunc(orgs.Require(orgs.Editor)), negroni.HandlerFunc(self.PostMessage)},
+			Request:  &IncomingMessage{},
+			Response: route.NoBody,
 		},
 		&route.Endpoint{
 			Method:   "PUT",
-			Handlers: []negroni.Handler{negroni.HandlerFunc(self.UpdateMessage)},
+			Handlers: []negroni.Handler{negroni.HandlerFunc(orgs.Require(orgs.Editor)), negroni.HandlerFunc(self.UpdateMessage)},
+			Request:  &IncomingMessage{},
+			Response: route.NoBody,
 		},
 	}
 
 	r := route.Router{
 		Path:              "/messages",
 		Endpoints:         endpoints,
-		DefaultMiddleware: []negroni.Handler{negroni.HandlerFunc(self.JWTDecoder.Validate)},
+		DefaultMiddleware: []negroni.Handler{negroni.HandlerFunc(self.Auth.Validate)},
 		Name:              "messagesRouter",
 	}
 
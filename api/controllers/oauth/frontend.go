@@ -0,0 +1,90 @@
+package oauth
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// Frontend is where browsers are sent once a login completes
+type Frontend struct {
+	Url *url.URL
+	// Origins which return_to may point at, in addition to the frontend's own
+	Allowlist []*url.URL
+}
+
+// NewFrontend parses the frontend url & allowlisted origins
+func NewFrontend(frontendUrl string, allowlist []string) (*Frontend, error) {
+	parsed, parseErr := parseOrigin(frontendUrl)
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	res := &Frontend{Url: parsed, Allowlist: make([]*url.URL, 0, len(allowlist))}
+	for _, raw := range allowlist {
+		origin, err := parseOrigin(raw)
+		if err != nil {
+			return nil, err
+		}
+		res.Allowlist = append(res.Allowlist, origin)
+	}
+	return res, nil
+}
+
+func parseOrigin(raw string) (*url.URL, error) {
+	parsed, parseErr := url.Parse(raw)
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	if (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+		return nil, errors.New("frontend urls must be absolute http(s) urls: " + raw)
+	}
+	return parsed, nil
+}
+
+// ReturnTo resolves a return_to parameter against the frontend url, rejecting any destination outside the allowlist
+func (self *Frontend) ReturnTo(raw string) (string, bool) {
+	if raw == "" {
+		return self.Url.String(), true
+	}
+
+	// browsers treat backslashes as slashes, so //evil.com & \\evil.com are equivalent
+	if strings.Contains(raw, "\\") {
+		return "", false
+	}
+
+	ref, parseErr := url.Parse(raw)
+	if parseErr != nil {
+		return "", false
+	}
+
+	resolved := self.Url.ResolveReference(ref)
+	if !self.allowed(resolved) {
+		return "", false
+	}
+
+	// any fragment is replaced with the login result
+	resolved.Fragment = ""
+	return resolved.String(), true
+}
+
+// Origins lists the frontend's origin along with the allowlisted ones, i.e. for cors
+func (self *Frontend) Origins() []string {
+	res := make([]string, 0, len(self.Allowlist)+1)
+	for _, u := range append([]*url.URL{self.Url}, self.Allowlist...) {
+		res = append(res, u.Scheme+"://"+u.Host)
+	}
+	return res
+}
+
+func (self *Frontend) allowed(u *url.URL) bool {
+	if u.User != nil {
+		return false
+	}
+	for _, origin := range append([]*url.URL{self.Url}, self.Allowlist...) {
+		if strings.EqualFold(u.Scheme, origin.Scheme) && strings.EqualFold(u.Host, origin.Host) {
+			return true
+		}
+	}
+	return false
+}
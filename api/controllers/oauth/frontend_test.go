@@ -0,0 +1,200 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gocql/gocql"
+	"github.com/owen-d/beacon-api/config"
+	"github.com/owen-d/beacon-api/lib/auth/jwt"
+	"github.com/owen-d/beacon-api/lib/crypt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestReturnTo(t *testing.T) {
+	frontend, err := NewFrontend("https://app.sharecro.ws/home", []string{"https://beta.sharecro.ws"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		raw      string
+		expected string
+		ok       bool
+	}{
+		{"", "https://app.sharecro.ws/home", true},
+		{"/deployments?tab=stats", "https://app.sharecro.ws/deployments?tab=stats", true},
+		{"https://beta.sharecro.ws/x#frag", "https://beta.sharecro.ws/x", true},
+		{"https://evil.com/", "", false},
+		{"//evil.com/", "", false},
+		{"\\\\evil.com/", "", false},
+		{"https://app.sharecro.ws@evil.com/", "", false},
+		{"https://user@app.sharecro.ws/", "", false},
+		{"http://app.sharecro.ws/", "", false},
+		{"javascript:alert(1)", "", false},
+	}
+
+	for _, c := range cases {
+		res, ok := frontend.ReturnTo(c.raw)
+		if ok != c.ok || res != c.expected {
+			t.Errorf("ReturnTo(%q) = %q, %v; expected %q, %v", c.raw, res, ok, c.expected, c.ok)
+		}
+	}
+}
+
+func TestState(t *testing.T) {
+	coder := testCrypter(t)
+	flow := &Flow{Nonce: "nonce", Verifier: "verifier", ReturnTo: "https://app.sharecro.ws"}
+
+	state, err := GenState(coder, flow)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ParseState(coder, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Nonce != flow.Nonce || parsed.Verifier != flow.Verifier || parsed.ReturnTo != flow.ReturnTo {
+		t.Error("state did not round trip:", parsed)
+	}
+
+	if _, err := ParseState(coder, state[:len(state)-2]+"00"); err == nil {
+		t.Error("expected tampered state to fail")
+	}
+}
+
+func testCrypter(t *testing.T) *crypt.OmniCrypter {
+	coder, err := crypt.NewOmniCrypter("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return coder
+}
+
+func TestRedirectBindsNonce(t *testing.T) {
+	frontend, _ := NewFrontend("https://app.sharecro.ws", nil)
+	methods := &ProviderMethods{
+		Provider: NewGoogleProvider(&config.OAuth{ClientID: "id", RedirectUri: "https://our.sharecro.ws/v1/auth/google/authorize"}),
+		Coder:    testCrypter(t),
+		Frontend: frontend,
+	}
+
+	t.Run("disallowed return_to", func(t *testing.T) {
+		rw := httptest.NewRecorder()
+		methods.Redirect(rw, httptest.NewRequest("GET", "/v1/auth/google/init?return_to=https://evil.com", nil), nil)
+		if rw.Code != http.StatusBadRequest {
+			t.Errorf("expected 400, got %d", rw.Code)
+		}
+	})
+
+	rw := httptest.NewRecorder()
+	methods.Redirect(rw, httptest.NewRequest("GET", "/v1/auth/google/init?return_to=/beacons", nil), nil)
+	if rw.Code != http.StatusFound {
+		t.Fatalf("expected 302, got %d", rw.Code)
+	}
+
+	location, _ := url.Parse(rw.Header().Get("Location"))
+	if location.Query().Get("code_challenge_method") != "S256" || location.Query().Get("code_challenge") == "" {
+		t.Error("expected a pkce challenge, got:", location)
+	}
+
+	flow, err := ParseState(methods.Coder, location.Query().Get("state"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cookies := rw.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value != flow.Nonce || !cookies[0].HttpOnly {
+		t.Errorf("expected an http only cookie holding the nonce, got %v", cookies)
+	}
+	if codeChallenge(flow.Verifier) != location.Query().Get("code_challenge") {
+		t.Error("challenge does not match the state's verifier")
+	}
+
+	// the browser's jar decides which cookies reach the callback
+	jar, _ := cookiejar.New(nil)
+	jar.SetCookies(mustParse(t, "https://our.sharecro.ws/v1/auth/google/init"), rw.Result().Cookies())
+	callback := mustParse(t, "https://our.sharecro.ws/v1/auth/google/authorize?code=c&state="+url.QueryEscape(location.Query().Get("state")))
+	if sent := jar.Cookies(callback); len(sent) != 1 || sent[0].Value != flow.Nonce {
+		t.Errorf("expected the nonce to be sent to the callback, got %v", sent)
+	}
+
+	t.Run("callback from another browser", func(t *testing.T) {
+		other := httptest.NewRecorder()
+		methods.Redirect(other, httptest.NewRequest("GET", "/v1/auth/google/init?return_to=/beacons", nil), nil)
+		otherJar, _ := cookiejar.New(nil)
+		otherJar.SetCookies(callback, other.Result().Cookies())
+
+		r := httptest.NewRequest("GET", callback.String(), nil)
+		for _, c := range otherJar.Cookies(callback) {
+			r.AddCookie(c)
+		}
+		rw := httptest.NewRecorder()
+		methods.HandleAuth(rw, r, nil)
+		if rw.Code != http.StatusBadRequest {
+			t.Errorf("expected 400, got %d", rw.Code)
+		}
+
+		otherJar.SetCookies(callback, rw.Result().Cookies())
+		if left := otherJar.Cookies(callback); len(left) != 0 {
+			t.Errorf("expected the callback to clear the nonce, got %v", left)
+		}
+	})
+}
+
+func TestLinkBindsBrowser(t *testing.T) {
+	frontend, _ := NewFrontend("https://app.sharecro.ws", nil)
+	methods := &ProviderMethods{
+		Provider: NewGoogleProvider(&config.OAuth{ClientID: "id", RedirectUri: "https://our.sharecro.ws/v1/auth/google/authorize"}),
+		Coder:    testCrypter(t),
+		Frontend: frontend,
+	}
+
+	userId := gocql.TimeUUID()
+	r := httptest.NewRequest("POST", "/v1/auth/google/link", nil)
+	rw := httptest.NewRecorder()
+	methods.Link(rw, r.WithContext(context.WithValue(r.Context(), jwt.JWTNamespace, &jwt.Bindings{UserId: &userId})), nil)
+
+	res := &LinkResponse{}
+	if err := json.Unmarshal(rw.Body.Bytes(), res); err != nil {
+		t.Fatal(err)
+	}
+	initUrl := mustParse(t, "https://our.sharecro.ws"+res.Url)
+
+	cases := []struct {
+		name     string
+		cookies  []*http.Cookie
+		expected int
+	}{
+		{"requesting browser", rw.Result().Cookies(), http.StatusFound},
+		{"another browser", nil, http.StatusBadRequest},
+		{"another ticket", []*http.Cookie{&http.Cookie{Name: linkCookie, Value: "other"}}, http.StatusBadRequest},
+	}
+
+	for _, c := range cases {
+		jar, _ := cookiejar.New(nil)
+		jar.SetCookies(initUrl, c.cookies)
+		r := httptest.NewRequest("GET", initUrl.String(), nil)
+		for _, cookie := range jar.Cookies(initUrl) {
+			r.AddCookie(cookie)
+		}
+
+		rw := httptest.NewRecorder()
+		methods.Redirect(rw, r, nil)
+		if rw.Code != c.expected {
+			t.Errorf("%s: expected %d, got %d", c.name, c.expected, rw.Code)
+		}
+	}
+}
+
+func mustParse(t *testing.T, raw string) *url.URL {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
@@ -6,22 +6,19 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"github.com/gocql/gocql"
 	"github.com/owen-d/beacon-api/config"
-	"github.com/owen-d/beacon-api/lib/auth/jwt"
 	"github.com/owen-d/beacon-api/lib/cass"
 	"github.com/owen-d/beacon-api/lib/crypt"
-	"github.com/owen-d/beacon-api/lib/route"
-	"github.com/owen-d/beacon-api/lib/validator"
-	"github.com/urfave/negroni"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
-	"io/ioutil"
-	"net/http"
 	"time"
 )
 
 const (
 	userinfoEndpoint = "https://www.googleapis.com/oauth2/v3/userinfo"
+	// states begin with a varint encoded expiry
+	stateExpiryLength = 9
 )
 
 func NewOAuthConf(vars *config.OAuth) *oauth2.Config {
@@ -34,133 +31,45 @@ func NewOAuthConf(vars *config.OAuth) *oauth2.Config {
 	}
 }
 
-type GoogleAuth interface {
-	HandleAuth(http.ResponseWriter, *http.Request, http.HandlerFunc)
-	Redirect(http.ResponseWriter, *http.Request, http.HandlerFunc)
-}
-
-type GoogleAuthMethods struct {
-	OAuth      *oauth2.Config
-	Coder      *crypt.OmniCrypter
-	CassClient cass.Client
-	JWTEncoder *jwt.Encoder
-}
-
-// Exchange validates a state string & exchanges the code for a token
-func (self *GoogleAuthMethods) exchange(state string, code string) (*oauth2.Token, error) {
-	if validationErr := ValidateState(self.Coder, state); validationErr != nil {
-		return nil, validationErr
+// NewGoogleProvider adapts the google oauth config, which predates generic providers
+func NewGoogleProvider(vars *config.OAuth) *Provider {
+	return &Provider{
+		Name:         "google",
+		Id:           cass.Google,
+		OAuth:        NewOAuthConf(vars),
+		UserinfoURL:  userinfoEndpoint,
+		SubjectClaim: "sub",
+		EmailClaim:   "email",
 	}
-
-	return self.OAuth.Exchange(oauth2.NoContext, code)
-}
-
-// getUser will use a token to retrieve a corresponding user
-func (self *GoogleAuthMethods) getUser(tok *oauth2.Token) (*GoogleUser, error) {
-	client := self.OAuth.Client(oauth2.NoContext, tok)
-
-	resp, fetchErr := client.Get(userinfoEndpoint)
-	if fetchErr != nil {
-		return nil, fetchErr
-	}
-	defer resp.Body.Close()
-
-	data, readErr := ioutil.ReadAll(resp.Body)
-
-	if readErr != nil {
-		return nil, readErr
-	}
-
-	user := &GoogleUser{}
-	return user, json.Unmarshal(data, user)
 }
 
-// loginUser upserts a user
-func (self *GoogleAuthMethods) loginUser(user *GoogleUser) (*cass.UpsertResult, *cass.User) {
-	cassUser := user.ToCass()
-	return self.CassClient.CreateUser(cassUser, cass.Google, []byte(user.Sub), nil), cassUser
-}
-
-// HandleAuth handles redirect w/ state & code params. validate state & exchange code for user
-func (self *GoogleAuthMethods) HandleAuth(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
-	params := r.URL.Query()
-
-	codes, okcode := params["code"]
-	states, okstate := params["state"]
-
-	if !okstate || !okcode {
-		err := &validator.RequestErr{Status: http.StatusBadRequest, Message: "requires state & code params"}
-		err.Flush(rw)
-		return
-	}
-
-	token, exchangeErr := self.exchange(states[0], codes[0])
-
-	if exchangeErr != nil {
-		err := &validator.RequestErr{Status: http.StatusInternalServerError, Message: exchangeErr.Error()}
-		err.Flush(rw)
-		return
-	}
-
-	googleUser, userExchangeErr := self.getUser(token)
-
-	if userExchangeErr != nil {
-		err := &validator.RequestErr{Status: http.StatusInternalServerError, Message: userExchangeErr.Error()}
-		err.Flush(rw)
-		return
-	}
-
-	res, cassUser := self.loginUser(googleUser)
-
-	if res.Err != nil {
-		err := &validator.RequestErr{Status: http.StatusInternalServerError, Message: res.Err.Error()}
-		err.Flush(rw)
-		return
-	}
-
-	// 1 year jwt expiry
-	newJwt, jwtErr := self.JWTEncoder.Encode(*cassUser.Id, time.Now().Add(time.Hour*24*365).Unix())
-
-	if jwtErr != nil {
-		err := &validator.RequestErr{Status: http.StatusInternalServerError, Message: "error issuing jwt"}
-		err.Flush(rw)
-		return
-	}
-
-	rw.Header().Set("Content-Type", "application/json")
-	rw.WriteHeader(http.StatusCreated)
-
-	data, _ := json.Marshal(&LoginResponse{newJwt, cassUser})
-	rw.Write(data)
-
+// Flow is the state of a login, carried (encrypted) through the provider & back
+type Flow struct {
+	// Nonce is also held in a cookie, binding the flow to the browser which started it
+	Nonce string `json:"n,omitempty"`
+	// Verifier is the PKCE code verifier
+	Verifier string      `json:"v,omitempty"`
+	LinkUser *gocql.UUID `json:"u,omitempty"`
+	ReturnTo string      `json:"r,omitempty"`
+	// Session completes the login with a session cookie rather than tokens
+	Session bool `json:"s,omitempty"`
 }
 
-// RedirectToGoogle generates a state & redirects to the correct google login endpoint
-func (self *GoogleAuthMethods) Redirect(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
-	state, stateErr := GenState(self.Coder)
-	if stateErr != nil {
-		err := &validator.RequestErr{Status: 500, Message: stateErr.Error()}
-		err.Flush(rw)
-		return
+func GenState(encoder *crypt.OmniCrypter, flow *Flow) (string, error) {
+	payload, marshalErr := json.Marshal(flow)
+	if marshalErr != nil {
+		return "", marshalErr
 	}
-	redirectUrl := self.getLoginURL(state)
-	http.Redirect(rw, r, redirectUrl, http.StatusFound)
-}
-
-func (self *GoogleAuthMethods) getLoginURL(state string) string {
-	return self.OAuth.AuthCodeURL(state)
-}
 
-func GenState(encoder *crypt.OmniCrypter) (string, error) {
 	// default to 5 minutes
 	expiry := time.Now().Add(time.Minute * 10).Unix()
 
 	// 64 bit int = 8 bytes (assuming there isn't some leading/trailing identifiers)
 	// we then add 1 byte, to facilitate 'variable-length encoding'
 	// see https://medium.com/go-walkthrough/go-walkthrough-encoding-binary-96dc5d4abb5d
-	msgBuf := make([]byte, 9)
+	msgBuf := make([]byte, stateExpiryLength, stateExpiryLength+len(payload))
 	binary.PutVarint(msgBuf, expiry)
-	encrypted, err := encoder.Encrypt(msgBuf)
+	encrypted, err := encoder.Encrypt(append(msgBuf, payload...))
 
 	if err != nil {
 		return "", err
@@ -169,78 +78,42 @@ func GenState(encoder *crypt.OmniCrypter) (string, error) {
 	return hex.EncodeToString(encrypted), nil
 }
 
-func ValidateState(decoder *crypt.OmniCrypter, sig string) error {
+// ParseState validates a state's expiry, returning the flow it carries
+func ParseState(decoder *crypt.OmniCrypter, sig string) (*Flow, error) {
 	buf, decodeErr := hex.DecodeString(sig)
 	// str -> []byte
 	if decodeErr != nil {
-		return decodeErr
+		return nil, decodeErr
+	}
+
+	// secretbox prepends a 24 byte nonce
+	if len(buf) < 24 {
+		return nil, errors.New("invalid state")
 	}
 
 	// decrypt
 	decrypted, decryptErr := decoder.Decrypt(buf)
 	if decryptErr != nil {
-		return decryptErr
+		return nil, decryptErr
 	}
 
 	// time validation
 	expiry, expiryErr := binary.ReadVarint(bytes.NewReader(decrypted))
 
 	if expiryErr != nil {
-		return expiryErr
+		return nil, expiryErr
 	}
 
 	// expiry check
 	if time.Now().After(time.Unix(expiry, 0)) {
-		return errors.New("state expired")
-	} else {
-		return nil
+		return nil, errors.New("state expired")
 	}
 
-}
-
-type GoogleUser struct {
-	Sub           string `json:"sub"`
-	GivenName     string `json:"given_name"`
-	FamilyName    string `json:"family_name"`
-	Picture       string `json:"picture"`
-	Email         string `json:"email"`
-	EmailVerified bool   `json:"email_verified"`
-	Gender        string `json:"gender"`
-}
-
-func (self *GoogleUser) ToCass() *cass.User {
-	return &cass.User{
-		Email:            self.Email,
-		GivenName:        self.GivenName,
-		FamilyName:       self.FamilyName,
-		PublicPictureUrl: self.Picture,
-	}
-}
-
-type LoginResponse struct {
-	Jwt  string     `json:"jwt"`
-	User *cass.User `json:"user"`
-}
-
-func (self *GoogleAuthMethods) Router() *route.Router {
-	endpoints := []*route.Endpoint{
-		&route.Endpoint{
-			Method:   http.MethodGet,
-			Handlers: []negroni.Handler{negroni.HandlerFunc(self.Redirect)},
-			SubPath:  "/init",
-		},
-		&route.Endpoint{
-			Method:   http.MethodGet,
-			Handlers: []negroni.Handler{negroni.HandlerFunc(self.HandleAuth)},
-			SubPath:  "/authorize",
-		},
+	flow := &Flow{}
+	if len(decrypted) > stateExpiryLength {
+		if err := json.Unmarshal(decrypted[stateExpiryLength:], flow); err != nil {
+			return nil, err
+		}
 	}
-
-	r := route.Router{
-		Path:      "/auth/google",
-		Endpoints: endpoints,
-		Name:      "beaconRouter",
-	}
-
-	return &r
+	return flow, nil
 }
@@ -0,0 +1,41 @@
+package oauth
+
+import (
+	"html/template"
+	"net/http"
+)
+
+var (
+	errorTemplate = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Sign in failed</title>
+</head>
+<body>
+<h1>Sign in failed</h1>
+<p>{{.Message}}</p>
+{{if .RetryUrl}}<a href="{{.RetryUrl}}">Back to sharecrows</a>{{end}}
+</body>
+</html>
+`))
+)
+
+type errorData struct {
+	Message  string
+	RetryUrl string
+}
+
+// renderError shows a browser friendly error page, as login endpoints are navigated to rather than fetched
+func (self *ProviderMethods) renderError(rw http.ResponseWriter, status int, message string) {
+	data := &errorData{Message: message}
+	if self.Frontend != nil {
+		data.RetryUrl = self.Frontend.Url.String()
+	}
+
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.WriteHeader(status)
+	errorTemplate.Execute(rw, data)
+}
@@ -0,0 +1,98 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/oauth2"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// randomString returns n random bytes, base64url encoded
+func randomString(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// codeChallenge derives the S256 PKCE challenge for a verifier
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// challengeOptions adds PKCE to an authorization url
+func challengeOptions(verifier string) []oauth2.AuthCodeOption {
+	return []oauth2.AuthCodeOption{
+		oauth2.SetAuthURLParam("code_challenge", codeChallenge(verifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	}
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	Error        string `json:"error"`
+}
+
+// exchangeCode trades a code for a token along with its PKCE verifier, which the vendored oauth2 Exchange cannot send
+func exchangeCode(ctx context.Context, conf *oauth2.Config, code string, verifier string) (*oauth2.Token, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {conf.RedirectURL},
+		"client_id":     {conf.ClientID},
+		"client_secret": {conf.ClientSecret},
+		"code_verifier": {verifier},
+	}
+
+	req, reqErr := http.NewRequest(http.MethodPost, conf.Endpoint.TokenURL, strings.NewReader(form.Encode()))
+	if reqErr != nil {
+		return nil, reqErr
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	// some providers (i.e. github) respond with a form encoded body unless asked otherwise
+	req.Header.Set("Accept", "application/json")
+
+	resp, respErr := http.DefaultClient.Do(req.WithContext(ctx))
+	if respErr != nil {
+		return nil, respErr
+	}
+	defer resp.Body.Close()
+
+	data, readErr := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	body := &tokenResponse{}
+	if err := json.Unmarshal(data, body); err != nil {
+		return nil, fmt.Errorf("token exchange failed with status %d", resp.StatusCode)
+	}
+
+	if resp.StatusCode != http.StatusOK || body.AccessToken == "" {
+		return nil, fmt.Errorf("token exchange failed with status %d: %s", resp.StatusCode, body.Error)
+	}
+
+	tok := &oauth2.Token{
+		AccessToken:  body.AccessToken,
+		TokenType:    body.TokenType,
+		RefreshToken: body.RefreshToken,
+	}
+	if body.ExpiresIn > 0 {
+		tok.Expiry = time.Now().Add(time.Duration(body.ExpiresIn) * time.Second)
+	}
+	return tok, nil
+}
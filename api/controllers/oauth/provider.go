@@ -0,0 +1,551 @@
+package oauth
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gocql/gocql"
+	"github.com/owen-d/beacon-api/config"
+	"github.com/owen-d/beacon-api/lib/apierr"
+	"github.com/owen-d/beacon-api/lib/audit"
+	"github.com/owen-d/beacon-api/lib/auth/jwt"
+	"github.com/owen-d/beacon-api/lib/auth/session"
+	"github.com/owen-d/beacon-api/lib/auth/tokens"
+	"github.com/owen-d/beacon-api/lib/cass"
+	"github.com/owen-d/beacon-api/lib/crypt"
+	"github.com/owen-d/beacon-api/lib/route"
+	"github.com/owen-d/beacon-api/lib/validator"
+	"github.com/urfave/negroni"
+	"golang.org/x/oauth2"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// reservedNames would collide with google or the routes of the auth controller
+var reservedNames = map[string]bool{
+	"google":     true,
+	"refresh":    true,
+	"logout":     true,
+	"identities": true,
+	"session":    true,
+}
+
+type ProviderRoutes interface {
+	HandleAuth(http.ResponseWriter, *http.Request, http.HandlerFunc)
+	Redirect(http.ResponseWriter, *http.Request, http.HandlerFunc)
+	Link(http.ResponseWriter, *http.Request, http.HandlerFunc)
+}
+
+// Provider describes a login provider which exposes a userinfo endpoint, as OpenID Connect providers do
+type Provider struct {
+	Name         string
+	Id           cass.ProviderId
+	OAuth        *oauth2.Config
+	UserinfoURL  string
+	SubjectClaim string
+	EmailClaim   string
+}
+
+// NewOIDCProvider builds a provider from config
+func NewOIDCProvider(vars *config.OIDCProvider) (*Provider, error) {
+	if vars.Name == "" || reservedNames[vars.Name] {
+		return nil, errors.New("oidc provider requires a unique name")
+	}
+	if vars.Id == 0 || cass.ProviderId(vars.Id) == cass.Google {
+		return nil, fmt.Errorf("oidc provider %s requires a unique id", vars.Name)
+	}
+	if vars.AuthURL == "" || vars.TokenURL == "" || vars.UserinfoURL == "" {
+		return nil, fmt.Errorf("oidc provider %s requires auth_url, token_url & userinfo_url", vars.Name)
+	}
+
+	p := &Provider{
+		Name: vars.Name,
+		Id:   cass.ProviderId(vars.Id),
+		OAuth: &oauth2.Config{
+			ClientID:     vars.ClientID,
+			ClientSecret: vars.ClientSecret,
+			RedirectURL:  vars.RedirectUri,
+			Scopes:       vars.Scopes,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  vars.AuthURL,
+				TokenURL: vars.TokenURL,
+			},
+		},
+		UserinfoURL:  vars.UserinfoURL,
+		SubjectClaim: vars.SubjectClaim,
+		EmailClaim:   vars.EmailClaim,
+	}
+
+	if p.SubjectClaim == "" {
+		p.SubjectClaim = "sub"
+	}
+	if p.EmailClaim == "" {
+		p.EmailClaim = "email"
+	}
+
+	return p, nil
+}
+
+// NewOIDCProviders builds all configured providers, ensuring their names & ids are unique
+func NewOIDCProviders(vars []config.OIDCProvider) ([]*Provider, error) {
+	providers := make([]*Provider, 0, len(vars))
+	names := map[string]bool{}
+	ids := map[cass.ProviderId]bool{}
+
+	for i := range vars {
+		p, err := NewOIDCProvider(&vars[i])
+		if err != nil {
+			return nil, err
+		}
+		if names[p.Name] || ids[p.Id] {
+			return nil, fmt.Errorf("duplicate oidc provider %s (id %d)", p.Name, p.Id)
+		}
+		names[p.Name], ids[p.Id] = true, true
+		providers = append(providers, p)
+	}
+
+	return providers, nil
+}
+
+const (
+	// nonceCookie binds a login flow to the browser which started it
+	nonceCookie = "sc_oauth_nonce"
+	// linkCookie binds a link ticket to the browser which requested it
+	linkCookie = "sc_oauth_link"
+	// flows must complete within the lifetime of their state
+	nonceCookieMaxAge = 60 * 10
+)
+
+// ProviderMethods handles sign in & account linking for a single provider
+type ProviderMethods struct {
+	Provider   *Provider
+	Coder      *crypt.OmniCrypter
+	CassClient cass.Client
+	Tokens     *tokens.Issuer
+	Auth       jwt.Authenticator
+	Frontend   *Frontend
+	// Sessions is optional, allowing logins to complete with a session cookie via ?mode=session
+	Sessions *session.Manager
+	Audit    *audit.Recorder
+}
+
+type LinkResponse struct {
+	// Url is relative to the api's host & should be navigated to, not fetched
+	Url string `json:"url"`
+}
+
+// Claims are the fields returned by a provider's userinfo endpoint
+type Claims map[string]interface{}
+
+// Subject returns the claim identifying a user. Some providers (i.e. github) use numeric ids.
+func (self Claims) Subject(claim string) string {
+	switch v := self[claim].(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
+
+func (self Claims) str(claim string) string {
+	v, _ := self[claim].(string)
+	return v
+}
+
+// verified reports whether the provider vouches for the email claim. Some providers send email_verified as a string.
+func (self Claims) verified() bool {
+	switch v := self["email_verified"].(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+// ToCass maps standard OpenID Connect claims onto a user. The email is dropped unless the provider verified it, since self registering providers let anyone claim any address.
+func (self Claims) ToCass(emailClaim string) *cass.User {
+	u := &cass.User{
+		GivenName:        self.str("given_name"),
+		FamilyName:       self.str("family_name"),
+		PublicPictureUrl: self.str("picture"),
+	}
+	if self.verified() {
+		u.Email, u.EmailVerified = self.str(emailClaim), true
+	}
+	return u
+}
+
+// exchange validates a state string against the browser's nonce & exchanges the code for a token
+func (self *ProviderMethods) exchange(r *http.Request, state string, code string) (*oauth2.Token, *Flow, error) {
+	flow, validationErr := ParseState(self.Coder, state)
+	if validationErr != nil {
+		return nil, nil, validationErr
+	}
+
+	cookie, cookieErr := r.Cookie(nonceCookie)
+	if cookieErr != nil || flow.Nonce == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(flow.Nonce)) != 1 {
+		return nil, nil, errors.New("this sign in was started in a different browser")
+	}
+
+	tok, exchangeErr := exchangeCode(r.Context(), self.Provider.OAuth, code, flow.Verifier)
+	return tok, flow, exchangeErr
+}
+
+// getClaims will use a token to retrieve the corresponding userinfo
+func (self *ProviderMethods) getClaims(tok *oauth2.Token) (Claims, error) {
+	client := self.Provider.OAuth.Client(oauth2.NoContext, tok)
+
+	resp, fetchErr := client.Get(self.Provider.UserinfoURL)
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+	defer resp.Body.Close()
+
+	data, readErr := ioutil.ReadAll(resp.Body)
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("userinfo request failed with status %d", resp.StatusCode)
+	}
+
+	claims := Claims{}
+	return claims, json.Unmarshal(data, &claims)
+}
+
+// signIn resolves the user linked to an identity, creating the user upon their first sign in
+func (self *ProviderMethods) signIn(ctx context.Context, subject string, profile *cass.User) (*cass.User, error) {
+	ident, fetchErr := self.CassClient.FetchIdentity(ctx, self.Provider.Id, subject)
+	if fetchErr == nil {
+		return self.existing(ctx, ident.UserId, profile)
+	}
+	if fetchErr != gocql.ErrNotFound {
+		return nil, fetchErr
+	}
+
+	// first sign in (or the first since identities were introduced); the user id derives from the provider & subject
+	if res := self.CassClient.CreateUser(ctx, profile, self.Provider.Id, []byte(subject), nil); res.Err != nil {
+		return nil, res.Err
+	}
+
+	linked, linkErr := self.CassClient.LinkIdentity(ctx, &cass.Identity{
+		ProviderId: self.Provider.Id,
+		Subject:    subject,
+		UserId:     profile.Id,
+		LinkedAt:   time.Now(),
+	})
+	if linkErr != nil {
+		return nil, linkErr
+	}
+
+	// a concurrent sign in linked the identity first
+	if linked.UserId.String() != profile.Id.String() {
+		return self.CassClient.FetchUser(ctx, &cass.User{Id: linked.UserId})
+	}
+
+	return profile, nil
+}
+
+// existing fetches a returning user, adopting the provider's email once it has been verified
+func (self *ProviderMethods) existing(ctx context.Context, userId *gocql.UUID, profile *cass.User) (*cass.User, error) {
+	user, err := self.CassClient.FetchUser(ctx, &cass.User{Id: userId})
+	if err != nil || !profile.EmailVerified || (user.EmailVerified && user.Email == profile.Email) {
+		return user, err
+	}
+
+	if res := self.CassClient.VerifyEmail(ctx, userId, profile.Email); res.Err != nil {
+		return nil, res.Err
+	}
+	user.Email, user.EmailVerified = profile.Email, true
+	return user, nil
+}
+
+// HandleAuth handles redirect w/ state & code params. validate state & exchange code for user, then return the browser to the frontend
+func (self *ProviderMethods) HandleAuth(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	params := r.URL.Query()
+
+	// the nonce is single use, regardless of the outcome
+	self.clearCookie(rw, nonceCookie)
+
+	if providerErr := params.Get("error"); providerErr != "" {
+		self.renderError(rw, http.StatusBadRequest, "Sign in was cancelled or denied ("+providerErr+").")
+		return
+	}
+
+	code, state := params.Get("code"), params.Get("state")
+
+	if code == "" || state == "" {
+		self.renderError(rw, http.StatusBadRequest, "Sign in is missing its state & code, please try again.")
+		return
+	}
+
+	token, flow, exchangeErr := self.exchange(r, state, code)
+
+	if exchangeErr != nil {
+		self.renderError(rw, http.StatusBadRequest, "Sign in could not be verified: "+exchangeErr.Error())
+		return
+	}
+
+	claims, claimsErr := self.getClaims(token)
+
+	if claimsErr != nil {
+		self.renderError(rw, http.StatusBadGateway, "Your profile could not be retrieved from "+self.Provider.Name+".")
+		return
+	}
+
+	subject := claims.Subject(self.Provider.SubjectClaim)
+	if subject == "" {
+		self.renderError(rw, http.StatusBadGateway, "Your profile from "+self.Provider.Name+" is missing an id.")
+		return
+	}
+
+	if flow.LinkUser != nil {
+		self.link(rw, r, flow, subject)
+		return
+	}
+
+	cassUser, signInErr := self.signIn(r.Context(), subject, claims.ToCass(self.Provider.EmailClaim))
+
+	if signInErr != nil {
+		self.renderError(rw, http.StatusInternalServerError, "Sign in failed, please try again.")
+		return
+	}
+
+	self.Audit.RecordUser(r, cassUser.Id, audit.Login, self.Provider.Name)
+
+	if flow.Session {
+		csrf, startErr := self.Sessions.Start(r.Context(), rw, cassUser.Id)
+		if startErr != nil {
+			self.renderError(rw, http.StatusInternalServerError, "Sign in failed, please try again.")
+			return
+		}
+		self.complete(rw, r, flow, url.Values{"csrf_token": {csrf}})
+		return
+	}
+
+	pair, issueErr := self.Tokens.Issue(r.Context(), cassUser.Id)
+
+	if issueErr != nil {
+		self.renderError(rw, http.StatusInternalServerError, "Sign in failed, please try again.")
+		return
+	}
+
+	// tokens travel in the fragment, which browsers never send to servers
+	fragment := url.Values{
+		"jwt":           {pair.AccessToken},
+		"expires_in":    {strconv.FormatInt(pair.ExpiresIn, 10)},
+		"refresh_token": {pair.RefreshToken},
+	}
+	self.complete(rw, r, flow, fragment)
+}
+
+// link attaches an identity to an existing user
+func (self *ProviderMethods) link(rw http.ResponseWriter, r *http.Request, flow *Flow, subject string) {
+	linked, linkErr := self.CassClient.LinkIdentity(r.Context(), &cass.Identity{
+		ProviderId: self.Provider.Id,
+		Subject:    subject,
+		UserId:     flow.LinkUser,
+		LinkedAt:   time.Now(),
+	})
+
+	if linkErr != nil {
+		self.renderError(rw, http.StatusInternalServerError, "Linking failed, please try again.")
+		return
+	}
+
+	if linked.UserId.String() != flow.LinkUser.String() {
+		self.renderError(rw, http.StatusConflict, "This "+self.Provider.Name+" account is already linked to another user.")
+		return
+	}
+
+	self.Audit.RecordUser(r, flow.LinkUser, audit.LinkIdentity, self.Provider.Name)
+	self.complete(rw, r, flow, url.Values{"linked": {self.Provider.Name}})
+}
+
+// complete redirects to the flow's return_to, carrying the result in the fragment
+func (self *ProviderMethods) complete(rw http.ResponseWriter, r *http.Request, flow *Flow, fragment url.Values) {
+	returnTo, ok := self.Frontend.ReturnTo(flow.ReturnTo)
+	if !ok {
+		self.renderError(rw, http.StatusBadRequest, "Sign in requested an unknown destination.")
+		return
+	}
+
+	rw.Header().Set("Cache-Control", "no-store")
+	http.Redirect(rw, r, returnTo+"#"+fragment.Encode(), http.StatusFound)
+}
+
+// Redirect starts a login flow: it binds a nonce to the browser & redirects to the provider's login endpoint with a PKCE challenge.
+// A link param (from Link) continues an account linking flow instead.
+func (self *ProviderMethods) Redirect(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	params := r.URL.Query()
+
+	returnTo := params.Get("return_to")
+	if _, ok := self.Frontend.ReturnTo(returnTo); !ok {
+		self.renderError(rw, http.StatusBadRequest, "Sign in requested an unknown destination.")
+		return
+	}
+
+	flow := &Flow{ReturnTo: returnTo}
+
+	switch params.Get("mode") {
+	case "":
+	case "session":
+		if self.Sessions == nil {
+			self.renderError(rw, http.StatusBadRequest, "Session sign in is not enabled.")
+			return
+		}
+		flow.Session = true
+	default:
+		self.renderError(rw, http.StatusBadRequest, "Sign in requested an unknown mode.")
+		return
+	}
+
+	if ticket := params.Get("link"); ticket != "" {
+		self.clearCookie(rw, linkCookie)
+		linkFlow, ticketErr := ParseState(self.Coder, ticket)
+		if ticketErr != nil || linkFlow.LinkUser == nil {
+			self.renderError(rw, http.StatusBadRequest, "This link has expired, please try again.")
+			return
+		}
+
+		// otherwise anyone holding the ticket could link their identity to its user
+		cookie, cookieErr := r.Cookie(linkCookie)
+		if cookieErr != nil || linkFlow.Nonce == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(linkFlow.Nonce)) != 1 {
+			self.renderError(rw, http.StatusBadRequest, "This link was requested in a different browser, please try again.")
+			return
+		}
+		flow.LinkUser = linkFlow.LinkUser
+	}
+
+	nonce, nonceErr := randomString(16)
+	verifier, verifierErr := randomString(32)
+	if nonceErr != nil || verifierErr != nil {
+		self.renderError(rw, http.StatusInternalServerError, "Sign in failed, please try again.")
+		return
+	}
+	flow.Nonce, flow.Verifier = nonce, verifier
+
+	state, stateErr := GenState(self.Coder, flow)
+	if stateErr != nil {
+		self.renderError(rw, http.StatusInternalServerError, "Sign in failed, please try again.")
+		return
+	}
+
+	http.SetCookie(rw, &http.Cookie{
+		Name:     nonceCookie,
+		Value:    nonce,
+		Path:     self.path(),
+		MaxAge:   nonceCookieMaxAge,
+		HttpOnly: true,
+		Secure:   true,
+		// lax cookies survive the top level redirect back from the provider
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(rw, r, self.Provider.OAuth.AuthCodeURL(state, challengeOptions(verifier)...), http.StatusFound)
+}
+
+// Link returns a login url which, once completed, links the provider's identity to the signed in user.
+// The url is returned rather than redirected to, as browsers will not forward the jwt header.
+// It only works in the browser that requested it (with credentials), which holds the ticket's nonce in a cookie.
+func (self *ProviderMethods) Link(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	bindings := r.Context().Value(jwt.JWTNamespace).(*jwt.Bindings)
+
+	returnTo := r.URL.Query().Get("return_to")
+	if _, ok := self.Frontend.ReturnTo(returnTo); !ok {
+		err := &validator.RequestErr{Status: http.StatusBadRequest, Message: "return_to is not allowed"}
+		err.Flush(rw)
+		return
+	}
+
+	nonce, nonceErr := randomString(16)
+	if nonceErr != nil {
+		err := apierr.From(nonceErr)
+		err.Flush(rw)
+		return
+	}
+
+	// the ticket's nonce only matches the link cookie, so it can start a flow but never complete one
+	ticket, ticketErr := GenState(self.Coder, &Flow{LinkUser: bindings.UserId, Nonce: nonce})
+	if ticketErr != nil {
+		err := apierr.From(ticketErr)
+		err.Flush(rw)
+		return
+	}
+
+	http.SetCookie(rw, &http.Cookie{
+		Name:     linkCookie,
+		Value:    nonce,
+		Path:     self.path(),
+		MaxAge:   nonceCookieMaxAge,
+		HttpOnly: true,
+		Secure:   true,
+		// set by the frontend's cross site request, then read upon navigating to the url
+		SameSite: http.SameSiteNoneMode,
+	})
+
+	query := url.Values{"link": {ticket}}
+	if returnTo != "" {
+		query.Set("return_to", returnTo)
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+
+	data, _ := json.Marshal(&LinkResponse{self.path() + "/init?" + query.Encode()})
+	rw.Write(data)
+}
+
+// path is where the provider's endpoints are served, scoping its cookies
+func (self *ProviderMethods) path() string {
+	return "/v1" + self.routerPath()
+}
+
+func (self *ProviderMethods) routerPath() string {
+	return "/auth/" + self.Provider.Name
+}
+
+// clearCookie expires one of the provider's cookies, which must match the path it was set with
+func (self *ProviderMethods) clearCookie(rw http.ResponseWriter, name string) {
+	http.SetCookie(rw, &http.Cookie{Name: name, Value: "", Path: self.path(), MaxAge: -1, HttpOnly: true, Secure: true})
+}
+
+func (self *ProviderMethods) Router() *route.Router {
+	endpoints := []*route.Endpoint{
+		&route.Endpoint{
+			Method:   http.MethodGet,
+			Handlers: []negroni.Handler{negroni.HandlerFunc(self.Redirect)},
+			Response: route.Redirect,
+			SubPath:  "/init",
+		},
+		&route.Endpoint{
+			Method:   http.MethodGet,
+			Handlers: []negroni.Handler{negroni.HandlerFunc(self.HandleAuth)},
+			Response: route.Redirect,
+			SubPath:  "/authorize",
+		},
+		&route.Endpoint{
+			Method:    http.MethodPost,
+			Handlers:  []negroni.Handler{negroni.HandlerFunc(self.Auth.Validate), negroni.HandlerFunc(jwt.NoImpersonation), negroni.HandlerFunc(self.Link)},
+			Request:   route.NoBody,
+			Response:  &LinkResponse{},
+			Sensitive: true,
+			SubPath:   "/link",
+		},
+	}
+
+	r := route.Router{
+		Path:      self.routerPath(),
+		Endpoints: endpoints,
+		Name:      self.Provider.Name + "AuthRouter",
+	}
+
+	return &r
+}
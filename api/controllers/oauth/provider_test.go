@@ -0,0 +1,33 @@
+package oauth
+
+import (
+	"testing"
+)
+
+func TestToCassVerifiedEmail(t *testing.T) {
+	cases := []struct {
+		verified interface{}
+		expected string
+	}{
+		{true, "a@b.c"},
+		{"true", "a@b.c"},
+		{false, ""},
+		{"false", ""},
+		{nil, ""},
+	}
+
+	for _, c := range cases {
+		claims := Claims{"email": "a@b.c", "given_name": "A"}
+		if c.verified != nil {
+			claims["email_verified"] = c.verified
+		}
+
+		u := claims.ToCass("email")
+		if u.Email != c.expected || u.EmailVerified != (c.expected != "") {
+			t.Errorf("email_verified %v: got email %q, verified %v", c.verified, u.Email, u.EmailVerified)
+		}
+		if u.GivenName != "A" {
+			t.Errorf("email_verified %v: lost the profile", c.verified)
+		}
+	}
+}
@@ -0,0 +1,589 @@
+package orgs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/gocql/gocql"
+	"github.com/gorilla/mux"
+	"github.com/owen-d/beacon-api/api/controllers/beacons"
+	"github.com/owen-d/beacon-api/lib/apierr"
+	"github.com/owen-d/beacon-api/lib/audit"
+	"github.com/owen-d/beacon-api/lib/auth/jwt"
+	"github.com/owen-d/beacon-api/lib/auth/orgs"
+	"github.com/owen-d/beacon-api/lib/auth/tokens"
+	"github.com/owen-d/beacon-api/lib/cass"
+	"github.com/owen-d/beacon-api/lib/route"
+	"github.com/owen-d/beacon-api/lib/validator"
+	"github.com/urfave/negroni"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	InvitationTTL = time.Hour * 24 * 7
+)
+
+type OrgRoutes interface {
+	FetchMemberships(http.ResponseWriter, *http.Request, http.HandlerFunc)
+	CreateOrg(http.ResponseWriter, *http.Request, http.HandlerFunc)
+	FetchMembers(http.ResponseWriter, *http.Request, http.HandlerFunc)
+	UpdateMember(http.ResponseWriter, *http.Request, http.HandlerFunc)
+	RemoveMember(http.ResponseWriter, *http.Request, http.HandlerFunc)
+	Invite(http.ResponseWriter, *http.Request, http.HandlerFunc)
+	AcceptInvitation(http.ResponseWriter, *http.Request, http.HandlerFunc)
+	TransferBeacons(http.ResponseWriter, *http.Request, http.HandlerFunc)
+}
+
+type OrgMethods struct {
+	// orgs are managed by signed in users; the org acted upon comes from the path rather than the org header
+	Auth       jwt.Authenticator
+	CassClient cass.Client
+	// events are recorded against the org, so its owners can review them
+	Audit *audit.Recorder
+}
+
+type MembersResponse struct {
+	Members []*cass.Member `json:"members"`
+}
+
+type InvitationResponse struct {
+	*cass.Invitation
+	Token string `json:"token"`
+}
+
+// IncomingOrg is the body of an org's creation
+type IncomingOrg struct {
+	Name string `json:"name" validate:"max=100"`
+}
+
+// Validate fulfills the validator.JSONValidator interface
+func (self *IncomingOrg) Validate(r *http.Request) *validator.RequestErr {
+	return validator.Decode(r, self)
+}
+
+// IncomingMember changes a member's role
+type IncomingMember struct {
+	Role string `json:"role" validate:"enum=owner|editor|viewer"`
+}
+
+// Validate fulfills the validator.JSONValidator interface
+func (self *IncomingMember) Validate(r *http.Request) *validator.RequestErr {
+	return validator.Decode(r, self)
+}
+
+// IncomingInvitation invites an email into an org with a role
+type IncomingInvitation struct {
+	Email string `json:"email" validate:"max=254"`
+	Role  string `json:"role" validate:"enum=owner|editor|viewer"`
+}
+
+// Validate fulfills the validator.JSONValidator interface
+func (self *IncomingInvitation) Validate(r *http.Request) *validator.RequestErr {
+	return validator.Decode(r, self)
+}
+
+// IncomingAcceptance redeems an invitation's token
+type IncomingAcceptance struct {
+	Token string `json:"token" validate:"max=128"`
+}
+
+// Validate fulfills the validator.JSONValidator interface
+func (self *IncomingAcceptance) Validate(r *http.Request) *validator.RequestErr {
+	return validator.Decode(r, self)
+}
+
+// IncomingTransfer lists the beacons to move into an org
+type IncomingTransfer struct {
+	Beacons []*beacons.IncomingBeacon `json:"beacons" validate:"max=250"`
+}
+
+// Validate fulfills the validator.JSONValidator interface
+func (self *IncomingTransfer) Validate(r *http.Request) *validator.RequestErr {
+	return validator.Decode(r, self)
+}
+
+// FetchMemberships lists the orgs the user belongs to
+func (self *OrgMethods) FetchMemberships(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	bindings := r.Context().Value(jwt.JWTNamespace).(*jwt.Bindings)
+
+	members, fetchErr := self.CassClient.FetchUserMemberships(r.Context(), bindings.UserId)
+	if fetchErr != nil {
+		err := apierr.From(fetchErr)
+		err.Flush(rw)
+		return
+	}
+
+	apierr.WriteJSON(rw, http.StatusOK, &MembersResponse{members})
+}
+
+// CreateOrg creates an org, making the user its owner
+func (self *OrgMethods) CreateOrg(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	incoming := &IncomingOrg{}
+	if err := incoming.Validate(r); err != nil {
+		err.Flush(rw)
+		return
+	}
+
+	if strings.TrimSpace(incoming.Name) == "" {
+		err := validator.Collect("invalid org", &validator.FieldError{Field: "name", Message: "required"})
+		err.Flush(rw)
+		return
+	}
+
+	bindings := r.Context().Value(jwt.JWTNamespace).(*jwt.Bindings)
+	id := gocql.TimeUUID()
+	now := time.Now()
+
+	org := &cass.Org{Id: &id, Name: incoming.Name, CreatedAt: now}
+	owner := &cass.Member{OrgId: &id, UserId: bindings.UserId, Role: orgs.Owner, JoinedAt: now}
+
+	if res := self.CassClient.CreateOrg(r.Context(), org, owner); res.Err != nil {
+		err := apierr.From(res.Err)
+		err.Flush(rw)
+		return
+	}
+
+	self.Audit.RecordFor(r, &id, audit.OrgCreate, org.Name, nil, org)
+	apierr.WriteJSON(rw, http.StatusCreated, org)
+}
+
+// FetchMembers lists an org's members to any of its members
+func (self *OrgMethods) FetchMembers(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	orgId, _, ok := self.authorize(rw, r, orgs.Viewer)
+	if !ok {
+		return
+	}
+
+	members, fetchErr := self.CassClient.FetchMembers(r.Context(), orgId)
+	if fetchErr != nil {
+		err := apierr.From(fetchErr)
+		err.Flush(rw)
+		return
+	}
+
+	apierr.WriteJSON(rw, http.StatusOK, &MembersResponse{members})
+}
+
+// UpdateMember changes a member's role. Owners only.
+func (self *OrgMethods) UpdateMember(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	orgId, _, ok := self.authorize(rw, r, orgs.Owner)
+	if !ok {
+		return
+	}
+
+	incoming := &IncomingMember{}
+	if err := incoming.Validate(r); err != nil {
+		err.Flush(rw)
+		return
+	}
+
+	if !orgs.ValidRole(incoming.Role) {
+		err := validator.Collect("invalid member", &validator.FieldError{Field: "role", Message: "must be one of owner, editor, viewer"})
+		err.Flush(rw)
+		return
+	}
+
+	member, ok := self.targetMember(rw, r, orgId)
+	if !ok {
+		return
+	}
+
+	before := *member
+	if member.Role == orgs.Owner && incoming.Role != orgs.Owner {
+		if !self.demoteOwner(r.Context(), rw, member, incoming.Role) {
+			return
+		}
+		member.Role = incoming.Role
+	} else {
+		member.Role = incoming.Role
+		if res := self.CassClient.PutMember(r.Context(), member, nil); res.Err != nil {
+			err := apierr.From(res.Err)
+			err.Flush(rw)
+			return
+		}
+	}
+
+	self.Audit.RecordFor(r, orgId, audit.MemberUpdate, member.UserId.String(), &before, member)
+	apierr.WriteJSON(rw, http.StatusOK, member)
+}
+
+// RemoveMember removes a member from an org. Owners may remove anyone & members may remove themselves.
+func (self *OrgMethods) RemoveMember(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	orgId, caller, ok := self.authorize(rw, r, orgs.Viewer)
+	if !ok {
+		return
+	}
+
+	member, ok := self.targetMember(rw, r, orgId)
+	if !ok {
+		return
+	}
+
+	if caller.Role != orgs.Owner && member.UserId.String() != caller.UserId.String() {
+		err := &validator.RequestErr{Status: http.StatusForbidden, Message: "owner role required"}
+		err.Flush(rw)
+		return
+	}
+
+	if member.Role == orgs.Owner {
+		if !self.demoteOwner(r.Context(), rw, member, "") {
+			return
+		}
+	} else if res := self.CassClient.RemoveMember(r.Context(), orgId, member.UserId); res.Err != nil {
+		err := apierr.From(res.Err)
+		err.Flush(rw)
+		return
+	}
+
+	self.Audit.RecordFor(r, orgId, audit.MemberRemove, member.UserId.String(), member, nil)
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// Invite creates an invitation for an email address, returning its token. Owners only.
+func (self *OrgMethods) Invite(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	orgId, caller, ok := self.authorize(rw, r, orgs.Owner)
+	if !ok {
+		return
+	}
+
+	incoming := &IncomingInvitation{}
+	if err := incoming.Validate(r); err != nil {
+		err.Flush(rw)
+		return
+	}
+
+	var emailErr, roleErr *validator.FieldError
+	if !strings.Contains(incoming.Email, "@") {
+		emailErr = &validator.FieldError{Field: "email", Message: "invalid email"}
+	}
+	if !orgs.ValidRole(incoming.Role) {
+		roleErr = &validator.FieldError{Field: "role", Message: "must be one of owner, editor, viewer"}
+	}
+	if err := validator.Collect("invalid invitation", emailErr, roleErr); err != nil {
+		err.Flush(rw)
+		return
+	}
+
+	token, tokenErr := newInvitationToken()
+	if tokenErr != nil {
+		err := apierr.From(tokenErr)
+		err.Flush(rw)
+		return
+	}
+
+	inv := &cass.Invitation{
+		Hash:      tokens.Hash(token),
+		OrgId:     orgId,
+		Email:     strings.ToLower(strings.TrimSpace(incoming.Email)),
+		Role:      incoming.Role,
+		InvitedBy: caller.UserId,
+		ExpiresAt: time.Now().Add(InvitationTTL),
+	}
+
+	if res := self.CassClient.CreateInvitation(r.Context(), inv); res.Err != nil {
+		err := apierr.From(res.Err)
+		err.Flush(rw)
+		return
+	}
+
+	self.Audit.RecordFor(r, orgId, audit.OrgInvite, inv.Email, nil, inv)
+	apierr.WriteJSON(rw, http.StatusCreated, &InvitationResponse{inv, token})
+}
+
+// AcceptInvitation joins the user to the invitation's org, provided their email matches
+func (self *OrgMethods) AcceptInvitation(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	incoming := &IncomingAcceptance{}
+	if err := incoming.Validate(r); err != nil {
+		err.Flush(rw)
+		return
+	}
+
+	bindings := r.Context().Value(jwt.JWTNamespace).(*jwt.Bindings)
+
+	user, userErr := self.CassClient.FetchUser(r.Context(), &cass.User{Id: bindings.UserId})
+	if userErr != nil {
+		err := apierr.From(userErr)
+		err.Flush(rw)
+		return
+	}
+
+	hash := tokens.Hash(incoming.Token)
+	inv, fetchErr := self.CassClient.FetchInvitation(r.Context(), hash)
+	if fetchErr == gocql.ErrNotFound {
+		err := &validator.RequestErr{Status: http.StatusNotFound, Message: "invitation not found or expired"}
+		err.Flush(rw)
+		return
+	}
+	if fetchErr != nil {
+		err := apierr.From(fetchErr)
+		err.Flush(rw)
+		return
+	}
+
+	// checked before consuming, so the wrong account can't burn the invitation
+	if !user.EmailVerified {
+		err := &validator.RequestErr{Status: http.StatusForbidden, Message: "verify your email with your sign in provider before accepting invitations"}
+		err.Flush(rw)
+		return
+	}
+	if !strings.EqualFold(inv.Email, user.Email) {
+		err := &validator.RequestErr{Status: http.StatusForbidden, Message: "invitation was sent to a different email"}
+		err.Flush(rw)
+		return
+	}
+
+	// the org may have been deleted along with its last member since the invitation was sent
+	if _, orgErr := self.CassClient.FetchOrg(r.Context(), inv.OrgId); orgErr == gocql.ErrNotFound {
+		err := &validator.RequestErr{Status: http.StatusNotFound, Message: "org no longer exists"}
+		err.Flush(rw)
+		return
+	} else if orgErr != nil {
+		err := apierr.From(orgErr)
+		err.Flush(rw)
+		return
+	}
+
+	if res := self.CassClient.ConsumeInvitation(r.Context(), hash); res.Err == gocql.ErrNotFound {
+		err := &validator.RequestErr{Status: http.StatusNotFound, Message: "invitation not found or expired"}
+		err.Flush(rw)
+		return
+	} else if res.Err != nil {
+		err := apierr.From(res.Err)
+		err.Flush(rw)
+		return
+	}
+
+	member := &cass.Member{OrgId: inv.OrgId, UserId: bindings.UserId, Role: inv.Role, JoinedAt: time.Now()}
+
+	// accepting never demotes an existing member
+	if existing, fetchErr := self.CassClient.FetchMember(r.Context(), inv.OrgId, bindings.UserId); fetchErr == nil && orgs.Allows(existing.Role, inv.Role) {
+		self.Audit.RecordFor(r, inv.OrgId, audit.OrgAccept, inv.Email, existing, existing)
+		apierr.WriteJSON(rw, http.StatusOK, existing)
+		return
+	}
+
+	if res := self.CassClient.PutMember(r.Context(), member, nil); res.Err != nil {
+		err := apierr.From(res.Err)
+		err.Flush(rw)
+		return
+	}
+	self.Audit.RecordFor(r, inv.OrgId, audit.OrgAccept, inv.Email, nil, member)
+
+	apierr.WriteJSON(rw, http.StatusCreated, member)
+}
+
+// TransferBeacons moves beacons from the user's own account into an org. Editors & owners only.
+func (self *OrgMethods) TransferBeacons(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	orgId, caller, ok := self.authorize(rw, r, orgs.Editor)
+	if !ok {
+		return
+	}
+
+	incoming := &IncomingTransfer{}
+	if err := incoming.Validate(r); err != nil {
+		err.Flush(rw)
+		return
+	}
+
+	if len(incoming.Beacons) == 0 {
+		err := validator.Collect("invalid transfer", &validator.FieldError{Field: "beacons", Message: "required"})
+		err.Flush(rw)
+		return
+	}
+
+	// only the user's own beacons may be transferred
+	bkns := make([]*cass.Beacon, 0, len(incoming.Beacons))
+	for _, bkn := range incoming.Beacons {
+		bkns = append(bkns, bkn.ToCass(caller.UserId))
+	}
+
+	// unowned beacons are reported as not found & deployed ones as conflicts
+	if res := self.CassClient.TransferBeacons(r.Context(), bkns, orgId); res.Err != nil {
+		apierr.From(res.Err).Flush(rw)
+		return
+	}
+
+	// recorded against both owners, so either's audit shows where the beacons went
+	names := make([]string, 0, len(bkns))
+	for _, bkn := range bkns {
+		names = append(names, hex.EncodeToString(bkn.Name))
+	}
+	for _, owner := range []*gocql.UUID{caller.UserId, orgId} {
+		self.Audit.RecordFor(r, owner, audit.BeaconsTransfer, "beacons", map[string][]string{caller.UserId.String(): names}, map[string][]string{orgId.String(): names})
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// authorize resolves the org in the path & checks the user's role within it, flushing an error if they lack the required role
+func (self *OrgMethods) authorize(rw http.ResponseWriter, r *http.Request, required string) (*gocql.UUID, *cass.Member, bool) {
+	bindings := r.Context().Value(jwt.JWTNamespace).(*jwt.Bindings)
+
+	orgId, parseErr := gocql.ParseUUID(mux.Vars(r)["id"])
+	if parseErr != nil {
+		err := &validator.RequestErr{Status: http.StatusNotFound, Message: "org not found"}
+		err.Flush(rw)
+		return nil, nil, false
+	}
+
+	member, fetchErr := self.CassClient.FetchMember(r.Context(), &orgId, bindings.UserId)
+	if fetchErr == gocql.ErrNotFound {
+		err := &validator.RequestErr{Status: http.StatusNotFound, Message: "org not found"}
+		err.Flush(rw)
+		return nil, nil, false
+	}
+	if fetchErr != nil {
+		err := apierr.From(fetchErr)
+		err.Flush(rw)
+		return nil, nil, false
+	}
+
+	if !orgs.Allows(member.Role, required) {
+		err := &validator.RequestErr{Status: http.StatusForbidden, Message: required + " role required"}
+		err.Flush(rw)
+		return nil, nil, false
+	}
+
+	return &orgId, member, true
+}
+
+// targetMember fetches the member named in the path
+func (self *OrgMethods) targetMember(rw http.ResponseWriter, r *http.Request, orgId *gocql.UUID) (*cass.Member, bool) {
+	userId, parseErr := gocql.ParseUUID(mux.Vars(r)["user_id"])
+	if parseErr != nil {
+		err := &validator.RequestErr{Status: http.StatusNotFound, Message: "member not found"}
+		err.Flush(rw)
+		return nil, false
+	}
+
+	member, fetchErr := self.CassClient.FetchMember(r.Context(), orgId, &userId)
+	if fetchErr == gocql.ErrNotFound {
+		err := &validator.RequestErr{Status: http.StatusNotFound, Message: "member not found"}
+		err.Flush(rw)
+		return nil, false
+	}
+	if fetchErr != nil {
+		err := apierr.From(fetchErr)
+		err.Flush(rw)
+		return nil, false
+	}
+
+	return member, true
+}
+
+// demoteOwner changes an owner's role, or removes them when role is empty, as long as another owner remains.
+// The other owner is checked again as part of the write, so concurrent demotions can't both succeed.
+func (self *OrgMethods) demoteOwner(ctx context.Context, rw http.ResponseWriter, owner *cass.Member, role string) bool {
+	members, fetchErr := self.CassClient.FetchMembers(ctx, owner.OrgId)
+	if fetchErr != nil {
+		err := apierr.From(fetchErr)
+		err.Flush(rw)
+		return false
+	}
+
+	var keeper *cass.Member
+	for _, m := range members {
+		if m.Role == orgs.Owner && m.UserId.String() != owner.UserId.String() {
+			keeper = m
+			break
+		}
+	}
+
+	if keeper == nil {
+		err := &validator.RequestErr{Status: http.StatusConflict, Message: "an org must keep at least one owner"}
+		err.Flush(rw)
+		return false
+	}
+
+	if res := self.CassClient.DemoteOwner(ctx, owner, role, keeper); res.Err == cass.ErrConflict {
+		err := &validator.RequestErr{Status: http.StatusConflict, Message: "the org's owners changed concurrently, please retry"}
+		err.Flush(rw)
+		return false
+	} else if res.Err != nil {
+		err := apierr.From(res.Err)
+		err.Flush(rw)
+		return false
+	}
+	return true
+}
+
+func newInvitationToken() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+func (self *OrgMethods) Router() *route.Router {
+	endpoints := []*route.Endpoint{
+		&route.Endpoint{
+			Method:   http.MethodGet,
+			Handlers: []negroni.Handler{negroni.HandlerFunc(self.FetchMemberships)},
+			Response: &MembersResponse{},
+		},
+		&route.Endpoint{
+			Method:   http.MethodPost,
+			Handlers: []negroni.Handler{negroni.HandlerFunc(self.CreateOrg)},
+			Request:  &IncomingOrg{},
+			Response: &cass.Org{},
+			Status:   http.StatusCreated,
+		},
+		&route.Endpoint{
+			Method:   http.MethodPost,
+			Handlers: []negroni.Handler{negroni.HandlerFunc(self.AcceptInvitation)},
+			Request:  &IncomingAcceptance{},
+			Response: &cass.Member{},
+			Status:   http.StatusCreated,
+			SubPath:  "/invitations/accept",
+		},
+		// /:id routes
+		&route.Endpoint{
+			Method:   http.MethodGet,
+			Handlers: []negroni.Handler{negroni.HandlerFunc(self.FetchMembers)},
+			Response: &MembersResponse{},
+			SubPath:  "/{id}/members",
+		},
+		&route.Endpoint{
+			Method:   http.MethodPut,
+			Handlers: []negroni.Handler{negroni.HandlerFunc(self.UpdateMember)},
+			Request:  &IncomingMember{},
+			Response: &cass.Member{},
+			SubPath:  "/{id}/members/{user_id}",
+		},
+		&route.Endpoint{
+			Method:   http.MethodDelete,
+			Handlers: []negroni.Handler{negroni.HandlerFunc(self.RemoveMember)},
+			Response: route.NoContent,
+			SubPath:  "/{id}/members/{user_id}",
+		},
+		&route.Endpoint{
+			Method:    http.MethodPost,
+			Handlers:  []negroni.Handler{negroni.HandlerFunc(self.Invite)},
+			Request:   &IncomingInvitation{},
+			Response:  &InvitationResponse{},
+			Sensitive: true,
+			Status:    http.StatusCreated,
+			SubPath:   "/{id}/invitations",
+		},
+		&route.Endpoint{
+			Method:   http.MethodPost,
+			Handlers: []negroni.Handler{negroni.HandlerFunc(self.TransferBeacons)},
+			Request:  &IncomingTransfer{},
+			Response: route.NoContent,
+			SubPath:  "/{id}/beacons",
+		},
+	}
+
+	r := route.Router{
+		Path:              "/orgs",
+		Endpoints:         endpoints,
+		DefaultMiddleware: []negroni.Handler{negroni.HandlerFunc(self.Auth.Validate)},
+		Name:              "orgsRouter",
+	}
+
+	return &r
+}
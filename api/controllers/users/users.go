@@ -0,0 +1,298 @@
+package users
+
+import (
+	"context"
+	"github.com/gocql/gocql"
+	"github.com/owen-d/beacon-api/lib/apierr"
+	"github.com/owen-d/beacon-api/lib/audit"
+	"github.com/owen-d/beacon-api/lib/auth/jwt"
+	"github.com/owen-d/beacon-api/lib/auth/orgs"
+	"github.com/owen-d/beacon-api/lib/auth/session"
+	"github.com/owen-d/beacon-api/lib/auth/tokens"
+	"github.com/owen-d/beacon-api/lib/beaconclient"
+	"github.com/owen-d/beacon-api/lib/cass"
+	"github.com/owen-d/beacon-api/lib/route"
+	"github.com/owen-d/beacon-api/lib/validator"
+	"github.com/urfave/negroni"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	MaxNameLength = 100
+)
+
+type UserRoutes interface {
+	FetchMe(http.ResponseWriter, *http.Request, http.HandlerFunc)
+	UpdateMe(http.ResponseWriter, *http.Request, http.HandlerFunc)
+	DeleteMe(http.ResponseWriter, *http.Request, http.HandlerFunc)
+}
+
+type UserMethods struct {
+	// profiles are managed by signed in users, never by api keys
+	Auth         jwt.Authenticator
+	CassClient   cass.Client
+	BeaconClient beaconclient.Client
+	Tokens       *tokens.Issuer
+	Content      *validator.ContentRules
+	// Sessions is optional; when set, deleting an account also clears the session cookies
+	Sessions *session.Manager
+	Audit    *audit.Recorder
+}
+
+// IncomingProfile only changes the fields which are present
+type IncomingProfile struct {
+	GivenName        *string `json:"given_name"`
+	FamilyName       *string `json:"family_name"`
+	PublicPictureUrl *string `json:"public_picture_url"`
+}
+
+// Validate fulfills the validator.JSONValidator interface
+func (self *IncomingProfile) Validate(r *http.Request) *validator.RequestErr {
+	return validator.Decode(r, self)
+}
+
+// Check ensures names are printable & the picture is a link we're willing to display
+func (self *IncomingProfile) Check(rules *validator.ContentRules) *validator.RequestErr {
+	var pictureErr *validator.FieldError
+	if self.PublicPictureUrl != nil && *self.PublicPictureUrl != "" {
+		pictureErr = rules.CheckUrl("public_picture_url", *self.PublicPictureUrl)
+	}
+
+	return validator.Collect("invalid profile",
+		checkName("given_name", self.GivenName),
+		checkName("family_name", self.FamilyName),
+		pictureErr,
+	)
+}
+
+// Apply merges the present fields into a user
+func (self *IncomingProfile) Apply(u *cass.User) {
+	if self.GivenName != nil {
+		u.GivenName = strings.TrimSpace(*self.GivenName)
+	}
+	if self.FamilyName != nil {
+		u.FamilyName = strings.TrimSpace(*self.FamilyName)
+	}
+	if self.PublicPictureUrl != nil {
+		u.PublicPictureUrl = *self.PublicPictureUrl
+	}
+}
+
+func checkName(field string, name *string) *validator.FieldError {
+	if name == nil {
+		return nil
+	}
+
+	if utf8.RuneCountInString(*name) > MaxNameLength {
+		return &validator.FieldError{Field: field, Message: "must be at most 100 characters"}
+	}
+
+	for _, r := range *name {
+		if unicode.IsControl(r) {
+			return &validator.FieldError{Field: field, Message: "must not contain control characters"}
+		}
+	}
+	return nil
+}
+
+// FetchMe returns the signed in user's profile
+func (self *UserMethods) FetchMe(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	user, ok := self.fetchUser(rw, r)
+	if !ok {
+		return
+	}
+
+	apierr.WriteJSON(rw, http.StatusOK, user)
+}
+
+// UpdateMe changes the signed in user's names & picture
+func (self *UserMethods) UpdateMe(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	incoming := &IncomingProfile{}
+	if err := incoming.Validate(r); err != nil {
+		err.Flush(rw)
+		return
+	}
+
+	if err := incoming.Check(self.Content); err != nil {
+		err.Flush(rw)
+		return
+	}
+
+	user, ok := self.fetchUser(rw, r)
+	if !ok {
+		return
+	}
+
+	before := *user
+	incoming.Apply(user)
+
+	res := self.CassClient.UpdateUser(r.Context(), user)
+	if res.Err == gocql.ErrNotFound {
+		err := &validator.RequestErr{Status: http.StatusNotFound, Message: "user not found"}
+		err.Flush(rw)
+		return
+	}
+	if res.Err != nil {
+		err := apierr.From(res.Err)
+		err.Flush(rw)
+		return
+	}
+
+	self.Audit.Record(r, audit.UserUpdate, "me", &before, user)
+	apierr.WriteJSON(rw, http.StatusOK, user)
+}
+
+// DeleteMe removes the signed in user's attachments from their beacons, purges everything they own & revokes their credentials.
+// Outstanding access tokens other than the presented one lapse on their own within the issuer's AccessTTL.
+func (self *UserMethods) DeleteMe(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	bindings := r.Context().Value(jwt.JWTNamespace).(*jwt.Bindings)
+
+	if !self.ensureNotLastOwner(r.Context(), rw, bindings.UserId) {
+		return
+	}
+
+	// orgs the user alone belongs to are purged along with them
+	owners, orgsErr := self.CassClient.FetchSoleOrgs(r.Context(), bindings.UserId)
+	if orgsErr != nil {
+		err := apierr.From(orgsErr)
+		err.Flush(rw)
+		return
+	}
+	owners = append(owners, bindings.UserId)
+
+	names := make([][]byte, 0)
+	for _, owner := range owners {
+		beacons, fetchErr := self.CassClient.FetchUserBeacons(r.Context(), owner)
+		if fetchErr != nil {
+			err := apierr.From(fetchErr)
+			err.Flush(rw)
+			return
+		}
+		for _, bkn := range beacons {
+			names = append(names, bkn.Name)
+		}
+	}
+
+	// attachments are removed first, as the beacons can no longer be found once purged
+	for _, res := range self.BeaconClient.DeclarativeAttach(r.Context(), names, nil) {
+		if res.Err != nil {
+			err := &validator.RequestErr{Status: http.StatusBadGateway, Message: "failed to remove attachments from beacon " + res.Name}
+			err.Flush(rw)
+			return
+		}
+	}
+
+	if res := self.CassClient.DeleteUser(r.Context(), bindings.UserId); res.Err != nil {
+		err := apierr.From(res.Err)
+		err.Flush(rw)
+		return
+	}
+	// audit events outlive the purge, recording that it happened
+	self.Audit.Record(r, audit.UserDelete, "me", nil, nil)
+
+	if bindings.TokenId != "" {
+		if revokeErr := self.Tokens.Revoke(r.Context(), bindings, ""); revokeErr != nil {
+			err := apierr.From(revokeErr)
+			err.Flush(rw)
+			return
+		}
+	}
+
+	if bindings.Session != nil && self.Sessions != nil {
+		self.Sessions.End(rw, r)
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// ensureNotLastOwner prevents deleting a user who is the only owner of an org with other members
+func (self *UserMethods) ensureNotLastOwner(ctx context.Context, rw http.ResponseWriter, userId *gocql.UUID) bool {
+	memberships, fetchErr := self.CassClient.FetchUserMemberships(ctx, userId)
+	if fetchErr != nil {
+		err := apierr.From(fetchErr)
+		err.Flush(rw)
+		return false
+	}
+
+	for _, membership := range memberships {
+		members, membersErr := self.CassClient.FetchMembers(ctx, membership.OrgId)
+		if membersErr != nil {
+			err := apierr.From(membersErr)
+			err.Flush(rw)
+			return false
+		}
+
+		others, otherOwners := 0, 0
+		for _, m := range members {
+			if m.UserId.String() == userId.String() {
+				continue
+			}
+			others++
+			if m.Role == orgs.Owner {
+				otherOwners++
+			}
+		}
+
+		if others > 0 && otherOwners == 0 {
+			err := &validator.RequestErr{Status: http.StatusConflict, Message: "transfer ownership of org " + membership.OrgId.String() + " before deleting your account"}
+			err.Flush(rw)
+			return false
+		}
+	}
+
+	return true
+}
+
+func (self *UserMethods) fetchUser(rw http.ResponseWriter, r *http.Request) (*cass.User, bool) {
+	bindings := r.Context().Value(jwt.JWTNamespace).(*jwt.Bindings)
+
+	user, fetchErr := self.CassClient.FetchUser(r.Context(), &cass.User{Id: bindings.UserId})
+	if fetchErr == gocql.ErrNotFound {
+		err := &validator.RequestErr{Status: http.StatusNotFound, Message: "user not found"}
+		err.Flush(rw)
+		return nil, false
+	}
+	if fetchErr != nil {
+		err := apierr.From(fetchErr)
+		err.Flush(rw)
+		return nil, false
+	}
+
+	return user, true
+}
+
+func (self *UserMethods) Router() *route.Router {
+	endpoints := []*route.Endpoint{
+		&route.Endpoint{
+			Method:   http.MethodGet,
+			Handlers: []negroni.Handler{negroni.HandlerFunc(self.FetchMe)},
+			Response: &cass.User{},
+			SubPath:  "/me",
+		},
+		&route.Endpoint{
+			Method:   http.MethodPatch,
+			Handlers: []negroni.Handler{negroni.HandlerFunc(self.UpdateMe)},
+			Request:  &IncomingProfile{},
+			Response: &cass.User{},
+			SubPath:  "/me",
+		},
+		&route.Endpoint{
+			Method:   http.MethodDelete,
+			Handlers: []negroni.Handler{negroni.HandlerFunc(jwt.NoImpersonation), negroni.HandlerFunc(self.DeleteMe)},
+			Response: route.NoContent,
+			SubPath:  "/me",
+		},
+	}
+
+	r := route.Router{
+		Path:              "/users",
+		DefaultMiddleware: []negroni.Handler{negroni.HandlerFunc(self.Auth.Validate)},
+		Endpoints:         endpoints,
+		Name:              "usersRouter",
+	}
+
+	return &r
+}
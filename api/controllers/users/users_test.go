@@ -0,0 +1,47 @@
+package users
+
+import (
+	"github.com/owen-d/beacon-api/lib/cass"
+	"github.com/owen-d/beacon-api/lib/validator"
+	"strings"
+	"testing"
+)
+
+func str(s string) *string {
+	return &s
+}
+
+func TestCheckProfile(t *testing.T) {
+	rules := &validator.ContentRules{Blocklist: []string{"evil.com"}}
+
+	cases := []struct {
+		name    string
+		profile *IncomingProfile
+		valid   bool
+	}{
+		{"empty", &IncomingProfile{}, true},
+		{"names", &IncomingProfile{GivenName: str("Ada"), FamilyName: str("Lovelace")}, true},
+		{"cleared picture", &IncomingProfile{PublicPictureUrl: str("")}, true},
+		{"picture", &IncomingProfile{PublicPictureUrl: str("https://example.com/me.png")}, true},
+		{"long name", &IncomingProfile{GivenName: str(strings.Repeat("a", MaxNameLength+1))}, false},
+		{"control characters", &IncomingProfile{FamilyName: str("a\nb")}, false},
+		{"blocked picture", &IncomingProfile{PublicPictureUrl: str("https://evil.com/me.png")}, false},
+		{"javascript picture", &IncomingProfile{PublicPictureUrl: str("javascript:alert(1)")}, false},
+	}
+
+	for _, c := range cases {
+		if err := c.profile.Check(rules); (err == nil) != c.valid {
+			t.Error(c.name, "expected valid:", c.valid, "got:", err)
+		}
+	}
+}
+
+func TestApplyProfile(t *testing.T) {
+	u := &cass.User{GivenName: "Ada", FamilyName: "Lovelace", PublicPictureUrl: "https://example.com/me.png"}
+
+	(&IncomingProfile{GivenName: str(" Augusta "), PublicPictureUrl: str("")}).Apply(u)
+
+	if u.GivenName != "Augusta" || u.FamilyName != "Lovelace" || u.PublicPictureUrl != "" {
+		t.Error("unexpected profile:", u)
+	}
+}
@@ -1,58 +1,202 @@
 package api
 
 import (
+	"context"
 	"github.com/gocql/gocql"
 	"github.com/gorilla/mux"
+	"github.com/owen-d/beacon-api/api/controllers/admin"
+	"github.com/owen-d/beacon-api/api/controllers/apikeys"
+	"github.com/owen-d/beacon-api/api/controllers/audit"
+	"github.com/owen-d/beacon-api/api/controllers/auth"
 	"github.com/owen-d/beacon-api/api/controllers/beacons"
 	"github.com/owen-d/beacon-api/api/controllers/deployments"
+	"github.com/owen-d/beacon-api/api/controllers/links"
 	"github.com/owen-d/beacon-api/api/controllers/messages"
 	"github.com/owen-d/beacon-api/api/controllers/oauth"
+	"github.com/owen-d/beacon-api/api/controllers/orgs"
+	"github.com/owen-d/beacon-api/api/controllers/users"
+	"github.com/owen-d/beacon-api/api/scheduler"
 	"github.com/owen-d/beacon-api/config"
+	auditlog "github.com/owen-d/beacon-api/lib/audit"
+	"github.com/owen-d/beacon-api/lib/auth/apikey"
 	"github.com/owen-d/beacon-api/lib/auth/jwt"
+	orgauth "github.com/owen-d/beacon-api/lib/auth/orgs"
+	"github.com/owen-d/beacon-api/lib/auth/session"
+	"github.com/owen-d/beacon-api/lib/auth/tokens"
 	"github.com/owen-d/beacon-api/lib/beaconclient"
 	"github.com/owen-d/beacon-api/lib/cass"
 	"github.com/owen-d/beacon-api/lib/crypt"
+	"github.com/owen-d/beacon-api/lib/health"
+	"github.com/owen-d/beacon-api/lib/idempotency"
+	"github.com/owen-d/beacon-api/lib/logging"
+	"github.com/owen-d/beacon-api/lib/metrics"
+	"github.com/owen-d/beacon-api/lib/ratelimit"
+	"github.com/owen-d/beacon-api/lib/reqid"
 	"github.com/owen-d/beacon-api/lib/route"
+	"github.com/owen-d/beacon-api/lib/validator"
 	"github.com/urfave/negroni"
-	"log"
+	"io/ioutil"
+	"log/slog"
 	"net"
 	"net/http"
+	"strconv"
+	"time"
 )
 
+var apiInfo = &route.Info{Title: "sharecrows api", Version: "1"}
+
 type Env struct {
 	Conf *config.JsonConfig
+	// set by Init, for Serve to shut down
+	health     *health.Checker
+	scheduler  *scheduler.Scheduler
+	cassClient *cass.CassClient
 }
 
 func (self *Env) Init() http.Handler {
 
-	JWTDecoder := jwt.Decoder{[]byte(self.Conf.JWTSecret)}
-	JWTEncoder := jwt.Encoder{[]byte(self.Conf.JWTSecret)}
+	cassClient := createCassClient(self.Conf.CassKeyspace, self.Conf.CassEndpoint)
+
+	keys, keysErr := createKeySet(self.Conf)
+	safeExit(keysErr)
+
+	JWTDecoder := jwt.Decoder{Keys: keys, Revocations: cassClient, Accounts: cassClient}
+	JWTEncoder := jwt.Encoder{Keys: keys}
+	issuer := tokens.NewIssuer(&JWTEncoder, cassClient)
 
 	httpClient := beaconclient.JWTConfigFromJSON(self.Conf.GCloudConfigPath, self.Conf.Scope)
 	svc, bknClientErr := beaconclient.NewBeaconClient(httpClient)
 	safeExit(bknClientErr)
 
-	cassClient := createCassClient(self.Conf.CassKeyspace, self.Conf.CassEndpoint)
+	content := &validator.ContentRules{Blocklist: self.Conf.DomainBlocklist}
+	recorder := &auditlog.Recorder{CassClient: cassClient}
+
+	var sessions *session.Manager
+	if self.Conf.Sessions.Enabled {
+		sessions = session.NewManager(
+			cassClient,
+			self.Conf.Sessions.CookieDomain,
+			time.Minute*time.Duration(self.Conf.Sessions.IdleMinutes),
+			time.Hour*time.Duration(self.Conf.Sessions.MaxAgeHours),
+		)
+	}
+	// user endpoints accept session cookies in addition to jwts
+	userAuthenticator := &session.Authenticator{Next: &JWTDecoder, Sessions: sessions, Decoder: &JWTDecoder}
+
+	// accepts api keys in addition to user jwts & sessions, scoping requests to the user or an org they belong to
+	authenticator := &orgauth.Authorizer{
+		Auth: &session.Authenticator{
+			Next:     &apikey.Authenticator{Decoder: &JWTDecoder, CassClient: cassClient},
+			Sessions: sessions,
+			Decoder:  &JWTDecoder,
+		},
+		CassClient: cassClient,
+	}
+
+	limits := self.Conf.RateLimits
+	deployLimit := ratelimit.New(limits.DeploymentsPerMinute, 0, limits.TrustedHops)
+
+	beacons := beacons.BeaconMethods{authenticator, svc, cassClient, recorder, deployLimit}
+	deployments := deployments.DeploymentMethods{
+		Auth:         authenticator,
+		BeaconClient: svc,
+		CassClient:   cassClient,
+		Content:      content,
+		Audit:        recorder,
+		DeployLimit:  deployLimit,
+	}
+	messages := messages.MessageMethods{
+		Auth:       authenticator,
+		CassClient: cassClient,
+		Content:    content,
+		Audit:      recorder,
+	}
+	links := links.LinkMethods{CassClient: cassClient}
+
+	// switch scheduled deployments in the background
+	self.scheduler = scheduler.NewScheduler(cassClient, svc, scheduler.DefaultInterval)
+	go self.scheduler.Run()
 
-	beacons := beacons.BeaconMethods{JWTDecoder, svc, cassClient}
-	deployments := deployments.DeploymentMethods{JWTDecoder, svc, cassClient}
-	messages := messages.MessageMethods{JWTDecoder, cassClient}
+	self.cassClient = cassClient
+	self.health = &health.Checker{Checks: map[string]health.Check{
+		"cassandra": cassClient.Ping,
+		"proximity": svc.Ping,
+	}}
 
 	googleCrypter, googleCrypterErr := crypt.NewOmniCrypter(self.Conf.GoogleOAuth.StateKey)
 	safeExit(googleCrypterErr)
-	googleOAuth := oauth.GoogleAuthMethods{
-		OAuth:      oauth.NewOAuthConf(&self.Conf.GoogleOAuth),
+	frontend, frontendErr := oauth.NewFrontend(self.Conf.FrontendURL, self.Conf.ReturnToAllowlist)
+	safeExit(frontendErr)
+	googleOAuth := oauth.ProviderMethods{
+		Provider:   oauth.NewGoogleProvider(&self.Conf.GoogleOAuth),
 		Coder:      googleCrypter,
 		CassClient: cassClient,
-		JWTEncoder: &JWTEncoder,
+		Tokens:     issuer,
+		Auth:       userAuthenticator,
+		Frontend:   frontend,
+		Sessions:   sessions,
+		Audit:      recorder,
+	}
+	auth := auth.AuthMethods{
+		JWTDecoder: JWTDecoder,
+		Auth:       userAuthenticator,
+		Tokens:     issuer,
+		CassClient: cassClient,
+		Sessions:   sessions,
+		Audit:      recorder,
+	}
+	apiKeys := apikeys.APIKeyMethods{Auth: userAuthenticator, CassClient: cassClient, Audit: recorder}
+	orgs := orgs.OrgMethods{Auth: userAuthenticator, CassClient: cassClient, Audit: recorder}
+	audit := audit.AuditMethods{Auth: authenticator, CassClient: cassClient}
+	admin := admin.AdminMethods{Auth: userAuthenticator, CassClient: cassClient, Tokens: issuer, Audit: recorder, Events: &audit}
+	users := users.UserMethods{
+		Auth:         userAuthenticator,
+		CassClient:   cassClient,
+		BeaconClient: svc,
+		Tokens:       issuer,
+		Content:      content,
+		Sessions:     sessions,
+		Audit:        recorder,
 	}
 
-	v1Router := &route.Router{
-		Path:      "/v1",
-		SubRoutes: []*route.Router{beacons.Router(), deployments.Router(), messages.Router(), googleOAuth.Router()},
+	// additional login providers share google's state key
+	providers, providersErr := oauth.NewOIDCProviders(self.Conf.OIDCProviders)
+	safeExit(providersErr)
+	providerMethods := []*oauth.ProviderMethods{&googleOAuth}
+	for _, provider := range providers {
+		providerMethods = append(providerMethods, &oauth.ProviderMethods{
+			Provider:   provider,
+			Coder:      googleCrypter,
+			CassClient: cassClient,
+			Tokens:     issuer,
+			Auth:       userAuthenticator,
+			Frontend:   frontend,
+			Sessions:   sessions,
+			Audit:      recorder,
+		})
 	}
 
-	// default root handler (for healthchecks/welcome msg)
+	v1Router, routers := routeTree(&handlers{
+		beacons:     &beacons,
+		deployments: &deployments,
+		messages:    &messages,
+		providers:   providerMethods,
+		auth:        &auth,
+		apiKeys:     &apiKeys,
+		orgs:        &orgs,
+		users:       &users,
+		admin:       &admin,
+		audit:       &audit,
+		links:       &links,
+		jwks:        keys.ServeJWKS,
+		redocPath:   self.Conf.RedocPath,
+	})
+	v1Router.RateLimit = ratelimit.New(limits.PerMinute, limits.Burst, limits.TrustedHops)
+	v1Router.IPRateLimit = ratelimit.New(limits.PerIPPerMinute, 0, limits.TrustedHops)
+	// mobile clients retry deployments over flaky connections
+	v1Router.Idempotency = idempotency.New(cassClient, time.Hour*time.Duration(self.Conf.IdempotencyHours))
+
+	// default root handler (welcome msg)
 	root := mux.NewRouter()
 	root.HandleFunc("/", func(rw http.ResponseWriter, r *http.Request) {
 		rw.Header().Set("Content-Type", "application/json")
@@ -60,9 +204,112 @@ func (self *Env) Init() http.Handler {
 
 		rw.Write([]byte("welcome to the sharecrows api"))
 	})
+	// scraped by prometheus; outside the versioned api so it's neither rate limited nor documented
+	root.Handle("/metrics", metrics.Default).Methods(http.MethodGet)
+	// kubernetes probes: liveness restarts a wedged process, readiness holds traffic while cassandra or google are unreachable
+	root.HandleFunc("/healthz", self.health.Live).Methods(http.MethodGet)
+	root.HandleFunc("/readyz", self.health.Ready).Methods(http.MethodGet)
+
+	for _, router := range routers {
+		policy, ok := self.Conf.Cors.Routers[router.Path]
+		if !ok {
+			policy = self.Conf.Cors.CorsPolicy
+		}
+		cors, corsErr := createCors(policy, frontend)
+		safeExit(corsErr)
+		router.Cors = cors
+	}
+
+	// only v1 is versioned: jwks & public short links attached to beacons live outside it
+	for _, router := range routers {
+		root = route.Inject(router, root)
+	}
+
+	return negroni.New(negroni.HandlerFunc(reqid.Middleware), negroni.HandlerFunc(logging.Middleware), negroni.Wrap(root))
+}
 
-	root = route.Inject(v1Router, root)
-	return negroni.New(negroni.NewLogger(), route.CorsHandler, negroni.Wrap(root))
+// handlers are the controllers mounted by Init
+type handlers struct {
+	beacons     *beacons.BeaconMethods
+	deployments *deployments.DeploymentMethods
+	messages    *messages.MessageMethods
+	providers   []*oauth.ProviderMethods
+	auth        *auth.AuthMethods
+	apiKeys     *apikeys.APIKeyMethods
+	orgs        *orgs.OrgMethods
+	users       *users.UserMethods
+	admin       *admin.AdminMethods
+	audit       *audit.AuditMethods
+	links       *links.LinkMethods
+	jwks        negroni.HandlerFunc
+	redocPath   string
+}
+
+// routeTree builds every router Init mounts: the versioned api, public keys for verifying our jwts & public short links.
+// The openapi document, served under /v1, describes each of them including itself.
+func routeTree(h *handlers) (*route.Router, []*route.Router) {
+	v1Router := &route.Router{
+		Path:      "/v1",
+		SubRoutes: []*route.Router{h.beacons.Router(), h.deployments.Router(), h.messages.Router()},
+	}
+	for _, provider := range h.providers {
+		v1Router.SubRoutes = append(v1Router.SubRoutes, provider.Router())
+	}
+	v1Router.SubRoutes = append(v1Router.SubRoutes, h.auth.Router(), h.apiKeys.Router(), h.orgs.Router(), h.users.Router(), h.admin.Router(), h.audit.Router())
+
+	wellKnown := &route.Router{
+		Path: "/.well-known",
+		Endpoints: []*route.Endpoint{
+			&route.Endpoint{
+				Method:   http.MethodGet,
+				Handlers: []negroni.Handler{h.jwks},
+				SubPath:  "/jwks.json",
+				Response: &jwt.JWKS{},
+			},
+		},
+		Name: "wellKnownRouter",
+	}
+
+	routers := []*route.Router{v1Router, wellKnown, h.links.Router()}
+	docs := &route.Docs{Info: apiInfo, Routers: routers, RedocPath: h.redocPath}
+	v1Router.Endpoints = append(v1Router.Endpoints, docs.Endpoints()...)
+
+	return v1Router, routers
+}
+
+// Serve listens on the configured port until ctx is done, then drains: readiness fails, in flight requests finish & background work stops
+func (self *Env) Serve(ctx context.Context) error {
+	server := &http.Server{
+		Addr:              ":" + strconv.Itoa(self.Conf.Port),
+		Handler:           self.Init(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		slog.Info("sharecrows api live", "port", self.Conf.Port)
+		errCh <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down")
+	self.health.Drain()
+	// keep serving until readiness probes have failed & endpoints stop routing to us
+	time.Sleep(time.Duration(self.Conf.DrainSeconds) * time.Second)
+
+	timeout := time.Duration(self.Conf.ShutdownSeconds) * time.Second
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := server.Shutdown(shutdownCtx)
+	self.scheduler.Stop(shutdownCtx)
+	self.cassClient.Sess.Close()
+	return err
 }
 
 func createCassClient(keyspace string, address string) *cass.CassClient {
@@ -72,20 +319,77 @@ func createCassClient(keyspace string, address string) *cass.CassClient {
 
 	addrs, lookupErr := net.LookupHost(address)
 	if lookupErr != nil {
-		log.Fatal("couldn't match cassandra host:\n", lookupErr)
+		logging.Fatal("couldn't match cassandra host", lookupErr)
 	}
 
 	cluster := gocql.NewCluster(addrs...)
 	client, err := cass.Connect(cluster, keyspace)
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal("couldn't connect to cassandra", err)
 	}
 
 	return client
 }
 
+// createCors allows the frontend's origins unless the policy names its own
+func createCors(policy config.CorsPolicy, frontend *oauth.Frontend) (*route.Cors, error) {
+	origins := policy.AllowedOrigins
+	if len(origins) == 0 {
+		origins = frontend.Origins()
+	}
+
+	return route.NewCors(route.CorsOptions{
+		AllowedOrigins:   origins,
+		AllowedHeaders:   policy.AllowedHeaders,
+		ExposedHeaders:   policy.ExposedHeaders,
+		AllowCredentials: policy.AllowCredentials,
+		MaxAge:           time.Duration(policy.MaxAgeSeconds) * time.Second,
+	})
+}
+
+// createKeySet loads the configured jwt keys, falling back to the legacy secret for signing when none are configured
+func createKeySet(conf *config.JsonConfig) (*jwt.KeySet, error) {
+	keys := make([]*jwt.Key, 0, len(conf.JWTKeys)+1)
+
+	if conf.JWTSecret != "" {
+		legacy, err := jwt.NewHMACKey(jwt.LegacyKeyId, []byte(conf.JWTSecret))
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, legacy)
+	}
+
+	for _, k := range conf.JWTKeys {
+		if k.Secret != "" {
+			key, err := jwt.NewHMACKey(k.Id, []byte(k.Secret))
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+			continue
+		}
+
+		data, readErr := ioutil.ReadFile(k.Path)
+		if readErr != nil {
+			return nil, readErr
+		}
+		key, err := jwt.ParseKey(k.Id, data)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	signingKeyId := conf.JWTSigningKey
+	if signingKeyId == "" {
+		signingKeyId = jwt.LegacyKeyId
+	}
+
+	return jwt.NewKeySet(signingKeyId, conf.JWTIssuer, conf.JWTAudience, keys...)
+}
+
 func safeExit(e error) {
 	if e != nil {
-		log.Fatal(e)
+		logging.Fatal("couldn't start", e)
 	}
 }
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/owen-d/beacon-api/api/controllers/admin"
+	"github.com/owen-d/beacon-api/api/controllers/apikeys"
+	"github.com/owen-d/beacon-api/api/controllers/audit"
+	"github.com/owen-d/beacon-api/api/controllers/auth"
+	"github.com/owen-d/beacon-api/api/controllers/beacons"
+	"github.com/owen-d/beacon-api/api/controllers/deployments"
+	"github.com/owen-d/beacon-api/api/controllers/links"
+	"github.com/owen-d/beacon-api/api/controllers/messages"
+	"github.com/owen-d/beacon-api/api/controllers/oauth"
+	"github.com/owen-d/beacon-api/api/controllers/orgs"
+	"github.com/owen-d/beacon-api/api/controllers/users"
+	"github.com/owen-d/beacon-api/lib/auth/session"
+	"github.com/owen-d/beacon-api/lib/route"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type stubAuth struct{}
+
+func (self *stubAuth) Validate(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	next(rw, r)
+}
+
+// documentedTree builds the routers mounted by Init, with every optional router enabled
+func documentedTree() (*route.Router, []*route.Router) {
+	a := &stubAuth{}
+	sessions := &session.Manager{}
+
+	return routeTree(&handlers{
+		beacons:     &beacons.BeaconMethods{Auth: a},
+		deployments: &deployments.DeploymentMethods{Auth: a},
+		messages:    &messages.MessageMethods{Auth: a},
+		providers:   []*oauth.ProviderMethods{&oauth.ProviderMethods{Provider: &oauth.Provider{Name: "google"}, Auth: a, Sessions: sessions}},
+		auth:        &auth.AuthMethods{Auth: a, Sessions: sessions},
+		apiKeys:     &apikeys.APIKeyMethods{Auth: a},
+		orgs:        &orgs.OrgMethods{Auth: a},
+		users:       &users.UserMethods{Auth: a},
+		admin:       &admin.AdminMethods{Auth: a},
+		audit:       &audit.AuditMethods{Auth: a},
+		links:       &links.LinkMethods{},
+		jwks:        a.Validate,
+	})
+}
+
+func TestRoutesDocumented(t *testing.T) {
+	_, routers := documentedTree()
+	if len(routers) != 3 {
+		t.Fatalf("expected the v1, well known & links routers, got %d", len(routers))
+	}
+
+	for _, missing := range route.Undocumented(routers...) {
+		t.Error("missing request or response schema:", missing)
+	}
+}
+
+func TestServeSpec(t *testing.T) {
+	v1, routers := documentedTree()
+	root := mux.NewRouter()
+	for _, router := range routers {
+		root = route.Inject(router, root)
+	}
+
+	rw := httptest.NewRecorder()
+	root.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, v1.Path+"/openapi.json", nil))
+
+	spec := &route.OpenAPI{}
+	if err := json.Unmarshal(rw.Body.Bytes(), spec); err != nil {
+		t.Fatal("invalid spec:", err)
+	}
+
+	op := spec.Paths["/v1/orgs/{id}/members/{user_id}"]["put"]
+	if op == nil || op.OperationId != "UpdateMember" || len(op.Parameters) != 2 || op.RequestBody == nil {
+		t.Errorf("expected the member update to be documented, got %+v", op)
+	}
+	if _, ok := spec.Paths["/v1/openapi.json"]; !ok {
+		t.Error("expected the spec to describe itself")
+	}
+	if _, ok := spec.Paths["/.well-known/jwks.json"]; !ok {
+		t.Error("expected the spec to describe the well known keys")
+	}
+}
+
+// unaudited mutations change nothing an owner would review
+var unaudited = map[string]bool{
+	// rotates the caller's own refresh token
+	"Refresh": true,
+	// only issues a ticket; the identity is recorded once linked, by the provider's redirect
+	"Link": true,
+}
+
+func TestMutationsAudited(t *testing.T) {
+	// a handler records its change if it calls Record, RecordFor or RecordUser itself
+	recorded := map[string]bool{}
+	fset := token.NewFileSet()
+	dirs, _ := filepath.Glob("controllers/*")
+	for _, dir := range dirs {
+		pkgs, parseErr := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool { return !strings.HasSuffix(fi.Name(), "_test.go") }, 0)
+		if parseErr != nil {
+			t.Fatal(parseErr)
+		}
+		for _, pkg := range pkgs {
+			for _, file := range pkg.Files {
+				for _, decl := range file.Decls {
+					fn, ok := decl.(*ast.FuncDecl)
+					if !ok || fn.Recv == nil || fn.Body == nil {
+						continue
+					}
+					ast.Inspect(fn.Body, func(n ast.Node) bool {
+						if call, ok := n.(*ast.CallExpr); ok {
+							if sel, ok := call.Fun.(*ast.SelectorExpr); ok && strings.HasPrefix(sel.Sel.Name, "Record") {
+								recorded[fn.Name.Name] = true
+							}
+						}
+						return true
+					})
+				}
+			}
+		}
+	}
+
+	_, routers := documentedTree()
+	for p, ops := range route.Spec(&route.Info{}, routers...).Paths {
+		for method, op := range ops {
+			if method == "get" || unaudited[op.OperationId] {
+				continue
+			}
+			if !recorded[op.OperationId] {
+				t.Errorf("%s %s (%s) changes state without an audit event", strings.ToUpper(method), p, op.OperationId)
+			}
+		}
+	}
+}
@@ -0,0 +1,177 @@
+// Package scheduler switches scheduled deployments to the message their schedule calls for
+package scheduler
+
+import (
+	"context"
+	"github.com/owen-d/beacon-api/lib/beaconclient"
+	"github.com/owen-d/beacon-api/lib/cass"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultInterval = time.Minute
+)
+
+// Scheduler polls every scheduled deployment on an interval. Transitions are claimed via a conditional update, so several replicas may run one safely.
+type Scheduler struct {
+	CassClient   cass.Client
+	BeaconClient beaconclient.Client
+	Interval     time.Duration
+	done         chan struct{}
+	stopped      chan struct{}
+	stop         sync.Once
+	// cancels a tick in progress once Stop's ctx is done
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewScheduler(cassClient cass.Client, beaconClient beaconclient.Client, interval time.Duration) *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{
+		CassClient:   cassClient,
+		BeaconClient: beaconClient,
+		Interval:     interval,
+		done:         make(chan struct{}),
+		stopped:      make(chan struct{}),
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+}
+
+// Run ticks until Stop is called
+func (self *Scheduler) Run() {
+	defer close(self.stopped)
+	ticker := time.NewTicker(self.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			// a tick mustn't overrun the next one
+			ctx, cancel := context.WithTimeout(self.ctx, self.Interval)
+			for _, err := range self.Tick(ctx, now) {
+				slog.ErrorContext(ctx, "scheduler: failed to switch deployment", "error", err)
+			}
+			cancel()
+		case <-self.done:
+			return
+		}
+	}
+}
+
+// Stop ends Run, waiting for a tick in progress to finish so deployments aren't left half switched. Run must have been called.
+// Once ctx is done the tick is cancelled instead: calls already started complete, while transitions not yet made are retried by the next scheduler.
+func (self *Scheduler) Stop(ctx context.Context) {
+	self.stop.Do(func() { close(self.done) })
+	select {
+	case <-self.stopped:
+	case <-ctx.Done():
+		self.cancel()
+		<-self.stopped
+	}
+	self.cancel()
+}
+
+// Tick advances every scheduled deployment to the message due at now
+func (self *Scheduler) Tick(ctx context.Context, now time.Time) []error {
+	deps, fetchErr := self.CassClient.FetchScheduledDeployments(ctx)
+	if fetchErr != nil {
+		return []error{fetchErr}
+	}
+
+	errs := make([]error, 0)
+	for _, dep := range deps {
+		errs = append(errs, self.advance(ctx, dep, now)...)
+	}
+	return errs
+}
+
+// advance switches a single deployment's beacons, message & attachments when its schedule calls for a different message
+func (self *Scheduler) advance(ctx context.Context, ref *cass.Deployment, now time.Time) []error {
+	meta, metaErr := self.CassClient.FetchDeploymentMetadata(ctx, ref.UserId, ref.DeployName)
+	if metaErr != nil {
+		return []error{metaErr}
+	}
+
+	// the index may briefly outlive a schedule's removal
+	if meta.Schedule == nil {
+		return nil
+	}
+
+	next := meta.Schedule.MessageAt(now)
+	if next == "" || next == meta.MessageName {
+		return nil
+	}
+
+	claimed, claimErr := self.CassClient.ClaimDeploymentMessage(ctx, meta, next)
+	if claimErr != nil {
+		return []error{claimErr}
+	}
+
+	// another scheduler already made this transition
+	if !claimed {
+		return nil
+	}
+
+	errs := self.switchMessage(ctx, meta, next)
+	if len(errs) == 0 {
+		return nil
+	}
+
+	// hand the transition back, so the next tick retries it rather than leaving beacons on the old message.
+	// The tick may have run out of time, so the rollback gets its own.
+	rollbackCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cass.QueryTimeout)
+	defer cancel()
+	switched := &cass.Deployment{UserId: meta.UserId, DeployName: meta.DeployName, MessageName: next}
+	if _, rollbackErr := self.CassClient.ClaimDeploymentMessage(rollbackCtx, switched, meta.MessageName); rollbackErr != nil {
+		errs = append(errs, rollbackErr)
+	}
+	return errs
+}
+
+// switchMessage rewrites a claimed deployment's beacons, metadata & attachments to the next message
+func (self *Scheduler) switchMessage(ctx context.Context, meta *cass.Deployment, next string) []error {
+	bkns, bknsErr := self.CassClient.FetchDeploymentBeacons(ctx, meta)
+	if bknsErr != nil {
+		return []error{bknsErr}
+	}
+
+	bNames := make([][]byte, 0, len(bkns))
+	for _, bkn := range bkns {
+		bNames = append(bNames, bkn.Name)
+	}
+
+	dep := &cass.Deployment{
+		UserId:      meta.UserId,
+		DeployName:  meta.DeployName,
+		MessageName: next,
+		BeaconNames: bNames,
+		// the metadata row is rewritten whole, so everything but the message is carried over
+		Rules:    meta.Rules,
+		Schedule: meta.Schedule,
+		Variants: meta.Variants,
+	}
+
+	// rewrites msg_url on every beacon & the deployment's metadata
+	if res := self.CassClient.PostDeployment(ctx, dep); res.Err != nil {
+		return []error{res.Err}
+	}
+
+	attachment := &beaconclient.AttachmentData{
+		Title: dep.Message.Title,
+	}
+
+	errs := make([]error, 0)
+	for _, attachRes := range self.BeaconClient.DeclarativeAttach(ctx, dep.BeaconNames, attachment) {
+		if attachRes.Err != nil {
+			errs = append(errs, attachRes.Err)
+		}
+	}
+
+	if len(errs) == 0 {
+		slog.InfoContext(ctx, "scheduler: switched deployment", "user_id", dep.UserId.String(), "deployment", dep.DeployName, "message", next)
+	}
+	return errs
+}
@@ -0,0 +1,108 @@
+package scheduler
+
+import (
+	"context"
+	"github.com/gocql/gocql"
+	"github.com/owen-d/beacon-api/lib/beaconclient"
+	"github.com/owen-d/beacon-api/lib/cass"
+	"github.com/owen-d/beacon-api/lib/redirect"
+	"github.com/owen-d/beacon-api/lib/schedule"
+	"testing"
+	"time"
+)
+
+// stalled never answers before its ctx is done, like an unreachable cassandra
+type stalled struct {
+	cass.Client
+	fetching chan struct{}
+}
+
+func (self *stalled) FetchScheduledDeployments(ctx context.Context) ([]*cass.Deployment, error) {
+	select {
+	case self.fetching <- struct{}{}:
+	default:
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestStopBoundsTick(t *testing.T) {
+	client := &stalled{fetching: make(chan struct{}, 1)}
+	// a tick may run for the whole interval
+	interval := 500 * time.Millisecond
+	s := NewScheduler(client, nil, interval)
+	go s.Run()
+	<-client.fetching
+
+	// shutdown has already run out of time
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	s.Stop(ctx)
+	if elapsed := time.Since(start); elapsed > interval/2 {
+		t.Error("expected Stop to cancel the tick once its ctx was done, took", elapsed)
+	}
+}
+
+// deployments holds a single scheduled deployment in memory, recording what each transition writes
+type deployments struct {
+	cass.Client
+	meta   *cass.Deployment
+	posted *cass.Deployment
+}
+
+func (self *deployments) FetchScheduledDeployments(ctx context.Context) ([]*cass.Deployment, error) {
+	return []*cass.Deployment{self.meta}, nil
+}
+
+func (self *deployments) FetchDeploymentMetadata(ctx context.Context, userId *gocql.UUID, deployName string) (*cass.Deployment, error) {
+	return self.meta, nil
+}
+
+func (self *deployments) ClaimDeploymentMessage(ctx context.Context, dep *cass.Deployment, next string) (bool, error) {
+	return true, nil
+}
+
+func (self *deployments) FetchDeploymentBeacons(ctx context.Context, dep *cass.Deployment) ([]*cass.Beacon, error) {
+	return nil, nil
+}
+
+func (self *deployments) PostDeployment(ctx context.Context, dep *cass.Deployment) *cass.UpsertResult {
+	dep.Message = &cass.Message{Name: dep.MessageName}
+	self.posted = dep
+	return &cass.UpsertResult{}
+}
+
+// attacher succeeds without calling google
+type attacher struct {
+	beaconclient.Client
+}
+
+func (self *attacher) DeclarativeAttach(ctx context.Context, bNames [][]byte, attachment *beaconclient.AttachmentData) []*beaconclient.AttachmentResult {
+	return nil
+}
+
+func TestTickKeepsVariants(t *testing.T) {
+	userId := gocql.TimeUUID()
+	variants := []*redirect.Variant{{MessageName: "a", Weight: 1}, {MessageName: "b", Weight: 1}}
+	client := &deployments{meta: &cass.Deployment{
+		UserId:      &userId,
+		DeployName:  "dep",
+		MessageName: "a",
+		Schedule:    &schedule.Schedule{TimeZone: "UTC", DefaultMessageName: "b"},
+		Variants:    variants,
+	}}
+
+	s := NewScheduler(client, &attacher{}, time.Minute)
+	if errs := s.Tick(context.Background(), time.Now()); len(errs) != 0 {
+		t.Fatal("expected the tick to succeed, got", errs)
+	}
+
+	if client.posted == nil || client.posted.MessageName != "b" {
+		t.Fatalf("expected the deployment to switch to b, got %+v", client.posted)
+	}
+	if len(client.posted.Variants) != len(variants) {
+		t.Errorf("expected the variants to survive the transition, got %v", client.posted.Variants)
+	}
+}
@@ -16,6 +16,37 @@ type JsonConfig struct {
 	CassKeyspace     string
 	Port             int
 	GoogleOAuth      OAuth `json:"googleOAuth`
+	// JWTKeys rotate by adding a key, pointing JWTSigningKey at it & removing the old key once its tokens have expired.
+	// JWTSecret remains valid for tokens without a kid, & signs new tokens when no JWTKeys are configured.
+	JWTKeys       []JWTKey `json:"jwtKeys"`
+	JWTSigningKey string   `json:"jwtSigningKey"`
+	JWTIssuer     string   `json:"jwtIssuer"`
+	JWTAudience   string   `json:"jwtAudience"`
+	// OIDCProviders are additional login providers, served at /v1/auth/<name>
+	OIDCProviders []OIDCProvider `json:"oidcProviders"`
+	// FrontendURL is where browsers land after signing in. ReturnToAllowlist holds other origins a login may return to.
+	FrontendURL       string   `json:"frontendUrl"`
+	ReturnToAllowlist []string `json:"returnToAllowlist"`
+	// Sessions optionally lets the frontend authenticate via cookies instead of jwt headers
+	Sessions Sessions `json:"sessions"`
+	// Cors governs which browser origins may call the api
+	Cors Cors `json:"cors"`
+	// RateLimits throttle each user, or ip for anonymous requests
+	RateLimits RateLimits `json:"rateLimits"`
+	// IdempotencyHours is how long responses to requests sent with an Idempotency-Key are replayed for
+	IdempotencyHours int `json:"idempotencyHours"`
+	// DomainBlocklist holds domains (& their subdomains) which messages may not link to
+	DomainBlocklist []string `json:"domainBlocklist"`
+	// LogLevel is one of debug, info, warn or error, defaulting to info
+	LogLevel string `json:"logLevel"`
+	// RedocPath is the vendored redoc bundle (see `make redoc`) rendering /v1/docs, relative to the working directory
+	RedocPath string `json:"redocPath"`
+	// DrainSeconds is how long readiness fails after SIGTERM before the server stops accepting connections, letting the pod leave its endpoints first.
+	// It should cover the readiness probe's period times its failure threshold.
+	DrainSeconds int `json:"drainSeconds"`
+	// ShutdownSeconds then bounds how long in flight requests & background work may take to finish.
+	// Together with DrainSeconds, it should stay under the pod's termination grace period.
+	ShutdownSeconds int `json:"shutdownSeconds"`
 }
 
 type OAuth struct {
@@ -26,6 +57,65 @@ type OAuth struct {
 	StateKey     string   `json:"state_key"`
 }
 
+type Sessions struct {
+	Enabled bool `json:"enabled"`
+	// CookieDomain lets the frontend read the csrf cookie when it is served from a sibling domain, i.e. sharecro.ws
+	CookieDomain string `json:"cookie_domain"`
+	IdleMinutes  int    `json:"idle_minutes"`
+	MaxAgeHours  int    `json:"max_age_hours"`
+}
+
+// CorsPolicy is the cors policy of a router. Origins may use a wildcard subdomain, i.e. https://*.sharecro.ws.
+type CorsPolicy struct {
+	AllowedOrigins []string `json:"allowed_origins"`
+	// AllowedHeaders defaults to any header
+	AllowedHeaders   []string `json:"allowed_headers"`
+	ExposedHeaders   []string `json:"exposed_headers"`
+	AllowCredentials bool     `json:"allow_credentials"`
+	MaxAgeSeconds    int      `json:"max_age_seconds"`
+}
+
+// Cors applies its policy to every router, defaulting its origins to the frontend's. Routers overrides the policy of a top level router by path, i.e. /bkn.
+type Cors struct {
+	CorsPolicy
+	Routers map[string]CorsPolicy `json:"routers"`
+}
+
+// RateLimits are in requests per minute. Zero disables a limit.
+type RateLimits struct {
+	PerMinute int `json:"per_minute"`
+	Burst     int `json:"burst"`
+	// PerIPPerMinute bounds every request from an ip before authentication, so failed attempts are limited too
+	PerIPPerMinute int `json:"per_ip_per_minute"`
+	// DeploymentsPerMinute bounds the requests which fan out to the proximity api
+	DeploymentsPerMinute int `json:"deployments_per_minute"`
+	// TrustedHops is the number of proxies appending to X-Forwarded-For ahead of the api: the load balancer & nginx sidecar.
+	// Zero keys clients by their connection's address, which behind a proxy is the proxy's.
+	TrustedHops int `json:"trusted_hops"`
+}
+
+// JWTKey holds either an HS256 Secret or the Path to a PEM encoded RS256/EdDSA key. Public keys may only verify.
+type JWTKey struct {
+	Id     string `json:"kid"`
+	Secret string `json:"secret"`
+	Path   string `json:"path"`
+}
+
+// OIDCProvider configures a generic OpenID Connect (or OAuth2 + userinfo) login provider
+type OIDCProvider struct {
+	OAuth
+	// Name is used in the login paths, i.e. github
+	Name string `json:"name"`
+	// Id must be unique & never change, as user ids are derived from it. Google is 1.
+	Id          uint8  `json:"id"`
+	AuthURL     string `json:"auth_url"`
+	TokenURL    string `json:"token_url"`
+	UserinfoURL string `json:"userinfo_url"`
+	// SubjectClaim & EmailClaim name the userinfo fields identifying a user, defaulting to sub & email
+	SubjectClaim string `json:"subject_claim"`
+	EmailClaim   string `json:"email_claim"`
+}
+
 func LoadConfFromDir(fPath string) (*JsonConfig, error) {
 
 	cassEndpoint := os.Getenv("CASSANDRA_ENDPOINT")
@@ -48,6 +138,32 @@ func LoadConfFromDir(fPath string) (*JsonConfig, error) {
 		CassEndpoint:     cassEndpoint,
 		CassKeyspace:     "bkn",
 		Port:             port,
+		JWTIssuer:        "https://our.sharecro.ws",
+		JWTAudience:      "beacon-api",
+		FrontendURL:      "https://sharecro.ws",
+		DrainSeconds:     10,
+		ShutdownSeconds:  15,
+		IdempotencyHours: 24,
+		RedocPath:        "static/redoc.standalone.js",
+		Cors: Cors{
+			CorsPolicy: CorsPolicy{
+				ExposedHeaders:   []string{"X-Request-Id", "Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Idempotent-Replayed"},
+				AllowCredentials: true,
+				MaxAgeSeconds:    600,
+			},
+			// short links & public keys are fetched by anyone, but never with credentials
+			Routers: map[string]CorsPolicy{
+				"/bkn":         {AllowedOrigins: []string{"*"}, MaxAgeSeconds: 600},
+				"/.well-known": {AllowedOrigins: []string{"*"}, MaxAgeSeconds: 600},
+			},
+		},
+		RateLimits: RateLimits{
+			PerMinute:            300,
+			Burst:                60,
+			PerIPPerMinute:       600,
+			DeploymentsPerMinute: 10,
+			TrustedHops:          2,
+		},
 	}
 	data, err := ioutil.ReadFile(filepath.Join(fPath, "config.json"))
 	if err != nil {
@@ -56,5 +172,12 @@ func LoadConfFromDir(fPath string) (*JsonConfig, error) {
 
 	err = json.Unmarshal(data, conf)
 
+	// key files live alongside config.json unless otherwise specified
+	for i, key := range conf.JWTKeys {
+		if key.Path != "" && !filepath.IsAbs(key.Path) {
+			conf.JWTKeys[i].Path = filepath.Join(fPath, key.Path)
+		}
+	}
+
 	return conf, err
 }
@@ -0,0 +1,204 @@
+// Package apierr is the error model shared by every api response
+package apierr
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gocql/gocql"
+	"google.golang.org/api/googleapi"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// Code is a stable, machine readable error identifier. Messages may change; codes may not.
+type Code string
+
+const (
+	Invalid      Code = "invalid_request"
+	Unauthorized Code = "unauthorized"
+	Forbidden    Code = "forbidden"
+	NotFound     Code = "not_found"
+	Conflict     Code = "conflict"
+	TooLarge     Code = "too_large"
+	RateLimited  Code = "rate_limited"
+	Internal     Code = "internal"
+	Upstream     Code = "upstream"
+	Unavailable  Code = "unavailable"
+	// KeyReused is returned when an idempotency key is resent with a different request
+	KeyReused Code = "idempotency_key_reused"
+)
+
+// FieldError pinpoints a single invalid input
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ItemError reports the failure of a single item within a bulk operation, i.e. one beacon of many
+type ItemError struct {
+	Item    string `json:"item"`
+	Code    Code   `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error is the body of every error response
+type Error struct {
+	Status  int    `json:"-"`
+	Code    Code   `json:"code"`
+	Message string `json:"message"`
+	// Errors lists the individual invalid fields, if any
+	Errors []*FieldError `json:"errors,omitempty"`
+	// Items lists the failed items of a bulk operation, if any
+	Items []*ItemError `json:"items,omitempty"`
+}
+
+func (self *Error) Error() string {
+	return self.Message
+}
+
+func (self *Error) Flush(rw http.ResponseWriter) {
+	if self.Message == "" {
+		self.Message = http.StatusText(self.Status)
+	}
+	if self.Code == "" {
+		self.Code = CodeFor(self.Status)
+	}
+	WriteJSON(rw, self.Status, self)
+}
+
+// WriteJSON writes any response body, errors included, as json
+func WriteJSON(rw http.ResponseWriter, status int, v interface{}) {
+	data, _ := json.Marshal(v)
+	// Add headers to header map before flushing them with WriteHeader
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	rw.Write(data)
+}
+
+// CodeFor is the default code of a status
+func CodeFor(status int) Code {
+	switch {
+	case status == http.StatusUnauthorized:
+		return Unauthorized
+	case status == http.StatusForbidden:
+		return Forbidden
+	case status == http.StatusNotFound:
+		return NotFound
+	case status == http.StatusConflict:
+		return Conflict
+	case status == http.StatusRequestEntityTooLarge:
+		return TooLarge
+	case status == http.StatusTooManyRequests:
+		return RateLimited
+	case status == http.StatusBadGateway:
+		return Upstream
+	case status == http.StatusServiceUnavailable:
+		return Unavailable
+	case status >= 400 && status < 500:
+		return Invalid
+	}
+	return Internal
+}
+
+type sentinel struct {
+	err    error
+	status int
+	code   Code
+}
+
+var (
+	sentinelsMtx sync.RWMutex
+	sentinels    = []*sentinel{
+		{gocql.ErrNotFound, http.StatusNotFound, NotFound},
+	}
+)
+
+// Register maps a sentinel error, matched with errors.Is, onto a response
+func Register(err error, status int, code Code) {
+	sentinelsMtx.Lock()
+	defer sentinelsMtx.Unlock()
+	sentinels = append(sentinels, &sentinel{err, status, code})
+}
+
+// From converts any error into a response. Unrecognized errors are logged & hidden behind a 500.
+func From(err error) *Error {
+	if err == nil {
+		return nil
+	}
+
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+
+	sentinelsMtx.RLock()
+	defer sentinelsMtx.RUnlock()
+	for _, s := range sentinels {
+		if errors.Is(err, s.err) {
+			return &Error{Status: s.status, Code: s.code, Message: err.Error()}
+		}
+	}
+
+	var googleErr *googleapi.Error
+	if errors.As(err, &googleErr) {
+		return fromGoogle(googleErr)
+	}
+
+	slog.Error("apierr: unexpected error", "error", err)
+	return &Error{Status: http.StatusInternalServerError, Code: Internal, Message: "internal error"}
+}
+
+// IsQuota reports whether err is the proximity api refusing a call over our quota
+func IsQuota(err error) bool {
+	var googleErr *googleapi.Error
+	if !errors.As(err, &googleErr) {
+		return false
+	}
+	if googleErr.Code == http.StatusTooManyRequests {
+		return true
+	}
+	for _, item := range googleErr.Errors {
+		if item.Reason == "rateLimitExceeded" || item.Reason == "quotaExceeded" || item.Reason == "userRateLimitExceeded" {
+			return true
+		}
+	}
+	return false
+}
+
+// fromGoogle maps proximity api failures. Quota errors surface as 429 so clients back off; the rest are upstream failures.
+func fromGoogle(err *googleapi.Error) *Error {
+	if IsQuota(err) {
+		return &Error{Status: http.StatusTooManyRequests, Code: RateLimited, Message: "proximity api quota exceeded"}
+	}
+	if err.Code == http.StatusNotFound {
+		return &Error{Status: http.StatusNotFound, Code: NotFound, Message: "beacon not found in the proximity api"}
+	}
+	return &Error{Status: http.StatusBadGateway, Code: Upstream, Message: "proximity api error: " + strconv.Itoa(err.Code)}
+}
+
+// Item reports err against a single item of a bulk operation
+func Item(item string, err error) *ItemError {
+	res := From(err)
+	return &ItemError{Item: item, Code: res.Code, Message: res.Message}
+}
+
+// Bulk summarizes the failed items of an operation, responding with the most pressing status among them
+func Bulk(message string, items []*ItemError) *Error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	res := &Error{Status: http.StatusBadGateway, Code: Upstream, Message: message, Items: items}
+	for _, item := range items {
+		switch item.Code {
+		case RateLimited:
+			// retrying later may succeed, so it takes precedence
+			return &Error{Status: http.StatusTooManyRequests, Code: RateLimited, Message: message, Items: items}
+		case Internal:
+			res.Status, res.Code = http.StatusInternalServerError, Internal
+		}
+	}
+	return res
+}
@@ -0,0 +1,74 @@
+package apierr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gocql/gocql"
+	"google.golang.org/api/googleapi"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFrom(t *testing.T) {
+	conflict := errors.New("taken")
+	Register(conflict, http.StatusConflict, Conflict)
+
+	cases := []struct {
+		name   string
+		err    error
+		status int
+		code   Code
+	}{
+		{"not found", gocql.ErrNotFound, 404, NotFound},
+		{"registered", fmt.Errorf("creating: %w", conflict), 409, Conflict},
+		{"api error", &Error{Status: 403, Code: Forbidden}, 403, Forbidden},
+		{"quota", &googleapi.Error{Code: 429}, 429, RateLimited},
+		{"quota reason", &googleapi.Error{Code: 403, Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}}}, 429, RateLimited},
+		{"upstream", &googleapi.Error{Code: 500}, 502, Upstream},
+		{"unknown", errors.New("connection refused"), 500, Internal},
+	}
+
+	for _, c := range cases {
+		res := From(c.err)
+		if res.Status != c.status || res.Code != c.code {
+			t.Errorf("%s: expected %d %s, got %d %s", c.name, c.status, c.code, res.Status, res.Code)
+		}
+	}
+
+	if From(nil) != nil {
+		t.Error("expected nil")
+	}
+	if res := From(errors.New("secret host 10.0.0.1")); res.Message != "internal error" {
+		t.Error("expected internal details to be hidden, got", res.Message)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	rw := httptest.NewRecorder()
+	(&Error{Status: http.StatusNotFound}).Flush(rw)
+
+	res := &Error{}
+	if err := json.Unmarshal(rw.Body.Bytes(), res); err != nil {
+		t.Fatal(err)
+	}
+	if rw.Code != 404 || res.Code != NotFound || res.Message != "Not Found" {
+		t.Error("unexpected response:", rw.Code, rw.Body.String())
+	}
+}
+
+func TestBulk(t *testing.T) {
+	if Bulk("failed", nil) != nil {
+		t.Error("expected nil")
+	}
+
+	items := []*ItemError{
+		Item("beef", &googleapi.Error{Code: 500}),
+		Item("cafe", &googleapi.Error{Code: 429}),
+	}
+	res := Bulk("failed", items)
+	if res.Status != 429 || len(res.Items) != 2 || res.Items[0].Item != "beef" || res.Items[0].Code != Upstream {
+		t.Errorf("unexpected bulk error: %+v", res)
+	}
+}
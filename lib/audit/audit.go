@@ -0,0 +1,111 @@
+// Package audit records mutating operations, so owners can review who changed what
+package audit
+
+import (
+	"encoding/json"
+	"github.com/gocql/gocql"
+	"github.com/owen-d/beacon-api/lib/auth/jwt"
+	"github.com/owen-d/beacon-api/lib/cass"
+	"github.com/owen-d/beacon-api/lib/reqid"
+	"log/slog"
+	"net/http"
+)
+
+const (
+	DeploymentPut   = "deployment.put"
+	BeaconsDeploy   = "beacons.deploy"
+	MessageCreate   = "message.create"
+	MessageUpdate   = "message.update"
+	Login           = "auth.login"
+	LinkIdentity    = "auth.link"
+	Logout          = "auth.logout"
+	SessionStart    = "auth.session"
+	UnlinkIdentity  = "auth.unlink"
+	APIKeyCreate    = "apikey.create"
+	APIKeyRevoke    = "apikey.revoke"
+	OrgCreate       = "org.create"
+	OrgInvite       = "org.invite"
+	OrgAccept       = "org.invite.accept"
+	BeaconsTransfer = "beacons.transfer"
+	MemberUpdate    = "org.member.update"
+	MemberRemove    = "org.member.remove"
+	UserUpdate      = "user.update"
+	UserDelete      = "user.delete"
+	// admin actions are recorded against the user or owner they affected
+	AdminSuspend     = "admin.suspend"
+	AdminUnsuspend   = "admin.unsuspend"
+	AdminImpersonate = "admin.impersonate"
+	AdminTransfer    = "admin.beacon.transfer"
+)
+
+// Recorder writes audit events. A nil Recorder records nothing.
+type Recorder struct {
+	CassClient cass.Client
+}
+
+// Record attributes an event to the request's authenticated actor & the owner they act for
+func (self *Recorder) Record(r *http.Request, action string, target string, before interface{}, after interface{}) {
+	var owner *gocql.UUID
+	if bindings, ok := r.Context().Value(jwt.JWTNamespace).(*jwt.Bindings); ok {
+		owner = bindings.OwnerId
+		if owner == nil {
+			owner = bindings.UserId
+		}
+	}
+
+	self.RecordFor(r, owner, action, target, before, after)
+}
+
+// RecordFor attributes an event to the request's authenticated actor, acting on a given owner's resources, i.e. an org named in the path
+func (self *Recorder) RecordFor(r *http.Request, owner *gocql.UUID, action string, target string, before interface{}, after interface{}) {
+	bindings, ok := r.Context().Value(jwt.JWTNamespace).(*jwt.Bindings)
+	if !ok {
+		slog.WarnContext(r.Context(), "audit: unauthenticated request cannot be attributed", "action", action, "target", target)
+		return
+	}
+
+	self.record(r, &cass.AuditEvent{
+		OwnerId:        owner,
+		ActorId:        bindings.UserId,
+		ImpersonatorId: bindings.ImpersonatorId,
+		Action:         action,
+		Target:         target,
+	}, before, after)
+}
+
+// RecordUser attributes an event to a user acting for themselves, i.e. during login before any bindings exist
+func (self *Recorder) RecordUser(r *http.Request, userId *gocql.UUID, action string, target string) {
+	self.record(r, &cass.AuditEvent{
+		OwnerId: userId,
+		ActorId: userId,
+		Action:  action,
+		Target:  target,
+	}, nil, nil)
+}
+
+// record is best effort: the operation has already happened, so a failure is logged rather than returned
+func (self *Recorder) record(r *http.Request, e *cass.AuditEvent, before interface{}, after interface{}) {
+	if self == nil {
+		return
+	}
+
+	e.Before = summarize(before)
+	e.After = summarize(after)
+	e.RequestId = reqid.FromContext(r.Context())
+
+	if res := self.CassClient.RecordEvent(r.Context(), e); res.Err != nil {
+		slog.ErrorContext(r.Context(), "audit: failed to record event", "action", e.Action, "target", e.Target, "error", res.Err)
+	}
+}
+
+func summarize(v interface{}) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil || string(data) == "null" {
+		return nil
+	}
+	return data
+}
@@ -0,0 +1,96 @@
+// Package apikey authenticates machine clients via api keys, as an alternative to user jwts
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/gocql/gocql"
+	"github.com/owen-d/beacon-api/lib/apierr"
+	"github.com/owen-d/beacon-api/lib/auth/jwt"
+	"github.com/owen-d/beacon-api/lib/cass"
+	"github.com/owen-d/beacon-api/lib/validator"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	APIKeyKeyword = "x-api-key"
+	// Prefix makes keys recognizable, i.e. when scanning for leaked secrets
+	Prefix   = "bkn_"
+	keyBytes = 32
+)
+
+// Authenticator accepts either an api key or a jwt, producing the same jwt.Bindings for both
+type Authenticator struct {
+	Decoder    *jwt.Decoder
+	CassClient cass.Client
+}
+
+// Validate fulfills the jwt.Authenticator interface
+func (self *Authenticator) Validate(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	key := r.Header.Get(APIKeyKeyword)
+
+	if key == "" {
+		self.Decoder.Validate(rw, r, next)
+		return
+	}
+
+	stored, fetchErr := self.CassClient.FetchAPIKey(r.Context(), Hash(key))
+	if fetchErr == gocql.ErrNotFound {
+		err := &validator.RequestErr{Status: http.StatusUnauthorized, Message: "invalid api key"}
+		err.Flush(rw)
+		return
+	}
+	if fetchErr != nil {
+		err := apierr.From(fetchErr)
+		err.Flush(rw)
+		return
+	}
+
+	if stored.ReadOnly && !safeMethod(r.Method) {
+		err := &validator.RequestErr{Status: http.StatusForbidden, Message: "read-only api key"}
+		err.Flush(rw)
+		return
+	}
+
+	bindings := &jwt.Bindings{
+		UserId:   stored.UserId,
+		ReadOnly: stored.ReadOnly,
+	}
+
+	if err := self.Decoder.CheckAccount(r.Context(), bindings); err != nil {
+		err.Flush(rw)
+		return
+	}
+
+	newCtx := context.WithValue(r.Context(), jwt.JWTNamespace, bindings)
+	next(rw, r.WithContext(newCtx))
+}
+
+// Generate creates a new plaintext key along with the hash under which it is stored
+func Generate() (string, []byte, error) {
+	secret := make([]byte, keyBytes)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return "", nil, err
+	}
+
+	key := Prefix + hex.EncodeToString(secret)
+	return key, Hash(key), nil
+}
+
+// Hash is the form in which api keys are stored
+func Hash(key string) []byte {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(key)))
+	return sum[:]
+}
+
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
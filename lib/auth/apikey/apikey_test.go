@@ -0,0 +1,41 @@
+package apikey
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	key, hash, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(key, Prefix) {
+		t.Error("expected prefixed key, got", key)
+	}
+
+	if !bytes.Equal(hash, Hash(key)) {
+		t.Error("hash mismatch")
+	}
+
+	other, _, _ := Generate()
+	if other == key {
+		t.Error("generated duplicate keys")
+	}
+}
+
+func TestSafeMethod(t *testing.T) {
+	for _, method := range []string{"GET", "HEAD", "OPTIONS"} {
+		if !safeMethod(method) {
+			t.Error("expected safe method:", method)
+		}
+	}
+
+	for _, method := range []string{"POST", "PUT", "PATCH", "DELETE"} {
+		if safeMethod(method) {
+			t.Error("expected unsafe method:", method)
+		}
+	}
+}
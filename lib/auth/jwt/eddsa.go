@@ -0,0 +1,52 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"errors"
+	jwtGo "github.com/dgrijalva/jwt-go"
+)
+
+var (
+	// SigningMethodEdDSA signs with Ed25519 keys, which jwt-go does not provide
+	SigningMethodEdDSA = &signingMethodEdDSA{}
+)
+
+type signingMethodEdDSA struct{}
+
+func init() {
+	jwtGo.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwtGo.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+func (self *signingMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+// Verify expects an ed25519.PublicKey
+func (self *signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwtGo.ErrInvalidKeyType
+	}
+
+	sig, decodeErr := jwtGo.DecodeSegment(signature)
+	if decodeErr != nil {
+		return decodeErr
+	}
+
+	if !ed25519.Verify(pub, []byte(signingString), sig) {
+		return errors.New("ed25519 verification failed")
+	}
+	return nil
+}
+
+// Sign expects an ed25519.PrivateKey
+func (self *signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwtGo.ErrInvalidKeyType
+	}
+
+	return jwtGo.EncodeSegment(ed25519.Sign(priv, []byte(signingString))), nil
+}
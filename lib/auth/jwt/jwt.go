@@ -3,9 +3,9 @@ package jwt
 import (
 	"context"
 	"errors"
-	"fmt"
 	jwtGo "github.com/dgrijalva/jwt-go"
 	"github.com/gocql/gocql"
+	"github.com/owen-d/beacon-api/lib/apierr"
 	"github.com/owen-d/beacon-api/lib/validator"
 	"net/http"
 	"time"
@@ -19,20 +19,33 @@ var (
 // alias string as a namespaced type to avoid collisions when used w/ context map. Unexported
 type key struct{ string }
 
+// Authenticator is middleware which attaches Bindings to authenticated requests under JWTNamespace
+type Authenticator interface {
+	Validate(http.ResponseWriter, *http.Request, http.HandlerFunc)
+}
+
+// RevocationList reports whether a token, identified by its jti, has been revoked before its expiry
+type RevocationList interface {
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+}
+
+// AccountChecker reports whether a user is an admin, or has been suspended
+type AccountChecker interface {
+	CheckAccount(ctx context.Context, userId *gocql.UUID) (admin bool, suspended bool, err error)
+}
+
 // Decoder is a wrapper struct which handles decoding
 type Decoder struct {
-	Secret []byte
+	Keys *KeySet
+	// Revocations is optional; when nil, tokens are valid until they expire
+	Revocations RevocationList
+	// Accounts is optional; when nil, no user is an admin & suspensions are not enforced
+	Accounts AccountChecker
 }
 
 // Decode parses a jwt and produces a relevant application bindings struct
 func (self *Decoder) Decode(unparsed string) (*Bindings, error) {
-	token, parseErr := jwtGo.Parse(unparsed, func(token *jwtGo.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwtGo.SigningMethodHMAC); !ok {
-			return nil, errors.New(fmt.Sprintf("Unexpected signing method: %v", token.Header["alg"]))
-		}
-
-		return self.Secret, nil
-	})
+	token, parseErr := jwtGo.Parse(unparsed, self.Keys.verificationKey)
 
 	if parseErr != nil {
 		return nil, parseErr
@@ -41,6 +54,13 @@ func (self *Decoder) Decode(unparsed string) (*Bindings, error) {
 	bindings := &Bindings{Token: token}
 
 	if claims, ok := token.Claims.(jwtGo.MapClaims); ok && token.Valid {
+		if !claims.VerifyIssuer(self.Keys.Issuer, self.Keys.Issuer != "") {
+			return nil, errors.New("invalid issuer")
+		}
+		if !claims.VerifyAudience(self.Keys.Audience, self.Keys.Audience != "") {
+			return nil, errors.New("invalid audience")
+		}
+
 		if castErr := bindings.ConvertFromJwt(claims); castErr != nil {
 			return nil, castErr
 		}
@@ -68,15 +88,81 @@ func (self *Decoder) Validate(rw http.ResponseWriter, r *http.Request, next http
 		return
 	}
 
+	if self.Revocations != nil {
+		revoked, revocationErr := self.Revocations.IsRevoked(r.Context(), bindings.TokenId)
+		if revocationErr != nil {
+			err := apierr.From(revocationErr)
+			err.Flush(rw)
+			return
+		}
+
+		if revoked {
+			err := &validator.RequestErr{Status: http.StatusUnauthorized, Message: "token revoked"}
+			err.Flush(rw)
+			return
+		}
+	}
+
+	if err := self.CheckAccount(r.Context(), bindings); err != nil {
+		err.Flush(rw)
+		return
+	}
+
 	newCtx := context.WithValue(r.Context(), JWTNamespace, bindings)
 	next(rw, r.WithContext(newCtx))
 
 }
 
+// CheckAccount rejects suspended users & marks admins. Authenticators which produce Bindings without a jwt should call it as well.
+func (self *Decoder) CheckAccount(ctx context.Context, bindings *Bindings) *validator.RequestErr {
+	if self.Accounts == nil {
+		return nil
+	}
+
+	admin, suspended, err := self.Accounts.CheckAccount(ctx, bindings.UserId)
+	if err != nil {
+		return apierr.From(err)
+	}
+
+	if suspended {
+		return &validator.RequestErr{Status: http.StatusForbidden, Message: "account suspended"}
+	}
+
+	// an impersonated user is never an admin, whatever the impersonated account is
+	bindings.Admin = admin && bindings.ImpersonatorId == nil
+	return nil
+}
+
+// NoImpersonation is middleware for endpoints an admin may not use while impersonating, i.e. those minting lasting credentials.
+// It must follow an Authenticator.
+func NoImpersonation(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	bindings := r.Context().Value(JWTNamespace).(*Bindings)
+
+	if bindings.ImpersonatorId != nil {
+		err := &validator.RequestErr{Status: http.StatusForbidden, Message: "not allowed while impersonating"}
+		err.Flush(rw)
+		return
+	}
+
+	next(rw, r)
+}
+
 // Bindings is an application struct extracted & casted from JWTGO claims
 type Bindings struct {
-	Token  *jwtGo.Token
-	UserId *gocql.UUID
+	Token     *jwtGo.Token
+	UserId    *gocql.UUID
+	TokenId   string
+	ExpiresAt time.Time
+	// ReadOnly is set for api keys scoped to safe methods
+	ReadOnly bool
+	// OwnerId is the user or org whose resources the request acts upon, set alongside Role by orgs.Authorizer
+	OwnerId *gocql.UUID
+	Role    string
+	// Session is the hash of the session cookie, set for requests authenticated by session.Authenticator
+	Session []byte
+	Admin   bool
+	// ImpersonatorId is the admin acting as UserId, for tokens issued via impersonation
+	ImpersonatorId *gocql.UUID
 }
 
 // ConvertFromJwtGo casts a *jwtGo.MapClaims into a Bindings struct
@@ -92,25 +178,75 @@ func (self *Bindings) ConvertFromJwt(claims jwtGo.MapClaims) error {
 		return parseErr
 	}
 
+	// tokens without a jti cannot be revoked, so they are no longer accepted
+	jti, ok := claims["jti"].(string)
+	if !ok || jti == "" {
+		return errors.New("no jti field")
+	}
+
+	// numeric claims are decoded as float64
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("no exp field")
+	}
+
+	// impersonation tokens name the acting admin, as in RFC 8693
+	if act, ok := claims["act"].(map[string]interface{}); ok {
+		actor, _ := act["sub"].(string)
+		impersonatorId, actErr := gocql.ParseUUID(actor)
+		if actErr != nil {
+			return errors.New("invalid act field")
+		}
+		self.ImpersonatorId = &impersonatorId
+	}
+
 	self.UserId = &userId
+	self.TokenId = jti
+	self.ExpiresAt = time.Unix(int64(exp), 0)
 	return nil
 }
 
 // Encoder is a wrapper struct which handles encoding
 type Encoder struct {
-	Secret []byte
+	Keys *KeySet
 }
 
 // Encode can be used to via enc.Encode(userIdString, time.Now().Add(time.Hour * 24 * 30).Unix())
 func (self *Encoder) Encode(userId [16]byte, expires int64) (string, error) {
+	return self.encode(userId, expires, nil)
+}
+
+// EncodeImpersonation creates a token for userId on behalf of an admin
+func (self *Encoder) EncodeImpersonation(userId [16]byte, adminId [16]byte, expires int64) (string, error) {
+	admin, _ := gocql.UUIDFromBytes((&adminId)[:])
+	return self.encode(userId, expires, jwtGo.MapClaims{
+		"act": map[string]interface{}{"sub": admin.String()},
+	})
+}
+
+func (self *Encoder) encode(userId [16]byte, expires int64, extra jwtGo.MapClaims) (string, error) {
 	uuid, _ := gocql.UUIDFromBytes((&userId)[:])
 	claims := jwtGo.MapClaims{
 		"user_id": uuid.String(),
 		"exp":     expires,
 		"iat":     time.Now().Unix(),
+		// unique token id, allowing revocation
+		"jti": gocql.TimeUUID().String(),
+	}
+	for k, v := range extra {
+		claims[k] = v
 	}
-	token := jwtGo.NewWithClaims(jwtGo.SigningMethodHS256, claims)
+	if self.Keys.Issuer != "" {
+		claims["iss"] = self.Keys.Issuer
+	}
+	if self.Keys.Audience != "" {
+		claims["aud"] = self.Keys.Audience
+	}
+
+	signer := self.Keys.Signer()
+	token := jwtGo.NewWithClaims(signer.Method, claims)
+	token.Header["kid"] = signer.Id
 
-	return token.SignedString(self.Secret)
+	return token.SignedString(signer.Private)
 
 }
@@ -0,0 +1,71 @@
+package jwt
+
+import (
+	"context"
+	"github.com/gocql/gocql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type accounts struct {
+	admin     bool
+	suspended bool
+}
+
+func (self *accounts) CheckAccount(ctx context.Context, userId *gocql.UUID) (bool, bool, error) {
+	return self.admin, self.suspended, nil
+}
+
+func TestImpersonation(t *testing.T) {
+	key, _ := NewHMACKey("hmac", []byte("secret"))
+	set := mustKeySet(t, "hmac", key)
+
+	userId, adminId := gocql.TimeUUID(), gocql.TimeUUID()
+	tok, err := (&Encoder{Keys: set}).EncodeImpersonation(userId, adminId, time.Now().Add(time.Minute).Unix())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoder := &Decoder{Keys: set, Accounts: &accounts{admin: true}}
+	bindings, err := decoder.Decode(tok)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bindings.UserId.String() != userId.String() || bindings.ImpersonatorId == nil || bindings.ImpersonatorId.String() != adminId.String() {
+		t.Error("expected the admin as impersonator, got:", bindings.ImpersonatorId)
+	}
+
+	if err := decoder.CheckAccount(context.Background(), bindings); err != nil || bindings.Admin {
+		t.Error("an impersonated account must never be an admin")
+	}
+
+	rw := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/", nil)
+	NoImpersonation(rw, r.WithContext(context.WithValue(r.Context(), JWTNamespace, bindings)), func(http.ResponseWriter, *http.Request) {
+		t.Error("expected impersonation to be rejected")
+	})
+	if rw.Code != http.StatusForbidden {
+		t.Error("expected 403, got", rw.Code)
+	}
+}
+
+func TestCheckAccount(t *testing.T) {
+	userId := gocql.TimeUUID()
+
+	admin := &Bindings{UserId: &userId}
+	if err := (&Decoder{Accounts: &accounts{admin: true}}).CheckAccount(context.Background(), admin); err != nil || !admin.Admin {
+		t.Error("expected admin bindings")
+	}
+
+	suspended := &Bindings{UserId: &userId}
+	if err := (&Decoder{Accounts: &accounts{admin: true, suspended: true}}).CheckAccount(context.Background(), suspended); err == nil || err.Status != http.StatusForbidden {
+		t.Error("expected suspended account to be forbidden")
+	}
+
+	if err := (&Decoder{}).CheckAccount(context.Background(), &Bindings{UserId: &userId}); err != nil {
+		t.Error("expected accounts to be optional")
+	}
+}
@@ -0,0 +1,193 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	jwtGo "github.com/dgrijalva/jwt-go"
+	"math/big"
+	"net/http"
+)
+
+const (
+	// LegacyKeyId is assumed for tokens without a kid header, which were signed with the original shared secret
+	LegacyKeyId = "legacy"
+)
+
+// Key is a signing key, or a verification-only key (i.e. one being rotated out) when Private is nil
+type Key struct {
+	Id      string
+	Method  jwtGo.SigningMethod
+	Private interface{}
+	Public  interface{}
+}
+
+// NewHMACKey creates a symmetric HS256 key. Its secret is never published.
+func NewHMACKey(kid string, secret []byte) (*Key, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("key %s: empty secret", kid)
+	}
+	return &Key{Id: kid, Method: jwtGo.SigningMethodHS256, Private: secret, Public: secret}, nil
+}
+
+// ParseKey reads a PEM encoded RS256 or EdDSA key. Private keys may sign, while public keys only verify.
+func ParseKey(kid string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("key %s: no pem block", kid)
+	}
+
+	var parsed interface{}
+	var parseErr error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, parseErr = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, parseErr = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, parseErr = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("key %s: unsupported pem type %s", kid, block.Type)
+	}
+	if parseErr != nil {
+		return nil, fmt.Errorf("key %s: %v", kid, parseErr)
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &Key{Id: kid, Method: jwtGo.SigningMethodRS256, Private: k, Public: &k.PublicKey}, nil
+	case *rsa.PublicKey:
+		return &Key{Id: kid, Method: jwtGo.SigningMethodRS256, Public: k}, nil
+	case ed25519.PrivateKey:
+		return &Key{Id: kid, Method: SigningMethodEdDSA, Private: k, Public: k.Public()}, nil
+	case ed25519.PublicKey:
+		return &Key{Id: kid, Method: SigningMethodEdDSA, Public: k}, nil
+	}
+	return nil, fmt.Errorf("key %s: unsupported key type %T", kid, parsed)
+}
+
+// KeySet holds the keys tokens are signed & verified with, along with the issuer & audience they're bound to
+type KeySet struct {
+	Keys map[string]*Key
+	// SigningKeyId selects the key new tokens are signed with
+	SigningKeyId string
+	Issuer       string
+	Audience     string
+}
+
+// NewKeySet validates that the signing key exists & is able to sign
+func NewKeySet(signingKeyId string, issuer string, audience string, keys ...*Key) (*KeySet, error) {
+	set := &KeySet{
+		Keys:         make(map[string]*Key, len(keys)),
+		SigningKeyId: signingKeyId,
+		Issuer:       issuer,
+		Audience:     audience,
+	}
+
+	for _, k := range keys {
+		if _, exists := set.Keys[k.Id]; exists {
+			return nil, fmt.Errorf("duplicate key id: %s", k.Id)
+		}
+		set.Keys[k.Id] = k
+	}
+
+	if signer, ok := set.Keys[signingKeyId]; !ok || signer.Private == nil {
+		return nil, fmt.Errorf("no private key for signing key id: %s", signingKeyId)
+	}
+
+	return set, nil
+}
+
+// Signer returns the key new tokens are signed with
+func (self *KeySet) Signer() *Key {
+	return self.Keys[self.SigningKeyId]
+}
+
+// verificationKey is a jwtGo.Keyfunc, matching a token to a key by its kid & algorithm
+func (self *KeySet) verificationKey(token *jwtGo.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		kid = LegacyKeyId
+	}
+
+	key, ok := self.Keys[kid]
+	if !ok {
+		return nil, errors.New("unknown signing key: " + kid)
+	}
+
+	// the key, not the token, decides the algorithm, preventing i.e. an RS256 public key being used as an HMAC secret
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, errors.New(fmt.Sprintf("Unexpected signing method: %v", token.Header["alg"]))
+	}
+
+	return key.Public, nil
+}
+
+// JWK is a public key in JSON Web Key format
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []*JWK `json:"keys"`
+}
+
+// JWKS lists the public keys other services may verify our tokens with. HMAC keys are never included.
+func (self *KeySet) JWKS() *JWKS {
+	res := &JWKS{Keys: make([]*JWK, 0, len(self.Keys))}
+	for _, key := range self.Keys {
+		if jwk := toJWK(key); jwk != nil {
+			res.Keys = append(res.Keys, jwk)
+		}
+	}
+	return res
+}
+
+// ServeJWKS is a handler publishing the keyset's public keys
+func (self *KeySet) ServeJWKS(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	data, _ := json.Marshal(self.JWKS())
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "public, max-age=300")
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(data)
+}
+
+func toJWK(key *Key) *JWK {
+	encode := base64.RawURLEncoding.EncodeToString
+
+	switch k := key.Public.(type) {
+	case *rsa.PublicKey:
+		return &JWK{
+			Kty: "RSA",
+			Kid: key.Id,
+			Use: "sig",
+			Alg: key.Method.Alg(),
+			N:   encode(k.N.Bytes()),
+			E:   encode(big.NewInt(int64(k.E)).Bytes()),
+		}
+	case ed25519.PublicKey:
+		return &JWK{
+			Kty: "OKP",
+			Kid: key.Id,
+			Use: "sig",
+			Alg: key.Method.Alg(),
+			Crv: "Ed25519",
+			X:   encode(k),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/gocql/gocql"
+	"testing"
+	"time"
+)
+
+func mustKeySet(t *testing.T, signingKeyId string, keys ...*Key) *KeySet {
+	set, err := NewKeySet(signingKeyId, "https://issuer.test", "audience.test", keys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return set
+}
+
+func rsaKey(t *testing.T, kid string) *Key {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
+	key, err := ParseKey(kid, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func ed25519Key(t *testing.T, kid string) *Key {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ParseKey(kid, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func roundTrip(set *KeySet, verifier *KeySet) error {
+	userId := gocql.TimeUUID()
+	tok, err := (&Encoder{Keys: set}).Encode(userId, time.Now().Add(time.Minute).Unix())
+	if err != nil {
+		return err
+	}
+	_, err = (&Decoder{Keys: verifier}).Decode(tok)
+	return err
+}
+
+func TestKeySetAlgorithms(t *testing.T) {
+	hmac, _ := NewHMACKey("hmac", []byte("secret"))
+	keys := []*Key{hmac, rsaKey(t, "rsa"), ed25519Key(t, "ed25519")}
+
+	for _, key := range keys {
+		t.Run(key.Method.Alg(), func(t *testing.T) {
+			set := mustKeySet(t, key.Id, key)
+			if err := roundTrip(set, set); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestKeySetRotation(t *testing.T) {
+	old, next := rsaKey(t, "old"), ed25519Key(t, "next")
+
+	before := mustKeySet(t, "old", old)
+	during := mustKeySet(t, "next", old, next)
+	after := mustKeySet(t, "next", next)
+
+	if err := roundTrip(before, during); err != nil {
+		t.Error("tokens signed by the old key should verify during rotation:", err)
+	}
+	if err := roundTrip(during, after); err != nil {
+		t.Error("tokens signed by the new key should verify after rotation:", err)
+	}
+	if err := roundTrip(before, after); err == nil {
+		t.Error("tokens signed by a removed key should not verify")
+	}
+}
+
+func TestKeySetClaims(t *testing.T) {
+	key, _ := NewHMACKey("hmac", []byte("secret"))
+	set := mustKeySet(t, "hmac", key)
+
+	otherAudience, _ := NewKeySet("hmac", set.Issuer, "elsewhere.test", key)
+	if err := roundTrip(otherAudience, set); err == nil {
+		t.Error("expected audience mismatch to fail")
+	}
+
+	otherIssuer, _ := NewKeySet("hmac", "https://elsewhere.test", set.Audience, key)
+	if err := roundTrip(otherIssuer, set); err == nil {
+		t.Error("expected issuer mismatch to fail")
+	}
+}
+
+func TestKeySetAlgorithmConfusion(t *testing.T) {
+	pub := rsaKey(t, "rsa")
+	// a token claiming the rsa key id, but signed by a different algorithm
+	forged := &Key{Id: "rsa", Method: SigningMethodEdDSA, Private: ed25519Key(t, "x").Private}
+	forger := mustKeySet(t, "rsa", forged)
+
+	if err := roundTrip(forger, mustKeySet(t, "rsa", pub)); err == nil {
+		t.Error("expected mismatched algorithm to fail")
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	hmac, _ := NewHMACKey("hmac", []byte("secret"))
+	set := mustKeySet(t, "hmac", hmac, rsaKey(t, "rsa"), ed25519Key(t, "ed25519"))
+
+	jwks := set.JWKS()
+	if len(jwks.Keys) != 2 {
+		t.Fatal("expected only the public keys to be published, got", len(jwks.Keys))
+	}
+	for _, k := range jwks.Keys {
+		if k.Kid == "hmac" {
+			t.Error("hmac secret published")
+		}
+	}
+}
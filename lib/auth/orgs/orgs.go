@@ -0,0 +1,96 @@
+// Package orgs scopes authenticated requests to the personal workspace or an organization & authorizes them by role
+package orgs
+
+import (
+	"github.com/gocql/gocql"
+	"github.com/owen-d/beacon-api/lib/apierr"
+	"github.com/owen-d/beacon-api/lib/auth/jwt"
+	"github.com/owen-d/beacon-api/lib/cass"
+	"github.com/owen-d/beacon-api/lib/validator"
+	"net/http"
+)
+
+const (
+	// OrgKeyword selects the org a request acts upon. Without it, requests act upon the user's own resources.
+	OrgKeyword = "x-org-id"
+
+	Viewer = "viewer"
+	Editor = "editor"
+	Owner  = "owner"
+)
+
+var ranks = map[string]int{
+	Viewer: 1,
+	Editor: 2,
+	Owner:  3,
+}
+
+// ValidRole reports whether role is one of Viewer, Editor or Owner
+func ValidRole(role string) bool {
+	_, ok := ranks[role]
+	return ok
+}
+
+// Allows reports whether a member with role may act as required
+func Allows(role string, required string) bool {
+	return ranks[role] >= ranks[required] && ranks[role] > 0
+}
+
+// Authorizer wraps an authenticator, resolving the owner & role a request acts with
+type Authorizer struct {
+	Auth       jwt.Authenticator
+	CassClient cass.Client
+}
+
+// Validate fulfills the jwt.Authenticator interface
+func (self *Authorizer) Validate(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	self.Auth.Validate(rw, r, func(rw http.ResponseWriter, r *http.Request) {
+		bindings := r.Context().Value(jwt.JWTNamespace).(*jwt.Bindings)
+
+		orgStr := r.Header.Get(OrgKeyword)
+		if orgStr == "" {
+			bindings.OwnerId = bindings.UserId
+			bindings.Role = Owner
+			next(rw, r)
+			return
+		}
+
+		orgId, parseErr := gocql.ParseUUID(orgStr)
+		if parseErr != nil {
+			err := &validator.RequestErr{Status: http.StatusBadRequest, Message: "invalid " + OrgKeyword}
+			err.Flush(rw)
+			return
+		}
+
+		member, fetchErr := self.CassClient.FetchMember(r.Context(), &orgId, bindings.UserId)
+		if fetchErr == gocql.ErrNotFound {
+			err := &validator.RequestErr{Status: http.StatusForbidden, Message: "not a member of org"}
+			err.Flush(rw)
+			return
+		}
+		if fetchErr != nil {
+			err := apierr.From(fetchErr)
+			err.Flush(rw)
+			return
+		}
+
+		bindings.OwnerId = member.OrgId
+		bindings.Role = member.Role
+		next(rw, r)
+	})
+}
+
+// Require produces middleware rejecting requests whose role is below the required one
+func Require(required string) func(http.ResponseWriter, *http.Request, http.HandlerFunc) {
+	return func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		bindings := r.Context().Value(jwt.JWTNamespace).(*jwt.Bindings)
+
+		if !Allows(bindings.Role, required) {
+			err := &validator.RequestErr{Status: http.StatusForbidden, Message: required + " role required"}
+			err.Flush(rw)
+			return
+		}
+
+		next(rw, r)
+	}
+}
@@ -0,0 +1,28 @@
+package orgs
+
+import (
+	"testing"
+)
+
+func TestAllows(t *testing.T) {
+	cases := []struct {
+		role     string
+		required string
+		expected bool
+	}{
+		{Owner, Owner, true},
+		{Owner, Viewer, true},
+		{Editor, Editor, true},
+		{Editor, Owner, false},
+		{Viewer, Editor, false},
+		{Viewer, Viewer, true},
+		{"", Viewer, false},
+		{"admin", Viewer, false},
+	}
+
+	for _, c := range cases {
+		if res := Allows(c.role, c.required); res != c.expected {
+			t.Error("Allows(", c.role, ",", c.required, ") expected", c.expected)
+		}
+	}
+}
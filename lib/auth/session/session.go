@@ -0,0 +1,252 @@
+// Package session authenticates browsers via an http only cookie, as an alternative to holding jwts in javascript
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"github.com/gocql/gocql"
+	"github.com/owen-d/beacon-api/lib/apierr"
+	"github.com/owen-d/beacon-api/lib/auth/apikey"
+	"github.com/owen-d/beacon-api/lib/auth/jwt"
+	"github.com/owen-d/beacon-api/lib/cass"
+	"github.com/owen-d/beacon-api/lib/validator"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	CookieName = "sc_session"
+	// CSRFCookieName is readable by the frontend, which echoes it in the CSRFKeyword header on unsafe requests
+	CSRFCookieName     = "sc_csrf"
+	CSRFKeyword        = "x-csrf-token"
+	DefaultIdleTimeout = time.Hour * 2
+	DefaultMaxAge      = time.Hour * 24 * 30
+	// sessions are only rewritten once per touchInterval, rather than on every request
+	touchInterval = time.Minute
+	secretBytes   = 32
+)
+
+// Manager starts & ends sessions, storing them hashed in cassandra
+type Manager struct {
+	CassClient cass.Client
+	// Domain is optional. Set it to the frontend's domain so its javascript can read the csrf cookie
+	Domain      string
+	IdleTimeout time.Duration
+	MaxAge      time.Duration
+}
+
+func NewManager(cassClient cass.Client, domain string, idleTimeout time.Duration, maxAge time.Duration) *Manager {
+	if idleTimeout <= 0 {
+		idleTimeout = DefaultIdleTimeout
+	}
+	if maxAge <= 0 {
+		maxAge = DefaultMaxAge
+	}
+
+	return &Manager{
+		CassClient:  cassClient,
+		Domain:      domain,
+		IdleTimeout: idleTimeout,
+		MaxAge:      maxAge,
+	}
+}
+
+// Start creates a session for a user & sets its cookies, returning the session's csrf token
+func (self *Manager) Start(ctx context.Context, rw http.ResponseWriter, userId *gocql.UUID) (string, error) {
+	secret, secretErr := randomHex()
+	if secretErr != nil {
+		return "", secretErr
+	}
+	csrf, csrfErr := randomHex()
+	if csrfErr != nil {
+		return "", csrfErr
+	}
+
+	now := time.Now()
+	s := &cass.Session{
+		Hash:      Hash(secret),
+		UserId:    userId,
+		CSRFToken: csrf,
+		CreatedAt: now,
+		LastSeen:  now,
+		ExpiresAt: now.Add(self.MaxAge),
+	}
+
+	if res := self.CassClient.PutSession(ctx, s, self.IdleTimeout); res.Err != nil {
+		return "", res.Err
+	}
+
+	http.SetCookie(rw, self.cookie(CookieName, secret, true, int(self.MaxAge.Seconds())))
+	http.SetCookie(rw, self.cookie(CSRFCookieName, csrf, false, int(self.MaxAge.Seconds())))
+	return csrf, nil
+}
+
+// End deletes the request's session, if any, & clears its cookies
+func (self *Manager) End(rw http.ResponseWriter, r *http.Request) error {
+	self.clear(rw)
+
+	cookie, cookieErr := r.Cookie(CookieName)
+	if cookieErr != nil {
+		return nil
+	}
+
+	return self.CassClient.DeleteSession(r.Context(), Hash(cookie.Value)).Err
+}
+
+// Fetch returns the request's session, or nil if it has none or it has expired
+func (self *Manager) Fetch(r *http.Request) (*cass.Session, error) {
+	cookie, cookieErr := r.Cookie(CookieName)
+	if cookieErr != nil || cookie.Value == "" {
+		return nil, nil
+	}
+
+	s, fetchErr := self.CassClient.FetchSession(r.Context(), Hash(cookie.Value))
+	if fetchErr == gocql.ErrNotFound {
+		return nil, nil
+	}
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+
+	now := time.Now()
+	if now.After(s.ExpiresAt) || now.After(s.LastSeen.Add(self.IdleTimeout)) {
+		return nil, nil
+	}
+	return s, nil
+}
+
+// touch extends an idle session's lifetime. It returns gocql.ErrNotFound if the session has ended since it was fetched.
+func (self *Manager) touch(ctx context.Context, s *cass.Session) error {
+	if time.Since(s.LastSeen) < touchInterval {
+		return nil
+	}
+
+	s.LastSeen = time.Now()
+	return self.CassClient.TouchSession(ctx, s, self.IdleTimeout).Err
+}
+
+func (self *Manager) cookie(name string, value string, httpOnly bool, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   self.Domain,
+		MaxAge:   maxAge,
+		HttpOnly: httpOnly,
+		Secure:   true,
+		// the frontend is same-site, so the cookies are never needed on cross-site requests
+		SameSite: http.SameSiteStrictMode,
+	}
+}
+
+func (self *Manager) clear(rw http.ResponseWriter) {
+	http.SetCookie(rw, self.cookie(CookieName, "", true, -1))
+	http.SetCookie(rw, self.cookie(CSRFCookieName, "", false, -1))
+}
+
+// Authenticator accepts a session cookie, deferring to Next when the request carries a jwt or api key header
+type Authenticator struct {
+	Next jwt.Authenticator
+	// Sessions is optional; when nil, session cookies are ignored
+	Sessions *Manager
+	// Decoder checks the session's account for suspensions & the admin role
+	Decoder *jwt.Decoder
+}
+
+// Validate fulfills the jwt.Authenticator interface
+func (self *Authenticator) Validate(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if self.Sessions == nil || r.Header.Get(jwt.JWTKeyword) != "" || r.Header.Get(apikey.APIKeyKeyword) != "" {
+		self.Next.Validate(rw, r, next)
+		return
+	}
+
+	if _, cookieErr := r.Cookie(CookieName); cookieErr != nil {
+		self.Next.Validate(rw, r, next)
+		return
+	}
+
+	s, fetchErr := self.Sessions.Fetch(r)
+	if fetchErr != nil {
+		err := apierr.From(fetchErr)
+		err.Flush(rw)
+		return
+	}
+	if s == nil {
+		self.Sessions.clear(rw)
+		err := &validator.RequestErr{Status: http.StatusUnauthorized, Message: "session expired"}
+		err.Flush(rw)
+		return
+	}
+
+	if !safeMethod(r.Method) && !validCSRF(r, s.CSRFToken) {
+		err := &validator.RequestErr{Status: http.StatusForbidden, Message: "invalid csrf token"}
+		err.Flush(rw)
+		return
+	}
+
+	// a logout or purge may have raced this request
+	if touchErr := self.Sessions.touch(r.Context(), s); touchErr == gocql.ErrNotFound {
+		self.Sessions.clear(rw)
+		err := &validator.RequestErr{Status: http.StatusUnauthorized, Message: "session expired"}
+		err.Flush(rw)
+		return
+	} else if touchErr != nil {
+		err := apierr.From(touchErr)
+		err.Flush(rw)
+		return
+	}
+
+	bindings := &jwt.Bindings{
+		UserId:    s.UserId,
+		ExpiresAt: s.ExpiresAt,
+		Session:   s.Hash,
+	}
+
+	if self.Decoder != nil {
+		if err := self.Decoder.CheckAccount(r.Context(), bindings); err != nil {
+			err.Flush(rw)
+			return
+		}
+	}
+
+	newCtx := context.WithValue(r.Context(), jwt.JWTNamespace, bindings)
+	next(rw, r.WithContext(newCtx))
+}
+
+// validCSRF requires the csrf header to match both the csrf cookie & the token stored with the session
+func validCSRF(r *http.Request, expected string) bool {
+	header := r.Header.Get(CSRFKeyword)
+	cookie, cookieErr := r.Cookie(CSRFCookieName)
+	if header == "" || cookieErr != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(header), []byte(cookie.Value)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(header), []byte(expected)) == 1
+}
+
+// Hash is the form in which session cookies are stored
+func Hash(secret string) []byte {
+	sum := sha256.Sum256([]byte(secret))
+	return sum[:]
+}
+
+func randomHex() (string, error) {
+	secret := make([]byte, secretBytes)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package session
+
+import (
+	"context"
+	"github.com/gocql/gocql"
+	"github.com/owen-d/beacon-api/lib/auth/jwt"
+	"github.com/owen-d/beacon-api/lib/cass"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestValidCSRF(t *testing.T) {
+	cases := []struct {
+		name     string
+		header   string
+		cookie   string
+		expected bool
+	}{
+		{"matching", "tok", "tok", true},
+		{"missing header", "", "tok", false},
+		{"missing cookie", "tok", "", false},
+		{"cookie mismatch", "tok", "other", false},
+		{"session mismatch", "other", "other", false},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest("POST", "/", nil)
+		if c.header != "" {
+			r.Header.Set(CSRFKeyword, c.header)
+		}
+		if c.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: CSRFCookieName, Value: c.cookie})
+		}
+
+		if res := validCSRF(r, "tok"); res != c.expected {
+			t.Error(c.name, "expected", c.expected)
+		}
+	}
+}
+
+type recordingAuth struct{ called bool }
+
+func (self *recordingAuth) Validate(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	self.called = true
+}
+
+func TestHeadersTakePrecedence(t *testing.T) {
+	next := &recordingAuth{}
+	auth := &Authenticator{Next: next, Sessions: NewManager(nil, "", 0, 0)}
+
+	r := httptest.NewRequest("POST", "/", nil)
+	r.Header.Set(jwt.JWTKeyword, "token")
+	r.AddCookie(&http.Cookie{Name: CookieName, Value: "secret"})
+
+	auth.Validate(httptest.NewRecorder(), r, nil)
+	if !next.called {
+		t.Error("expected the jwt header to be validated instead of the session")
+	}
+
+	next.called = false
+	auth.Validate(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), nil)
+	if !next.called {
+		t.Error("expected requests without a session cookie to fall through")
+	}
+}
+
+// loggedOut finds a session that is deleted before it can be touched, as when a logout races the request
+type loggedOut struct {
+	cass.Client
+	session *cass.Session
+}
+
+func (self *loggedOut) FetchSession(ctx context.Context, hash []byte) (*cass.Session, error) {
+	return self.session, nil
+}
+
+func (self *loggedOut) TouchSession(ctx context.Context, s *cass.Session, idle time.Duration) *cass.UpsertResult {
+	return &cass.UpsertResult{Err: gocql.ErrNotFound}
+}
+
+func TestTouchAfterLogout(t *testing.T) {
+	userId := gocql.TimeUUID()
+	// idle long enough to be touched
+	seen := time.Now().Add(-2 * touchInterval)
+	client := &loggedOut{session: &cass.Session{UserId: &userId, LastSeen: seen, ExpiresAt: time.Now().Add(time.Hour)}}
+	auth := &Authenticator{Next: &recordingAuth{}, Sessions: NewManager(client, "", 0, 0)}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: CookieName, Value: "secret"})
+	rw := httptest.NewRecorder()
+	auth.Validate(rw, r, func(http.ResponseWriter, *http.Request) {
+		t.Error("expected an ended session to be refused")
+	})
+
+	if rw.Code != http.StatusUnauthorized {
+		t.Error("expected a logged out session to be unauthorized, got", rw.Code)
+	}
+}
@@ -0,0 +1,132 @@
+// Package tokens issues short-lived access tokens alongside rotating refresh tokens
+package tokens
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/gocql/gocql"
+	"github.com/owen-d/beacon-api/lib/auth/jwt"
+	"github.com/owen-d/beacon-api/lib/cass"
+	"io"
+	"time"
+)
+
+const (
+	DefaultAccessTTL  = time.Minute * 15
+	DefaultRefreshTTL = time.Hour * 24 * 30
+	refreshTokenBytes = 32
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+)
+
+// Pair is handed to clients upon login & every refresh
+type Pair struct {
+	AccessToken string `json:"jwt"`
+	// ExpiresIn is the access token's lifetime in seconds
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// Issuer mints token pairs, storing refresh tokens hashed in cassandra
+type Issuer struct {
+	Encoder    *jwt.Encoder
+	CassClient cass.Client
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+}
+
+func NewIssuer(encoder *jwt.Encoder, cassClient cass.Client) *Issuer {
+	return &Issuer{
+		Encoder:    encoder,
+		CassClient: cassClient,
+		AccessTTL:  DefaultAccessTTL,
+		RefreshTTL: DefaultRefreshTTL,
+	}
+}
+
+// Issue creates a new access & refresh token for a user
+func (self *Issuer) Issue(ctx context.Context, userId *gocql.UUID) (*Pair, error) {
+	accessToken, encodeErr := self.Encoder.Encode(*userId, time.Now().Add(self.AccessTTL).Unix())
+	if encodeErr != nil {
+		return nil, encodeErr
+	}
+
+	secret := make([]byte, refreshTokenBytes)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return nil, err
+	}
+	refreshToken := hex.EncodeToString(secret)
+
+	res := self.CassClient.CreateRefreshToken(ctx, &cass.RefreshToken{
+		Hash:      Hash(refreshToken),
+		UserId:    userId,
+		ExpiresAt: time.Now().Add(self.RefreshTTL),
+	})
+	if res.Err != nil {
+		return nil, res.Err
+	}
+
+	return &Pair{
+		AccessToken:  accessToken,
+		ExpiresIn:    int64(self.AccessTTL.Seconds()),
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// Impersonate creates an access token acting as userId on behalf of an admin. It has no refresh token & cannot be refreshed.
+func (self *Issuer) Impersonate(userId *gocql.UUID, adminId *gocql.UUID) (*Pair, error) {
+	accessToken, encodeErr := self.Encoder.EncodeImpersonation(*userId, *adminId, time.Now().Add(self.AccessTTL).Unix())
+	if encodeErr != nil {
+		return nil, encodeErr
+	}
+
+	return &Pair{
+		AccessToken: accessToken,
+		ExpiresIn:   int64(self.AccessTTL.Seconds()),
+	}, nil
+}
+
+// Refresh exchanges a refresh token for a new pair. The presented refresh token is consumed & cannot be reused.
+func (self *Issuer) Refresh(ctx context.Context, refreshToken string) (*Pair, error) {
+	tok, consumeErr := self.CassClient.ConsumeRefreshToken(ctx, Hash(refreshToken))
+	if consumeErr == gocql.ErrNotFound || consumeErr == cass.ErrTokenConsumed {
+		return nil, ErrInvalidRefreshToken
+	}
+	if consumeErr != nil {
+		return nil, consumeErr
+	}
+
+	if time.Now().After(tok.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	return self.Issue(ctx, tok.UserId)
+}
+
+// Revoke invalidates the access token described by bindings, as well as a refresh token if one is provided
+func (self *Issuer) Revoke(ctx context.Context, bindings *jwt.Bindings, refreshToken string) error {
+	if res := self.CassClient.RevokeToken(ctx, bindings.TokenId, bindings.ExpiresAt); res.Err != nil {
+		return res.Err
+	}
+
+	if refreshToken == "" {
+		return nil
+	}
+
+	_, consumeErr := self.CassClient.ConsumeRefreshToken(ctx, Hash(refreshToken))
+	if consumeErr == gocql.ErrNotFound || consumeErr == cass.ErrTokenConsumed {
+		return nil
+	}
+	return consumeErr
+}
+
+// Hash is the form in which refresh tokens are stored
+func Hash(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
@@ -1,20 +1,27 @@
 package beaconclient
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"github.com/owen-d/beacon-api/lib/apierr"
+	"github.com/owen-d/beacon-api/lib/logging"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/proximitybeacon/v1beta1"
 	"io/ioutil"
-	"log"
 	"net/http"
+	"time"
 )
 
 const (
 	googleNamespacedType = "com.google.nearby/en"
+	// MaxConcurrentCalls bounds the proximity api calls in flight across all requests, protecting our quota
+	MaxConcurrentCalls = 8
+	// CallTimeout bounds each proximity api call, within whatever deadline the caller's context carries
+	CallTimeout = 10 * time.Second
 )
 
 // Instantiate a client with credentials bound
@@ -28,11 +35,11 @@ func JWTConfigFromJSON(fPath, scope string) *http.Client {
 	// key file will then be downloaded to your computer.
 	data, err := ioutil.ReadFile(fPath)
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal("couldn't read google credentials", err)
 	}
 	conf, err := google.JWTConfigFromJSON(data, scope)
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal("couldn't parse google credentials", err)
 	}
 	// Initiate an http.Client. The following GET request will be
 	// authorized and authenticated on the behalf of
@@ -42,18 +49,23 @@ func JWTConfigFromJSON(fPath, scope string) *http.Client {
 	return client
 }
 
+// Client calls the proximity api. Each call is bounded by its context & CallTimeout.
 type Client interface {
-	GetOwnedBeaconNames() (*proximitybeacon.ListBeaconsResponse, error)
-	GetBeaconById(name string) (*proximitybeacon.Beacon, error)
-	GetBeaconsByNames(bNames []string) []*proximitybeacon.Beacon
-	GetAttachmentsForBeacon(name string) ([]*proximitybeacon.BeaconAttachment, error)
-	CreateAttachment(beaconName string, attachmentData *AttachmentData) (*proximitybeacon.BeaconAttachment, error)
-	BatchDeleteAttachments(beaconName string) (int64, error)
-	DeclarativeAttach([][]byte, *AttachmentData) []*AttachmentResult
+	GetOwnedBeaconNames(ctx context.Context) (*proximitybeacon.ListBeaconsResponse, error)
+	GetBeaconById(ctx context.Context, name string) (*proximitybeacon.Beacon, error)
+	GetBeaconsByNames(ctx context.Context, bNames []string) []*proximitybeacon.Beacon
+	GetAttachmentsForBeacon(ctx context.Context, name string) ([]*proximitybeacon.BeaconAttachment, error)
+	CreateAttachment(ctx context.Context, beaconName string, attachmentData *AttachmentData) (*proximitybeacon.BeaconAttachment, error)
+	BatchDeleteAttachments(ctx context.Context, beaconName string) (int64, error)
+	DeclarativeAttach(context.Context, [][]byte, *AttachmentData) []*AttachmentResult
 }
 
 type BeaconClient struct {
 	Svc *proximitybeacon.Service
+	// calls is a semaphore of MaxConcurrentCalls. A nil semaphore doesn't limit calls.
+	calls chan struct{}
+	// tokens authorize calls, if the client was created with oauth2 credentials
+	tokens oauth2.TokenSource
 }
 
 func NewBeaconClient(client *http.Client) (*BeaconClient, error) {
@@ -61,20 +73,37 @@ func NewBeaconClient(client *http.Client) (*BeaconClient, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &BeaconClient{svc}, nil
+	res := &BeaconClient{Svc: svc, calls: make(chan struct{}, MaxConcurrentCalls)}
+	if transport, ok := client.Transport.(*oauth2.Transport); ok {
+		res.tokens = transport.Source
+	}
+	return res, nil
 
 }
 
-func (c *BeaconClient) GetOwnedBeaconNames() (*proximitybeacon.ListBeaconsResponse, error) {
-	return c.Svc.Beacons.List().Q("status:active").Do()
+// Ping checks our credentials can be exchanged for a token. Tokens are cached until they expire, so this rarely calls google.
+func (c *BeaconClient) Ping(ctx context.Context) error {
+	if c.tokens == nil {
+		return nil
+	}
+	_, err := c.tokens.Token()
+	return err
 }
 
-func (c *BeaconClient) GetBeaconById(name string) (*proximitybeacon.Beacon, error) {
+func (c *BeaconClient) GetOwnedBeaconNames(ctx context.Context) (res *proximitybeacon.ListBeaconsResponse, err error) {
+	ctx, done := call(ctx, "list_beacons")
+	defer done(&err)
+	return c.Svc.Beacons.List().Q("status:active").Context(ctx).Do()
+}
+
+func (c *BeaconClient) GetBeaconById(ctx context.Context, name string) (res *proximitybeacon.Beacon, err error) {
+	ctx, done := call(ctx, "get_beacon")
+	defer done(&err)
 	prefixed := "beacons/3!" + name
-	return c.Svc.Beacons.Get(prefixed).Do()
+	return c.Svc.Beacons.Get(prefixed).Context(ctx).Do()
 }
 
-func (c *BeaconClient) GetBeaconsByNames(bNames []string) []*proximitybeacon.Beacon {
+func (c *BeaconClient) GetBeaconsByNames(ctx context.Context, bNames []string) []*proximitybeacon.Beacon {
 	length := len(bNames)
 	type Wrapper struct {
 		I   int
@@ -85,8 +114,10 @@ func (c *BeaconClient) GetBeaconsByNames(bNames []string) []*proximitybeacon.Bea
 	results := make([]*proximitybeacon.Beacon, length)
 	// process concurently in goroutines
 	for i, name := range bNames {
+		dispatched.Inc()
 		go func(i int) {
-			beacon, err := c.GetBeaconById(name)
+			defer dispatched.Dec()
+			beacon, err := c.GetBeaconById(ctx, name)
 			ch <- &Wrapper{i, beacon, err}
 		}(i)
 	}
@@ -100,10 +131,11 @@ func (c *BeaconClient) GetBeaconsByNames(bNames []string) []*proximitybeacon.Bea
 	return results
 }
 
-func (c *BeaconClient) GetAttachmentsForBeacon(name string) ([]*proximitybeacon.BeaconAttachment, error) {
+func (c *BeaconClient) GetAttachmentsForBeacon(ctx context.Context, name string) (results []*proximitybeacon.BeaconAttachment, err error) {
+	ctx, done := call(ctx, "list_attachments")
+	defer done(&err)
 	prefixed := "beacons/3!" + name
-	res, err := c.Svc.Beacons.Attachments.List(prefixed).NamespacedType(googleNamespacedType).Do()
-	var results []*proximitybeacon.BeaconAttachment
+	res, err := c.Svc.Beacons.Attachments.List(prefixed).NamespacedType(googleNamespacedType).Context(ctx).Do()
 	if err != nil {
 		return results, err
 	}
@@ -112,7 +144,9 @@ func (c *BeaconClient) GetAttachmentsForBeacon(name string) ([]*proximitybeacon.
 }
 
 // TBD: parameterize namespacedType
-func (c *BeaconClient) CreateAttachment(beaconName string, attachmentData *AttachmentData) (*proximitybeacon.BeaconAttachment, error) {
+func (c *BeaconClient) CreateAttachment(ctx context.Context, beaconName string, attachmentData *AttachmentData) (res *proximitybeacon.BeaconAttachment, err error) {
+	ctx, done := call(ctx, "create_attachment")
+	defer done(&err)
 	prefixed := "beacons/3!" + beaconName
 	data := attachmentData.encode()
 	newAttachment := proximitybeacon.BeaconAttachment{
@@ -120,13 +154,15 @@ func (c *BeaconClient) CreateAttachment(beaconName string, attachmentData *Attac
 		NamespacedType: googleNamespacedType,
 	}
 
-	return c.Svc.Beacons.Attachments.Create(prefixed, &newAttachment).Do()
+	return c.Svc.Beacons.Attachments.Create(prefixed, &newAttachment).Context(ctx).Do()
 }
 
 // TBD: parameterize namespacedType
-func (c *BeaconClient) BatchDeleteAttachments(beaconName string) (int64, error) {
+func (c *BeaconClient) BatchDeleteAttachments(ctx context.Context, beaconName string) (deleted int64, err error) {
+	ctx, done := call(ctx, "delete_attachments")
+	defer done(&err)
 	prefixed := "beacons/3!" + beaconName
-	res, err := c.Svc.Beacons.Attachments.BatchDelete(prefixed).NamespacedType(googleNamespacedType).Do()
+	res, err := c.Svc.Beacons.Attachments.BatchDelete(prefixed).NamespacedType(googleNamespacedType).Context(ctx).Do()
 	if err != nil {
 		return 0, err
 	}
@@ -145,31 +181,84 @@ func (a *AttachmentData) encode() string {
 
 // AttachmentResult is a wrapper type hol,ding response data from google beacon platform about attachment deletions and creations
 type AttachmentResult struct {
-	Name       string
-	Err        error
-	Attachment *proximitybeacon.BeaconAttachment `json:-`
+	Name       string                            `json:"name"`
+	Err        error                             `json:"error,omitempty"`
+	Attachment *proximitybeacon.BeaconAttachment `json:"-"`
+}
+
+// MarshalJSON reports Err in the api's error model, as errors otherwise marshal to {}
+func (self *AttachmentResult) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		Name  string        `json:"name"`
+		Error *apierr.Error `json:"error,omitempty"`
+	}{self.Name, apierr.From(self.Err)})
+}
+
+// call bounds a proximity api call by CallTimeout, returning a func to defer which records the call's error
+func call(ctx context.Context, op string) (context.Context, func(*error)) {
+	ctx, cancel := context.WithTimeout(ctx, CallTimeout)
+	start := time.Now()
+	return ctx, func(err *error) {
+		cancel()
+		observe(ctx, op, start, err)
+	}
+}
+
+// acquire waits for a call slot, giving up once ctx is done
+func (self *BeaconClient) acquire(ctx context.Context) error {
+	if self.calls != nil {
+		select {
+		case self.calls <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	// a slot may free up just as ctx is done
+	if err := ctx.Err(); err != nil {
+		self.release()
+		return err
+	}
+	return nil
+}
+
+func (self *BeaconClient) release() {
+	if self.calls != nil {
+		<-self.calls
+	}
 }
 
-func (self *BeaconClient) DeclarativeAttach(bNames [][]byte, attachment *AttachmentData) []*AttachmentResult {
+// DeclarativeAttach replaces the attachments of each beacon concurrently. Once ctx is done, beacons waiting their turn fail rather than run,
+// while those already started finish.
+func (self *BeaconClient) DeclarativeAttach(ctx context.Context, bNames [][]byte, attachment *AttachmentData) []*AttachmentResult {
 	res := make([]*AttachmentResult, 0, len(bNames))
 
-	ch := make(chan *AttachmentResult)
+	// buffered, so every goroutine can finish even if results are no longer awaited
+	ch := make(chan *AttachmentResult, len(bNames))
 
 	// delete old attachments & apply new one
 	for _, bName := range bNames {
+		dispatched.Inc()
 		go func(bName []byte, ch chan<- *AttachmentResult) {
+			defer dispatched.Dec()
 			// assign url altered url
 			strName := hex.EncodeToString(bName)
-			shortBknName := bName[len(bName)-6:]
-			alteredAttach := &AttachmentData{
-				Title: attachment.Title,
-				Url:   fmt.Sprint("https://our.sharecro.ws/bkn/", hex.EncodeToString(shortBknName)),
-			}
 
 			resp := &AttachmentResult{Name: strName}
 
+			if err := self.acquire(ctx); err != nil {
+				resp.Err = err
+				ch <- resp
+				return
+			}
+			defer self.release()
+
+			// once started, the delete & create run to completion (each within CallTimeout) even if ctx is cancelled,
+			// so a beacon is never left without its attachment. Cancellation only stops beacons not yet started.
+			ctx := context.WithoutCancel(ctx)
+
 			// remove old attachments on beacon
-			_, deleteErr := self.BatchDeleteAttachments(strName)
+			_, deleteErr := self.BatchDeleteAttachments(ctx, strName)
 			if deleteErr != nil {
 				resp.Err = deleteErr
 				ch <- resp
@@ -182,7 +271,13 @@ func (self *BeaconClient) DeclarativeAttach(bNames [][]byte, attachment *Attachm
 				return
 			}
 
-			postedAttachment, postErr := self.CreateAttachment(strName, alteredAttach)
+			shortBknName := bName[len(bName)-6:]
+			alteredAttach := &AttachmentData{
+				Title: attachment.Title,
+				Url:   fmt.Sprint("https://our.sharecro.ws/bkn/", hex.EncodeToString(shortBknName)),
+			}
+
+			postedAttachment, postErr := self.CreateAttachment(ctx, strName, alteredAttach)
 
 			if postErr != nil {
 				resp.Err = postErr
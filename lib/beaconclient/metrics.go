@@ -0,0 +1,36 @@
+package beaconclient
+
+import (
+	"context"
+	"errors"
+	"github.com/owen-d/beacon-api/lib/apierr"
+	"github.com/owen-d/beacon-api/lib/metrics"
+	"log/slog"
+	"time"
+)
+
+var (
+	calls        = metrics.NewCounter("proximity_calls_total", "Proximity api calls, by operation.", "op")
+	callErrors   = metrics.NewCounter("proximity_errors_total", "Failed proximity api calls, by operation.", "op")
+	quotaErrors  = metrics.NewCounter("proximity_quota_errors_total", "Proximity api calls refused over our quota, by operation.", "op")
+	callDuration = metrics.NewHistogram("proximity_call_duration_seconds", "Proximity api latency, by operation.", nil, "op")
+	dispatched   = metrics.NewGauge("proximity_dispatch_goroutines", "Goroutines dispatched to call the proximity api which haven't finished, including those waiting their turn.")
+)
+
+// observe records a call once it returns, so it's deferred with a pointer to the call's error. ctx tags the failures logged with their request's id.
+func observe(ctx context.Context, op string, start time.Time, err *error) {
+	calls.Inc(op)
+	callDuration.Since(start, op)
+	if errors.Is(*err, context.Canceled) {
+		// the caller went away, which isn't the proximity api's failure
+		slog.DebugContext(ctx, "beaconclient: proximity call canceled", "op", op)
+		return
+	}
+	if *err != nil {
+		callErrors.Inc(op)
+		if apierr.IsQuota(*err) {
+			quotaErrors.Inc(op)
+		}
+		slog.ErrorContext(ctx, "beaconclient: proximity call failed", "op", op, "error", *err)
+	}
+}
@@ -0,0 +1,74 @@
+// Cassandra lib
+package cass
+
+import (
+	"context"
+	"github.com/gocql/gocql"
+	"time"
+)
+
+// UserStatus holds account level state. Users without a row are neither admins nor suspended.
+type UserStatus struct {
+	UserId           *gocql.UUID `cql:"user_id" json:"user_id"`
+	Admin            bool        `cql:"admin" json:"admin"`
+	SuspendedAt      *time.Time  `cql:"suspended_at" json:"suspended_at,omitempty"`
+	SuspendedBy      *gocql.UUID `cql:"suspended_by" json:"suspended_by,omitempty"`
+	SuspensionReason string      `cql:"suspension_reason" json:"suspension_reason,omitempty"`
+}
+
+func (self *CassClient) FetchUserStatus(ctx context.Context, userId *gocql.UUID) (*UserStatus, error) {
+	status := &UserStatus{UserId: userId}
+	var suspendedAt time.Time
+	template := `SELECT admin, suspended_at, suspended_by, suspension_reason FROM user_status WHERE user_id = ?`
+	err := self.query(ctx, template, userId).Scan(&status.Admin, &suspendedAt, &status.SuspendedBy, &status.SuspensionReason)
+
+	if err == gocql.ErrNotFound {
+		return status, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !suspendedAt.IsZero() {
+		status.SuspendedAt = &suspendedAt
+	}
+	return status, nil
+}
+
+// CheckAccount fulfills the jwt.AccountChecker interface
+func (self *CassClient) CheckAccount(ctx context.Context, userId *gocql.UUID) (bool, bool, error) {
+	status, err := self.FetchUserStatus(ctx, userId)
+	if err != nil {
+		return false, false, err
+	}
+	return status.Admin, status.SuspendedAt != nil, nil
+}
+
+func (self *CassClient) SuspendUser(ctx context.Context, userId *gocql.UUID, by *gocql.UUID, reason string) *UpsertResult {
+	template := `UPDATE user_status SET suspended_at = ?, suspended_by = ?, suspension_reason = ? WHERE user_id = ?`
+
+	return &UpsertResult{
+		Batch: nil,
+		Err:   self.query(ctx, template, time.Now(), by, reason, userId).Exec(),
+	}
+}
+
+func (self *CassClient) UnsuspendUser(ctx context.Context, userId *gocql.UUID) *UpsertResult {
+	template := `DELETE suspended_at, suspended_by, suspension_reason FROM user_status WHERE user_id = ?`
+
+	return &UpsertResult{
+		Batch: nil,
+		Err:   self.query(ctx, template, userId).Exec(),
+	}
+}
+
+// FetchBeaconOwner finds a beacon by name alone, via the beacons_by_id view
+func (self *CassClient) FetchBeaconOwner(ctx context.Context, name []byte) (*Beacon, error) {
+	bkn := &Beacon{Name: name}
+	template := `SELECT user_id, deploy_name FROM beacons_by_id WHERE name = ? LIMIT 1`
+
+	if err := self.query(ctx, template, name).Scan(&bkn.UserId, &bkn.DeployName); err != nil {
+		return nil, err
+	}
+	return bkn, nil
+}
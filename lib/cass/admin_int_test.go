@@ -0,0 +1,45 @@
+package cass
+
+import (
+	"context"
+	"github.com/gocql/gocql"
+	"testing"
+)
+
+func TestSuspendUser(t *testing.T) {
+	client := createLocalhostClient("bkn")
+	defer client.Sess.Close()
+
+	userId, adminId := gocql.TimeUUID(), gocql.TimeUUID()
+
+	if admin, suspended, err := client.CheckAccount(context.Background(), &userId); err != nil || admin || suspended {
+		t.Error("expected an unremarkable account:", err)
+	}
+
+	if res := client.SuspendUser(context.Background(), &userId, &adminId, "spam"); res.Err != nil {
+		t.Error("failed to suspend user:", res.Err)
+	}
+
+	status, err := client.FetchUserStatus(context.Background(), &userId)
+	if err != nil || status.SuspendedAt == nil || status.SuspensionReason != "spam" {
+		t.Error("expected suspended status:", err)
+	}
+
+	if res := client.UnsuspendUser(context.Background(), &userId); res.Err != nil {
+		t.Error("failed to unsuspend user:", res.Err)
+	}
+
+	if _, suspended, err := client.CheckAccount(context.Background(), &userId); err != nil || suspended {
+		t.Error("expected unsuspended account:", err)
+	}
+}
+
+func TestFetchBeaconOwner(t *testing.T) {
+	client := createLocalhostClient("bkn")
+	defer client.Sess.Close()
+
+	bkn, err := client.FetchBeaconOwner(context.Background(), prepopBName)
+	if err != nil || bkn.UserId.String() != prepopId {
+		t.Error("failed to fetch beacon owner:", err)
+	}
+}
@@ -0,0 +1,99 @@
+// Cassandra lib
+package cass
+
+import (
+	"context"
+	"github.com/gocql/gocql"
+	"time"
+)
+
+// APIKey grants machine access on behalf of a user. Only the hash of the key is stored.
+type APIKey struct {
+	Hash      []byte      `cql:"key_hash" json:"-"`
+	UserId    *gocql.UUID `cql:"user_id" json:"-"`
+	Name      string      `cql:"name" json:"name"`
+	ReadOnly  bool        `cql:"read_only" json:"read_only"`
+	CreatedAt time.Time   `cql:"created_at" json:"created_at"`
+}
+
+// CreateAPIKey stores a new api key, returning ErrConflict if the user already has a key by that name
+func (self *CassClient) CreateAPIKey(ctx context.Context, key *APIKey) *UpsertResult {
+	claim := `INSERT INTO api_key_names (user_id, name, key_hash) VALUES (?, ?, ?) IF NOT EXISTS`
+	applied, claimErr := self.query(ctx, claim, key.UserId, key.Name, key.Hash).MapScanCAS(map[string]interface{}{})
+	if claimErr != nil {
+		return &UpsertResult{Batch: nil, Err: claimErr}
+	}
+	if !applied {
+		return &UpsertResult{Batch: nil, Err: ErrConflict}
+	}
+
+	template := `INSERT INTO api_keys (key_hash, user_id, name, read_only, created_at) VALUES (?, ?, ?, ?, ?)`
+	args := []interface{}{
+		key.Hash,
+		key.UserId,
+		key.Name,
+		key.ReadOnly,
+		key.CreatedAt,
+	}
+
+	if err := self.query(ctx, template, args...).Exec(); err != nil {
+		// release the name, so the user may try again
+		self.query(ctx, `DELETE FROM api_key_names WHERE user_id = ? AND name = ?`, key.UserId, key.Name).Exec()
+		return &UpsertResult{Batch: nil, Err: err}
+	}
+	return &UpsertResult{}
+}
+
+// FetchAPIKey looks up a key by its hash
+func (self *CassClient) FetchAPIKey(ctx context.Context, hash []byte) (*APIKey, error) {
+	key := &APIKey{Hash: hash}
+	template := `SELECT user_id, name, read_only, created_at FROM api_keys WHERE key_hash = ?`
+	if err := self.query(ctx, template, hash).Scan(&key.UserId, &key.Name, &key.ReadOnly, &key.CreatedAt); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// FetchAPIKeys lists a user's keys, ordered by name
+func (self *CassClient) FetchAPIKeys(ctx context.Context, userId *gocql.UUID) ([]*APIKey, error) {
+	template := `SELECT key_hash, user_id, name, read_only, created_at FROM api_keys_by_user WHERE user_id = ?`
+
+	resRows := make([]*APIKey, 0)
+	iter := self.query(ctx, template, userId).Iter()
+	shell := map[string]interface{}{}
+	for iter.MapScan(shell) {
+		id := shell["user_id"].(gocql.UUID)
+		resRows = append(resRows, &APIKey{
+			Hash:      shell["key_hash"].([]byte),
+			UserId:    &id,
+			Name:      shell["name"].(string),
+			ReadOnly:  shell["read_only"].(bool),
+			CreatedAt: shell["created_at"].(time.Time),
+		})
+
+		shell = map[string]interface{}{}
+	}
+
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return resRows, nil
+}
+
+// RevokeAPIKey deletes a user's key by name, returning gocql.ErrNotFound if there is no such key
+func (self *CassClient) RevokeAPIKey(ctx context.Context, userId *gocql.UUID, name string) *UpsertResult {
+	var hash []byte
+	lookup := `SELECT key_hash FROM api_keys_by_user WHERE user_id = ? AND name = ? LIMIT 1`
+	if err := self.query(ctx, lookup, userId, name).Scan(&hash); err != nil {
+		return &UpsertResult{Batch: nil, Err: err}
+	}
+
+	batch := gocql.NewBatch(gocql.LoggedBatch)
+	batch.Query(`DELETE FROM api_keys WHERE key_hash = ?`, hash)
+	batch.Query(`DELETE FROM api_key_names WHERE user_id = ? AND name = ?`, userId, name)
+
+	return &UpsertResult{
+		Batch: nil,
+		Err:   self.executeBatch(ctx, batch),
+	}
+}
@@ -0,0 +1,58 @@
+package cass
+
+import (
+	"context"
+	"github.com/gocql/gocql"
+	"testing"
+	"time"
+)
+
+func TestAPIKeys(t *testing.T) {
+	client := createLocalhostClient("bkn")
+	defer client.Sess.Close()
+
+	uuid, _ := gocql.ParseUUID(prepopId)
+	key := &APIKey{
+		Hash:      gocql.TimeUUID().Bytes(),
+		UserId:    &uuid,
+		Name:      "test-key-" + gocql.TimeUUID().String(),
+		ReadOnly:  true,
+		CreatedAt: time.Now(),
+	}
+
+	if res := client.CreateAPIKey(context.Background(), key); res.Err != nil {
+		t.Error("failed to create api key:", res.Err)
+		return
+	}
+
+	t.Run("fetch", func(t *testing.T) {
+		fetched, err := client.FetchAPIKey(context.Background(), key.Hash)
+		if err != nil || fetched.Name != key.Name || !fetched.ReadOnly {
+			t.Error("failed to fetch api key:", err)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		keys, err := client.FetchAPIKeys(context.Background(), &uuid)
+		if err != nil || len(keys) == 0 {
+			t.Error("failed to list api keys:", err)
+		}
+	})
+
+	t.Run("duplicate", func(t *testing.T) {
+		dup := *key
+		dup.Hash = gocql.TimeUUID().Bytes()
+		if res := client.CreateAPIKey(context.Background(), &dup); res.Err != ErrConflict {
+			t.Error("expected a duplicate name to conflict, got:", res.Err)
+		}
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		if res := client.RevokeAPIKey(context.Background(), &uuid, key.Name); res.Err != nil {
+			t.Error("failed to revoke api key:", res.Err)
+		}
+		if _, err := client.FetchAPIKey(context.Background(), key.Hash); err != gocql.ErrNotFound {
+			t.Error("expected revoked key to be gone, got:", err)
+		}
+	})
+}
@@ -0,0 +1,104 @@
+// Cassandra lib
+package cass
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gocql/gocql"
+	"time"
+)
+
+// AuditEvent records a single mutating operation. Before & After are json summaries of the target.
+type AuditEvent struct {
+	OwnerId        *gocql.UUID     `cql:"owner_id" json:"owner_id"`
+	Id             *gocql.UUID     `cql:"id" json:"id"`
+	ActorId        *gocql.UUID     `cql:"actor_id" json:"actor_id"`
+	ImpersonatorId *gocql.UUID     `cql:"impersonator_id" json:"impersonator_id,omitempty"`
+	Action         string          `cql:"action" json:"action"`
+	Target         string          `cql:"target" json:"target"`
+	Before         json.RawMessage `cql:"before" json:"before,omitempty"`
+	After          json.RawMessage `cql:"after" json:"after,omitempty"`
+	RequestId      string          `cql:"request_id" json:"request_id,omitempty"`
+	// At is derived from the event's timeuuid
+	At time.Time `json:"at"`
+}
+
+// AuditDay is the partition an event falls in
+func AuditDay(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Hour * 24)
+}
+
+func (self *CassClient) RecordEvent(ctx context.Context, e *AuditEvent) *UpsertResult {
+	if e.Id == nil {
+		id := gocql.TimeUUID()
+		e.Id = &id
+	}
+	e.At = e.Id.Time()
+
+	template := `INSERT INTO audit_events (owner_id, day, id, actor_id, impersonator_id, action, target, before, after, request_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	args := []interface{}{
+		e.OwnerId,
+		AuditDay(e.At),
+		e.Id,
+		e.ActorId,
+		e.ImpersonatorId,
+		e.Action,
+		e.Target,
+		string(e.Before),
+		string(e.After),
+		e.RequestId,
+	}
+
+	return &UpsertResult{
+		Batch: nil,
+		Err:   self.query(ctx, template, args...).Exec(),
+	}
+}
+
+// FetchEvents lists up to limit of an owner's events on a given day, newest first. A non nil before resumes after that event.
+func (self *CassClient) FetchEvents(ctx context.Context, ownerId *gocql.UUID, day time.Time, before *gocql.UUID, limit int) ([]*AuditEvent, error) {
+	template := `SELECT owner_id, id, actor_id, impersonator_id, action, target, before, after, request_id FROM audit_events WHERE owner_id = ? AND day = ?`
+	args := []interface{}{ownerId, AuditDay(day)}
+	if before != nil {
+		template += ` AND id < ?`
+		args = append(args, before)
+	}
+	template += ` LIMIT ?`
+	args = append(args, limit)
+
+	resRows := make([]*AuditEvent, 0)
+	iter := self.query(ctx, template, args...).Iter()
+	shell := map[string]interface{}{}
+	for iter.MapScan(shell) {
+		owner := shell["owner_id"].(gocql.UUID)
+		id := shell["id"].(gocql.UUID)
+		actor := shell["actor_id"].(gocql.UUID)
+		e := &AuditEvent{
+			OwnerId:   &owner,
+			Id:        &id,
+			ActorId:   &actor,
+			Action:    shell["action"].(string),
+			Target:    shell["target"].(string),
+			RequestId: shell["request_id"].(string),
+			At:        id.Time(),
+		}
+		if impersonator := shell["impersonator_id"].(gocql.UUID); impersonator != (gocql.UUID{}) {
+			e.ImpersonatorId = &impersonator
+		}
+		if before := shell["before"].(string); before != "" {
+			e.Before = json.RawMessage(before)
+		}
+		if after := shell["after"].(string); after != "" {
+			e.After = json.RawMessage(after)
+		}
+		resRows = append(resRows, e)
+
+		// since shell is used in each iteration, we must clear it.
+		shell = map[string]interface{}{}
+	}
+
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return resRows, nil
+}
@@ -0,0 +1,44 @@
+package cass
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gocql/gocql"
+	"testing"
+	"time"
+)
+
+func TestAuditEvents(t *testing.T) {
+	client := createLocalhostClient("bkn")
+	defer client.Sess.Close()
+
+	ownerId := gocql.TimeUUID()
+
+	for _, action := range []string{"deployment.put", "message.update"} {
+		e := &AuditEvent{
+			OwnerId: &ownerId,
+			ActorId: &ownerId,
+			Action:  action,
+			Target:  "example",
+			After:   json.RawMessage(`{"name":"example"}`),
+		}
+		if res := client.RecordEvent(context.Background(), e); res.Err != nil {
+			t.Error("failed to record event:", res.Err)
+		}
+	}
+
+	events, err := client.FetchEvents(context.Background(), &ownerId, time.Now(), nil, DefaultLimit)
+	if err != nil || len(events) != 2 || events[0].Action != "message.update" {
+		t.Error("expected newest events first:", err)
+	}
+	if len(events) > 0 && (events[0].Before != nil || events[0].ImpersonatorId != nil) {
+		t.Error("expected empty fields to round trip as nil")
+	}
+
+	if len(events) > 0 {
+		rest, err := client.FetchEvents(context.Background(), &ownerId, time.Now(), events[0].Id, DefaultLimit)
+		if err != nil || len(rest) != 1 || rest[0].Action != "deployment.put" {
+			t.Error("expected to resume after the first event:", err)
+		}
+	}
+}
@@ -1,8 +1,10 @@
 package cass
 
 import (
+	"context"
 	"encoding/hex"
 	"github.com/gocql/gocql"
+	"github.com/owen-d/beacon-api/lib/schedule"
 	"log"
 	"testing"
 )
@@ -67,7 +69,7 @@ func TestCreateBeacons(t *testing.T) {
 			},
 		}
 
-		res := client.CreateBeacons(bkns, nil)
+		res := client.CreateBeacons(context.Background(), bkns, nil)
 		if res.Err != nil {
 			t.Error("failed to create beacons: %v", res.Err)
 		}
@@ -88,7 +90,7 @@ func TestCreateBeacons(t *testing.T) {
 
 		batch := gocql.NewBatch(gocql.LoggedBatch)
 
-		res := client.CreateBeacons(bkns, batch)
+		res := client.CreateBeacons(context.Background(), bkns, batch)
 		testBatch(t, res, client, batch)
 	})
 }
@@ -105,7 +107,7 @@ func TestUpdateBeacons(t *testing.T) {
 		MsgUrl:     "http://fake.url",
 	}
 
-	res := client.UpdateBeacons([]*Beacon{&bkn})
+	res := client.UpdateBeacons(context.Background(), []*Beacon{&bkn})
 	if res.Err != nil {
 		t.Error("failed to create beacons: %v", res.Err)
 	}
@@ -121,7 +123,7 @@ func TestRemoveBeaconsDeployments(t *testing.T) {
 		Name:   prepopBName,
 	}
 
-	res := client.UpdateBeacons([]*Beacon{&bkn})
+	res := client.UpdateBeacons(context.Background(), []*Beacon{&bkn})
 	if res.Err != nil {
 		t.Error("failed to create beacons: %v", res.Err)
 	}
@@ -138,7 +140,7 @@ func TestFetchBeacon(t *testing.T) {
 		Name:   prepopBName,
 	}
 
-	found, err := client.FetchBeacon(&bkn)
+	found, err := client.FetchBeacon(context.Background(), &bkn)
 	if err != nil || found == nil {
 		t.Error("failed to match beacon:", err, ", ", found)
 	}
@@ -153,18 +155,22 @@ func TestCreateMessage(t *testing.T) {
 	t.Run("non-batch", func(t *testing.T) {
 		msg := Message{
 			UserId:      &uuid,
-			Name:        "non-batch-create-msg",
+			Name:        "non-batch-create-msg-" + gocql.TimeUUID().String(),
 			Title:       "filler",
 			Url:         "https://filler.com",
 			Lang:        "en",
 			Deployments: []string{"dep1"},
 		}
 
-		res := client.CreateMessage(&msg, nil)
+		res := client.CreateMessage(context.Background(), &msg, nil)
 		if res.Err != nil {
 			t.Error("failed to create msg:", res.Err)
 		}
 
+		if res := client.CreateMessage(context.Background(), &msg, nil); res.Err != ErrConflict {
+			t.Error("expected a conflict creating an existing msg:", res.Err)
+		}
+
 	})
 
 	t.Run("batch", func(t *testing.T) {
@@ -178,7 +184,7 @@ func TestCreateMessage(t *testing.T) {
 		}
 
 		batch := gocql.NewBatch(gocql.LoggedBatch)
-		res := client.CreateMessage(&msg, batch)
+		res := client.CreateMessage(context.Background(), &msg, batch)
 
 		testBatch(t, res, client, batch)
 	})
@@ -197,14 +203,14 @@ func TestUpdateMessage(t *testing.T) {
 	}
 
 	t.Run("update", func(t *testing.T) {
-		res := client.UpdateMessage(&msg, nil)
+		res := client.UpdateMessage(context.Background(), &msg, nil)
 		if res.Err != nil {
 			t.Error("failed to update msg:", res.Err)
 		}
 	})
 	t.Run("update-batch", func(t *testing.T) {
 		batch := gocql.NewBatch(gocql.LoggedBatch)
-		res := client.UpdateMessage(&msg, batch)
+		res := client.UpdateMessage(context.Background(), &msg, batch)
 
 		testBatch(t, res, client, batch)
 	})
@@ -223,7 +229,7 @@ func TestChangeMessageDeployments(t *testing.T) {
 
 	t.Run("add", func(t *testing.T) {
 		additions := []string{"add1", "add2"}
-		res := client.AddMessageDeployments(&msg, additions, nil)
+		res := client.AddMessageDeployments(context.Background(), &msg, additions, nil)
 		if res.Err != nil {
 			t.Fail()
 		}
@@ -232,14 +238,14 @@ func TestChangeMessageDeployments(t *testing.T) {
 	t.Run("add-batch", func(t *testing.T) {
 		additions := []string{"add1-batch", "add2-batch"}
 		batch := gocql.NewBatch(gocql.LoggedBatch)
-		res := client.AddMessageDeployments(&msg, additions, batch)
+		res := client.AddMessageDeployments(context.Background(), &msg, additions, batch)
 
 		testBatch(t, res, client, batch)
 	})
 
 	t.Run("remove", func(t *testing.T) {
 		removals := []string{"remove1", "remove2"}
-		res := client.RemoveMessageDeployments(&msg, removals, nil)
+		res := client.RemoveMessageDeployments(context.Background(), &msg, removals, nil)
 		if res.Err != nil {
 			t.Fail()
 		}
@@ -248,7 +254,7 @@ func TestChangeMessageDeployments(t *testing.T) {
 	t.Run("remove-batch", func(t *testing.T) {
 		removals := []string{"remove1-batch", "remove2-batch"}
 		batch := gocql.NewBatch(gocql.LoggedBatch)
-		res := client.RemoveMessageDeployments(&msg, removals, batch)
+		res := client.RemoveMessageDeployments(context.Background(), &msg, removals, batch)
 
 		testBatch(t, res, client, batch)
 	})
@@ -265,7 +271,7 @@ func TestFetchMessage(t *testing.T) {
 		Name:   prepopMName,
 	}
 
-	fetched, err := client.FetchMessage(&msg)
+	fetched, err := client.FetchMessage(context.Background(), &msg)
 
 	if err != nil || fetched == nil {
 		t.Error("failed to fetch msg:", err)
@@ -284,7 +290,7 @@ func TestFetchMessages(t *testing.T) {
 		UserId: &uuid,
 	}
 
-	fetched, err := client.FetchMessages(msg.UserId, 5)
+	fetched, err := client.FetchMessages(context.Background(), msg.UserId, 5)
 
 	if err != nil || len(fetched) == 0 {
 		t.Error("failed to fetch msg:", err)
@@ -306,7 +312,7 @@ func TestPostDeploymentMetadata(t *testing.T) {
 			MessageName: "msg",
 		}
 
-		res := client.PostDeploymentMetadata(&dep, nil)
+		res := client.PostDeploymentMetadata(context.Background(), &dep, nil)
 
 		if res.Err != nil {
 			t.Fail()
@@ -321,7 +327,7 @@ func TestPostDeploymentMetadata(t *testing.T) {
 		}
 
 		batch := gocql.NewBatch(gocql.LoggedBatch)
-		res := client.PostDeploymentMetadata(&dep, batch)
+		res := client.PostDeploymentMetadata(context.Background(), &dep, batch)
 
 		testBatch(t, res, client, batch)
 	})
@@ -331,7 +337,7 @@ func TestPostDeploymentMetadata(t *testing.T) {
 			UserId: &uuid,
 		}
 
-		_, err := client.FetchDeploymentsMetadata(dep.UserId)
+		_, err := client.FetchDeploymentsMetadata(context.Background(), dep.UserId)
 
 		if err != nil {
 			t.Error(err)
@@ -349,13 +355,13 @@ func TestFetchDeploymentMetadata(t *testing.T) {
 		DeployName: prepopDName,
 	}
 	t.Run("single", func(t *testing.T) {
-		fetched, err := client.FetchDeploymentMetadata(dep.UserId, dep.DeployName)
+		fetched, err := client.FetchDeploymentMetadata(context.Background(), dep.UserId, dep.DeployName)
 		if err != nil || fetched == nil {
 			t.Error(err)
 		}
 	})
 	t.Run("multi", func(t *testing.T) {
-		fetched, err := client.FetchDeploymentsMetadata(dep.UserId)
+		fetched, err := client.FetchDeploymentsMetadata(context.Background(), dep.UserId)
 		if err != nil || len(fetched) == 0 {
 			t.Error(err)
 		}
@@ -376,7 +382,7 @@ func TestPostDeployment(t *testing.T) {
 			BeaconNames: [][]byte{prepopBName},
 		}
 
-		res := client.PostDeployment(&dep)
+		res := client.PostDeployment(context.Background(), &dep)
 		if res.Err != nil {
 			t.Error("failed to post deployment:", res.Err)
 		}
@@ -390,7 +396,7 @@ func TestPostDeployment(t *testing.T) {
 			BeaconNames: [][]byte{prepopBName},
 		}
 
-		res := client.PostDeployment(&dep)
+		res := client.PostDeployment(context.Background(), &dep)
 		if res.Err == nil {
 			t.Error("false positive: should have failed with invalid message name")
 		}
@@ -409,7 +415,7 @@ func TestPostDeployment(t *testing.T) {
 			},
 		}
 
-		res := client.PostDeployment(&dep)
+		res := client.PostDeployment(context.Background(), &dep)
 		if res.Err != nil {
 			t.Error("failed to post deployment:", res.Err)
 		}
@@ -427,7 +433,7 @@ func TestFetchDeploymentBeacons(t *testing.T) {
 		DeployName: prepopDName,
 	}
 
-	fetched, err := client.FetchDeploymentBeacons(&dep)
+	fetched, err := client.FetchDeploymentBeacons(context.Background(), &dep)
 
 	if err != nil || len(fetched) == 0 {
 		t.Error(err)
@@ -446,9 +452,51 @@ func TestFetchDeployment(t *testing.T) {
 		DeployName: prepopDName,
 	}
 
-	fetched, err := client.FetchDeployment(&dep)
+	fetched, err := client.FetchDeployment(context.Background(), &dep)
 
 	if err != nil || fetched == nil {
 		t.Error(err)
 	}
 }
+
+func TestScheduledDeployments(t *testing.T) {
+	client := createLocalhostClient("bkn")
+	defer client.Sess.Close()
+
+	uuid, _ := gocql.ParseUUID(prepopId)
+	dep := &Deployment{
+		UserId:      &uuid,
+		DeployName:  "dep_scheduled",
+		MessageName: prepopMName,
+		Schedule: &schedule.Schedule{
+			TimeZone: "UTC",
+			Windows: []*schedule.Window{
+				&schedule.Window{MessageName: prepopMName, Start: "06:00", End: "11:00"},
+			},
+		},
+	}
+
+	t.Run("fetch", func(t *testing.T) {
+		if res := client.PostDeploymentMetadata(context.Background(), dep, nil); res.Err != nil {
+			t.Error("failed to post metadata:", res.Err)
+			return
+		}
+
+		fetched, err := client.FetchScheduledDeployments(context.Background())
+		if err != nil || len(fetched) == 0 {
+			t.Error("failed to fetch scheduled deployments:", err)
+		}
+	})
+
+	t.Run("claim", func(t *testing.T) {
+		claimed, err := client.ClaimDeploymentMessage(context.Background(), dep, prepopMName)
+		if err != nil || !claimed {
+			t.Error("failed to claim transition:", err)
+		}
+
+		stale := &Deployment{UserId: &uuid, DeployName: dep.DeployName, MessageName: "not-the-current-msg"}
+		if claimed, _ := client.ClaimDeploymentMessage(context.Background(), stale, prepopMName); claimed {
+			t.Error("false positive: claimed transition from stale message")
+		}
+	})
+}
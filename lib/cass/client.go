@@ -2,42 +2,106 @@
 package cass
 
 import (
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"github.com/gocql/gocql"
+	"github.com/owen-d/beacon-api/lib/redirect"
+	"github.com/owen-d/beacon-api/lib/schedule"
+	"time"
 )
 
 // interface for exported functionality
 type Client interface {
 	// Users
-	CreateUser(*User, providerId, []byte, *gocql.Batch) *UpsertResult
-	FetchUser(*User) (*User, error)
+	CreateUser(context.Context, *User, ProviderId, []byte, *gocql.Batch) *UpsertResult
+	VerifyEmail(context.Context, *gocql.UUID, string) *UpsertResult
+	FetchUser(context.Context, *User) (*User, error)
+	UpdateUser(context.Context, *User) *UpsertResult
+	DeleteUser(context.Context, *gocql.UUID) *UpsertResult
+	FetchIdentity(context.Context, ProviderId, string) (*Identity, error)
+	LinkIdentity(context.Context, *Identity) (*Identity, error)
+	FetchUserIdentities(context.Context, *gocql.UUID) ([]*Identity, error)
+	UnlinkIdentity(context.Context, *Identity, int) *UpsertResult
+	// Tokens
+	CreateRefreshToken(context.Context, *RefreshToken) *UpsertResult
+	ConsumeRefreshToken(context.Context, []byte) (*RefreshToken, error)
+	RevokeToken(context.Context, string, time.Time) *UpsertResult
+	IsRevoked(context.Context, string) (bool, error)
+	// Admin
+	FetchUserStatus(context.Context, *gocql.UUID) (*UserStatus, error)
+	CheckAccount(context.Context, *gocql.UUID) (bool, bool, error)
+	SuspendUser(context.Context, *gocql.UUID, *gocql.UUID, string) *UpsertResult
+	UnsuspendUser(context.Context, *gocql.UUID) *UpsertResult
+	FetchBeaconOwner(context.Context, []byte) (*Beacon, error)
+	// Audit
+	RecordEvent(context.Context, *AuditEvent) *UpsertResult
+	FetchEvents(context.Context, *gocql.UUID, time.Time, *gocql.UUID, int) ([]*AuditEvent, error)
+	// Sessions
+	PutSession(context.Context, *Session, time.Duration) *UpsertResult
+	TouchSession(context.Context, *Session, time.Duration) *UpsertResult
+	FetchSession(context.Context, []byte) (*Session, error)
+	DeleteSession(context.Context, []byte) *UpsertResult
+	// API keys
+	CreateAPIKey(context.Context, *APIKey) *UpsertResult
+	FetchAPIKey(context.Context, []byte) (*APIKey, error)
+	FetchAPIKeys(context.Context, *gocql.UUID) ([]*APIKey, error)
+	RevokeAPIKey(context.Context, *gocql.UUID, string) *UpsertResult
+	// Orgs
+	CreateOrg(context.Context, *Org, *Member) *UpsertResult
+	FetchOrg(context.Context, *gocql.UUID) (*Org, error)
+	PutMember(context.Context, *Member, *gocql.Batch) *UpsertResult
+	RemoveMember(context.Context, *gocql.UUID, *gocql.UUID) *UpsertResult
+	DemoteOwner(context.Context, *Member, string, *Member) *UpsertResult
+	FetchMember(context.Context, *gocql.UUID, *gocql.UUID) (*Member, error)
+	FetchMembers(context.Context, *gocql.UUID) ([]*Member, error)
+	FetchUserMemberships(context.Context, *gocql.UUID) ([]*Member, error)
+	FetchSoleOrgs(context.Context, *gocql.UUID) ([]*gocql.UUID, error)
+	CreateInvitation(context.Context, *Invitation) *UpsertResult
+	FetchInvitation(context.Context, []byte) (*Invitation, error)
+	ConsumeInvitation(context.Context, []byte) *UpsertResult
 	// Beacons
-	CreateBeacons([]*Beacon, *gocql.Batch) *UpsertResult
-	RemoveBeaconsDeployments(beacons []*Beacon) *UpsertResult
-	UpdateBeacons([]*Beacon) *UpsertResult
-	FetchBeacon(*Beacon) (*Beacon, error)
-	FetchUserBeacons(*gocql.UUID) ([]*Beacon, error)
+	TransferBeacons(context.Context, []*Beacon, *gocql.UUID) *UpsertResult
+	CreateBeacons(context.Context, []*Beacon, *gocql.Batch) *UpsertResult
+	RemoveBeaconsDeployments(context.Context, []*Beacon) *UpsertResult
+	UpdateBeacons(context.Context, []*Beacon) *UpsertResult
+	FetchBeacon(context.Context, *Beacon) (*Beacon, error)
+	FetchUserBeacons(context.Context, *gocql.UUID) ([]*Beacon, error)
 	// Messages
-	CreateMessage(*Message, *gocql.Batch) *UpsertResult
-	UpdateMessage(*Message, *gocql.Batch) *UpsertResult
-	AddMessageDeployments(*Message, []string, *gocql.Batch) *UpsertResult
-	RemoveMessageDeployments(*Message, []string, *gocql.Batch) *UpsertResult
-	FetchMessage(*Message) (*Message, error)
-	FetchMessages(*gocql.UUID, uint8) ([]*Message, error)
+	CreateMessage(context.Context, *Message, *gocql.Batch) *UpsertResult
+	UpdateMessage(context.Context, *Message, *gocql.Batch) *UpsertResult
+	AddMessageDeployments(context.Context, *Message, []string, *gocql.Batch) *UpsertResult
+	RemoveMessageDeployments(context.Context, *Message, []string, *gocql.Batch) *UpsertResult
+	FetchMessage(context.Context, *Message) (*Message, error)
+	FetchMessages(context.Context, *gocql.UUID, uint8) ([]*Message, error)
 	// Deployments
-	FetchDeployment(*Deployment) (*Deployment, error)
-	PostDeployment(*Deployment) *UpsertResult
-	FetchDeploymentBeacons(dep *Deployment) ([]*Beacon, error)
+	FetchDeployment(context.Context, *Deployment) (*Deployment, error)
+	PostDeployment(context.Context, *Deployment) *UpsertResult
+	FetchDeploymentBeacons(context.Context, *Deployment) ([]*Beacon, error)
 	// Metadata
-	FetchDeploymentsMetadata(*gocql.UUID) ([]*Deployment, error)
-	FetchDeploymentMetadata(*gocql.UUID, string) (*Deployment, error)
-	PostDeploymentMetadata(*Deployment, *gocql.Batch) *UpsertResult
+	FetchDeploymentsMetadata(context.Context, *gocql.UUID) ([]*Deployment, error)
+	FetchDeploymentMetadata(context.Context, *gocql.UUID, string) (*Deployment, error)
+	PostDeploymentMetadata(context.Context, *Deployment, *gocql.Batch) *UpsertResult
+	FetchScheduledDeployments(context.Context) ([]*Deployment, error)
+	ClaimDeploymentMessage(context.Context, *Deployment, string) (bool, error)
+	// Links
+	PostLinks(context.Context, []*Beacon) *UpsertResult
+	FetchLink(context.Context, []byte) (*Link, error)
+	// Idempotency
+	ClaimIdempotencyKey(context.Context, *IdempotencyRecord, time.Duration) (*IdempotencyRecord, bool, error)
+	PutIdempotencyRecord(context.Context, *IdempotencyRecord, time.Duration) *UpsertResult
+	DeleteIdempotencyKey(context.Context, *gocql.UUID, string) *UpsertResult
+	// Analytics
+	RecordPasserby(context.Context, *Event) *UpsertResult
+	RecordInteraction(context.Context, *Event) *UpsertResult
+	FetchDeploymentStats(context.Context, *Deployment, time.Time) ([]*VariantStats, error)
 }
 
 const (
 	DefaultLimit = 250
+	// conditional updates that lost a race are retried this many times before giving up with ErrConflict
+	casAttempts = 3
 )
 
 type Beacon struct {
@@ -77,7 +141,7 @@ func (self *Beacon) UnmarshalJSON(data []byte) error {
 }
 
 type Message struct {
-	UserId      *gocql.UUID `cql:"user_id" json:-`
+	UserId      *gocql.UUID `cql:"user_id" json:"-"`
 	Name        string      `cql:"name" json:"name"`
 	Title       string      `cql:"title" json:"title"`
 	Url         string      `cql:"url" json:"url"`
@@ -93,6 +157,12 @@ type Deployment struct {
 	MessageName string      `json:"message_name,omitempty"`
 	Message     *Message    `json:"message,omitempty"`
 	BeaconNames [][]byte    `json:"beacon_names"`
+	// Rules are evaluated in order by the public redirect handler, falling back to the message url
+	Rules []*redirect.Rule `json:"rules,omitempty"`
+	// Schedule switches the deployed message at each window boundary, via the background scheduler
+	Schedule *schedule.Schedule `json:"schedule,omitempty"`
+	// Variants split visitors of the public redirect handler across several weighted messages
+	Variants []*redirect.Variant `json:"variants,omitempty"`
 }
 
 func (self *Deployment) MarshalJSON() ([]byte, error) {
@@ -163,9 +233,14 @@ func Connect(cluster *gocql.ClusterConfig, keyspace string) (*CassClient, error)
 	return &CassClient{Sess: session}, nil
 }
 
+// Ping checks a cassandra node answers queries
+func (self *CassClient) Ping(ctx context.Context) error {
+	return self.query(ctx, `SELECT release_version FROM system.local`).Exec()
+}
+
 // Beacons ------------------------------------------------------------------------------
 
-func (self *CassClient) CreateBeacons(beacons []*Beacon, batch *gocql.Batch) *UpsertResult {
+func (self *CassClient) CreateBeacons(ctx context.Context, beacons []*Beacon, batch *gocql.Batch) *UpsertResult {
 	template := `INSERT INTO beacons (user_id, name, deploy_name) VALUES (?, ?, ?) IF NOT EXISTS`
 
 	providedBatch := (batch != nil)
@@ -189,18 +264,19 @@ func (self *CassClient) CreateBeacons(beacons []*Beacon, batch *gocql.Batch) *Up
 
 	// If a batch was provided, we do not need to execute the query, it may be done as part of a later transaction.
 	if !providedBatch {
-		res.Err = self.Sess.ExecuteBatch(batch)
+		res.Err = self.executeBatch(ctx, batch)
 	}
 
 	return &res
 }
 
 // UpdateBeacons must use an if exists clause to prevent errors like inserting a beacon which a user does not own.
-func (self *CassClient) UpdateBeacons(beacons []*Beacon) *UpsertResult {
+func (self *CassClient) UpdateBeacons(ctx context.Context, beacons []*Beacon) *UpsertResult {
 	template := `UPDATE beacons SET deploy_name = ?, msg_url = ? WHERE user_id = ? AND name = ? IF EXISTS`
-	dispatch := newDispatcher()
+	dispatch := newDispatcher(ctx)
 
 	for _, bkn := range beacons {
+		bkn := bkn
 		cmd := []interface{}{
 			bkn.DeployName,
 			bkn.MsgUrl,
@@ -208,35 +284,31 @@ func (self *CassClient) UpdateBeacons(beacons []*Beacon) *UpsertResult {
 			bkn.Name,
 		}
 
-		dispatch.Register(func() *UpsertResult {
-			return &UpsertResult{
-				Batch: nil,
-				Err:   self.Sess.Query(template, cmd...).Exec(),
+		dispatch.Register(func(ctx context.Context) *UpsertResult {
+			// the beacon's row confirms ownership, so it may claim its public link. The link is claimed first,
+			// so a beacon whose short name is taken is never left advertising a url resolving to another owner's beacon.
+			if _, fetchErr := self.FetchBeacon(ctx, bkn); fetchErr == gocql.ErrNotFound {
+				return &UpsertResult{Batch: nil, Err: nil}
+			} else if fetchErr != nil {
+				return &UpsertResult{Batch: nil, Err: fetchErr}
 			}
-		})
-	}
-
-	for i := uint32(0); i < dispatch.Ct; i++ {
-		res := <-dispatch.Ch
 
-		if res.Err != nil {
-			return res
-		}
-	}
+			if res := self.PostLinks(ctx, []*Beacon{bkn}); res.Err != nil {
+				return res
+			}
 
-	res := UpsertResult{
-		Err: nil,
-		// return nil batch b/c theres no collective batch
-		Batch: nil,
+			_, err := self.query(ctx, template, cmd...).MapScanCAS(map[string]interface{}{})
+			return &UpsertResult{Batch: nil, Err: err}
+		})
 	}
 
-	return &res
+	return dispatch.Wait()
 
 }
 
-func (self *CassClient) RemoveBeaconsDeployments(beacons []*Beacon) *UpsertResult {
+func (self *CassClient) RemoveBeaconsDeployments(ctx context.Context, beacons []*Beacon) *UpsertResult {
 	template := `DELETE deploy_name from beacons WHERE user_id = ? AND name = ? IF EXISTS`
-	dispatch := newDispatcher()
+	dispatch := newDispatcher(ctx)
 
 	for _, bkn := range beacons {
 		cmd := []interface{}{
@@ -244,82 +316,65 @@ func (self *CassClient) RemoveBeaconsDeployments(beacons []*Beacon) *UpsertResul
 			bkn.Name,
 		}
 
-		dispatch.Register(func() *UpsertResult {
+		dispatch.Register(func(ctx context.Context) *UpsertResult {
 			return &UpsertResult{
 				Batch: nil,
-				Err:   self.Sess.Query(template, cmd...).Exec(),
+				Err:   self.query(ctx, template, cmd...).Exec(),
 			}
 		})
 	}
 
-	for i := uint32(0); i < dispatch.Ct; i++ {
-		res := <-dispatch.Ch
-
-		if res.Err != nil {
-			return res
-		}
-	}
-
-	res := UpsertResult{
-		Err: nil,
-		// return nil batch b/c theres no collective batch
-		Batch: nil,
-	}
-
-	return &res
+	return dispatch.Wait()
 
 }
 
 // FetchBeacon takes a slice of Beacons with primary keys defined, fetches the remaining data, & updates the structs
-func (self *CassClient) FetchBeacon(bkn *Beacon) (*Beacon, error) {
+func (self *CassClient) FetchBeacon(ctx context.Context, bkn *Beacon) (*Beacon, error) {
 	resBkn := Beacon{
 		UserId: bkn.UserId,
 		Name:   bkn.Name,
 	}
 
-	template := `SELECT user_id, deploy_name FROM beacons WHERE user_id = ? AND name = ?`
+	template := `SELECT user_id, deploy_name, msg_url FROM beacons WHERE user_id = ? AND name = ?`
 	cmd := []interface{}{
 		bkn.UserId,
 		bkn.Name,
 	}
 
-	err := self.Sess.Query(template, cmd...).Scan(&resBkn.UserId, &resBkn.DeployName)
+	err := self.query(ctx, template, cmd...).Scan(&resBkn.UserId, &resBkn.DeployName, &resBkn.MsgUrl)
 	return &resBkn, err
 }
 
-// FetchUserBeacons returns a slice of beacons belonging to a user
-func (self *CassClient) FetchUserBeacons(userId *gocql.UUID) ([]*Beacon, error) {
-	template := `SELECT user_id, deploy_name, name FROM beacons WHERE user_id = ? LIMIT ?`
-	args := []interface{}{
-		userId,
-		DefaultLimit,
-	}
+// FetchUserBeacons returns every beacon belonging to a user, paging through them DefaultLimit at a time
+func (self *CassClient) FetchUserBeacons(ctx context.Context, userId *gocql.UUID) ([]*Beacon, error) {
+	template := `SELECT user_id, deploy_name, name FROM beacons WHERE user_id = ?`
 
 	resRows := make([]*Beacon, 0)
-	iter := self.Sess.Query(template, args...).Iter()
-	shell := map[string]interface{}{}
-	for iter.MapScan(shell) {
-		id := shell["user_id"].(gocql.UUID)
-		resRows = append(resRows, &Beacon{
-			UserId:     &id,
-			DeployName: shell["deploy_name"].(string),
-			Name:       shell["name"].([]uint8),
-		})
-
-		// since shell is used in each iteration, we must clear it.
-		shell = map[string]interface{}{}
-	}
+	err := self.eachPage(ctx, template, []interface{}{userId}, func(iter *iter) error {
+		shell := map[string]interface{}{}
+		for iter.MapScan(shell) {
+			id := shell["user_id"].(gocql.UUID)
+			resRows = append(resRows, &Beacon{
+				UserId:     &id,
+				DeployName: shell["deploy_name"].(string),
+				Name:       shell["name"].([]uint8),
+			})
+
+			// since shell is used in each iteration, we must clear it.
+			shell = map[string]interface{}{}
+		}
+		return nil
+	})
 
-	if err := iter.Close(); err != nil {
+	if err != nil {
 		return nil, err
 	}
 	return resRows, nil
-
 }
 
 // Messages ------------------------------------------------------------------------------
 
-func (self *CassClient) CreateMessage(m *Message, batch *gocql.Batch) *UpsertResult {
+func (self *CassClient) CreateMessage(ctx context.Context, m *Message, batch *gocql.Batch) *UpsertResult {
 	template := `INSERT INTO messages (user_id, name, title, url, lang, deployments) VALUES (?, ?, ?, ?, ?, ?) IF NOT EXISTS`
 	args := []interface{}{
 		m.UserId,
@@ -334,14 +389,18 @@ func (self *CassClient) CreateMessage(m *Message, batch *gocql.Batch) *UpsertRes
 		batch.Query(template, args...)
 		return &UpsertResult{Batch: batch, Err: nil}
 	} else {
+		applied, err := self.query(ctx, template, args...).MapScanCAS(map[string]interface{}{})
+		if err == nil && !applied {
+			err = ErrConflict
+		}
 		return &UpsertResult{
 			Batch: nil,
-			Err:   self.Sess.Query(template, args...).Exec(),
+			Err:   err,
 		}
 	}
 }
 
-func (self *CassClient) UpdateMessage(m *Message, batch *gocql.Batch) *UpsertResult {
+func (self *CassClient) UpdateMessage(ctx context.Context, m *Message, batch *gocql.Batch) *UpsertResult {
 	template := `UPDATE messages SET title = ?, url = ? WHERE user_id = ? AND name = ?`
 	args := []interface{}{
 		m.Title,
@@ -356,20 +415,20 @@ func (self *CassClient) UpdateMessage(m *Message, batch *gocql.Batch) *UpsertRes
 	} else {
 		return &UpsertResult{
 			Batch: nil,
-			Err:   self.Sess.Query(template, args...).Exec(),
+			Err:   self.query(ctx, template, args...).Exec(),
 		}
 	}
 }
 
-func (self *CassClient) AddMessageDeployments(m *Message, additions []string, batch *gocql.Batch) *UpsertResult {
-	return self.addOrRemoveMessageDeployments(m, additions, true, batch)
+func (self *CassClient) AddMessageDeployments(ctx context.Context, m *Message, additions []string, batch *gocql.Batch) *UpsertResult {
+	return self.addOrRemoveMessageDeployments(ctx, m, additions, true, batch)
 }
-func (self *CassClient) RemoveMessageDeployments(m *Message, removals []string, batch *gocql.Batch) *UpsertResult {
-	return self.addOrRemoveMessageDeployments(m, removals, false, batch)
+func (self *CassClient) RemoveMessageDeployments(ctx context.Context, m *Message, removals []string, batch *gocql.Batch) *UpsertResult {
+	return self.addOrRemoveMessageDeployments(ctx, m, removals, false, batch)
 }
 
 // addOrRemoveMessageDeployments is the underlying function behind the exported AddMessageDeployments and RemoveMessageDeployments
-func (self *CassClient) addOrRemoveMessageDeployments(m *Message, changes []string, add bool, batch *gocql.Batch) *UpsertResult {
+func (self *CassClient) addOrRemoveMessageDeployments(ctx context.Context, m *Message, changes []string, add bool, batch *gocql.Batch) *UpsertResult {
 	if len(changes) == 0 {
 		return &UpsertResult{Err: errors.New("must specify changes to message deployments")}
 	}
@@ -394,13 +453,13 @@ func (self *CassClient) addOrRemoveMessageDeployments(m *Message, changes []stri
 	} else {
 		return &UpsertResult{
 			Batch: nil,
-			Err:   self.Sess.Query(template, args...).Exec(),
+			Err:   self.query(ctx, template, args...).Exec(),
 		}
 	}
 
 }
 
-func (self *CassClient) FetchMessage(m *Message) (*Message, error) {
+func (self *CassClient) FetchMessage(ctx context.Context, m *Message) (*Message, error) {
 	resMsg := &Message{}
 	template := `SELECT user_id, name, title, url, lang, deployments FROM messages WHERE user_id = ? AND name = ?`
 	args := []interface{}{
@@ -408,11 +467,11 @@ func (self *CassClient) FetchMessage(m *Message) (*Message, error) {
 		m.Name,
 	}
 
-	err := self.Sess.Query(template, args...).Scan(&resMsg.UserId, &resMsg.Name, &resMsg.Title, &resMsg.Url, &resMsg.Lang, &resMsg.Deployments)
+	err := self.query(ctx, template, args...).Scan(&resMsg.UserId, &resMsg.Name, &resMsg.Title, &resMsg.Url, &resMsg.Lang, &resMsg.Deployments)
 	return resMsg, err
 }
 
-func (self *CassClient) FetchMessages(id *gocql.UUID, lim uint8) ([]*Message, error) {
+func (self *CassClient) FetchMessages(ctx context.Context, id *gocql.UUID, lim uint8) ([]*Message, error) {
 	template := `SELECT user_id, name, title, url, lang, deployments FROM messages WHERE user_id = ? LIMIT ?`
 	args := []interface{}{
 		id,
@@ -420,7 +479,7 @@ func (self *CassClient) FetchMessages(id *gocql.UUID, lim uint8) ([]*Message, er
 	}
 
 	resRows := make([]*Message, 0)
-	iter := self.Sess.Query(template, args...).Iter()
+	iter := self.query(ctx, template, args...).Iter()
 	shell := map[string]interface{}{}
 	for iter.MapScan(shell) {
 		id := shell["user_id"].(gocql.UUID)
@@ -445,42 +504,54 @@ func (self *CassClient) FetchMessages(id *gocql.UUID, lim uint8) ([]*Message, er
 }
 
 // DeploymentMetadata
-func (self *CassClient) PostDeploymentMetadata(dep *Deployment, batch *gocql.Batch) *UpsertResult {
-	template := `INSERT INTO deployments_metadata (user_id, deploy_name, message_name) VALUES (?, ?, ?)`
-	args := []interface{}{
+func (self *CassClient) PostDeploymentMetadata(ctx context.Context, dep *Deployment, batch *gocql.Batch) *UpsertResult {
+	encoded, marshalErr := dep.marshalMetadata()
+	if marshalErr != nil {
+		return &UpsertResult{Batch: batch, Err: marshalErr}
+	}
+
+	template := `INSERT INTO deployments_metadata (user_id, deploy_name, message_name, redirect_rules, schedule, variants) VALUES (?, ?, ?, ?, ?, ?)`
+	args := append([]interface{}{
 		dep.UserId,
 		dep.DeployName,
 		dep.MessageName,
+	}, encoded...)
+
+	// keep the scheduled_deployments index in sync so the scheduler never has to scan every deployment
+	indexTemplate := `DELETE FROM scheduled_deployments WHERE user_id = ? AND deploy_name = ?`
+	if dep.Schedule != nil {
+		indexTemplate = `INSERT INTO scheduled_deployments (user_id, deploy_name) VALUES (?, ?)`
 	}
 
-	if batch != nil {
-		batch.Query(template, args...)
-		return &UpsertResult{Batch: batch, Err: nil}
-	} else {
-		return &UpsertResult{
-			Batch: nil,
-			Err:   self.Sess.Query(template, args...).Exec(),
-		}
+	providedBatch := (batch != nil)
+	if !providedBatch {
+		batch = gocql.NewBatch(gocql.LoggedBatch)
 	}
 
+	batch.Query(template, args...)
+	batch.Query(indexTemplate, dep.UserId, dep.DeployName)
+
+	res := UpsertResult{
+		Batch: batch,
+	}
+
+	if !providedBatch {
+		res.Err = self.executeBatch(ctx, batch)
+	}
+
+	return &res
 }
 
-// FetchDeploymentsMetadata
-func (self *CassClient) FetchDeploymentsMetadata(userId *gocql.UUID) ([]*Deployment, error) {
+// FetchScheduledDeployments lists the user_id & deploy_name of every deployment with a schedule
+func (self *CassClient) FetchScheduledDeployments(ctx context.Context) ([]*Deployment, error) {
 	resRows := make([]*Deployment, 0)
-	template := `SELECT user_id, deploy_name, message_name FROM deployments_metadata WHERE user_id = ? LIMIT ?`
-	args := []interface{}{
-		userId,
-		DefaultLimit,
-	}
-	iter := self.Sess.Query(template, args...).Iter()
+	iter := self.query(ctx, `SELECT user_id, deploy_name FROM scheduled_deployments`).Iter()
 	shell := map[string]interface{}{}
 	for iter.MapScan(shell) {
 		id := shell["user_id"].(gocql.UUID)
 		resRows = append(resRows, &Deployment{
-			UserId:      &id,
-			DeployName:  shell["deploy_name"].(string),
-			MessageName: shell["message_name"].(string),
+			UserId:     &id,
+			DeployName: shell["deploy_name"].(string),
 		})
 
 		// since shell is used in each iteration, we must clear it.
@@ -493,19 +564,71 @@ func (self *CassClient) FetchDeploymentsMetadata(userId *gocql.UUID) ([]*Deploym
 	return resRows, nil
 }
 
+// ClaimDeploymentMessage switches a deployment's message to next only if it is still dep.MessageName. This allows a single scheduler to win each transition when several are running.
+func (self *CassClient) ClaimDeploymentMessage(ctx context.Context, dep *Deployment, next string) (bool, error) {
+	template := `UPDATE deployments_metadata SET message_name = ? WHERE user_id = ? AND deploy_name = ? IF message_name = ?`
+	args := []interface{}{
+		next,
+		dep.UserId,
+		dep.DeployName,
+		dep.MessageName,
+	}
+
+	return self.query(ctx, template, args...).MapScanCAS(map[string]interface{}{})
+}
+
+// FetchDeploymentsMetadata returns the metadata of every deployment belonging to a user, paging through them DefaultLimit at a time
+func (self *CassClient) FetchDeploymentsMetadata(ctx context.Context, userId *gocql.UUID) ([]*Deployment, error) {
+	resRows := make([]*Deployment, 0)
+	template := `SELECT user_id, deploy_name, message_name, redirect_rules, schedule, variants FROM deployments_metadata WHERE user_id = ?`
+
+	err := self.eachPage(ctx, template, []interface{}{userId}, func(iter *iter) error {
+		shell := map[string]interface{}{}
+		for iter.MapScan(shell) {
+			id := shell["user_id"].(gocql.UUID)
+			dep := &Deployment{
+				UserId:      &id,
+				DeployName:  shell["deploy_name"].(string),
+				MessageName: shell["message_name"].(string),
+			}
+
+			unmarshalErr := dep.unmarshalMetadata(shell["redirect_rules"].(string), shell["schedule"].(string), shell["variants"].(string))
+			if unmarshalErr != nil {
+				return unmarshalErr
+			}
+
+			resRows = append(resRows, dep)
+
+			// since shell is used in each iteration, we must clear it.
+			shell = map[string]interface{}{}
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return resRows, nil
+}
+
 // FetchDeploymentMetadata is the single version of FetchDeploymentsMetadata. It requires a DeployName.
-func (self *CassClient) FetchDeploymentMetadata(userId *gocql.UUID, depName string) (*Deployment, error) {
+func (self *CassClient) FetchDeploymentMetadata(ctx context.Context, userId *gocql.UUID, depName string) (*Deployment, error) {
 	res := &Deployment{}
-	template := `SELECT user_id, deploy_name, message_name FROM deployments_metadata WHERE user_id = ? AND deploy_name = ? LIMIT 1`
+	var rules, sched, variants string
+	template := `SELECT user_id, deploy_name, message_name, redirect_rules, schedule, variants FROM deployments_metadata WHERE user_id = ? AND deploy_name = ? LIMIT 1`
 	args := []interface{}{
 		userId,
 		depName,
 	}
-	err := self.Sess.Query(template, args...).Scan(&res.UserId, &res.DeployName, &res.MessageName)
+	err := self.query(ctx, template, args...).Scan(&res.UserId, &res.DeployName, &res.MessageName, &rules, &sched, &variants)
 
 	if err != nil {
 		return nil, err
 	}
+
+	if err = res.unmarshalMetadata(rules, sched, variants); err != nil {
+		return nil, err
+	}
 	return res, nil
 }
 
@@ -513,14 +636,14 @@ func (self *CassClient) FetchDeploymentMetadata(userId *gocql.UUID, depName stri
 
 // PostDeployment writes the current deployname to every beacon in the list via an update clause, causing any now-invalid records in the deployments materialized view (on top of beacons) to be deleted via a partition drop (& subsequently recreated)
 // Must write Deployment to every beacon, deploy_name to any message used, and bNames/messageName/deployName to deployments_metadata
-func (self *CassClient) PostDeployment(deployment *Deployment) *UpsertResult {
+func (self *CassClient) PostDeployment(ctx context.Context, deployment *Deployment) *UpsertResult {
 	// execute everything concurrently & pass results through dispatcher
-	dispatch := newDispatcher()
+	dispatch := newDispatcher(ctx)
 
 	// handle MessageName or Message fields appropriately
 	if mName := deployment.MessageName; mName != "" {
 		// must make sure MessageName matches an existing message & assign it into the deployment struct
-		foundMsg, err := self.FetchMessage(&Message{UserId: deployment.UserId, Name: mName})
+		foundMsg, err := self.FetchMessage(ctx, &Message{UserId: deployment.UserId, Name: mName})
 		if err != nil {
 			return &UpsertResult{Err: err}
 		} else {
@@ -529,16 +652,16 @@ func (self *CassClient) PostDeployment(deployment *Deployment) *UpsertResult {
 
 		// Need to UPDATE the message with the new deployment_name (append to set)
 		additions := []string{deployment.DeployName}
-		dispatch.Register(func() *UpsertResult {
-			return self.AddMessageDeployments(&Message{UserId: deployment.UserId, Name: mName}, additions, nil)
+		dispatch.Register(func(ctx context.Context) *UpsertResult {
+			return self.AddMessageDeployments(ctx, &Message{UserId: deployment.UserId, Name: mName}, additions, nil)
 		})
 	} else {
 
 		// Otherwise, create a new message from the provided Message
 		deployment.Message.Deployments = []string{deployment.DeployName}
 		deployment.Message.UserId = deployment.UserId
-		dispatch.Register(func() *UpsertResult {
-			return self.CreateMessage(deployment.Message, nil)
+		dispatch.Register(func(ctx context.Context) *UpsertResult {
+			return self.CreateMessage(ctx, deployment.Message, nil)
 		})
 	}
 	// take list of beacon names, write the new deployname to them all
@@ -553,8 +676,8 @@ func (self *CassClient) PostDeployment(deployment *Deployment) *UpsertResult {
 		bkns = append(bkns, &bkn)
 	}
 
-	dispatch.Register(func() *UpsertResult {
-		return self.UpdateBeacons(bkns)
+	dispatch.Register(func(ctx context.Context) *UpsertResult {
+		return self.UpdateBeacons(ctx, bkns)
 	})
 
 	// update metadata
@@ -563,31 +686,20 @@ func (self *CassClient) PostDeployment(deployment *Deployment) *UpsertResult {
 		DeployName: deployment.DeployName,
 		// message could be provided as a reference (MessageName) or as a new Message object
 		MessageName: deployment.Message.Name,
+		Rules:       deployment.Rules,
+		Schedule:    deployment.Schedule,
+		Variants:    deployment.Variants,
 	}
 
-	dispatch.Register(func() *UpsertResult {
-		return self.PostDeploymentMetadata(&deploymentMeta, nil)
+	dispatch.Register(func(ctx context.Context) *UpsertResult {
+		return self.PostDeploymentMetadata(ctx, &deploymentMeta, nil)
 	})
 
-	for i := uint32(0); i < dispatch.Ct; i++ {
-		res := <-dispatch.Ch
-
-		if res.Err != nil {
-			return res
-		}
-	}
-
-	res := UpsertResult{
-		Err: nil,
-		// return nil batch b/c theres no collective batch
-		Batch: nil,
-	}
-
-	return &res
+	return dispatch.Wait()
 }
 
 // FetchDeploymentBeacons uses the deployments materialized view to gather a list of beacons associated with a deployment.
-func (self *CassClient) FetchDeploymentBeacons(dep *Deployment) ([]*Beacon, error) {
+func (self *CassClient) FetchDeploymentBeacons(ctx context.Context, dep *Deployment) ([]*Beacon, error) {
 	resRows := make([]*Beacon, 0)
 	template := `SELECT user_id, deploy_name, name FROM beacon_deployments WHERE user_id = ? AND deploy_name = ? LIMIT ?`
 	args := []interface{}{
@@ -595,7 +707,7 @@ func (self *CassClient) FetchDeploymentBeacons(dep *Deployment) ([]*Beacon, erro
 		dep.DeployName,
 		DefaultLimit,
 	}
-	iter := self.Sess.Query(template, args...).Iter()
+	iter := self.query(ctx, template, args...).Iter()
 	shell := map[string]interface{}{}
 	for iter.MapScan(shell) {
 		id := shell["user_id"].(gocql.UUID)
@@ -617,19 +729,23 @@ func (self *CassClient) FetchDeploymentBeacons(dep *Deployment) ([]*Beacon, erro
 }
 
 // FetchDeployment will fetch & merge both the deployment metadata & the beacons belonging to it
-func (self *CassClient) FetchDeployment(dep *Deployment) (*Deployment, error) {
+func (self *CassClient) FetchDeployment(ctx context.Context, dep *Deployment) (*Deployment, error) {
 	res := &Deployment{
 		UserId:     dep.UserId,
 		DeployName: dep.DeployName,
 	}
 
+	// buffered, so neither fetch blocks once the other has failed
 	errCh := make(chan error, 2)
-	metaCh := make(chan *Deployment)
-	bknsCh := make(chan []*Beacon)
+	metaCh := make(chan *Deployment, 1)
+	bknsCh := make(chan []*Beacon, 1)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	// Fetch metadata
 	go func(ch chan<- *Deployment, errCh chan<- error) {
-		meta, metaErr := self.FetchDeploymentMetadata(dep.UserId, dep.DeployName)
+		meta, metaErr := self.FetchDeploymentMetadata(ctx, dep.UserId, dep.DeployName)
 		if metaErr != nil {
 			errCh <- metaErr
 			return
@@ -640,7 +756,7 @@ func (self *CassClient) FetchDeployment(dep *Deployment) (*Deployment, error) {
 	}(metaCh, errCh)
 	// Fetch beacons & merge
 	go func(ch chan<- []*Beacon, errCh chan<- error) {
-		bkns, err := self.FetchDeploymentBeacons(dep)
+		bkns, err := self.FetchDeploymentBeacons(ctx, dep)
 		if err != nil {
 			errCh <- err
 			return
@@ -653,6 +769,9 @@ func (self *CassClient) FetchDeployment(dep *Deployment) (*Deployment, error) {
 		select {
 		case meta := <-metaCh:
 			res.MessageName = meta.MessageName
+			res.Rules = meta.Rules
+			res.Schedule = meta.Schedule
+			res.Variants = meta.Variants
 		case bkns := <-bknsCh:
 			res.BeaconNames = mapBeaconNames(bkns)
 		// If we pull an error, return through
@@ -665,25 +784,96 @@ func (self *CassClient) FetchDeployment(dep *Deployment) (*Deployment, error) {
 
 // Helpers
 
-//dispatcher is a private struct which will receive commands & execute them in goroutines. You may then await the channel for responses.
-// It encloses the logic for maintaining/incrementing counts
+// dispatcher runs commands concurrently, collecting their results with Wait
 type dispatcher struct {
-	Ch chan *UpsertResult
-	Ct uint32
+	ch     chan *UpsertResult
+	ct     int
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newDispatcher(ctx context.Context) *dispatcher {
+	ctx, cancel := context.WithCancel(ctx)
+	return &dispatcher{ch: make(chan *UpsertResult), ctx: ctx, cancel: cancel}
 }
 
-func (self *dispatcher) Register(fn func() *UpsertResult) {
-	self.Ct += 1
+// Register runs fn in a goroutine. fn receives a context canceled once any command fails.
+func (self *dispatcher) Register(fn func(context.Context) *UpsertResult) {
+	self.ct++
 	go func() {
-		self.Ch <- fn()
+		res := fn(self.ctx)
+		// Wait stops receiving after the first failure, so the rest mustn't block
+		select {
+		case self.ch <- res:
+		case <-self.ctx.Done():
+		}
 	}()
 }
 
-func newDispatcher() *dispatcher {
-	return &dispatcher{
-		Ch: make(chan *UpsertResult),
-		Ct: 0,
+// Wait returns the first failure, canceling the remaining commands, or an empty result once all succeed.
+// There's no collective batch, so the result never has one.
+func (self *dispatcher) Wait() *UpsertResult {
+	defer self.cancel()
+	for i := 0; i < self.ct; i++ {
+		select {
+		case res := <-self.ch:
+			if res.Err != nil {
+				return res
+			}
+		case <-self.ctx.Done():
+			return &UpsertResult{Err: self.ctx.Err()}
+		}
+	}
+	return &UpsertResult{}
+}
+
+// marshalMetadata serializes the json encoded deployments_metadata columns, in order: redirect_rules, schedule & variants. Unset values are stored as empty strings.
+func (self *Deployment) marshalMetadata() ([]interface{}, error) {
+	values := []struct {
+		v     interface{}
+		empty bool
+	}{
+		{self.Rules, len(self.Rules) == 0},
+		{self.Schedule, self.Schedule == nil},
+		{self.Variants, len(self.Variants) == 0},
+	}
+
+	res := make([]interface{}, 0, len(values))
+	for _, val := range values {
+		if val.empty {
+			res = append(res, "")
+			continue
+		}
+
+		data, err := json.Marshal(val.v)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, string(data))
+	}
+	return res, nil
+}
+
+// unmarshalMetadata is the inverse of marshalMetadata
+func (self *Deployment) unmarshalMetadata(rules string, sched string, variants string) error {
+	columns := []struct {
+		data string
+		dst  interface{}
+	}{
+		{rules, &self.Rules},
+		{sched, &self.Schedule},
+		{variants, &self.Variants},
+	}
+
+	for _, col := range columns {
+		if col.data == "" {
+			continue
+		}
+		if err := json.Unmarshal([]byte(col.data), col.dst); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 func mapBeaconNames(bkns []*Beacon) [][]byte {
@@ -0,0 +1,27 @@
+// Cassandra lib
+package cass
+
+import (
+	"errors"
+	"github.com/owen-d/beacon-api/lib/apierr"
+	"net/http"
+)
+
+var (
+	// ErrNotOwned signals a beacon which does not belong to the expected owner
+	ErrNotOwned = errors.New("beacon not owned")
+	// ErrDeployed signals a beacon which must leave its deployment first
+	ErrDeployed = errors.New("beacon must be removed from its deployment before transfer")
+	// ErrConflict signals a lightweight transaction which wasn't applied, as the row already exists
+	ErrConflict = errors.New("already exists")
+	// ErrLinkTaken signals a beacon whose short name is already the public link of another beacon
+	ErrLinkTaken = errors.New("another beacon already holds this beacon's short link")
+)
+
+func init() {
+	apierr.Register(ErrNotOwned, http.StatusNotFound, apierr.NotFound)
+	apierr.Register(ErrDeployed, http.StatusConflict, apierr.Conflict)
+	apierr.Register(ErrConflict, http.StatusConflict, apierr.Conflict)
+	apierr.Register(ErrLinkTaken, http.StatusConflict, apierr.Conflict)
+	apierr.Register(ErrTokenConsumed, http.StatusConflict, apierr.Conflict)
+}
@@ -0,0 +1,71 @@
+// Cassandra lib
+package cass
+
+import (
+	"context"
+	"github.com/gocql/gocql"
+	"time"
+)
+
+// IdempotencyRecord is the outcome of a request made with an idempotency key. Status is 0 until the request completes.
+type IdempotencyRecord struct {
+	UserId      *gocql.UUID
+	Key         string
+	RequestHash []byte
+	Status      int
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time
+}
+
+// ClaimIdempotencyKey stores an incomplete record unless the key is already in use, in which case the existing record is returned instead
+func (self *CassClient) ClaimIdempotencyKey(ctx context.Context, rec *IdempotencyRecord, ttl time.Duration) (*IdempotencyRecord, bool, error) {
+	template := `INSERT INTO idempotency_keys (user_id, idempotency_key, request_hash, status, created_at) VALUES (?, ?, ?, 0, ?) IF NOT EXISTS USING TTL ?`
+	existing := map[string]interface{}{}
+	applied, err := self.query(ctx, template, rec.UserId, rec.Key, rec.RequestHash, rec.CreatedAt, int(ttl.Seconds())).MapScanCAS(existing)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if applied {
+		return rec, true, nil
+	}
+
+	res := &IdempotencyRecord{UserId: rec.UserId, Key: rec.Key}
+	res.RequestHash, _ = existing["request_hash"].([]byte)
+	res.Status, _ = existing["status"].(int)
+	res.ContentType, _ = existing["content_type"].(string)
+	res.Body, _ = existing["body"].([]byte)
+	res.CreatedAt, _ = existing["created_at"].(time.Time)
+	return res, false, nil
+}
+
+// PutIdempotencyRecord stores a completed request's response in place of its claim. Like the claim, it's a lightweight transaction,
+// so a concurrent retry never reads a stale claim. ErrConflict is returned if the claim has been released or has expired.
+func (self *CassClient) PutIdempotencyRecord(ctx context.Context, rec *IdempotencyRecord, ttl time.Duration) *UpsertResult {
+	// every column is rewritten, so none expire with the claim's shorter ttl
+	template := `UPDATE idempotency_keys USING TTL ? SET request_hash = ?, status = ?, content_type = ?, body = ?, created_at = ? WHERE user_id = ? AND idempotency_key = ? IF status = 0`
+	args := []interface{}{
+		int(ttl.Seconds()),
+		rec.RequestHash,
+		rec.Status,
+		rec.ContentType,
+		rec.Body,
+		rec.CreatedAt,
+		rec.UserId,
+		rec.Key,
+	}
+
+	applied, err := self.query(ctx, template, args...).MapScanCAS(map[string]interface{}{})
+	if err == nil && !applied {
+		err = ErrConflict
+	}
+	return &UpsertResult{Batch: nil, Err: err}
+}
+
+// DeleteIdempotencyKey releases a claimed key, so the request may be retried. Completed records are kept.
+func (self *CassClient) DeleteIdempotencyKey(ctx context.Context, userId *gocql.UUID, key string) *UpsertResult {
+	template := `DELETE FROM idempotency_keys WHERE user_id = ? AND idempotency_key = ? IF status = 0`
+	_, err := self.query(ctx, template, userId, key).MapScanCAS(map[string]interface{}{})
+	return &UpsertResult{Batch: nil, Err: err}
+}
@@ -0,0 +1,67 @@
+package cass
+
+import (
+	"context"
+	"github.com/gocql/gocql"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyKeys(t *testing.T) {
+	client := createLocalhostClient("bkn")
+	defer client.Sess.Close()
+
+	uuid, _ := gocql.ParseUUID(prepopId)
+	rec := &IdempotencyRecord{
+		UserId:      &uuid,
+		Key:         gocql.TimeUUID().String(),
+		RequestHash: []byte("hash"),
+		CreatedAt:   time.Now(),
+	}
+
+	if _, claimed, err := client.ClaimIdempotencyKey(context.Background(), rec, time.Minute); err != nil || !claimed {
+		t.Error("failed to claim key:", claimed, err)
+		return
+	}
+
+	existing, claimed, err := client.ClaimIdempotencyKey(context.Background(), rec, time.Minute)
+	if err != nil || claimed || existing.Status != 0 || string(existing.RequestHash) != "hash" {
+		t.Error("expected the claimed record, got:", existing, claimed, err)
+	}
+
+	rec.Status, rec.ContentType, rec.Body = 201, "application/json", []byte(`{}`)
+	if res := client.PutIdempotencyRecord(context.Background(), rec, time.Minute); res.Err != nil {
+		t.Error("failed to store response:", res.Err)
+	}
+
+	existing, _, err = client.ClaimIdempotencyKey(context.Background(), rec, time.Minute)
+	if err != nil || existing.Status != 201 || string(existing.Body) != `{}` {
+		t.Error("expected the stored response, got:", existing, err)
+	}
+
+	// completed records outlive a release
+	if res := client.DeleteIdempotencyKey(context.Background(), rec.UserId, rec.Key); res.Err != nil {
+		t.Error("failed to release key:", res.Err)
+	}
+	if existing, _, err = client.ClaimIdempotencyKey(context.Background(), rec, time.Minute); err != nil || existing.Status != 201 {
+		t.Error("expected the stored response to survive a release, got:", existing, err)
+	}
+
+	released := &IdempotencyRecord{UserId: &uuid, Key: gocql.TimeUUID().String(), RequestHash: []byte("hash"), CreatedAt: time.Now()}
+	if _, claimed, err := client.ClaimIdempotencyKey(context.Background(), released, time.Minute); err != nil || !claimed {
+		t.Fatal("failed to claim key:", claimed, err)
+	}
+	if res := client.DeleteIdempotencyKey(context.Background(), released.UserId, released.Key); res.Err != nil {
+		t.Error("failed to release key:", res.Err)
+	}
+
+	// a response can't complete a released claim
+	released.Status = 201
+	if res := client.PutIdempotencyRecord(context.Background(), released, time.Minute); res.Err != ErrConflict {
+		t.Error("expected a conflict completing a released key, got:", res.Err)
+	}
+
+	if _, claimed, err := client.ClaimIdempotencyKey(context.Background(), released, time.Minute); err != nil || !claimed {
+		t.Error("expected a released key to be claimable:", claimed, err)
+	}
+}
@@ -0,0 +1,222 @@
+// Cassandra lib
+package cass
+
+import (
+	"bytes"
+	"context"
+	"github.com/gocql/gocql"
+	"sort"
+	"time"
+)
+
+const (
+	// ShortNameLength is the number of trailing beacon name bytes used in public attachment urls
+	ShortNameLength = 6
+)
+
+// ShortName returns the suffix of a beacon name used for its public link. It must match the suffix used when building attachment urls in beaconclient.DeclarativeAttach
+func ShortName(name []byte) []byte {
+	if len(name) <= ShortNameLength {
+		return name
+	}
+	return name[len(name)-ShortNameLength:]
+}
+
+// Link is the resolved form of a public short link: the beacon it belongs to & the deployment/message currently on it (if any)
+type Link struct {
+	ShortName  []byte
+	Beacon     *Beacon
+	Deployment *Deployment
+	Message    *Message
+}
+
+// Event is a single passerby or interaction record against a deployed beacon
+type Event struct {
+	UserId     *gocql.UUID
+	DeployName string
+	BeaconName []byte
+	// Variant is the name of the message served, distinguishing a deployment's variants
+	Variant string
+	Moment  time.Time
+}
+
+// VariantStats summarizes the passersby (views) & interactions (clicks) of a single message served by a deployment
+type VariantStats struct {
+	MessageName      string  `json:"message_name"`
+	Views            int     `json:"views"`
+	Clicks           int     `json:"clicks"`
+	ClickThroughRate float64 `json:"click_through_rate"`
+}
+
+// PostLinks maps each beacon's short name back to the beacon. It should only be called for beacons whose ownership has been verified.
+// Short names may collide, so a link is only claimed if it's free or already held by the same beacon, i.e. after a transfer. Otherwise ErrLinkTaken is returned.
+func (self *CassClient) PostLinks(ctx context.Context, beacons []*Beacon) *UpsertResult {
+	template := `INSERT INTO beacon_links (short_name, user_id, name) VALUES (?, ?, ?) IF NOT EXISTS`
+
+	for _, bkn := range beacons {
+		short := ShortName(bkn.Name)
+		existing := map[string]interface{}{}
+		applied, err := self.query(ctx, template, short, bkn.UserId, bkn.Name).MapScanCAS(existing)
+		if err != nil {
+			return &UpsertResult{Batch: nil, Err: err}
+		}
+		if applied {
+			continue
+		}
+
+		name, _ := existing["name"].([]byte)
+		owner, _ := existing["user_id"].(gocql.UUID)
+		if !bytes.Equal(name, bkn.Name) {
+			return &UpsertResult{Batch: nil, Err: ErrLinkTaken}
+		}
+		if owner == *bkn.UserId {
+			continue
+		}
+
+		// the same beacon under a new owner; conditional on the previous owner, so concurrent transfers can't both win
+		transfer := `UPDATE beacon_links SET user_id = ? WHERE short_name = ? IF name = ? AND user_id = ?`
+		applied, err = self.query(ctx, transfer, bkn.UserId, short, bkn.Name, owner).MapScanCAS(map[string]interface{}{})
+		if err != nil {
+			return &UpsertResult{Batch: nil, Err: err}
+		}
+		if !applied {
+			return &UpsertResult{Batch: nil, Err: ErrLinkTaken}
+		}
+	}
+
+	return &UpsertResult{}
+}
+
+// FetchLink resolves a short name into its beacon, deployment & deployed message. Deployment & Message will be nil if the beacon has no deployment.
+func (self *CassClient) FetchLink(ctx context.Context, shortName []byte) (*Link, error) {
+	bkn := &Beacon{}
+	template := `SELECT user_id, name FROM beacon_links WHERE short_name = ?`
+
+	if err := self.query(ctx, template, shortName).Scan(&bkn.UserId, &bkn.Name); err != nil {
+		return nil, err
+	}
+
+	matchedBkn, bknErr := self.FetchBeacon(ctx, bkn)
+	if bknErr != nil {
+		return nil, bknErr
+	}
+
+	res := &Link{
+		ShortName: shortName,
+		Beacon:    matchedBkn,
+	}
+
+	if matchedBkn.DeployName == "" {
+		return res, nil
+	}
+
+	meta, metaErr := self.FetchDeploymentMetadata(ctx, matchedBkn.UserId, matchedBkn.DeployName)
+	if metaErr != nil {
+		return nil, metaErr
+	}
+
+	msg, msgErr := self.FetchMessage(ctx, &Message{UserId: matchedBkn.UserId, Name: meta.MessageName})
+	if msgErr != nil {
+		return nil, msgErr
+	}
+
+	res.Deployment = meta
+	res.Message = msg
+	return res, nil
+}
+
+// Analytics ------------------------------------------------------------------------------
+
+// RecordPasserby stores a passerby event, i.e. a phone pulling a beacon's page for metadata
+func (self *CassClient) RecordPasserby(ctx context.Context, e *Event) *UpsertResult {
+	return self.recordEvent(ctx, "passerby", e)
+}
+
+// RecordInteraction stores an interaction event, i.e. a passerby tapping through to a beacon's message
+func (self *CassClient) RecordInteraction(ctx context.Context, e *Event) *UpsertResult {
+	return self.recordEvent(ctx, "interactions", e)
+}
+
+// recordEvent is the underlying function behind RecordPasserby and RecordInteraction. Both tables share the same structure.
+func (self *CassClient) recordEvent(ctx context.Context, table string, e *Event) *UpsertResult {
+	moment := e.Moment
+	if moment.IsZero() {
+		moment = time.Now()
+	}
+
+	template := `INSERT INTO ` + table + ` (bkn_user_id, deploy_name, moment, bkn_name, variant) VALUES (?, ?, ?, ?, ?)`
+	args := []interface{}{
+		e.UserId,
+		e.DeployName,
+		moment,
+		e.BeaconName,
+		e.Variant,
+	}
+
+	return &UpsertResult{
+		Batch: nil,
+		Err:   self.query(ctx, template, args...).Exec(),
+	}
+}
+
+// FetchDeploymentStats tallies a deployment's passersby & interactions since a given time, grouped by the variant served
+func (self *CassClient) FetchDeploymentStats(ctx context.Context, dep *Deployment, since time.Time) ([]*VariantStats, error) {
+	views, viewsErr := self.countEvents(ctx, "passerby", dep, since)
+	if viewsErr != nil {
+		return nil, viewsErr
+	}
+
+	clicks, clicksErr := self.countEvents(ctx, "interactions", dep, since)
+	if clicksErr != nil {
+		return nil, clicksErr
+	}
+
+	// variants seen in either table
+	seen := make(map[string]bool, len(views))
+	names := make([]string, 0, len(views))
+	for _, counts := range []map[string]int{views, clicks} {
+		for name := range counts {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+
+	res := make([]*VariantStats, 0, len(names))
+	for _, name := range names {
+		stats := &VariantStats{
+			MessageName: name,
+			Views:       views[name],
+			Clicks:      clicks[name],
+		}
+		if stats.Views != 0 {
+			stats.ClickThroughRate = float64(stats.Clicks) / float64(stats.Views)
+		}
+		res = append(res, stats)
+	}
+	return res, nil
+}
+
+// countEvents counts the events in a passerby/interactions partition per variant
+func (self *CassClient) countEvents(ctx context.Context, table string, dep *Deployment, since time.Time) (map[string]int, error) {
+	template := `SELECT variant FROM ` + table + ` WHERE bkn_user_id = ? AND deploy_name = ? AND moment >= ?`
+	args := []interface{}{
+		dep.UserId,
+		dep.DeployName,
+		since,
+	}
+
+	res := make(map[string]int)
+	var variant string
+	iter := self.query(ctx, template, args...).Iter()
+	for iter.Scan(&variant) {
+		res[variant]++
+	}
+
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,120 @@
+package cass
+
+import (
+	"context"
+	"github.com/gocql/gocql"
+	"testing"
+	"time"
+)
+
+func TestFetchLink(t *testing.T) {
+	client := createLocalhostClient("bkn")
+	defer client.Sess.Close()
+
+	link, err := client.FetchLink(context.Background(), ShortName(prepopBName))
+	if err != nil || link == nil {
+		t.Error("failed to fetch link:", err)
+		return
+	}
+
+	if link.Message == nil {
+		t.Error("expected deployed message on link")
+	}
+}
+
+func TestRecordEvents(t *testing.T) {
+	client := createLocalhostClient("bkn")
+	defer client.Sess.Close()
+
+	uuid, _ := gocql.ParseUUID(prepopId)
+	e := &Event{
+		UserId:     &uuid,
+		DeployName: prepopDName,
+		BeaconName: prepopBName,
+		Variant:    prepopMName,
+	}
+
+	t.Run("passerby", func(t *testing.T) {
+		if res := client.RecordPasserby(context.Background(), e); res.Err != nil {
+			t.Error("failed to record passerby:", res.Err)
+		}
+	})
+
+	t.Run("interaction", func(t *testing.T) {
+		if res := client.RecordInteraction(context.Background(), e); res.Err != nil {
+			t.Error("failed to record interaction:", res.Err)
+		}
+	})
+}
+
+func TestFetchDeploymentStats(t *testing.T) {
+	client := createLocalhostClient("bkn")
+	defer client.Sess.Close()
+
+	uuid, _ := gocql.ParseUUID(prepopId)
+	dep := &Deployment{
+		UserId:     &uuid,
+		DeployName: prepopDName,
+	}
+
+	stats, err := client.FetchDeploymentStats(context.Background(), dep, time.Now().Add(-time.Hour))
+	if err != nil || stats == nil {
+		t.Error("failed to fetch stats:", err)
+	}
+}
+
+func TestPostLinksCollision(t *testing.T) {
+	client := createLocalhostClient("bkn")
+	defer client.Sess.Close()
+
+	owner, other := gocql.TimeUUID(), gocql.TimeUUID()
+	name := gocql.TimeUUID().Bytes()
+	// same short name, different beacon
+	imposter := append([]byte{0xff}, name...)
+
+	if res := client.PostLinks(context.Background(), []*Beacon{&Beacon{UserId: &owner, Name: name}}); res.Err != nil {
+		t.Error("failed to claim link:", res.Err)
+		return
+	}
+
+	if res := client.PostLinks(context.Background(), []*Beacon{&Beacon{UserId: &other, Name: imposter}}); res.Err != ErrLinkTaken {
+		t.Error("expected a colliding beacon to be refused, got:", res.Err)
+	}
+
+	// a transferred beacon keeps its link
+	if res := client.PostLinks(context.Background(), []*Beacon{&Beacon{UserId: &other, Name: name}}); res.Err != nil {
+		t.Error("failed to transfer link:", res.Err)
+	}
+}
+
+func TestUpdateBeaconsCollision(t *testing.T) {
+	client := createLocalhostClient("bkn")
+	defer client.Sess.Close()
+
+	owner, other := gocql.TimeUUID(), gocql.TimeUUID()
+	name := gocql.TimeUUID().Bytes()
+	// the last 6 bytes collide
+	imposter := append([]byte{0xff}, name...)
+
+	first := &Beacon{UserId: &owner, Name: name, DeployName: "dep", MsgUrl: "http://first.url"}
+	second := &Beacon{UserId: &other, Name: imposter, DeployName: "dep", MsgUrl: "http://second.url"}
+	for _, bkn := range []*Beacon{first, second} {
+		if res := client.CreateBeacons(context.Background(), []*Beacon{&Beacon{UserId: bkn.UserId, Name: bkn.Name}}, nil); res.Err != nil {
+			t.Fatal("failed to create beacon:", res.Err)
+		}
+	}
+
+	if res := client.UpdateBeacons(context.Background(), []*Beacon{first}); res.Err != nil {
+		t.Fatal("failed to deploy beacon:", res.Err)
+	}
+
+	if res := client.UpdateBeacons(context.Background(), []*Beacon{second}); res.Err != ErrLinkTaken {
+		t.Error("expected a colliding beacon to be refused, got:", res.Err)
+	}
+
+	// the refused beacon mustn't be left deployed
+	found, err := client.FetchBeacon(context.Background(), second)
+	if err != nil || found.DeployName != "" || found.MsgUrl != "" {
+		t.Errorf("expected the colliding beacon to be left undeployed, got %+v, %v", found, err)
+	}
+}
@@ -0,0 +1,148 @@
+package cass
+
+import (
+	"context"
+	"errors"
+	"github.com/gocql/gocql"
+	"github.com/owen-d/beacon-api/lib/metrics"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// QueryTimeout bounds each query & its retries, within whatever deadline the caller's context carries
+const QueryTimeout = 5 * time.Second
+
+var (
+	queryDuration = metrics.NewHistogram("cass_query_duration_seconds", "Cassandra query latency, by statement & table.", nil, "op")
+	queryErrors   = metrics.NewCounter("cass_query_errors_total", "Failed cassandra queries, by statement & table. Missing rows aren't failures.", "op")
+)
+
+// query wraps a gocql query bound by ctx & QueryTimeout, recording its latency & failures once executed.
+// The query must be executed, & an Iter closed, to release its timeout.
+type query struct {
+	*gocql.Query
+	op     string
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (self *CassClient) query(ctx context.Context, stmt string, values ...interface{}) *query {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	return &query{self.Sess.Query(stmt, values...).WithContext(ctx), operation(stmt), ctx, cancel}
+}
+
+func (self *query) Exec() error {
+	defer self.done(time.Now())
+	return self.record(self.Query.Exec())
+}
+
+func (self *query) Scan(dest ...interface{}) error {
+	defer self.done(time.Now())
+	return self.record(self.Query.Scan(dest...))
+}
+
+func (self *query) MapScanCAS(dest map[string]interface{}) (bool, error) {
+	defer self.done(time.Now())
+	applied, err := self.Query.MapScanCAS(dest)
+	return applied, self.record(err)
+}
+
+// Iter defers recording until the iterator is closed, as rows are paged in while iterating
+func (self *query) Iter() *iter {
+	return &iter{self.Query.Iter(), self, time.Now()}
+}
+
+// eachPage runs stmt a page of DefaultLimit rows at a time, each page bound by its own QueryTimeout, until scan has seen every row.
+// scan must not close the iterator; a scan error stops paging.
+func (self *CassClient) eachPage(ctx context.Context, stmt string, args []interface{}, scan func(*iter) error) error {
+	var state []byte
+	for {
+		q := self.query(ctx, stmt, args...)
+		// an explicit page state turns off gocql's auto paging, so each page is a query of its own
+		q.PageSize(DefaultLimit).PageState(state)
+		iter := q.Iter()
+
+		if err := scan(iter); err != nil {
+			iter.Close()
+			return err
+		}
+
+		state = iter.PageState()
+		if err := iter.Close(); err != nil {
+			return err
+		}
+		if len(state) == 0 {
+			return nil
+		}
+	}
+}
+
+func (self *query) done(start time.Time) {
+	self.cancel()
+	queryDuration.Since(start, self.op)
+}
+
+func (self *query) record(err error) error {
+	return recordErr(self.ctx, self.op, err)
+}
+
+// recordErr counts & logs failures. Missing rows aren't failures, nor are queries abandoned by their caller.
+func recordErr(ctx context.Context, op string, err error) error {
+	switch {
+	case err == nil, err == gocql.ErrNotFound:
+	case errors.Is(err, context.Canceled):
+		slog.DebugContext(ctx, "cass: query canceled", "op", op)
+	default:
+		queryErrors.Inc(op)
+		slog.ErrorContext(ctx, "cass: query failed", "op", op, "error", err)
+	}
+	return err
+}
+
+type iter struct {
+	*gocql.Iter
+	query *query
+	start time.Time
+}
+
+func (self *iter) Close() error {
+	defer self.query.done(self.start)
+	return self.query.record(self.Iter.Close())
+}
+
+func (self *CassClient) executeBatch(ctx context.Context, batch *gocql.Batch) error {
+	defer queryDuration.Since(time.Now(), "batch")
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+	return recordErr(ctx, "batch", self.Sess.ExecuteBatch(batch.WithContext(ctx)))
+}
+
+// executeBatchCAS executes a conditional batch, whose statements must share a partition, reporting whether it applied
+func (self *CassClient) executeBatchCAS(ctx context.Context, batch *gocql.Batch) (bool, error) {
+	defer queryDuration.Since(time.Now(), "batch")
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	applied, iter, err := self.Sess.MapExecuteBatchCAS(batch.WithContext(ctx), map[string]interface{}{})
+	if err == nil {
+		err = iter.Close()
+	}
+	return applied, recordErr(ctx, "batch", err)
+}
+
+// operation labels a statement by its kind & table, i.e. "select beacons"
+func operation(stmt string) string {
+	words := strings.Fields(strings.ToLower(stmt))
+	if len(words) == 0 {
+		return "unknown"
+	}
+
+	kind := words[0]
+	for i, word := range words[:len(words)-1] {
+		if word == "from" || word == "into" || (word == "update" && i == 0) {
+			return kind + " " + strings.Trim(words[i+1], "(")
+		}
+	}
+	return kind
+}
@@ -0,0 +1,256 @@
+// Cassandra lib
+package cass
+
+import (
+	"context"
+	"github.com/gocql/gocql"
+	"log/slog"
+	"time"
+)
+
+// Org is a shared owner of beacons, messages & deployments. Its Id takes the place of a user_id in those tables.
+type Org struct {
+	Id        *gocql.UUID `cql:"id" json:"id"`
+	Name      string      `cql:"name" json:"name"`
+	CreatedAt time.Time   `cql:"created_at" json:"created_at"`
+}
+
+// Member is a user's role within an org
+type Member struct {
+	OrgId    *gocql.UUID `cql:"org_id" json:"org_id"`
+	UserId   *gocql.UUID `cql:"user_id" json:"user_id"`
+	Role     string      `cql:"role" json:"role"`
+	JoinedAt time.Time   `cql:"joined_at" json:"joined_at,omitempty"`
+}
+
+// Invitation allows the holder of its token, signed in with a matching email, to join an org
+type Invitation struct {
+	Hash      []byte      `cql:"token_hash" json:"-"`
+	OrgId     *gocql.UUID `cql:"org_id" json:"org_id"`
+	Email     string      `cql:"email" json:"email"`
+	Role      string      `cql:"role" json:"role"`
+	InvitedBy *gocql.UUID `cql:"invited_by" json:"invited_by"`
+	ExpiresAt time.Time   `json:"expires_at"`
+}
+
+// CreateOrg stores an org along with its founding owner
+func (self *CassClient) CreateOrg(ctx context.Context, org *Org, owner *Member) *UpsertResult {
+	batch := gocql.NewBatch(gocql.LoggedBatch)
+	batch.Query(`INSERT INTO orgs (id, name, created_at) VALUES (?, ?, ?)`, org.Id, org.Name, org.CreatedAt)
+
+	res := self.PutMember(ctx, owner, batch)
+	if res.Err != nil {
+		return res
+	}
+
+	return &UpsertResult{
+		Batch: nil,
+		Err:   self.executeBatch(ctx, batch),
+	}
+}
+
+// FetchOrg finds an org by id
+func (self *CassClient) FetchOrg(ctx context.Context, id *gocql.UUID) (*Org, error) {
+	org := &Org{}
+	err := self.query(ctx, `SELECT id, name, created_at FROM orgs WHERE id = ?`, id).Scan(&org.Id, &org.Name, &org.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return org, nil
+}
+
+// PutMember adds a member or changes their role
+func (self *CassClient) PutMember(ctx context.Context, m *Member, batch *gocql.Batch) *UpsertResult {
+	template := `INSERT INTO org_members (org_id, user_id, role, joined_at) VALUES (?, ?, ?, ?)`
+	args := []interface{}{
+		m.OrgId,
+		m.UserId,
+		m.Role,
+		m.JoinedAt,
+	}
+
+	if batch != nil {
+		batch.Query(template, args...)
+		return &UpsertResult{Batch: batch, Err: nil}
+	}
+
+	return &UpsertResult{
+		Batch: nil,
+		Err:   self.query(ctx, template, args...).Exec(),
+	}
+}
+
+// RemoveMember removes a user from an org
+func (self *CassClient) RemoveMember(ctx context.Context, orgId *gocql.UUID, userId *gocql.UUID) *UpsertResult {
+	return &UpsertResult{
+		Batch: nil,
+		Err:   self.query(ctx, `DELETE FROM org_members WHERE org_id = ? AND user_id = ?`, orgId, userId).Exec(),
+	}
+}
+
+// DemoteOwner changes an owner's role, or removes them when role is empty, provided keeper is still an owner.
+// Both rows share the org's partition, so one conditional batch covers them: concurrent demotions can't leave an org without an owner. ErrConflict is returned if either row changed.
+func (self *CassClient) DemoteOwner(ctx context.Context, owner *Member, role string, keeper *Member) *UpsertResult {
+	batch := gocql.NewBatch(gocql.LoggedBatch)
+	// rewrites keeper's role unchanged, purely for the condition
+	batch.Query(`UPDATE org_members SET role = ? WHERE org_id = ? AND user_id = ? IF role = ?`, keeper.Role, keeper.OrgId, keeper.UserId, keeper.Role)
+	if role == "" {
+		batch.Query(`DELETE FROM org_members WHERE org_id = ? AND user_id = ? IF role = ?`, owner.OrgId, owner.UserId, owner.Role)
+	} else {
+		batch.Query(`UPDATE org_members SET role = ? WHERE org_id = ? AND user_id = ? IF role = ?`, role, owner.OrgId, owner.UserId, owner.Role)
+	}
+
+	applied, err := self.executeBatchCAS(ctx, batch)
+	if err == nil && !applied {
+		err = ErrConflict
+	}
+	return &UpsertResult{Batch: nil, Err: err}
+}
+
+// FetchMember returns a user's membership in an org, or gocql.ErrNotFound
+func (self *CassClient) FetchMember(ctx context.Context, orgId *gocql.UUID, userId *gocql.UUID) (*Member, error) {
+	m := &Member{}
+	template := `SELECT org_id, user_id, role, joined_at FROM org_members WHERE org_id = ? AND user_id = ?`
+	if err := self.query(ctx, template, orgId, userId).Scan(&m.OrgId, &m.UserId, &m.Role, &m.JoinedAt); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// FetchMembers lists the members of an org
+func (self *CassClient) FetchMembers(ctx context.Context, orgId *gocql.UUID) ([]*Member, error) {
+	return self.fetchMembers(ctx, `SELECT org_id, user_id, role, joined_at FROM org_members WHERE org_id = ?`, orgId)
+}
+
+// FetchUserMemberships lists the orgs a user belongs to
+func (self *CassClient) FetchUserMemberships(ctx context.Context, userId *gocql.UUID) ([]*Member, error) {
+	return self.fetchMembers(ctx, `SELECT org_id, user_id, role FROM org_members_by_user WHERE user_id = ?`, userId)
+}
+
+// FetchSoleOrgs lists the orgs whose only member is the user
+func (self *CassClient) FetchSoleOrgs(ctx context.Context, userId *gocql.UUID) ([]*gocql.UUID, error) {
+	memberships, err := self.FetchUserMemberships(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	orgIds := make([]*gocql.UUID, 0)
+	for _, membership := range memberships {
+		members, membersErr := self.FetchMembers(ctx, membership.OrgId)
+		if membersErr != nil {
+			return nil, membersErr
+		}
+		if len(members) == 1 && *members[0].UserId == *userId {
+			orgIds = append(orgIds, membership.OrgId)
+		}
+	}
+	return orgIds, nil
+}
+
+func (self *CassClient) fetchMembers(ctx context.Context, template string, id *gocql.UUID) ([]*Member, error) {
+	resRows := make([]*Member, 0)
+	iter := self.query(ctx, template, id).Iter()
+	shell := map[string]interface{}{}
+	for iter.MapScan(shell) {
+		orgId := shell["org_id"].(gocql.UUID)
+		userId := shell["user_id"].(gocql.UUID)
+		m := &Member{
+			OrgId:  &orgId,
+			UserId: &userId,
+			Role:   shell["role"].(string),
+		}
+		if joinedAt, ok := shell["joined_at"].(time.Time); ok {
+			m.JoinedAt = joinedAt
+		}
+		resRows = append(resRows, m)
+
+		shell = map[string]interface{}{}
+	}
+
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return resRows, nil
+}
+
+// CreateInvitation stores an invitation until it expires
+func (self *CassClient) CreateInvitation(ctx context.Context, inv *Invitation) *UpsertResult {
+	template := `INSERT INTO org_invitations (token_hash, org_id, email, role, invited_by) VALUES (?, ?, ?, ?, ?) USING TTL ?`
+	args := []interface{}{
+		inv.Hash,
+		inv.OrgId,
+		inv.Email,
+		inv.Role,
+		inv.InvitedBy,
+		ttlSeconds(inv.ExpiresAt),
+	}
+
+	return &UpsertResult{
+		Batch: nil,
+		Err:   self.query(ctx, template, args...).Exec(),
+	}
+}
+
+// FetchInvitation finds an unexpired invitation by the hash of its token, or gocql.ErrNotFound
+func (self *CassClient) FetchInvitation(ctx context.Context, hash []byte) (*Invitation, error) {
+	inv := &Invitation{Hash: hash}
+	template := `SELECT org_id, email, role, invited_by FROM org_invitations WHERE token_hash = ?`
+	if err := self.query(ctx, template, hash).Scan(&inv.OrgId, &inv.Email, &inv.Role, &inv.InvitedBy); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// ConsumeInvitation deletes an invitation so that it may only be accepted once. It returns gocql.ErrNotFound if another request consumed it first.
+func (self *CassClient) ConsumeInvitation(ctx context.Context, hash []byte) *UpsertResult {
+	applied, err := self.query(ctx, `DELETE FROM org_invitations WHERE token_hash = ? IF EXISTS`, hash).MapScanCAS(map[string]interface{}{})
+	if err == nil && !applied {
+		err = gocql.ErrNotFound
+	}
+	return &UpsertResult{Batch: nil, Err: err}
+}
+
+// TransferBeacons moves undeployed beacons to a new owner, i.e. from a user into their org
+func (self *CassClient) TransferBeacons(ctx context.Context, beacons []*Beacon, to *gocql.UUID) *UpsertResult {
+	for _, bkn := range beacons {
+		var deployName string
+		template := `SELECT deploy_name FROM beacons WHERE user_id = ? AND name = ?`
+		if err := self.query(ctx, template, bkn.UserId, bkn.Name).Scan(&deployName); err == gocql.ErrNotFound {
+			return &UpsertResult{Batch: nil, Err: ErrNotOwned}
+		} else if err != nil {
+			return &UpsertResult{Batch: nil, Err: err}
+		}
+
+		// deployments are scoped to an owner, so a beacon may not carry one across owners
+		if deployName != "" {
+			return &UpsertResult{Batch: nil, Err: ErrDeployed}
+		}
+	}
+
+	moved := make([]*Beacon, 0, len(beacons))
+	batch := gocql.NewBatch(gocql.LoggedBatch)
+	for _, bkn := range beacons {
+		// conditional statements may not span partitions within a batch, hence a plain insert rather than CreateBeacons
+		batch.Query(`DELETE FROM beacons WHERE user_id = ? AND name = ?`, bkn.UserId, bkn.Name)
+		batch.Query(`INSERT INTO beacons (user_id, name, created_at) VALUES (?, ?, ?)`, to, bkn.Name, time.Now())
+		moved = append(moved, &Beacon{UserId: to, Name: bkn.Name})
+	}
+
+	// links are claimed first, so a collision aborts the transfer. They're conditional, hence outside the batch.
+	if res := self.PostLinks(ctx, moved); res.Err != nil {
+		return res
+	}
+
+	batchErr := self.executeBatch(ctx, batch)
+	if batchErr == nil {
+		return &UpsertResult{Batch: nil, Err: nil}
+	}
+
+	// hand the links back, so they don't point at an owner without the beacons. The batch may have run out of time, so the rollback gets its own.
+	rollbackCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), QueryTimeout)
+	defer cancel()
+	if res := self.PostLinks(rollbackCtx, beacons); res.Err != nil {
+		slog.ErrorContext(ctx, "cass: failed to roll back transferred links", "error", res.Err)
+	}
+	return &UpsertResult{Batch: nil, Err: batchErr}
+}
@@ -0,0 +1,78 @@
+package cass
+
+import (
+	"context"
+	"github.com/gocql/gocql"
+	"testing"
+	"time"
+)
+
+func TestOrgs(t *testing.T) {
+	client := createLocalhostClient("bkn")
+	defer client.Sess.Close()
+
+	userId, _ := gocql.ParseUUID(prepopId)
+	orgId := gocql.TimeUUID()
+	org := &Org{Id: &orgId, Name: "test org", CreatedAt: time.Now()}
+	owner := &Member{OrgId: &orgId, UserId: &userId, Role: "owner", JoinedAt: time.Now()}
+
+	if res := client.CreateOrg(context.Background(), org, owner); res.Err != nil {
+		t.Error("failed to create org:", res.Err)
+		return
+	}
+
+	t.Run("member", func(t *testing.T) {
+		m, err := client.FetchMember(context.Background(), &orgId, &userId)
+		if err != nil || m.Role != "owner" {
+			t.Error("failed to fetch member:", err)
+		}
+	})
+
+	t.Run("memberships", func(t *testing.T) {
+		ms, err := client.FetchUserMemberships(context.Background(), &userId)
+		if err != nil || len(ms) == 0 {
+			t.Error("failed to fetch memberships:", err)
+		}
+	})
+
+	t.Run("invitation", func(t *testing.T) {
+		inv := &Invitation{
+			Hash:      gocql.TimeUUID().Bytes(),
+			OrgId:     &orgId,
+			Email:     "invitee@provider.com",
+			Role:      "viewer",
+			InvitedBy: &userId,
+			ExpiresAt: time.Now().Add(time.Hour),
+		}
+		if res := client.CreateInvitation(context.Background(), inv); res.Err != nil {
+			t.Error("failed to create invitation:", res.Err)
+			return
+		}
+		if fetched, err := client.FetchInvitation(context.Background(), inv.Hash); err != nil || fetched.Email != inv.Email {
+			t.Error("failed to fetch invitation:", err)
+		}
+		if res := client.ConsumeInvitation(context.Background(), inv.Hash); res.Err != nil {
+			t.Error("failed to consume invitation:", res.Err)
+		}
+		if res := client.ConsumeInvitation(context.Background(), inv.Hash); res.Err != gocql.ErrNotFound {
+			t.Error("invitation was consumed twice")
+		}
+	})
+
+	t.Run("demote owner", func(t *testing.T) {
+		otherId := gocql.TimeUUID()
+		other := &Member{OrgId: &orgId, UserId: &otherId, Role: "owner", JoinedAt: time.Now()}
+		if res := client.PutMember(context.Background(), other, nil); res.Err != nil {
+			t.Error("failed to add owner:", res.Err)
+			return
+		}
+
+		// each owner demotes the other, as concurrent requests would: only the first may succeed
+		if res := client.DemoteOwner(context.Background(), owner, "editor", other); res.Err != nil {
+			t.Error("failed to demote owner:", res.Err)
+		}
+		if res := client.DemoteOwner(context.Background(), other, "", owner); res.Err != ErrConflict {
+			t.Error("expected the last owner to be kept, got", res.Err)
+		}
+	})
+}
@@ -0,0 +1,86 @@
+// Cassandra lib
+package cass
+
+import (
+	"context"
+	"github.com/gocql/gocql"
+	"time"
+)
+
+// Session is a browser's sign in, only ever stored by the hash of its cookie
+type Session struct {
+	Hash      []byte
+	UserId    *gocql.UUID
+	CSRFToken string
+	CreatedAt time.Time
+	LastSeen  time.Time
+	ExpiresAt time.Time
+}
+
+// PutSession (re)writes a session, which expires once it has been idle for the given duration
+func (self *CassClient) PutSession(ctx context.Context, s *Session, idle time.Duration) *UpsertResult {
+	ttl := ttlSeconds(s.LastSeen.Add(idle))
+	if max := ttlSeconds(s.ExpiresAt); max < ttl {
+		ttl = max
+	}
+
+	template := `INSERT INTO sessions (session_hash, user_id, csrf_token, created_at, last_seen, expires_at) VALUES (?, ?, ?, ?, ?, ?) USING TTL ?`
+	args := []interface{}{
+		s.Hash,
+		s.UserId,
+		s.CSRFToken,
+		s.CreatedAt,
+		s.LastSeen,
+		s.ExpiresAt,
+		ttl,
+	}
+
+	return &UpsertResult{
+		Batch: nil,
+		Err:   self.query(ctx, template, args...).Exec(),
+	}
+}
+
+// TouchSession records a session's activity, extending its idle lifetime. Every column is rewritten so none expire before the others.
+// It's conditional, so a session deleted meanwhile, i.e. by a logout, isn't recreated; gocql.ErrNotFound is returned instead.
+func (self *CassClient) TouchSession(ctx context.Context, s *Session, idle time.Duration) *UpsertResult {
+	ttl := ttlSeconds(s.LastSeen.Add(idle))
+	if max := ttlSeconds(s.ExpiresAt); max < ttl {
+		ttl = max
+	}
+
+	template := `UPDATE sessions USING TTL ? SET user_id = ?, csrf_token = ?, created_at = ?, last_seen = ?, expires_at = ? WHERE session_hash = ? IF EXISTS`
+	args := []interface{}{
+		ttl,
+		s.UserId,
+		s.CSRFToken,
+		s.CreatedAt,
+		s.LastSeen,
+		s.ExpiresAt,
+		s.Hash,
+	}
+
+	applied, err := self.query(ctx, template, args...).MapScanCAS(map[string]interface{}{})
+	if err == nil && !applied {
+		err = gocql.ErrNotFound
+	}
+	return &UpsertResult{Batch: nil, Err: err}
+}
+
+// FetchSession returns gocql.ErrNotFound for unknown or expired sessions
+func (self *CassClient) FetchSession(ctx context.Context, hash []byte) (*Session, error) {
+	s := &Session{Hash: hash}
+	template := `SELECT user_id, csrf_token, created_at, last_seen, expires_at FROM sessions WHERE session_hash = ?`
+
+	if err := self.query(ctx, template, hash).Scan(&s.UserId, &s.CSRFToken, &s.CreatedAt, &s.LastSeen, &s.ExpiresAt); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (self *CassClient) DeleteSession(ctx context.Context, hash []byte) *UpsertResult {
+	return &UpsertResult{
+		Batch: nil,
+		Err:   self.query(ctx, `DELETE FROM sessions WHERE session_hash = ?`, hash).Exec(),
+	}
+}
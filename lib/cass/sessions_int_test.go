@@ -0,0 +1,54 @@
+package cass
+
+import (
+	"context"
+	"github.com/gocql/gocql"
+	"testing"
+	"time"
+)
+
+func TestSessions(t *testing.T) {
+	client := createLocalhostClient("bkn")
+	defer client.Sess.Close()
+
+	uuid, _ := gocql.ParseUUID(prepopId)
+	s := &Session{
+		Hash:      gocql.TimeUUID().Bytes(),
+		UserId:    &uuid,
+		CSRFToken: "csrf",
+		CreatedAt: time.Now(),
+		LastSeen:  time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+
+	if res := client.PutSession(context.Background(), s, time.Minute); res.Err != nil {
+		t.Error("failed to create session:", res.Err)
+		return
+	}
+
+	fetched, err := client.FetchSession(context.Background(), s.Hash)
+	if err != nil || fetched.UserId.String() != prepopId || fetched.CSRFToken != "csrf" {
+		t.Error("failed to fetch session:", err)
+	}
+
+	s.LastSeen = time.Now()
+	if res := client.TouchSession(context.Background(), s, time.Minute); res.Err != nil {
+		t.Error("failed to touch session:", res.Err)
+	}
+
+	if res := client.DeleteSession(context.Background(), s.Hash); res.Err != nil {
+		t.Error("failed to delete session:", res.Err)
+	}
+
+	if _, err := client.FetchSession(context.Background(), s.Hash); err != gocql.ErrNotFound {
+		t.Error("expected deleted session, got:", err)
+	}
+
+	// touching a deleted session mustn't recreate it
+	if res := client.TouchSession(context.Background(), s, time.Minute); res.Err != gocql.ErrNotFound {
+		t.Error("expected touching a deleted session to fail, got:", res.Err)
+	}
+	if _, err := client.FetchSession(context.Background(), s.Hash); err != gocql.ErrNotFound {
+		t.Error("expected the session to stay deleted, got:", err)
+	}
+}
@@ -0,0 +1,96 @@
+// Cassandra lib
+package cass
+
+import (
+	"context"
+	"errors"
+	"github.com/gocql/gocql"
+	"time"
+)
+
+var (
+	// ErrTokenConsumed signals a refresh token was used concurrently or after rotation
+	ErrTokenConsumed = errors.New("refresh token already consumed")
+)
+
+// RefreshToken is only ever stored by the hash of its secret value
+type RefreshToken struct {
+	Hash      []byte
+	UserId    *gocql.UUID
+	ExpiresAt time.Time
+}
+
+// CreateRefreshToken stores a refresh token until its expiry
+func (self *CassClient) CreateRefreshToken(ctx context.Context, tok *RefreshToken) *UpsertResult {
+	template := `INSERT INTO refresh_tokens (token_hash, user_id, created_at, expires_at) VALUES (?, ?, ?, ?) USING TTL ?`
+	args := []interface{}{
+		tok.Hash,
+		tok.UserId,
+		time.Now(),
+		tok.ExpiresAt,
+		ttlSeconds(tok.ExpiresAt),
+	}
+
+	return &UpsertResult{
+		Batch: nil,
+		Err:   self.query(ctx, template, args...).Exec(),
+	}
+}
+
+// ConsumeRefreshToken fetches & deletes a refresh token in one step, so that each token may be exchanged exactly once.
+func (self *CassClient) ConsumeRefreshToken(ctx context.Context, hash []byte) (*RefreshToken, error) {
+	tok := &RefreshToken{Hash: hash}
+	template := `SELECT user_id, expires_at FROM refresh_tokens WHERE token_hash = ?`
+	if err := self.query(ctx, template, hash).Scan(&tok.UserId, &tok.ExpiresAt); err != nil {
+		return nil, err
+	}
+
+	// the conditional delete guarantees a single winner between concurrent exchanges of the same token
+	applied, err := self.query(ctx, `DELETE FROM refresh_tokens WHERE token_hash = ? IF EXISTS`, hash).MapScanCAS(map[string]interface{}{})
+	if err != nil {
+		return nil, err
+	}
+	if !applied {
+		return nil, ErrTokenConsumed
+	}
+
+	return tok, nil
+}
+
+// RevokeToken records an access token's jti as revoked until the token would have expired anyway
+func (self *CassClient) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) *UpsertResult {
+	template := `INSERT INTO revoked_tokens (jti, revoked_at) VALUES (?, ?) USING TTL ?`
+	args := []interface{}{
+		jti,
+		time.Now(),
+		ttlSeconds(expiresAt),
+	}
+
+	return &UpsertResult{
+		Batch: nil,
+		Err:   self.query(ctx, template, args...).Exec(),
+	}
+}
+
+// IsRevoked fulfills the jwt.RevocationList interface
+func (self *CassClient) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	var revokedAt time.Time
+	err := self.query(ctx, `SELECT revoked_at FROM revoked_tokens WHERE jti = ?`, jti).Scan(&revokedAt)
+
+	if err == gocql.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ttlSeconds converts an expiry into a cassandra TTL, which must be positive
+func ttlSeconds(expiresAt time.Time) int {
+	ttl := int(time.Until(expiresAt).Seconds()) + 1
+	if ttl < 1 {
+		return 1
+	}
+	return ttl
+}
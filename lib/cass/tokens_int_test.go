@@ -0,0 +1,53 @@
+package cass
+
+import (
+	"context"
+	"github.com/gocql/gocql"
+	"testing"
+	"time"
+)
+
+func TestRefreshTokens(t *testing.T) {
+	client := createLocalhostClient("bkn")
+	defer client.Sess.Close()
+
+	uuid, _ := gocql.ParseUUID(prepopId)
+	tok := &RefreshToken{
+		Hash:      gocql.TimeUUID().Bytes(),
+		UserId:    &uuid,
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+
+	if res := client.CreateRefreshToken(context.Background(), tok); res.Err != nil {
+		t.Error("failed to create refresh token:", res.Err)
+		return
+	}
+
+	consumed, err := client.ConsumeRefreshToken(context.Background(), tok.Hash)
+	if err != nil || consumed.UserId.String() != prepopId {
+		t.Error("failed to consume refresh token:", err)
+	}
+
+	if _, err := client.ConsumeRefreshToken(context.Background(), tok.Hash); err == nil {
+		t.Error("refresh token was consumed twice")
+	}
+}
+
+func TestRevokeToken(t *testing.T) {
+	client := createLocalhostClient("bkn")
+	defer client.Sess.Close()
+
+	jti := gocql.TimeUUID().String()
+
+	if revoked, err := client.IsRevoked(context.Background(), jti); err != nil || revoked {
+		t.Error("expected unrevoked token:", err)
+	}
+
+	if res := client.RevokeToken(context.Background(), jti, time.Now().Add(time.Minute)); res.Err != nil {
+		t.Error("failed to revoke token:", res.Err)
+	}
+
+	if revoked, err := client.IsRevoked(context.Background(), jti); err != nil || !revoked {
+		t.Error("expected revoked token:", err)
+	}
+}
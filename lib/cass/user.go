@@ -2,43 +2,49 @@
 package cass
 
 import (
+	"context"
 	"errors"
 	"github.com/gocql/gocql"
 	"github.com/google/uuid"
+	"log/slog"
+	"strings"
 	"time"
 )
 
-type providerId uint8
+// ProviderId identifies a login provider. Ids are stable & must never be reused, as user ids derive from them.
+type ProviderId uint8
 
-func (self providerId) ToUUID() [16]byte {
+func (self ProviderId) ToUUID() [16]byte {
 	var selfAsUint uint8 = uint8(self)
 	return uuid.NewSHA1(uuid.Nil, []uint8{selfAsUint})
 }
 
-func (self providerId) Unwrap() uint8 {
+func (self ProviderId) Unwrap() uint8 {
 	return uint8(self)
 }
 
-func (self providerId) UUIDFromBytes(data []byte) [16]byte {
+func (self ProviderId) UUIDFromBytes(data []byte) [16]byte {
 	return uuid.NewSHA1(self.ToUUID(), data)
 }
 
 var (
-	Google providerId = 1
+	Google ProviderId = 1
 )
 
 type User struct {
-	Id               *gocql.UUID `cql:"id" json:"id"`
-	Email            string      `cql:"email" json:"email"`
-	CreatedAt        time.Time   `cql:"created_at" json:"-"`
-	UpdatedAt        time.Time   `cql:"updated_at" json:"-"`
-	ProviderId       uint8       `cql:"provider_id" json:"-"`
-	GivenName        string      `cql:"given_name" json:"given_name"`
-	FamilyName       string      `cql:"family_name" json:"family_name"`
-	PublicPictureUrl string      `cql:"public_picture_url json:"public_picture_url"`
+	Id    *gocql.UUID `cql:"id" json:"id"`
+	Email string      `cql:"email" json:"email"`
+	// EmailVerified is set once a provider has vouched for Email. Only verified emails are trusted, i.e. to accept invitations.
+	EmailVerified    bool      `cql:"email_verified" json:"email_verified"`
+	CreatedAt        time.Time `cql:"created_at" json:"-"`
+	UpdatedAt        time.Time `cql:"updated_at" json:"-"`
+	ProviderId       uint8     `cql:"provider_id" json:"-"`
+	GivenName        string    `cql:"given_name" json:"given_name"`
+	FamilyName       string    `cql:"family_name" json:"family_name"`
+	PublicPictureUrl string    `cql:"public_picture_url" json:"public_picture_url"`
 }
 
-func (self *CassClient) CreateUser(u *User, provider providerId, providerKey []byte, batch *gocql.Batch) *UpsertResult {
+func (self *CassClient) CreateUser(ctx context.Context, u *User, provider ProviderId, providerKey []byte, batch *gocql.Batch) *UpsertResult {
 
 	uuidBytes := provider.UUIDFromBytes(providerKey)
 	uuid, uuidErr := gocql.UUIDFromBytes((&uuidBytes)[:])
@@ -57,12 +63,13 @@ func (self *CassClient) CreateUser(u *User, provider providerId, providerKey []b
 		}
 	}
 
-	template := `INSERT INTO users (id, provider_id, email, given_name, family_name, public_picture_url, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`
+	template := `INSERT INTO users (id, provider_id, email, email_verified, given_name, family_name, public_picture_url, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
 	args := []interface{}{
 		&uuid,
 		// unwrap yields provider's id
 		provider.Unwrap(),
 		u.Email,
+		u.EmailVerified,
 		u.GivenName,
 		u.FamilyName,
 		u.PublicPictureUrl,
@@ -75,20 +82,145 @@ func (self *CassClient) CreateUser(u *User, provider providerId, providerKey []b
 	} else {
 		return &UpsertResult{
 			Batch: nil,
-			Err:   self.Sess.Query(template, args...).Exec(),
+			Err:   self.query(ctx, template, args...).Exec(),
 		}
 	}
 
 }
 
-func (self *CassClient) FetchUser(u *User) (*User, error) {
+// Identity links a provider's subject to a user, allowing one user to sign in through several providers
+type Identity struct {
+	ProviderId ProviderId  `cql:"provider_id" json:"provider_id"`
+	Subject    string      `cql:"subject" json:"subject"`
+	UserId     *gocql.UUID `cql:"user_id" json:"-"`
+	LinkedAt   time.Time   `cql:"linked_at" json:"linked_at"`
+}
+
+// FetchIdentity finds the user linked to a provider's subject, or gocql.ErrNotFound
+func (self *CassClient) FetchIdentity(ctx context.Context, provider ProviderId, subject string) (*Identity, error) {
+	ident := &Identity{ProviderId: provider, Subject: subject}
+	template := `SELECT user_id, linked_at FROM user_identities WHERE provider_id = ? AND subject = ?`
+	if err := self.query(ctx, template, provider.Unwrap(), subject).Scan(&ident.UserId, &ident.LinkedAt); err != nil {
+		return nil, err
+	}
+	return ident, nil
+}
+
+// LinkIdentity links an identity to a user unless it already belongs to one, in which case the existing identity is returned
+func (self *CassClient) LinkIdentity(ctx context.Context, ident *Identity) (*Identity, error) {
+	template := `INSERT INTO user_identities (provider_id, subject, user_id, linked_at) VALUES (?, ?, ?, ?) IF NOT EXISTS`
+	existing := map[string]interface{}{}
+	applied, err := self.query(ctx, template, ident.ProviderId.Unwrap(), ident.Subject, ident.UserId, ident.LinkedAt).MapScanCAS(existing)
+	if err != nil {
+		return nil, err
+	}
+
+	if applied {
+		// an undercount only refuses unlinks, so linking succeeds regardless
+		if err := self.adjustIdentityCount(ctx, ident.UserId, 1, 0); err != nil {
+			slog.ErrorContext(ctx, "cass: failed to count identity", "user_id", ident.UserId.String(), "error", err)
+		}
+		return ident, nil
+	}
+
+	userId := existing["user_id"].(gocql.UUID)
+	return &Identity{
+		ProviderId: ident.ProviderId,
+		Subject:    ident.Subject,
+		UserId:     &userId,
+		LinkedAt:   existing["linked_at"].(time.Time),
+	}, nil
+}
+
+// UnlinkIdentity removes a user's identity, returning ErrConflict if it's their last or gocql.ErrNotFound if it belongs to someone else.
+// linked is the number of identities the caller found, counting for users whose identity_count was never set.
+func (self *CassClient) UnlinkIdentity(ctx context.Context, ident *Identity, linked int) *UpsertResult {
+	if err := self.adjustIdentityCount(ctx, ident.UserId, -1, linked); err != nil {
+		return &UpsertResult{Batch: nil, Err: err}
+	}
+
+	template := `DELETE FROM user_identities WHERE provider_id = ? AND subject = ? IF user_id = ?`
+	applied, err := self.query(ctx, template, ident.ProviderId.Unwrap(), ident.Subject, ident.UserId).MapScanCAS(map[string]interface{}{})
+	if err == nil && !applied {
+		err = gocql.ErrNotFound
+	}
+
+	// nothing was unlinked, so hand the identity back to the count
+	if err != nil {
+		if restoreErr := self.adjustIdentityCount(context.WithoutCancel(ctx), ident.UserId, 1, 0); restoreErr != nil {
+			slog.ErrorContext(ctx, "cass: failed to restore identity count", "user_id", ident.UserId.String(), "error", restoreErr)
+		}
+	}
+
+	return &UpsertResult{Batch: nil, Err: err}
+}
+
+// adjustIdentityCount changes a user's identity_count by delta via a conditional update, so concurrent unlinks can't remove every identity.
+// An unset count is taken from fallback when decrementing, returning ErrConflict rather than dropping below one. Increments leave it unset.
+func (self *CassClient) adjustIdentityCount(ctx context.Context, userId *gocql.UUID, delta int, fallback int) error {
+	for attempt := 0; attempt < casAttempts; attempt++ {
+		var current *int
+		if err := self.query(ctx, `SELECT identity_count FROM users WHERE id = ?`, userId).Scan(&current); err != nil && err != gocql.ErrNotFound {
+			return err
+		}
+
+		count := fallback
+		if current != nil {
+			count = *current
+		} else if delta > 0 {
+			return nil
+		}
+
+		if count+delta < 1 {
+			return ErrConflict
+		}
+
+		template := `UPDATE users SET identity_count = ? WHERE id = ? IF identity_count = ?`
+		applied, err := self.query(ctx, template, count+delta, userId, current).MapScanCAS(map[string]interface{}{})
+		if err != nil {
+			return err
+		}
+		if applied {
+			return nil
+		}
+	}
+
+	return ErrConflict
+}
+
+// FetchUserIdentities lists the identities a user may sign in with
+func (self *CassClient) FetchUserIdentities(ctx context.Context, userId *gocql.UUID) ([]*Identity, error) {
+	template := `SELECT provider_id, subject, user_id, linked_at FROM user_identities_by_user WHERE user_id = ?`
+
+	resRows := make([]*Identity, 0)
+	iter := self.query(ctx, template, userId).Iter()
+	shell := map[string]interface{}{}
+	for iter.MapScan(shell) {
+		id := shell["user_id"].(gocql.UUID)
+		resRows = append(resRows, &Identity{
+			ProviderId: ProviderId(shell["provider_id"].(int8)),
+			Subject:    shell["subject"].(string),
+			UserId:     &id,
+			LinkedAt:   shell["linked_at"].(time.Time),
+		})
+
+		shell = map[string]interface{}{}
+	}
+
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return resRows, nil
+}
+
+func (self *CassClient) FetchUser(ctx context.Context, u *User) (*User, error) {
 	// instantiate user struct for unmarshalling
 	matchedUser := &User{}
 	var err error
 	if u.Id != nil {
-		err = self.Sess.Query(`SELECT id, email FROM users WHERE id = ?`, u.Id).Scan(&matchedUser.Id, &matchedUser.Email)
+		err = self.query(ctx, `SELECT id, email, email_verified, given_name, family_name, public_picture_url FROM users WHERE id = ?`, u.Id).Scan(&matchedUser.Id, &matchedUser.Email, &matchedUser.EmailVerified, &matchedUser.GivenName, &matchedUser.FamilyName, &matchedUser.PublicPictureUrl)
 	} else {
-		err = self.Sess.Query(`SELECT id, email FROM users_by_email WHERE email = ?`, u.Email).Scan(&matchedUser.Id, &matchedUser.Email)
+		err = self.query(ctx, `SELECT id, email, email_verified, given_name, family_name, public_picture_url FROM users_by_email WHERE email = ?`, u.Email).Scan(&matchedUser.Id, &matchedUser.Email, &matchedUser.EmailVerified, &matchedUser.GivenName, &matchedUser.FamilyName, &matchedUser.PublicPictureUrl)
 	}
 
 	if err != nil {
@@ -101,3 +233,169 @@ func (self *CassClient) FetchUser(u *User) (*User, error) {
 		return nil, errors.New("no matched user")
 	}
 }
+
+// UpdateUser overwrites a user's profile (names & picture)
+func (self *CassClient) UpdateUser(ctx context.Context, u *User) *UpsertResult {
+	template := `UPDATE users SET given_name = ?, family_name = ?, public_picture_url = ?, updated_at = ? WHERE id = ? IF EXISTS`
+	applied, err := self.query(ctx, template, u.GivenName, u.FamilyName, u.PublicPictureUrl, time.Now(), u.Id).MapScanCAS(map[string]interface{}{})
+	if err == nil && !applied {
+		err = gocql.ErrNotFound
+	}
+
+	return &UpsertResult{Batch: nil, Err: err}
+}
+
+// VerifyEmail replaces a user's email with one their provider has verified
+func (self *CassClient) VerifyEmail(ctx context.Context, userId *gocql.UUID, email string) *UpsertResult {
+	template := `UPDATE users SET email = ?, email_verified = true, updated_at = ? WHERE id = ? IF EXISTS`
+	applied, err := self.query(ctx, template, email, time.Now(), userId).MapScanCAS(map[string]interface{}{})
+	if err == nil && !applied {
+		err = gocql.ErrNotFound
+	}
+
+	return &UpsertResult{Batch: nil, Err: err}
+}
+
+type statement struct {
+	template string
+	args     []interface{}
+}
+
+// DeleteUser purges a user along with everything they own: beacons, messages, deployments, analytics, credentials, idempotency keys,
+// memberships & the orgs they alone belong to.
+// Statements run one at a time rather than as one oversized batch. Every step is idempotent, so a failed purge may be retried.
+// Conditional statements that don't apply are skipped.
+func (self *CassClient) DeleteUser(ctx context.Context, userId *gocql.UUID) *UpsertResult {
+	stmts, err := self.userPurgeStatements(ctx, userId)
+	if err != nil {
+		return &UpsertResult{Batch: nil, Err: err}
+	}
+
+	for _, stmt := range stmts {
+		if err := self.query(ctx, stmt.template, stmt.args...).Exec(); err != nil {
+			return &UpsertResult{Batch: nil, Err: err}
+		}
+	}
+
+	return &UpsertResult{Batch: nil, Err: nil}
+}
+
+func (self *CassClient) userPurgeStatements(ctx context.Context, userId *gocql.UUID) ([]*statement, error) {
+	stmts := make([]*statement, 0)
+
+	// orgs the user alone belongs to go with them. They're found through the user's membership, so are purged before it.
+	orgIds, orgsErr := self.FetchSoleOrgs(ctx, userId)
+	if orgsErr != nil {
+		return nil, orgsErr
+	}
+	for _, orgId := range orgIds {
+		refs, partitions, err := self.ownerPurgeStatements(ctx, orgId)
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(append(stmts, refs...), partitions...)
+		stmts = append(stmts, &statement{`DELETE FROM orgs WHERE id = ?`, []interface{}{orgId}})
+	}
+
+	refs, partitions, err := self.ownerPurgeStatements(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	stmts = append(stmts, refs...)
+
+	// credentials, memberships & idempotency keys are found through their by_user views
+	lookups := []struct {
+		view  string
+		table string
+		keys  []string
+	}{
+		{"api_keys_by_user", "api_keys", []string{"key_hash"}},
+		{"refresh_tokens_by_user", "refresh_tokens", []string{"token_hash"}},
+		{"sessions_by_user", "sessions", []string{"session_hash"}},
+		{"user_identities_by_user", "user_identities", []string{"provider_id", "subject"}},
+		{"org_members_by_user", "org_members", []string{"org_id", "user_id"}},
+		{"idempotency_keys_by_user", "idempotency_keys", []string{"user_id", "idempotency_key"}},
+	}
+	for _, lookup := range lookups {
+		rows, err := self.fetchUserRows(ctx, lookup.view, lookup.keys, userId)
+		if err != nil {
+			return nil, err
+		}
+
+		template := `DELETE FROM ` + lookup.table + ` WHERE ` + strings.Join(lookup.keys, " = ? AND ") + ` = ?`
+		for _, row := range rows {
+			stmts = append(stmts, &statement{template, row})
+		}
+	}
+
+	// partitions owned by the user go last, so a retried purge can still find what they referenced
+	stmts = append(stmts, partitions...)
+	stmts = append(stmts, &statement{`DELETE FROM users WHERE id = ?`, []interface{}{userId}})
+
+	return stmts, nil
+}
+
+// ownerPurgeStatements deletes everything owned by a user or org: first the rows referencing its beacons & deployments,
+// then its beacons, messages & deployments partitions, which must outlive the references for a retried purge to find them.
+func (self *CassClient) ownerPurgeStatements(ctx context.Context, ownerId *gocql.UUID) ([]*statement, []*statement, error) {
+	refs := make([]*statement, 0)
+
+	beacons, beaconsErr := self.FetchUserBeacons(ctx, ownerId)
+	if beaconsErr != nil {
+		return nil, nil, beaconsErr
+	}
+	deps, depsErr := self.FetchDeploymentsMetadata(ctx, ownerId)
+	if depsErr != nil {
+		return nil, nil, depsErr
+	}
+
+	// analytics are partitioned by deployment, which may be named by beacons without metadata
+	deployNames := map[string]bool{}
+	for _, bkn := range beacons {
+		if bkn.DeployName != "" {
+			deployNames[bkn.DeployName] = true
+		}
+		// conditional, as the link may have passed to another user along with its beacon
+		refs = append(refs, &statement{`DELETE FROM beacon_links WHERE short_name = ? IF user_id = ?`, []interface{}{ShortName(bkn.Name), ownerId}})
+	}
+	for _, dep := range deps {
+		deployNames[dep.DeployName] = true
+	}
+	for name := range deployNames {
+		for _, table := range []string{"passerby", "interactions"} {
+			refs = append(refs, &statement{`DELETE FROM ` + table + ` WHERE bkn_user_id = ? AND deploy_name = ?`, []interface{}{ownerId, name}})
+		}
+		refs = append(refs, &statement{`DELETE FROM scheduled_deployments WHERE user_id = ? AND deploy_name = ?`, []interface{}{ownerId, name}})
+	}
+
+	partitions := make([]*statement, 0)
+	for _, table := range []string{"beacons", "messages", "deployments_metadata"} {
+		partitions = append(partitions, &statement{`DELETE FROM ` + table + ` WHERE user_id = ?`, []interface{}{ownerId}})
+	}
+
+	return refs, partitions, nil
+}
+
+// fetchUserRows selects the given columns from a view partitioned by user_id
+func (self *CassClient) fetchUserRows(ctx context.Context, view string, columns []string, userId *gocql.UUID) ([][]interface{}, error) {
+	template := `SELECT ` + strings.Join(columns, ", ") + ` FROM ` + view + ` WHERE user_id = ?`
+
+	resRows := make([][]interface{}, 0)
+	iter := self.query(ctx, template, userId).Iter()
+	shell := map[string]interface{}{}
+	for iter.MapScan(shell) {
+		row := make([]interface{}, 0, len(columns))
+		for _, col := range columns {
+			row = append(row, shell[col])
+		}
+		resRows = append(resRows, row)
+
+		// since shell is used in each iteration, we must clear it.
+		shell = map[string]interface{}{}
+	}
+
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return resRows, nil
+}
@@ -1,9 +1,11 @@
 package cass
 
 import (
+	"context"
 	"github.com/gocql/gocql"
 	"math/rand"
 	"testing"
+	"time"
 )
 
 func randToken() []byte {
@@ -21,7 +23,7 @@ func TestCreateUser(t *testing.T) {
 		newUser := User{
 			Email: "newEmail@provider.com",
 		}
-		res := client.CreateUser(&newUser, Google, randToken(), nil)
+		res := client.CreateUser(context.Background(), &newUser, Google, randToken(), nil)
 		if res.Err != nil {
 			t.Fail()
 		}
@@ -31,7 +33,7 @@ func TestCreateUser(t *testing.T) {
 			Email: "newEmail-batch@provider.com",
 		}
 		batch := gocql.NewBatch(gocql.LoggedBatch)
-		res := client.CreateUser(&newUser, Google, randToken(), batch)
+		res := client.CreateUser(context.Background(), &newUser, Google, randToken(), batch)
 
 		// check size
 		if res.Batch.Size() != 1 {
@@ -60,7 +62,7 @@ func TestFetchUser(t *testing.T) {
 			Id: &uuid,
 		}
 
-		foundUser, fetchErr := client.FetchUser(&user)
+		foundUser, fetchErr := client.FetchUser(context.Background(), &user)
 
 		if fetchErr != nil {
 			t.Error("failed fetching user", fetchErr)
@@ -79,7 +81,7 @@ func TestFetchUser(t *testing.T) {
 			Email: prepopEmail,
 		}
 
-		foundUser, fetchErr := client.FetchUser(&user)
+		foundUser, fetchErr := client.FetchUser(context.Background(), &user)
 
 		if fetchErr != nil {
 			t.Error("failed fetching user", fetchErr)
@@ -92,3 +94,139 @@ func TestFetchUser(t *testing.T) {
 		}
 	})
 }
+
+func TestVerifyEmail(t *testing.T) {
+	client := createLocalhostClient("bkn")
+	defer client.Sess.Close()
+
+	u := &User{}
+	if res := client.CreateUser(context.Background(), u, Google, randToken(), nil); res.Err != nil {
+		t.Error("failed to create user:", res.Err)
+		return
+	}
+
+	if res := client.VerifyEmail(context.Background(), u.Id, "verified@provider.com"); res.Err != nil {
+		t.Error("failed to verify email:", res.Err)
+		return
+	}
+
+	found, err := client.FetchUser(context.Background(), &User{Id: u.Id})
+	if err != nil || found.Email != "verified@provider.com" || !found.EmailVerified {
+		t.Error("email was not verified:", err)
+	}
+
+	missing := gocql.TimeUUID()
+	if res := client.VerifyEmail(context.Background(), &missing, "verified@provider.com"); res.Err != gocql.ErrNotFound {
+		t.Error("expected ErrNotFound for a missing user, got", res.Err)
+	}
+}
+
+func TestIdentities(t *testing.T) {
+	client := createLocalhostClient("bkn")
+	defer client.Sess.Close()
+
+	userId, _ := gocql.ParseUUID(prepopId)
+	ident := &Identity{
+		ProviderId: Google,
+		Subject:    gocql.TimeUUID().String(),
+		UserId:     &userId,
+		LinkedAt:   time.Now(),
+	}
+
+	if _, err := client.LinkIdentity(context.Background(), ident); err != nil {
+		t.Error("failed to link identity:", err)
+		return
+	}
+
+	t.Run("fetch", func(t *testing.T) {
+		fetched, err := client.FetchIdentity(context.Background(), Google, ident.Subject)
+		if err != nil || fetched.UserId.String() != prepopId {
+			t.Error("failed to fetch identity:", err)
+		}
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		other := gocql.TimeUUID()
+		linked, err := client.LinkIdentity(context.Background(), &Identity{ProviderId: Google, Subject: ident.Subject, UserId: &other, LinkedAt: time.Now()})
+		if err != nil || linked.UserId.String() != prepopId {
+			t.Error("identity was relinked to another user:", err)
+		}
+	})
+
+	t.Run("unlink", func(t *testing.T) {
+		if res := client.UnlinkIdentity(context.Background(), ident, 2); res.Err != nil {
+			t.Error("failed to unlink identity:", res.Err)
+		}
+		// the count now stands at one
+		if res := client.UnlinkIdentity(context.Background(), ident, 2); res.Err != ErrConflict {
+			t.Error("expected the last identity to be kept, got", res.Err)
+		}
+	})
+}
+
+func TestUpdateAndDeleteUser(t *testing.T) {
+	client := createLocalhostClient("bkn")
+	defer client.Sess.Close()
+
+	u := &User{Email: "deleted@provider.com"}
+	if res := client.CreateUser(context.Background(), u, Google, randToken(), nil); res.Err != nil {
+		t.Fatal("failed to create user:", res.Err)
+	}
+
+	u.GivenName = "Given"
+	if res := client.UpdateUser(context.Background(), u); res.Err != nil {
+		t.Error("failed to update user:", res.Err)
+	}
+
+	fetched, err := client.FetchUser(context.Background(), &User{Id: u.Id})
+	if err != nil || fetched.GivenName != "Given" {
+		t.Error("expected updated profile:", err)
+	}
+
+	// more beacons than fit in a page
+	for i := 0; i < DefaultLimit+10; i += 50 {
+		batch := make([]*Beacon, 0, 50)
+		for j := i; j < i+50; j++ {
+			batch = append(batch, &Beacon{UserId: u.Id, Name: gocql.TimeUUID().Bytes()})
+		}
+		if res := client.CreateBeacons(context.Background(), batch, nil); res.Err != nil {
+			t.Fatal("failed to create beacons:", res.Err)
+		}
+	}
+	if beacons, err := client.FetchUserBeacons(context.Background(), u.Id); err != nil || len(beacons) <= DefaultLimit {
+		t.Errorf("expected every beacon across pages, got %d, %v", len(beacons), err)
+	}
+
+	// an org the user alone belongs to goes with them
+	orgId := gocql.TimeUUID()
+	if res := client.CreateOrg(context.Background(), &Org{Id: &orgId, Name: "sole"}, &Member{OrgId: &orgId, UserId: u.Id, Role: "owner"}); res.Err != nil {
+		t.Fatal("failed to create org:", res.Err)
+	}
+
+	key := &IdempotencyRecord{UserId: u.Id, Key: gocql.TimeUUID().String(), RequestHash: []byte{1}}
+	if _, claimed, err := client.ClaimIdempotencyKey(context.Background(), key, time.Minute); err != nil || !claimed {
+		t.Fatal("failed to claim idempotency key:", err)
+	}
+
+	if res := client.DeleteUser(context.Background(), u.Id); res.Err != nil {
+		t.Error("failed to delete user:", res.Err)
+	}
+
+	if beacons, err := client.FetchUserBeacons(context.Background(), u.Id); err != nil || len(beacons) != 0 {
+		t.Errorf("expected every beacon purged, got %d, %v", len(beacons), err)
+	}
+	if _, err := client.FetchOrg(context.Background(), &orgId); err != gocql.ErrNotFound {
+		t.Error("expected the sole member's org purged, got:", err)
+	}
+	if _, claimed, err := client.ClaimIdempotencyKey(context.Background(), key, time.Minute); err != nil || !claimed {
+		t.Error("expected the idempotency key purged, got:", err)
+	}
+
+	if _, err := client.FetchUser(context.Background(), &User{Id: u.Id}); err != gocql.ErrNotFound {
+		t.Error("expected deleted user, got:", err)
+	}
+
+	if res := client.UpdateUser(context.Background(), u); res.Err != gocql.ErrNotFound {
+		t.Error("expected updating a deleted user to fail, got:", res.Err)
+	}
+}
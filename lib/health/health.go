@@ -0,0 +1,118 @@
+// Package health serves liveness & readiness probes
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultTimeout bounds all of a readiness probe's checks, staying under kubernetes' default probe timeout
+const DefaultTimeout = 800 * time.Millisecond
+
+// Check reports whether a dependency is usable
+type Check func(ctx context.Context) error
+
+// Checker reports the process live as long as it serves, & ready while its dependencies are reachable & it isn't draining
+type Checker struct {
+	Checks   map[string]Check
+	Timeout  time.Duration
+	draining int32
+}
+
+// Status is the body of a readiness probe, naming each failing check
+type Status struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Drain fails readiness from now on, so load balancers stop routing requests here before shutdown
+func (self *Checker) Drain() {
+	atomic.StoreInt32(&self.draining, 1)
+}
+
+func (self *Checker) Draining() bool {
+	return atomic.LoadInt32(&self.draining) == 1
+}
+
+// Live serves /healthz. It never checks dependencies, as restarting won't fix them.
+func (self *Checker) Live(rw http.ResponseWriter, r *http.Request) {
+	flush(rw, http.StatusOK, &Status{Status: "ok"})
+}
+
+// Ready serves /readyz, running every check concurrently
+func (self *Checker) Ready(rw http.ResponseWriter, r *http.Request) {
+	if self.Draining() {
+		flush(rw, http.StatusServiceUnavailable, &Status{Status: "draining"})
+		return
+	}
+
+	failures := self.Run(r.Context())
+	if len(failures) > 0 {
+		flush(rw, http.StatusServiceUnavailable, &Status{Status: "unavailable", Checks: failures})
+		return
+	}
+	flush(rw, http.StatusOK, &Status{Status: "ok"})
+}
+
+// Run returns the checks which failed. Details are logged rather than returned, as probes are public.
+func (self *Checker) Run(ctx context.Context) map[string]string {
+	timeout := self.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	names := make([]string, 0, len(self.Checks))
+	for name := range self.Checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var mtx sync.Mutex
+	var wg sync.WaitGroup
+	failures := map[string]string{}
+
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+
+			// a hung check is abandoned once the deadline passes, so probes always answer in time
+			errCh := make(chan error, 1)
+			go func() { errCh <- check(ctx) }()
+
+			var err error
+			select {
+			case err = <-errCh:
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+			if err == nil {
+				return
+			}
+
+			slog.WarnContext(ctx, "health: check failed", "check", name, "error", err)
+			mtx.Lock()
+			failures[name] = "failing"
+			mtx.Unlock()
+		}(name, self.Checks[name])
+	}
+
+	wg.Wait()
+	return failures
+}
+
+func flush(rw http.ResponseWriter, status int, body *Status) {
+	data, _ := json.Marshal(body)
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.WriteHeader(status)
+	rw.Write(data)
+}
@@ -0,0 +1,72 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReady(t *testing.T) {
+	ok := func(ctx context.Context) error { return nil }
+	failing := func(ctx context.Context) error { return errors.New("connection refused") }
+	hung := func(ctx context.Context) error { select {} }
+
+	cases := []struct {
+		checks   map[string]Check
+		status   int
+		failures map[string]string
+	}{
+		{map[string]Check{"a": ok, "b": ok}, http.StatusOK, nil},
+		{map[string]Check{"a": ok, "b": failing}, http.StatusServiceUnavailable, map[string]string{"b": "failing"}},
+		{map[string]Check{"a": hung}, http.StatusServiceUnavailable, map[string]string{"a": "failing"}},
+	}
+
+	for i, c := range cases {
+		checker := &Checker{Checks: c.checks, Timeout: 10 * time.Millisecond}
+		rw := httptest.NewRecorder()
+		checker.Ready(rw, httptest.NewRequest("GET", "/readyz", nil))
+
+		if rw.Code != c.status {
+			t.Errorf("case %d: expected %d, got %d", i, c.status, rw.Code)
+		}
+
+		res := &Status{}
+		json.Unmarshal(rw.Body.Bytes(), res)
+		if len(res.Checks) != len(c.failures) {
+			t.Errorf("case %d: expected failures %v, got %v", i, c.failures, res.Checks)
+		}
+		for name := range c.failures {
+			if res.Checks[name] != c.failures[name] {
+				t.Errorf("case %d: expected %s to fail, got %v", i, name, res.Checks)
+			}
+		}
+	}
+}
+
+func TestDrain(t *testing.T) {
+	checker := &Checker{}
+
+	rw := httptest.NewRecorder()
+	checker.Ready(rw, httptest.NewRequest("GET", "/readyz", nil))
+	if rw.Code != http.StatusOK {
+		t.Error("expected a checker without checks to be ready, got", rw.Code)
+	}
+
+	checker.Drain()
+
+	rw = httptest.NewRecorder()
+	checker.Ready(rw, httptest.NewRequest("GET", "/readyz", nil))
+	if rw.Code != http.StatusServiceUnavailable {
+		t.Error("expected a draining checker to be unready, got", rw.Code)
+	}
+
+	rw = httptest.NewRecorder()
+	checker.Live(rw, httptest.NewRequest("GET", "/healthz", nil))
+	if rw.Code != http.StatusOK {
+		t.Error("expected a draining checker to stay live, got", rw.Code)
+	}
+}
@@ -0,0 +1,179 @@
+// Package idempotency replays the response of a request retried with the same Idempotency-Key, rather than running it again
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"github.com/gocql/gocql"
+	"github.com/owen-d/beacon-api/lib/apierr"
+	"github.com/owen-d/beacon-api/lib/auth/jwt"
+	"github.com/owen-d/beacon-api/lib/cass"
+	"github.com/owen-d/beacon-api/lib/validator"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	Header = "Idempotency-Key"
+	// ReplayedHeader marks a response served from a previous request
+	ReplayedHeader = "Idempotent-Replayed"
+	DefaultTTL     = 24 * time.Hour
+	// a claim outlives any request, but lets a key be retried soon after its request crashed
+	claimTTL     = 5 * time.Minute
+	maxKeyLength = 255
+	// larger responses aren't stored, releasing their key instead
+	maxStoredBody = 1 << 20
+)
+
+// Keys stores the responses of requests made with an idempotency key, per user, for TTL
+type Keys struct {
+	CassClient cass.Client
+	TTL        time.Duration
+}
+
+func New(cassClient cass.Client, ttl time.Duration) *Keys {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &Keys{CassClient: cassClient, TTL: ttl}
+}
+
+// Middleware must run after authentication, as keys are scoped to the user. Requests without a key, or a user, run as usual.
+// A nil Keys runs every request.
+func (self *Keys) Middleware(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	key := r.Header.Get(Header)
+	bindings, _ := r.Context().Value(jwt.JWTNamespace).(*jwt.Bindings)
+	if self == nil || key == "" || bindings == nil || bindings.UserId == nil {
+		next(rw, r)
+		return
+	}
+
+	if len(key) > maxKeyLength {
+		err := &apierr.Error{Status: http.StatusBadRequest, Message: Header + " may be at most 255 characters"}
+		err.Flush(rw)
+		return
+	}
+
+	hash, ok := hashRequest(r, bindings.OwnerId)
+	if !ok {
+		// too large to hash; the handler rejects it
+		next(rw, r)
+		return
+	}
+
+	rec := &cass.IdempotencyRecord{UserId: bindings.UserId, Key: key, RequestHash: hash, CreatedAt: time.Now()}
+	existing, claimed, claimErr := self.CassClient.ClaimIdempotencyKey(r.Context(), rec, claimTTL)
+	if claimErr != nil {
+		apierr.From(claimErr).Flush(rw)
+		return
+	}
+
+	if !claimed {
+		replay(rw, rec, existing)
+		return
+	}
+
+	// the request runs, and its response is stored, even if the client has gone, as that's when it will retry.
+	// Otherwise a disconnect would cancel the handler partway & store its cancellation errors as the result.
+	ctx := context.WithoutCancel(r.Context())
+	recorder := &recorder{ResponseWriter: rw}
+	next(recorder, r.WithContext(ctx))
+
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+	if retryable(recorder.status) || recorder.overflow {
+		if res := self.CassClient.DeleteIdempotencyKey(ctx, rec.UserId, rec.Key); res.Err != nil {
+			slog.ErrorContext(ctx, "idempotency: failed to release key", "error", res.Err)
+		}
+		return
+	}
+
+	rec.Status = recorder.status
+	rec.ContentType = recorder.Header().Get("Content-Type")
+	rec.Body = recorder.body.Bytes()
+	if res := self.CassClient.PutIdempotencyRecord(ctx, rec, self.TTL); res.Err != nil {
+		slog.ErrorContext(ctx, "idempotency: failed to store response", "error", res.Err)
+	}
+}
+
+// retryable reports whether a response may differ when the request is retried, so shouldn't be stored
+func retryable(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout, http.StatusConflict, http.StatusTooManyRequests:
+		return true
+	}
+	return status >= http.StatusInternalServerError
+}
+
+// replay serves a stored response, provided the key was sent with the same request & that request has completed
+func replay(rw http.ResponseWriter, rec *cass.IdempotencyRecord, existing *cass.IdempotencyRecord) {
+	if !bytes.Equal(rec.RequestHash, existing.RequestHash) {
+		err := &apierr.Error{Status: http.StatusUnprocessableEntity, Code: apierr.KeyReused, Message: Header + " was already used for a different request"}
+		err.Flush(rw)
+		return
+	}
+
+	if existing.Status == 0 {
+		rw.Header().Set("Retry-After", "1")
+		err := &apierr.Error{Status: http.StatusConflict, Message: "a request with this " + Header + " is in progress"}
+		err.Flush(rw)
+		return
+	}
+
+	if existing.ContentType != "" {
+		rw.Header().Set("Content-Type", existing.ContentType)
+	}
+	rw.Header().Set(ReplayedHeader, "true")
+	rw.WriteHeader(existing.Status)
+	rw.Write(existing.Body)
+}
+
+// hashRequest digests the owner acted for, method, url & body, restoring the body for the handler. Bodies over validator.MaxBodySize aren't hashed.
+// The owner is included as keys are scoped to the user, who may send the same key on behalf of several orgs.
+func hashRequest(r *http.Request, owner *gocql.UUID) ([]byte, bool) {
+	body, readErr := ioutil.ReadAll(io.LimitReader(r.Body, validator.MaxBodySize+1))
+	r.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+	if readErr != nil || len(body) > validator.MaxBodySize {
+		return nil, false
+	}
+
+	h := sha256.New()
+	if owner != nil {
+		h.Write(owner.Bytes())
+	}
+	h.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+	h.Write(body)
+	return h.Sum(nil), true
+}
+
+// recorder copies a response as it's written
+type recorder struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (self *recorder) WriteHeader(status int) {
+	if self.status == 0 {
+		self.status = status
+	}
+	self.ResponseWriter.WriteHeader(status)
+}
+
+func (self *recorder) Write(data []byte) (int, error) {
+	if self.status == 0 {
+		self.status = http.StatusOK
+	}
+	if self.body.Len()+len(data) > maxStoredBody {
+		self.overflow = true
+	} else if !self.overflow {
+		self.body.Write(data)
+	}
+	return self.ResponseWriter.Write(data)
+}
@@ -0,0 +1,168 @@
+package idempotency
+
+import (
+	"context"
+	"github.com/gocql/gocql"
+	"github.com/owen-d/beacon-api/lib/auth/jwt"
+	"github.com/owen-d/beacon-api/lib/cass"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// store keeps records in memory, in place of cassandra
+type store struct {
+	cass.Client
+	records map[string]*cass.IdempotencyRecord
+}
+
+func (self *store) ClaimIdempotencyKey(ctx context.Context, rec *cass.IdempotencyRecord, ttl time.Duration) (*cass.IdempotencyRecord, bool, error) {
+	if existing, ok := self.records[rec.Key]; ok {
+		return existing, false, nil
+	}
+	claim := *rec
+	self.records[rec.Key] = &claim
+	return rec, true, nil
+}
+
+func (self *store) PutIdempotencyRecord(ctx context.Context, rec *cass.IdempotencyRecord, ttl time.Duration) *cass.UpsertResult {
+	stored := *rec
+	self.records[rec.Key] = &stored
+	return &cass.UpsertResult{}
+}
+
+func (self *store) DeleteIdempotencyKey(ctx context.Context, userId *gocql.UUID, key string) *cass.UpsertResult {
+	delete(self.records, key)
+	return &cass.UpsertResult{}
+}
+
+var userId = gocql.TimeUUID()
+
+func request(key string, body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/v1/deployments", strings.NewReader(body))
+	r.Header.Set(Header, key)
+	return r.WithContext(context.WithValue(r.Context(), jwt.JWTNamespace, &jwt.Bindings{UserId: &userId}))
+}
+
+func TestMiddleware(t *testing.T) {
+	s := &store{records: map[string]*cass.IdempotencyRecord{}}
+	keys := New(s, 0)
+
+	runs := 0
+	status := http.StatusCreated
+	next := func(rw http.ResponseWriter, r *http.Request) {
+		runs++
+		body, _ := ioutil.ReadAll(r.Body)
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(status)
+		rw.Write(body)
+	}
+
+	cases := []struct {
+		key      string
+		body     string
+		status   int
+		runs     int
+		replayed bool
+	}{
+		{"a", `{"n":1}`, http.StatusCreated, 1, false},
+		// a retry is replayed rather than run
+		{"a", `{"n":1}`, http.StatusCreated, 1, true},
+		{"a", `{"n":2}`, http.StatusUnprocessableEntity, 1, false},
+		{"b", `{"n":1}`, http.StatusCreated, 2, false},
+		// requests without a key always run
+		{"", `{"n":1}`, http.StatusCreated, 3, false},
+	}
+
+	for _, c := range cases {
+		rw := httptest.NewRecorder()
+		keys.Middleware(rw, request(c.key, c.body), next)
+
+		if rw.Code != c.status || runs != c.runs || (rw.Header().Get(ReplayedHeader) == "true") != c.replayed {
+			t.Errorf("key %q with %s: expected %d after %d runs, replayed: %v, got %d after %d runs, %v", c.key, c.body, c.status, c.runs, c.replayed, rw.Code, runs, rw.Header())
+		}
+		if c.status == http.StatusCreated && rw.Body.String() != c.body {
+			t.Errorf("key %q: expected the handler's body, got %s", c.key, rw.Body.String())
+		}
+	}
+
+	// server errors & transient failures release the key, so the request may be retried
+	for _, failed := range []int{http.StatusInternalServerError, http.StatusTooManyRequests, http.StatusRequestTimeout, http.StatusConflict} {
+		status = failed
+		keys.Middleware(httptest.NewRecorder(), request("c", `{}`), next)
+		status = http.StatusCreated
+		rw := httptest.NewRecorder()
+		keys.Middleware(rw, request("c", `{}`), next)
+		if rw.Code != http.StatusCreated || rw.Header().Get(ReplayedHeader) != "" {
+			t.Errorf("expected a request failing with %d to run again, got %d", failed, rw.Code)
+		}
+		delete(s.records, "c")
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	s := &store{records: map[string]*cass.IdempotencyRecord{}}
+	keys := New(s, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := request("a", `{}`)
+	r = r.WithContext(context.WithValue(ctx, jwt.JWTNamespace, &jwt.Bindings{UserId: &userId}))
+
+	next := func(rw http.ResponseWriter, r *http.Request) {
+		// the client goes away partway through
+		cancel()
+		if err := r.Context().Err(); err != nil {
+			t.Error("expected the handler to run to completion, got", err)
+		}
+		rw.WriteHeader(http.StatusCreated)
+	}
+
+	keys.Middleware(httptest.NewRecorder(), r, next)
+	if rec := s.records["a"]; rec == nil || rec.Status != http.StatusCreated {
+		t.Errorf("expected the response to be stored, got %+v", rec)
+	}
+}
+
+func TestInProgress(t *testing.T) {
+	s := &store{records: map[string]*cass.IdempotencyRecord{}}
+	keys := New(s, 0)
+
+	next := func(rw http.ResponseWriter, r *http.Request) {
+		// a retry arrives while the first request is still running
+		retry := httptest.NewRecorder()
+		keys.Middleware(retry, request("a", `{}`), func(http.ResponseWriter, *http.Request) {
+			t.Error("expected the retry not to run")
+		})
+		if retry.Code != http.StatusConflict {
+			t.Error("expected a conflict, got", retry.Code)
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	}
+
+	keys.Middleware(httptest.NewRecorder(), request("a", `{}`), next)
+	if rec := s.records["a"]; rec == nil || rec.Status != http.StatusNoContent {
+		t.Errorf("expected the response to be stored, got %+v", rec)
+	}
+}
+
+func TestOwnerScoped(t *testing.T) {
+	keys := New(&store{records: map[string]*cass.IdempotencyRecord{}}, 0)
+	next := func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusCreated)
+	}
+
+	// the same user sends the same key & body on behalf of two orgs
+	for i, orgId := range []gocql.UUID{gocql.TimeUUID(), gocql.TimeUUID()} {
+		r := request("a", `{}`)
+		bindings := &jwt.Bindings{UserId: &userId, OwnerId: &orgId}
+		rw := httptest.NewRecorder()
+		keys.Middleware(rw, r.WithContext(context.WithValue(r.Context(), jwt.JWTNamespace, bindings)), next)
+
+		if i == 1 && (rw.Code != http.StatusUnprocessableEntity || rw.Header().Get(ReplayedHeader) != "") {
+			t.Errorf("expected the second org's request not to replay the first's, got %d", rw.Code)
+		}
+	}
+}
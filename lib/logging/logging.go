@@ -0,0 +1,124 @@
+// Package logging writes leveled json logs, tagging each line with its request's id & redacting credentials & personal data
+package logging
+
+import (
+	"context"
+	"github.com/owen-d/beacon-api/lib/reqid"
+	"io"
+	"log"
+	"log/slog"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const (
+	RequestIdKey = "request_id"
+	redacted     = "REDACTED"
+)
+
+var (
+	// sensitive keys are redacted wherever they appear: attributes, query params & headers
+	sensitive = map[string]bool{
+		"x-jwt":         true,
+		"authorization": true,
+		"cookie":        true,
+		"set-cookie":    true,
+		"code":          true,
+		"state":         true,
+		"link":          true,
+		"token":         true,
+		"access_token":  true,
+		"id_token":      true,
+		"refresh_token": true,
+		"password":      true,
+		"secret":        true,
+		"email":         true,
+	}
+
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	jwtPattern   = regexp.MustCompile(`eyJ[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]*`)
+)
+
+// Setup makes a json logger the default, which the log package writes through as well
+func Setup(w io.Writer, level string) *slog.Logger {
+	logger := New(w, ParseLevel(level))
+	slog.SetDefault(logger)
+	// slog adds its own timestamps
+	log.SetFlags(0)
+	return logger
+}
+
+func New(w io.Writer, level slog.Level) *slog.Logger {
+	return slog.New(&handler{slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level, ReplaceAttr: replace})})
+}
+
+// ParseLevel accepts debug, info, warn or error, defaulting to info
+func ParseLevel(level string) slog.Level {
+	var res slog.Level
+	if err := res.UnmarshalText([]byte(level)); err != nil {
+		return slog.LevelInfo
+	}
+	return res
+}
+
+// handler adds the request id of a line's context
+type handler struct {
+	slog.Handler
+}
+
+func (self *handler) Handle(ctx context.Context, rec slog.Record) error {
+	if id := reqid.FromContext(ctx); id != "" {
+		rec.AddAttrs(slog.String(RequestIdKey, id))
+	}
+	return self.Handler.Handle(ctx, rec)
+}
+
+func (self *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &handler{self.Handler.WithAttrs(attrs)}
+}
+
+func (self *handler) WithGroup(name string) slog.Handler {
+	return &handler{self.Handler.WithGroup(name)}
+}
+
+func replace(groups []string, attr slog.Attr) slog.Attr {
+	if sensitive[strings.ToLower(attr.Key)] {
+		return slog.String(attr.Key, redacted)
+	}
+	if attr.Value.Kind() == slog.KindString {
+		return slog.String(attr.Key, Redact(attr.Value.String()))
+	}
+	if err, ok := attr.Value.Any().(error); ok {
+		return slog.String(attr.Key, Redact(err.Error()))
+	}
+	return attr
+}
+
+// Redact scrubs emails & jwts from free text, such as error messages
+func Redact(s string) string {
+	s = jwtPattern.ReplaceAllString(s, redacted)
+	return emailPattern.ReplaceAllString(s, redacted)
+}
+
+// RedactURL renders a url's path & query, redacting sensitive query params such as oauth codes
+func RedactURL(u *url.URL) string {
+	if u.RawQuery == "" {
+		return Redact(u.Path)
+	}
+
+	query := u.Query()
+	for key := range query {
+		if sensitive[strings.ToLower(key)] {
+			query[key] = []string{redacted}
+		}
+	}
+	return Redact(u.Path + "?" + query.Encode())
+}
+
+// Fatal logs err & exits, for failures during startup
+func Fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
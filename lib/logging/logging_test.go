@@ -0,0 +1,82 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/owen-d/beacon-api/lib/reqid"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := New(buf, slog.LevelInfo)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	reqid.Middleware(httptest.NewRecorder(), r, func(rw http.ResponseWriter, r *http.Request) {
+		logger.InfoContext(r.Context(), "signed in owen@sharecro.ws",
+			"x-jwt", "eyJhbGciOiJIUzI1NiJ9.eyJzdWIiOiIxIn0.sig",
+			"Email", "owen@sharecro.ws",
+			"error", errors.New("token eyJhbGciOiJIUzI1NiJ9.eyJzdWIiOiIxIn0.sig expired"),
+		)
+		logger.DebugContext(r.Context(), "hidden")
+	})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatal("expected debug lines to be filtered, got", lines)
+	}
+
+	var line map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &line); err != nil {
+		t.Fatal("expected json, got", lines[0])
+	}
+
+	if id, _ := line[RequestIdKey].(string); id == "" {
+		t.Error("expected the request id, got", line)
+	}
+	if strings.Contains(lines[0], "owen@") || strings.Contains(lines[0], "eyJ") {
+		t.Error("expected emails & jwts to be redacted, got", lines[0])
+	}
+	if line["x-jwt"] != redacted || line["Email"] != redacted {
+		t.Error("expected sensitive keys to be redacted, got", line)
+	}
+}
+
+func TestRedactURL(t *testing.T) {
+	cases := []struct {
+		raw      string
+		expected string
+	}{
+		{"/v1/beacons", "/v1/beacons"},
+		{"/v1/auth/google/callback?code=abc&state=xyz&scope=email", "/v1/auth/google/callback?code=REDACTED&scope=email&state=REDACTED"},
+		{"/v1/auth/google/init?link=ticket&return_to=%2Fsettings", "/v1/auth/google/init?link=REDACTED&return_to=%2Fsettings"},
+		{"/v1/users/owen@sharecro.ws", "/v1/users/REDACTED"},
+	}
+
+	for _, c := range cases {
+		u, _ := url.Parse(c.raw)
+		if res := RedactURL(u); res != c.expected {
+			t.Errorf("expected %s, got %s", c.expected, res)
+		}
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	cases := map[string]slog.Level{
+		"":      slog.LevelInfo,
+		"debug": slog.LevelDebug,
+		"WARN":  slog.LevelWarn,
+		"bogus": slog.LevelInfo,
+	}
+	for level, expected := range cases {
+		if res := ParseLevel(level); res != expected {
+			t.Errorf("%q: expected %v, got %v", level, expected, res)
+		}
+	}
+}
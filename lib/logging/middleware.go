@@ -0,0 +1,32 @@
+package logging
+
+import (
+	"github.com/urfave/negroni"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Middleware logs each request once it's served. It replaces negroni's logger & must follow reqid.Middleware.
+func Middleware(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	start := time.Now()
+	next(rw, r)
+
+	status := http.StatusOK
+	if res, ok := rw.(negroni.ResponseWriter); ok && res.Status() != 0 {
+		status = res.Status()
+	}
+
+	level := slog.LevelInfo
+	if status >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+
+	slog.Default().LogAttrs(r.Context(), level, "request",
+		slog.String("method", r.Method),
+		slog.String("path", RedactURL(r.URL)),
+		slog.Int("status", status),
+		slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+		slog.String("remote", r.RemoteAddr),
+	)
+}
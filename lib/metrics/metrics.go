@@ -0,0 +1,294 @@
+// Package metrics collects counters, gauges & histograms, exposing them in the prometheus text format
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ContentType is the prometheus text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets suit request latencies, in seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Default holds every metric created through this package
+var Default = &Registry{}
+
+type metric interface {
+	write(*bytes.Buffer)
+	name() string
+}
+
+// Registry renders a set of metrics
+type Registry struct {
+	mtx     sync.Mutex
+	metrics []metric
+}
+
+func (self *Registry) register(m metric) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+	for _, existing := range self.metrics {
+		if existing.name() == m.name() {
+			panic("metrics: duplicate metric " + m.name())
+		}
+	}
+	self.metrics = append(self.metrics, m)
+}
+
+// Render writes every metric, sorted by name
+func (self *Registry) Render() []byte {
+	self.mtx.Lock()
+	metrics := append([]metric{}, self.metrics...)
+	self.mtx.Unlock()
+
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name() < metrics[j].name() })
+
+	buf := &bytes.Buffer{}
+	for _, m := range metrics {
+		m.write(buf)
+	}
+	return buf.Bytes()
+}
+
+// ServeHTTP exposes the registry for scraping
+func (self *Registry) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", ContentType)
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(self.Render())
+}
+
+// desc is shared by every kind of metric. Each set of label values is a separate series.
+type desc struct {
+	Name   string
+	Help   string
+	Labels []string
+	kind   string
+	mtx    sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labels []string
+	value  float64
+	// histograms only
+	buckets []uint64
+	count   uint64
+}
+
+func (self *desc) name() string {
+	return self.Name
+}
+
+// get finds or creates the series of the given label values. The caller must hold mtx.
+func (self *desc) get(values []string) *series {
+	if len(values) != len(self.Labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d labels, got %d", self.Name, len(self.Labels), len(values)))
+	}
+	if self.series == nil {
+		self.series = make(map[string]*series)
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := self.series[key]
+	if !ok {
+		s = &series{labels: append([]string{}, values...)}
+		self.series[key] = s
+	}
+	return s
+}
+
+// sorted returns a snapshot of each series, ordered by label values so scrapes are stable
+func (self *desc) sorted() []series {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	keys := make([]string, 0, len(self.series))
+	for key := range self.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	res := make([]series, 0, len(keys))
+	for _, key := range keys {
+		s := *self.series[key]
+		s.buckets = append([]uint64{}, s.buckets...)
+		res = append(res, s)
+	}
+	return res
+}
+
+func (self *desc) header(buf *bytes.Buffer) {
+	fmt.Fprintf(buf, "# HELP %s %s\n", self.Name, escape(self.Help, false))
+	fmt.Fprintf(buf, "# TYPE %s %s\n", self.Name, self.kind)
+}
+
+// Counter only goes up, i.e. the number of requests served
+type Counter struct {
+	desc
+}
+
+func NewCounter(name string, help string, labels ...string) *Counter {
+	c := &Counter{desc{Name: name, Help: help, Labels: labels, kind: "counter"}}
+	Default.register(c)
+	return c
+}
+
+func (self *Counter) Inc(labels ...string) {
+	self.Add(1, labels...)
+}
+
+func (self *Counter) Add(v float64, labels ...string) {
+	if v < 0 {
+		panic("metrics: counters can't decrease")
+	}
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+	self.get(labels).value += v
+}
+
+func (self *Counter) write(buf *bytes.Buffer) {
+	self.header(buf)
+	for _, s := range self.sorted() {
+		sample(buf, self.Name, self.Labels, s.labels, "", s.value)
+	}
+}
+
+// Gauge goes up & down, i.e. the number of requests in flight
+type Gauge struct {
+	desc
+}
+
+func NewGauge(name string, help string, labels ...string) *Gauge {
+	g := &Gauge{desc{Name: name, Help: help, Labels: labels, kind: "gauge"}}
+	Default.register(g)
+	return g
+}
+
+func (self *Gauge) Inc(labels ...string) {
+	self.Add(1, labels...)
+}
+
+func (self *Gauge) Dec(labels ...string) {
+	self.Add(-1, labels...)
+}
+
+func (self *Gauge) Add(v float64, labels ...string) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+	self.get(labels).value += v
+}
+
+func (self *Gauge) Set(v float64, labels ...string) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+	self.get(labels).value = v
+}
+
+func (self *Gauge) write(buf *bytes.Buffer) {
+	self.header(buf)
+	for _, s := range self.sorted() {
+		sample(buf, self.Name, self.Labels, s.labels, "", s.value)
+	}
+}
+
+// Histogram counts observations into cumulative buckets, i.e. request latencies
+type Histogram struct {
+	desc
+	Buckets []float64
+}
+
+// NewHistogram creates a histogram with sorted buckets, defaulting to DefaultBuckets
+func NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+
+	h := &Histogram{desc: desc{Name: name, Help: help, Labels: labels, kind: "histogram"}, Buckets: buckets}
+	Default.register(h)
+	return h
+}
+
+func (self *Histogram) Observe(v float64, labels ...string) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	s := self.get(labels)
+	if s.buckets == nil {
+		s.buckets = make([]uint64, len(self.Buckets))
+	}
+	// buckets are stored individually & accumulated when written
+	if i := sort.SearchFloat64s(self.Buckets, v); i < len(self.Buckets) {
+		s.buckets[i]++
+	}
+	s.count++
+	s.value += v
+}
+
+// Since observes the seconds elapsed from start
+func (self *Histogram) Since(start time.Time, labels ...string) {
+	self.Observe(time.Since(start).Seconds(), labels...)
+}
+
+func (self *Histogram) write(buf *bytes.Buffer) {
+	self.header(buf)
+	names := append(append([]string{}, self.Labels...), "le")
+	for _, s := range self.sorted() {
+		var cumulative uint64
+		for i, bound := range self.Buckets {
+			if s.buckets != nil {
+				cumulative += s.buckets[i]
+			}
+			sample(buf, self.Name, names, withLe(s.labels, formatFloat(bound)), "_bucket", float64(cumulative))
+		}
+		sample(buf, self.Name, names, withLe(s.labels, "+Inf"), "_bucket", float64(s.count))
+		sample(buf, self.Name, self.Labels, s.labels, "_sum", s.value)
+		sample(buf, self.Name, self.Labels, s.labels, "_count", float64(s.count))
+	}
+}
+
+// withLe copies labels, as they're shared with the live series
+func withLe(labels []string, le string) []string {
+	return append(append(make([]string, 0, len(labels)+1), labels...), le)
+}
+
+func sample(buf *bytes.Buffer, name string, labels []string, values []string, suffix string, v float64) {
+	buf.WriteString(name + suffix)
+	if len(labels) > 0 {
+		pairs := make([]string, len(labels))
+		for i, label := range labels {
+			pairs[i] = label + `="` + escape(values[i], true) + `"`
+		}
+		buf.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+	buf.WriteString(" " + formatFloat(v) + "\n")
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// escape follows the exposition format: help text escapes backslashes & newlines, label values also escape quotes
+func escape(s string, quotes bool) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	if quotes {
+		s = strings.Replace(s, `"`, `\"`, -1)
+	}
+	return s
+}
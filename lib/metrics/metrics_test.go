@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	counter := NewCounter("test_events_total", "Events.\nBy kind.", "kind")
+	counter.Inc(`a"b`)
+	counter.Add(2, "c")
+
+	gauge := NewGauge("test_in_flight", "In flight.")
+	gauge.Inc()
+	gauge.Inc()
+	gauge.Dec()
+
+	histogram := NewHistogram("test_duration_seconds", "Durations.", []float64{1, 0.1}, "op")
+	histogram.Observe(0.05, "x")
+	histogram.Observe(0.5, "x")
+	histogram.Observe(5, "x")
+
+	rw := httptest.NewRecorder()
+	Default.ServeHTTP(rw, httptest.NewRequest("GET", "/metrics", nil))
+	if rw.Header().Get("Content-Type") != ContentType {
+		t.Error("expected the exposition content type, got", rw.Header().Get("Content-Type"))
+	}
+
+	expected := []string{
+		"# HELP test_events_total Events.\\nBy kind.",
+		"# TYPE test_events_total counter",
+		`test_events_total{kind="a\"b"} 1`,
+		`test_events_total{kind="c"} 2`,
+		"# TYPE test_in_flight gauge",
+		"test_in_flight 1",
+		"# TYPE test_duration_seconds histogram",
+		`test_duration_seconds_bucket{op="x",le="0.1"} 1`,
+		`test_duration_seconds_bucket{op="x",le="1"} 2`,
+		`test_duration_seconds_bucket{op="x",le="+Inf"} 3`,
+		`test_duration_seconds_sum{op="x"} 5.55`,
+		`test_duration_seconds_count{op="x"} 3`,
+	}
+
+	body := rw.Body.String()
+	for _, line := range expected {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expected %q in:\n%s", line, body)
+		}
+	}
+	if strings.Index(body, "test_duration_seconds") > strings.Index(body, "test_events_total") {
+		t.Error("expected metrics sorted by name")
+	}
+}
+
+func TestDuplicate(t *testing.T) {
+	NewCounter("test_duplicate_total", "Duplicate.")
+	defer func() {
+		if recover() == nil {
+			t.Error("expected registering a name twice to panic")
+		}
+	}()
+	NewGauge("test_duplicate_total", "Duplicate.")
+}
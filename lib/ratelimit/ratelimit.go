@@ -0,0 +1,171 @@
+// Package ratelimit throttles clients with token buckets, keyed by user when signed in & by ip otherwise
+package ratelimit
+
+import (
+	"github.com/owen-d/beacon-api/lib/apierr"
+	"github.com/owen-d/beacon-api/lib/auth/jwt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	LimitHeader     = "RateLimit-Limit"
+	RemainingHeader = "RateLimit-Remaining"
+	ResetHeader     = "RateLimit-Reset"
+	// idle buckets are swept at most this often, bounding the memory held by one-off clients
+	sweepInterval = time.Minute
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter allows Burst requests at once per client, refilling at PerMinute
+type Limiter struct {
+	PerMinute int
+	Burst     int
+	// TrustedHops is the number of proxies in front of the api appending to X-Forwarded-For, i.e. 2 for the load balancer & nginx sidecar.
+	// Anonymous clients are keyed by the address the outermost of them saw. Zero ignores the header.
+	TrustedHops int
+
+	mtx       sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// New creates a limiter, defaulting the burst to a minute's worth of requests
+func New(perMinute int, burst int, trustedHops int) *Limiter {
+	if burst <= 0 {
+		burst = perMinute
+	}
+	return &Limiter{PerMinute: perMinute, Burst: burst, TrustedHops: trustedHops}
+}
+
+func (self *Limiter) rate() float64 {
+	return float64(self.PerMinute) / 60
+}
+
+// Take consumes a token from key's bucket, returning whether one was available, how many remain & how long until the next
+func (self *Limiter) Take(key string) (bool, int, time.Duration) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	now := time.Now()
+	if self.now != nil {
+		now = self.now()
+	}
+
+	if self.buckets == nil {
+		self.buckets = make(map[string]*bucket)
+	}
+	if now.Sub(self.lastSweep) > sweepInterval {
+		self.sweep(now)
+	}
+
+	b, ok := self.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(self.Burst), last: now}
+		self.buckets[key] = b
+	}
+
+	b.tokens = math.Min(float64(self.Burst), b.tokens+now.Sub(b.last).Seconds()*self.rate())
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, 0, self.until(1 - b.tokens)
+	}
+
+	b.tokens--
+	return true, int(b.tokens), 0
+}
+
+// until is how long the bucket takes to refill by tokens
+func (self *Limiter) until(tokens float64) time.Duration {
+	return time.Duration(tokens / self.rate() * float64(time.Second))
+}
+
+// sweep drops buckets which have refilled completely, as they're indistinguishable from new ones
+func (self *Limiter) sweep(now time.Time) {
+	full := self.until(float64(self.Burst))
+	for key, b := range self.buckets {
+		if now.Sub(b.last) > full {
+			delete(self.buckets, key)
+		}
+	}
+	self.lastSweep = now
+}
+
+// Key identifies the client of a request: the user if an Authenticator has run, otherwise the ip
+func (self *Limiter) Key(r *http.Request) string {
+	if bindings, ok := r.Context().Value(jwt.JWTNamespace).(*jwt.Bindings); ok && bindings.UserId != nil {
+		return "user:" + bindings.UserId.String()
+	}
+	return "ip:" + self.clientIP(r)
+}
+
+func (self *Limiter) clientIP(r *http.Request) string {
+	if forwarded := r.Header.Values("X-Forwarded-For"); self.TrustedHops > 0 && len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		// entries left of those our proxies appended may be forged by the client
+		i := len(hops) - self.TrustedHops
+		if i < 0 {
+			i = 0
+		}
+		return strings.TrimSpace(hops[i])
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Middleware responds with 429 once a client's bucket is empty. A nil limiter allows everything.
+func (self *Limiter) Middleware(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if self == nil || self.PerMinute <= 0 {
+		next(rw, r)
+		return
+	}
+
+	self.limit(rw, r, next, self.Key(r))
+}
+
+// IPMiddleware limits by ip alone, so it may run before authentication & throttle failed attempts too. A nil limiter allows everything.
+func (self *Limiter) IPMiddleware(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if self == nil || self.PerMinute <= 0 {
+		next(rw, r)
+		return
+	}
+
+	self.limit(rw, r, next, "ip:"+self.clientIP(r))
+}
+
+func (self *Limiter) limit(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc, key string) {
+	ok, remaining, retry := self.Take(key)
+
+	rw.Header().Set(LimitHeader, strconv.Itoa(self.Burst))
+	rw.Header().Set(RemainingHeader, strconv.Itoa(remaining))
+	rw.Header().Set(ResetHeader, strconv.Itoa(seconds(self.until(float64(self.Burst-remaining)))))
+
+	if !ok {
+		rw.Header().Set("Retry-After", strconv.Itoa(seconds(retry)))
+		err := &apierr.Error{Status: http.StatusTooManyRequests, Code: apierr.RateLimited, Message: "too many requests"}
+		err.Flush(rw)
+		return
+	}
+
+	next(rw, r)
+}
+
+// seconds rounds up, so clients never retry early
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,121 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/gocql/gocql"
+	"github.com/owen-d/beacon-api/lib/auth/jwt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTake(t *testing.T) {
+	now := time.Unix(0, 0)
+	limiter := New(60, 2, 0)
+	limiter.now = func() time.Time { return now }
+
+	for i := 1; i >= 0; i-- {
+		if ok, remaining, _ := limiter.Take("a"); !ok || remaining != i {
+			t.Errorf("expected a token with %d remaining, got %v, %d", i, ok, remaining)
+		}
+	}
+
+	ok, _, retry := limiter.Take("a")
+	if ok || retry != time.Second {
+		t.Errorf("expected an empty bucket refilling in a second, got %v, %v", ok, retry)
+	}
+
+	if ok, _, _ := limiter.Take("b"); !ok {
+		t.Error("expected buckets to be independent")
+	}
+
+	now = now.Add(time.Second)
+	if ok, _, _ := limiter.Take("a"); !ok {
+		t.Error("expected the bucket to refill")
+	}
+
+	now = now.Add(time.Hour)
+	limiter.Take("c")
+	if _, ok := limiter.buckets["a"]; ok {
+		t.Error("expected full buckets to be swept")
+	}
+}
+
+func TestKey(t *testing.T) {
+	cases := []struct {
+		trustedHops int
+		forwarded   string
+		expected    string
+	}{
+		{0, "", "ip:192.0.2.1"},
+		{0, "203.0.113.9", "ip:192.0.2.1"},
+		{2, "", "ip:192.0.2.1"},
+		// the load balancer appends the client's address, then nginx appends the load balancer's
+		{2, "203.0.113.9, 10.0.0.1", "ip:203.0.113.9"},
+		{2, "198.51.100.7, 203.0.113.9, 10.0.0.1", "ip:203.0.113.9"},
+		{1, "203.0.113.9, 10.0.0.1", "ip:10.0.0.1"},
+		{3, "203.0.113.9, 10.0.0.1", "ip:203.0.113.9"},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/", nil)
+		if c.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", c.forwarded)
+		}
+		if key := New(1, 0, c.trustedHops).Key(r); key != c.expected {
+			t.Errorf("%d hops of %q: expected %s, got %s", c.trustedHops, c.forwarded, c.expected, key)
+		}
+	}
+}
+
+func TestIPMiddleware(t *testing.T) {
+	limiter := New(60, 1, 0)
+	next := func(rw http.ResponseWriter, r *http.Request) { rw.WriteHeader(http.StatusOK) }
+
+	// signed in or not, requests from one ip share a bucket
+	userId := gocql.TimeUUID()
+	signedIn := httptest.NewRequest("GET", "/", nil)
+	signedIn = signedIn.WithContext(context.WithValue(signedIn.Context(), jwt.JWTNamespace, &jwt.Bindings{UserId: &userId}))
+
+	statuses := []int{}
+	for _, r := range []*http.Request{httptest.NewRequest("GET", "/", nil), signedIn} {
+		rw := httptest.NewRecorder()
+		limiter.IPMiddleware(rw, r, next)
+		statuses = append(statuses, rw.Code)
+	}
+
+	if statuses[0] != http.StatusOK || statuses[1] != http.StatusTooManyRequests {
+		t.Error("expected the second request from the ip to be limited, got", statuses)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	limiter := New(60, 1, 0)
+	next := func(rw http.ResponseWriter, r *http.Request) { rw.WriteHeader(http.StatusOK) }
+
+	statuses := []int{}
+	for i := 0; i < 2; i++ {
+		rw := httptest.NewRecorder()
+		limiter.Middleware(rw, httptest.NewRequest("GET", "/", nil), next)
+		statuses = append(statuses, rw.Code)
+
+		if rw.Header().Get(LimitHeader) != "1" {
+			t.Error("expected the limit header, got", rw.Header().Get(LimitHeader))
+		}
+		if rw.Code == http.StatusTooManyRequests && rw.Header().Get("Retry-After") != "1" {
+			t.Error("expected Retry-After, got", rw.Header().Get("Retry-After"))
+		}
+	}
+
+	if statuses[0] != http.StatusOK || statuses[1] != http.StatusTooManyRequests {
+		t.Error("expected the second request to be limited, got", statuses)
+	}
+
+	var disabled *Limiter
+	rw := httptest.NewRecorder()
+	disabled.Middleware(rw, httptest.NewRequest("GET", "/", nil), next)
+	if rw.Code != http.StatusOK {
+		t.Error("expected a nil limiter to allow requests, got", rw.Code)
+	}
+}
@@ -0,0 +1,191 @@
+// Package redirect evaluates a deployment's ordered redirect rules against an incoming request
+package redirect
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rule selects a Target when every condition it specifies matches. Unset conditions always match.
+type Rule struct {
+	Target string `json:"target"`
+	// Languages are matched against the Accept-Language header, i.e. "pt" matches "pt-BR" but "pt-BR" does not match "pt"
+	Languages []string `json:"languages,omitempty"`
+	// OS is one of the values returned by DetectOS, i.e. "ios" or "android"
+	OS    []string          `json:"os,omitempty"`
+	Hours *Hours            `json:"hours,omitempty"`
+	Query map[string]string `json:"query,omitempty"`
+}
+
+// Hours matches a time of day window, formatted as "15:04", in a given time zone. Windows where End precedes Start wrap past midnight.
+type Hours struct {
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	TimeZone string `json:"time_zone"`
+}
+
+const (
+	IOS     = "ios"
+	Android = "android"
+	Windows = "windows"
+	MacOS   = "macos"
+	Linux   = "linux"
+)
+
+// Evaluate returns the target of the first matching rule, or fallback if none match
+func Evaluate(rules []*Rule, r *http.Request, now time.Time, fallback string) string {
+	for _, rule := range rules {
+		if rule.Matches(r, now) {
+			return rule.Target
+		}
+	}
+	return fallback
+}
+
+// Matches reports whether every condition on the rule is satisfied by the request
+func (self *Rule) Matches(r *http.Request, now time.Time) bool {
+	if len(self.Languages) != 0 && !matchLanguages(self.Languages, r.Header.Get("Accept-Language")) {
+		return false
+	}
+
+	if len(self.OS) != 0 && !contains(self.OS, DetectOS(r.UserAgent())) {
+		return false
+	}
+
+	if self.Hours != nil && !self.Hours.Contains(now) {
+		return false
+	}
+
+	query := r.URL.Query()
+	for k, v := range self.Query {
+		if query.Get(k) != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Validate ensures a rule can be evaluated
+func (self *Rule) Validate() error {
+	parsed, err := url.Parse(self.Target)
+	if err != nil || !parsed.IsAbs() || parsed.Host == "" {
+		return fmt.Errorf("invalid target url: %q", self.Target)
+	}
+
+	for _, os := range self.OS {
+		if !contains([]string{IOS, Android, Windows, MacOS, Linux}, os) {
+			return fmt.Errorf("unknown os: %q", os)
+		}
+	}
+
+	if self.Hours != nil {
+		return self.Hours.Validate()
+	}
+
+	return nil
+}
+
+// Validate ensures the window's clock times & time zone parse
+func (self *Hours) Validate() error {
+	if _, err := ParseClock(self.Start); err != nil {
+		return err
+	}
+	if _, err := ParseClock(self.End); err != nil {
+		return err
+	}
+	if _, err := time.LoadLocation(self.TimeZone); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Contains reports whether the time of day at t, in the window's time zone, falls in [Start, End)
+func (self *Hours) Contains(t time.Time) bool {
+	loc, locErr := time.LoadLocation(self.TimeZone)
+	start, startErr := ParseClock(self.Start)
+	end, endErr := ParseClock(self.End)
+	if locErr != nil || startErr != nil || endErr != nil {
+		return false
+	}
+
+	local := t.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+
+	if start <= end {
+		return minute >= start && minute < end
+	}
+	// window wraps midnight
+	return minute >= start || minute < end
+}
+
+// DetectOS maps a User-Agent onto a coarse operating system. It returns an empty string when unknown.
+func DetectOS(userAgent string) string {
+	switch {
+	case strings.Contains(userAgent, "iPhone"), strings.Contains(userAgent, "iPad"), strings.Contains(userAgent, "iPod"):
+		return IOS
+	case strings.Contains(userAgent, "Android"):
+		return Android
+	case strings.Contains(userAgent, "Windows"):
+		return Windows
+	case strings.Contains(userAgent, "Macintosh"):
+		return MacOS
+	case strings.Contains(userAgent, "Linux"):
+		return Linux
+	default:
+		return ""
+	}
+}
+
+// matchLanguages checks every language the client accepts (ignoring q=0) against the rule's languages
+func matchLanguages(want []string, header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		if len(fields) > 1 && strings.TrimSpace(fields[1]) == "q=0" {
+			continue
+		}
+
+		for _, lang := range want {
+			lang = strings.ToLower(lang)
+			if tag == lang || strings.HasPrefix(tag, lang+"-") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ParseClock converts "15:04" into minutes past midnight
+func ParseClock(clock string) (int, error) {
+	parts := strings.Split(clock, ":")
+	if len(parts) != 2 {
+		return 0, errors.New("invalid clock time: " + clock)
+	}
+
+	hour, hourErr := strconv.Atoi(parts[0])
+	minute, minuteErr := strconv.Atoi(parts[1])
+	if hourErr != nil || minuteErr != nil || hour < 0 || hour > 24 || minute < 0 || minute > 59 || (hour == 24 && minute != 0) {
+		return 0, errors.New("invalid clock time: " + clock)
+	}
+
+	return hour*60 + minute, nil
+}
+
+func contains(haystack []string, needle string) bool {
+	for _, v := range haystack {
+		if v == needle {
+			return true
+		}
+	}
+	return false
+}
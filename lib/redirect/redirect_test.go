@@ -0,0 +1,136 @@
+package redirect
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+const (
+	fallback     = "https://fallback.sharecro.ws"
+	iphoneAgent  = "Mozilla/5.0 (iPhone; CPU iPhone OS 10_3 like Mac OS X) AppleWebKit/602.1.50 (KHTML, like Gecko)"
+	androidAgent = "Mozilla/5.0 (Linux; Android 7.0; Pixel Build/NDE63X) AppleWebKit/537.36 (KHTML, like Gecko)"
+)
+
+func newRequest(target string, headers map[string]string) *http.Request {
+	r, _ := http.NewRequest(http.MethodGet, target, nil)
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	return r
+}
+
+func TestEvaluate(t *testing.T) {
+	rules := []*Rule{
+		&Rule{Target: "https://apps.apple.com/app", OS: []string{IOS}},
+		&Rule{Target: "https://play.google.com/app", OS: []string{Android}},
+		&Rule{Target: "https://es.sharecro.ws", Languages: []string{"es"}},
+		&Rule{Target: "https://promo.sharecro.ws", Query: map[string]string{"src": "flyer"}},
+	}
+	now := time.Now()
+
+	cases := []struct {
+		name     string
+		r        *http.Request
+		expected string
+	}{
+		{"ios", newRequest("/", map[string]string{"User-Agent": iphoneAgent}), "https://apps.apple.com/app"},
+		{"android", newRequest("/", map[string]string{"User-Agent": androidAgent}), "https://play.google.com/app"},
+		{"language", newRequest("/", map[string]string{"Accept-Language": "fr-CH, es-MX;q=0.8"}), "https://es.sharecro.ws"},
+		{"language-refused", newRequest("/", map[string]string{"Accept-Language": "fr-CH, es;q=0"}), fallback},
+		{"query", newRequest("/?src=flyer", nil), "https://promo.sharecro.ws"},
+		{"fallback", newRequest("/", nil), fallback},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if res := Evaluate(rules, c.r, now, fallback); res != c.expected {
+				t.Error("expected", c.expected, "got", res)
+			}
+		})
+	}
+}
+
+func TestHoursContains(t *testing.T) {
+	loc, _ := time.LoadLocation("America/New_York")
+	at := func(hour, minute int) time.Time {
+		return time.Date(2017, time.August, 7, hour, minute, 0, 0, loc)
+	}
+
+	breakfast := &Hours{Start: "06:00", End: "11:00", TimeZone: "America/New_York"}
+	lateNight := &Hours{Start: "22:00", End: "02:00", TimeZone: "America/New_York"}
+
+	t.Run("inside", func(t *testing.T) {
+		if !breakfast.Contains(at(6, 0)) || !breakfast.Contains(at(10, 59)) {
+			t.Fail()
+		}
+	})
+	t.Run("outside", func(t *testing.T) {
+		if breakfast.Contains(at(11, 0)) || breakfast.Contains(at(5, 59)) {
+			t.Fail()
+		}
+	})
+	t.Run("wraps-midnight", func(t *testing.T) {
+		if !lateNight.Contains(at(23, 0)) || !lateNight.Contains(at(1, 0)) || lateNight.Contains(at(12, 0)) {
+			t.Fail()
+		}
+	})
+	t.Run("converts-zone", func(t *testing.T) {
+		// 12:00 UTC is 08:00 in New York during daylight time
+		if !breakfast.Contains(time.Date(2017, time.August, 7, 12, 0, 0, 0, time.UTC)) {
+			t.Fail()
+		}
+	})
+}
+
+func TestValidate(t *testing.T) {
+	invalid := []*Rule{
+		&Rule{Target: "not a url"},
+		&Rule{Target: "https://sharecro.ws", OS: []string{"beos"}},
+		&Rule{Target: "https://sharecro.ws", Hours: &Hours{Start: "6am", End: "11:00", TimeZone: "UTC"}},
+		&Rule{Target: "https://sharecro.ws", Hours: &Hours{Start: "06:00", End: "11:00", TimeZone: "Mars/Olympus"}},
+	}
+
+	for _, rule := range invalid {
+		if rule.Validate() == nil {
+			t.Error("false positive: rule should be invalid:", rule)
+		}
+	}
+
+	valid := &Rule{Target: "https://sharecro.ws", OS: []string{IOS}, Hours: &Hours{Start: "06:00", End: "11:00", TimeZone: "UTC"}}
+	if err := valid.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestChooseVariant(t *testing.T) {
+	variants := []*Variant{
+		&Variant{MessageName: "a", Weight: 3},
+		&Variant{MessageName: "b", Weight: 1},
+	}
+
+	t.Run("sticky", func(t *testing.T) {
+		for i := 0; i < 20; i++ {
+			if v := ChooseVariant(variants, "b"); v.MessageName != "b" {
+				t.Error("expected sticky variant b, got", v.MessageName)
+			}
+		}
+	})
+
+	t.Run("weighted", func(t *testing.T) {
+		counts := map[string]int{}
+		for i := 0; i < 4000; i++ {
+			counts[ChooseVariant(variants, "").MessageName]++
+		}
+		// expect roughly 3000/1000
+		if counts["a"] < 2700 || counts["b"] < 700 {
+			t.Error("unexpected distribution:", counts)
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		if v := ChooseVariant(nil, ""); v != nil {
+			t.Error("expected nil variant")
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package redirect
+
+import (
+	"errors"
+	"math/rand"
+)
+
+// Variant is a message a deployment may serve, chosen in proportion to its Weight
+type Variant struct {
+	MessageName string `json:"message_name"`
+	Weight      int    `json:"weight"`
+}
+
+// ValidateVariants ensures variants are uniquely named & positively weighted
+func ValidateVariants(variants []*Variant) error {
+	seen := make(map[string]bool, len(variants))
+	for _, v := range variants {
+		if v.MessageName == "" {
+			return errors.New("variant message_name required")
+		}
+		if v.Weight <= 0 {
+			return errors.New("variant weight must be positive: " + v.MessageName)
+		}
+		if seen[v.MessageName] {
+			return errors.New("duplicate variant: " + v.MessageName)
+		}
+		seen[v.MessageName] = true
+	}
+	return nil
+}
+
+// ChooseVariant keeps a visitor on the variant they were previously served (sticky), otherwise picking one at random by weight. It returns nil for no variants.
+func ChooseVariant(variants []*Variant, sticky string) *Variant {
+	total := 0
+	for _, v := range variants {
+		if v.MessageName == sticky {
+			return v
+		}
+		total += v.Weight
+	}
+
+	if total <= 0 {
+		return nil
+	}
+
+	roll := rand.Intn(total)
+	for _, v := range variants {
+		if roll < v.Weight {
+			return v
+		}
+		roll -= v.Weight
+	}
+	return nil
+}
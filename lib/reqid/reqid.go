@@ -0,0 +1,48 @@
+// Package reqid tags each request with an id, which is echoed in the response & recorded alongside audit events
+package reqid
+
+import (
+	"context"
+	"github.com/gocql/gocql"
+	"net/http"
+)
+
+const (
+	Header = "X-Request-Id"
+	// ids from upstream proxies are kept if they're reasonably sized & printable
+	maxLength = 64
+)
+
+type key struct{}
+
+// Middleware assigns the request's id, reusing one set by a proxy
+func Middleware(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	id := r.Header.Get(Header)
+	if !valid(id) {
+		id = gocql.TimeUUID().String()
+	}
+
+	rw.Header().Set(Header, id)
+	next(rw, r.WithContext(context.WithValue(r.Context(), key{}, id)))
+}
+
+// FromContext returns the request's id, or "" outside of Middleware
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(key{}).(string)
+	return id
+}
+
+func valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,41 @@
+package reqid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	cases := []struct {
+		incoming string
+		kept     bool
+	}{
+		{"", false},
+		{"abc-123_x.y", true},
+		{"has spaces", false},
+		{"line\nbreak", false},
+		{strings.Repeat("a", maxLength+1), false},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/", nil)
+		if c.incoming != "" {
+			r.Header[Header] = []string{c.incoming}
+		}
+		rw := httptest.NewRecorder()
+
+		var seen string
+		Middleware(rw, r, func(rw http.ResponseWriter, r *http.Request) {
+			seen = FromContext(r.Context())
+		})
+
+		if seen == "" || rw.Header().Get(Header) != seen {
+			t.Error("expected the id in both the context & response, got", seen)
+		}
+		if (seen == c.incoming) != c.kept {
+			t.Errorf("incoming id %q kept: %v", c.incoming, seen == c.incoming)
+		}
+	}
+}
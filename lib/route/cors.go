@@ -0,0 +1,94 @@
+package route
+
+import (
+	"errors"
+	"github.com/rs/cors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var corsMethods = []string{"HEAD", "GET", "POST", "PUT", "PATCH", "DELETE"}
+
+// CorsOptions configure a Cors policy
+type CorsOptions struct {
+	// AllowedOrigins are origins such as https://sharecro.ws, or wildcard subdomains such as https://*.sharecro.ws.
+	// A lone "*" allows every origin, which can't be combined with credentials.
+	AllowedOrigins []string
+	// AllowedHeaders may be sent by browsers, defaulting to any header
+	AllowedHeaders []string
+	// ExposedHeaders may be read by browsers in addition to the simple response headers
+	ExposedHeaders   []string
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache a preflight response
+	MaxAge time.Duration
+}
+
+// Cors decides which browser origins may call a router's endpoints, answering their preflight requests
+type Cors struct {
+	handler *cors.Cors
+}
+
+// NewCors validates opts, rejecting malformed origins & credentials for every origin
+func NewCors(opts CorsOptions) (*Cors, error) {
+	for _, origin := range opts.AllowedOrigins {
+		if origin == "*" {
+			if opts.AllowCredentials {
+				return nil, errors.New("cors: credentials can't be allowed for every origin")
+			}
+			continue
+		}
+		if err := validateOrigin(origin); err != nil {
+			return nil, err
+		}
+	}
+
+	headers := opts.AllowedHeaders
+	if len(headers) == 0 {
+		headers = []string{"*"}
+	}
+
+	return &Cors{handler: cors.New(cors.Options{
+		AllowedOrigins:   opts.AllowedOrigins,
+		AllowedMethods:   corsMethods,
+		AllowedHeaders:   headers,
+		ExposedHeaders:   opts.ExposedHeaders,
+		AllowCredentials: opts.AllowCredentials,
+		MaxAge:           int(opts.MaxAge / time.Second),
+	})}, nil
+}
+
+// validateOrigin requires a scheme & host without a path. A wildcard may only replace the leading subdomain.
+func validateOrigin(origin string) error {
+	invalid := errors.New("cors: invalid origin " + origin)
+
+	host := origin
+	if i := strings.Index(origin, "://"); i >= 0 {
+		host = origin[i+3:]
+	}
+	if strings.HasPrefix(host, "*.") {
+		host = host[2:]
+	}
+	if strings.Contains(host, "*") {
+		return invalid
+	}
+
+	parsed, err := url.Parse(strings.Replace(origin, "*.", "", 1))
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host != host || parsed.User != nil {
+		return invalid
+	}
+	return nil
+}
+
+// ServeHTTP adds cors headers to permitted requests, answering preflight requests itself
+func (self *Cors) ServeHTTP(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	self.handler.ServeHTTP(rw, r, next)
+}
+
+// preflight answers OPTIONS requests for any path under a router, as its endpoints only match their own methods
+func (self *Cors) preflight(rw http.ResponseWriter, r *http.Request) {
+	self.handler.ServeHTTP(rw, r, func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusNoContent)
+	})
+}
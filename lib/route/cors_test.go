@@ -0,0 +1,90 @@
+package route
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/urfave/negroni"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewCors(t *testing.T) {
+	cases := []struct {
+		opts  CorsOptions
+		valid bool
+	}{
+		{CorsOptions{AllowedOrigins: []string{"https://sharecro.ws", "https://*.sharecro.ws"}, AllowCredentials: true}, true},
+		{CorsOptions{AllowedOrigins: []string{"*"}}, true},
+		{CorsOptions{AllowedOrigins: []string{"*"}, AllowCredentials: true}, false},
+		{CorsOptions{AllowedOrigins: []string{"sharecro.ws"}}, false},
+		{CorsOptions{AllowedOrigins: []string{"https://sharecro.ws/app"}}, false},
+		{CorsOptions{AllowedOrigins: []string{"https://share*.ws"}}, false},
+		{CorsOptions{AllowedOrigins: []string{"https://*.*.sharecro.ws"}}, false},
+	}
+
+	for _, c := range cases {
+		if _, err := NewCors(c.opts); (err == nil) != c.valid {
+			t.Errorf("expected %v to be valid: %v, got %v", c.opts.AllowedOrigins, c.valid, err)
+		}
+	}
+}
+
+func TestCorsOverride(t *testing.T) {
+	credentialed, _ := NewCors(CorsOptions{
+		AllowedOrigins:   []string{"https://*.sharecro.ws"},
+		ExposedHeaders:   []string{"X-Request-Id"},
+		AllowCredentials: true,
+	})
+	public, _ := NewCors(CorsOptions{AllowedOrigins: []string{"*"}})
+
+	endpoint := &Endpoint{
+		Method:   http.MethodGet,
+		Handlers: []negroni.Handler{negroni.HandlerFunc(Teapot)},
+		SubPath:  "/pot",
+	}
+	router := &Router{
+		Path:      "/api",
+		Cors:      credentialed,
+		Endpoints: []*Endpoint{endpoint},
+		SubRoutes: []*Router{
+			&Router{Path: "/inherited", Endpoints: []*Endpoint{endpoint}},
+			&Router{Path: "/public", Cors: public, Endpoints: []*Endpoint{endpoint}},
+		},
+	}
+	root := Inject(router, mux.NewRouter())
+
+	cases := []struct {
+		method      string
+		path        string
+		origin      string
+		allowed     string
+		credentials string
+	}{
+		{http.MethodGet, "/api/pot", "https://app.sharecro.ws", "https://app.sharecro.ws", "true"},
+		{http.MethodGet, "/api/pot", "https://evil.com", "", ""},
+		{http.MethodOptions, "/api/inherited/pot", "https://app.sharecro.ws", "https://app.sharecro.ws", "true"},
+		{http.MethodGet, "/api/inherited/pot", "https://evil.com", "", ""},
+		{http.MethodOptions, "/api/public/pot", "https://evil.com", "*", ""},
+		{http.MethodGet, "/api/public/pot", "https://evil.com", "*", ""},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest(c.method, c.path, nil)
+		r.Header.Set("Origin", c.origin)
+		if c.method == http.MethodOptions {
+			r.Header.Set("Access-Control-Request-Method", http.MethodGet)
+		}
+		rw := httptest.NewRecorder()
+		root.ServeHTTP(rw, r)
+
+		if rw.Header().Get("Access-Control-Allow-Origin") != c.allowed || rw.Header().Get("Access-Control-Allow-Credentials") != c.credentials {
+			t.Errorf("%s %s from %s: expected origin %q & credentials %q, got %v", c.method, c.path, c.origin, c.allowed, c.credentials, rw.Header())
+		}
+		if c.method == http.MethodGet && rw.Code != http.StatusTeapot {
+			t.Errorf("%s %s: expected the endpoint to run regardless of origin, got %d", c.method, c.path, rw.Code)
+		}
+		if c.method == http.MethodOptions && rw.Code != http.StatusOK {
+			t.Errorf("%s %s: expected the preflight to be answered, got %d", c.method, c.path, rw.Code)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package route
+
+import (
+	"github.com/owen-d/beacon-api/lib/metrics"
+	"github.com/urfave/negroni"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	requestsTotal    = metrics.NewCounter("http_requests_total", "Requests served, by route & status.", "router", "endpoint", "method", "code")
+	requestDuration  = metrics.NewHistogram("http_request_duration_seconds", "Request latency, by route.", nil, "router", "endpoint", "method")
+	requestsInFlight = metrics.NewGauge("http_requests_in_flight", "Requests currently being served.")
+)
+
+// instrument records the requests of an endpoint, labelled by its router's name & its handler
+func (self *Endpoint) instrument(r *Router) negroni.Handler {
+	router, endpoint := r.Name, handlerName(self)
+	if endpoint == "" {
+		endpoint = self.SubPath
+	}
+
+	return negroni.HandlerFunc(func(rw http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+		start := time.Now()
+		requestsInFlight.Inc()
+		defer requestsInFlight.Dec()
+
+		next(rw, req)
+
+		status := http.StatusOK
+		if res, ok := rw.(negroni.ResponseWriter); ok && res.Status() != 0 {
+			status = res.Status()
+		}
+		requestsTotal.Inc(router, endpoint, self.Method, strconv.Itoa(status))
+		requestDuration.Since(start, router, endpoint, self.Method)
+	})
+}
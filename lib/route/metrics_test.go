@@ -0,0 +1,42 @@
+package route
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/owen-d/beacon-api/lib/metrics"
+	"github.com/urfave/negroni"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Teapot(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	rw.WriteHeader(http.StatusTeapot)
+}
+
+func TestInstrument(t *testing.T) {
+	router := &Router{
+		Path: "/tea",
+		Name: "teaRouter",
+		Endpoints: []*Endpoint{
+			&Endpoint{
+				Method:   http.MethodGet,
+				Handlers: []negroni.Handler{negroni.HandlerFunc(Teapot)},
+				SubPath:  "/pot",
+			},
+		},
+	}
+	root := Inject(router, mux.NewRouter())
+	root.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/tea/pot", nil))
+
+	body := string(metrics.Default.Render())
+	for _, line := range []string{
+		`http_requests_total{router="teaRouter",endpoint="Teapot",method="GET",code="418"} 1`,
+		`http_request_duration_seconds_count{router="teaRouter",endpoint="Teapot",method="GET"} 1`,
+		`http_requests_in_flight 0`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expected %q in:\n%s", line, body)
+		}
+	}
+}
@@ -0,0 +1,393 @@
+package route
+
+import (
+	"encoding/json"
+	"github.com/gocql/gocql"
+	"github.com/owen-d/beacon-api/lib/apierr"
+	"github.com/urfave/negroni"
+	"net/http"
+	"path"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const openAPIVersion = "3.0.3"
+
+// Body documents a request or response which isn't a json object
+type Body struct {
+	Status      int
+	ContentType string
+	Description string
+}
+
+var (
+	// NoBody documents a request without a body, or a response with an empty 200
+	NoBody = &Body{Status: http.StatusOK, Description: "empty"}
+	// NoContent documents a 204 response
+	NoContent = &Body{Status: http.StatusNoContent, Description: "no content"}
+	// Redirect documents a response sending the browser elsewhere
+	Redirect = &Body{Status: http.StatusFound, Description: "redirect"}
+	// Page documents a response rendering html
+	Page = &Body{Status: http.StatusOK, ContentType: "text/html", Description: "html page"}
+	// Script documents a response serving javascript
+	Script = &Body{Status: http.StatusOK, ContentType: "application/javascript", Description: "javascript"}
+
+	pathParam = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+	timeType  = reflect.TypeOf(time.Time{})
+	uuidType  = reflect.TypeOf(gocql.UUID{})
+	rawType   = reflect.TypeOf(json.RawMessage{})
+	bytesType = reflect.TypeOf([]byte{})
+	errorType = reflect.TypeOf(apierr.Error{})
+)
+
+type OpenAPI struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       *Info                            `json:"info"`
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components *Components                      `json:"components"`
+}
+
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas"`
+}
+
+type Operation struct {
+	OperationId string               `json:"operationId,omitempty"`
+	Tags        []string             `json:"tags,omitempty"`
+	Parameters  []*Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+// Undocumented lists the endpoints missing a response, or a request when their method carries a body
+func Undocumented(routers ...*Router) []string {
+	missing := []string{}
+	walk(routers, "", func(p string, _ *Router, e *Endpoint) {
+		if e.Response == nil || (e.Request == nil && hasBody(e.Method)) {
+			missing = append(missing, e.Method+" "+p)
+		}
+	})
+	return missing
+}
+
+// Spec generates an openapi document from the given routers
+func Spec(info *Info, routers ...*Router) *OpenAPI {
+	doc := &OpenAPI{
+		OpenAPI:    openAPIVersion,
+		Info:       info,
+		Paths:      map[string]map[string]*Operation{},
+		Components: &Components{Schemas: map[string]*Schema{}},
+	}
+
+	walk(routers, "", func(p string, r *Router, e *Endpoint) {
+		template := pathParam.ReplaceAllString(p, "{$1}")
+		if doc.Paths[template] == nil {
+			doc.Paths[template] = map[string]*Operation{}
+		}
+		doc.Paths[template][strings.ToLower(e.Method)] = doc.operation(p, r, e)
+	})
+
+	return doc
+}
+
+func (self *OpenAPI) operation(p string, r *Router, e *Endpoint) *Operation {
+	op := &Operation{
+		OperationId: handlerName(e),
+		Responses:   map[string]*Response{},
+	}
+	if r.Name != "" {
+		op.Tags = []string{r.Name}
+	}
+
+	for _, match := range pathParam.FindAllStringSubmatch(p, -1) {
+		op.Parameters = append(op.Parameters, &Parameter{
+			Name:     match[1],
+			In:       "path",
+			Required: true,
+			Schema:   &Schema{Type: "string"},
+		})
+	}
+
+	if e.Request != nil {
+		if body, ok := e.Request.(*Body); !ok {
+			op.RequestBody = &RequestBody{
+				Required: true,
+				Content:  map[string]*MediaType{"application/json": {Schema: self.schema(reflect.TypeOf(e.Request))}},
+			}
+		} else if body != NoBody {
+			op.RequestBody = &RequestBody{Required: true, Content: map[string]*MediaType{body.ContentType: {}}}
+		}
+	}
+
+	// every endpoint fails in the same shape
+	op.Responses["default"] = &Response{
+		Description: "error",
+		Content:     map[string]*MediaType{"application/json": {Schema: self.schema(errorType)}},
+	}
+
+	switch res := e.Response.(type) {
+	case nil:
+		op.Responses["default"].Description = "undocumented"
+	case *Body:
+		documented := &Response{Description: res.Description}
+		if res.ContentType != "" {
+			documented.Content = map[string]*MediaType{res.ContentType: {}}
+		}
+		op.Responses[statusKey(res.Status)] = documented
+	default:
+		status := e.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		op.Responses[statusKey(status)] = &Response{
+			Description: http.StatusText(status),
+			Content:     map[string]*MediaType{"application/json": {Schema: self.schema(reflect.TypeOf(res))}},
+		}
+	}
+
+	return op
+}
+
+// schema describes a type the way encoding/json would marshal it, registering named structs as components
+func (self *OpenAPI) schema(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case uuidType:
+		return &Schema{Type: "string", Format: "uuid"}
+	case rawType:
+		return &Schema{}
+	case bytesType:
+		return &Schema{Type: "string", Format: "byte"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: self.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: self.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return self.object(t)
+		}
+		name := path.Base(t.PkgPath()) + "." + t.Name()
+		if _, ok := self.Components.Schemas[name]; !ok {
+			// reserve the name first, as structs may refer to themselves
+			self.Components.Schemas[name] = &Schema{}
+			self.Components.Schemas[name] = self.object(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + name}
+	}
+
+	// interfaces & anything else may hold any value
+	return &Schema{}
+}
+
+func (self *OpenAPI) object(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+
+		name := field.Name
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		if tagged := strings.Split(tag, ",")[0]; tagged != "" {
+			name = tagged
+		}
+
+		// untagged embedded structs are flattened, as encoding/json does
+		if field.Anonymous && tag == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				for k, v := range self.object(embedded).Properties {
+					s.Properties[k] = v
+				}
+				continue
+			}
+		}
+
+		s.Properties[name] = self.schema(field.Type)
+	}
+	return s
+}
+
+// walk visits each endpoint along with its full path
+func walk(routers []*Router, prefix string, visit func(string, *Router, *Endpoint)) {
+	for _, r := range routers {
+		p := prefix + r.Path
+		for _, e := range r.Endpoints {
+			visit(p+e.SubPath, r, e)
+		}
+		walk(r.SubRoutes, p, visit)
+	}
+}
+
+// handlerName names an operation after its final handler, i.e. PostMessage
+func handlerName(e *Endpoint) string {
+	if len(e.Handlers) == 0 {
+		return ""
+	}
+	fn, ok := e.Handlers[len(e.Handlers)-1].(negroni.HandlerFunc)
+	if !ok {
+		return ""
+	}
+	f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer())
+	if f == nil {
+		return ""
+	}
+	name := strings.TrimSuffix(f.Name(), "-fm")
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+func hasBody(method string) bool {
+	return method == http.MethodPost || method == http.MethodPut || method == http.MethodPatch
+}
+
+func statusKey(status int) string {
+	return strconv.Itoa(status)
+}
+
+// Docs serves the openapi document of a route tree alongside a browsable ui
+type Docs struct {
+	Info    *Info
+	Routers []*Router
+	// RedocPath is the file holding redoc's standalone bundle, which the ui loads from us rather than a cdn
+	RedocPath string
+	once      sync.Once
+	doc       []byte
+	err       error
+}
+
+// Endpoints serves /openapi.json, /docs & its script, to be mounted on a versioned router
+func (self *Docs) Endpoints() []*Endpoint {
+	return []*Endpoint{
+		&Endpoint{
+			Method:   http.MethodGet,
+			Handlers: []negroni.Handler{negroni.HandlerFunc(self.ServeSpec)},
+			SubPath:  "/openapi.json",
+			Response: &OpenAPI{},
+		},
+		&Endpoint{
+			Method:   http.MethodGet,
+			Handlers: []negroni.Handler{negroni.HandlerFunc(self.ServeUI)},
+			SubPath:  "/docs",
+			Response: Page,
+		},
+		&Endpoint{
+			Method:   http.MethodGet,
+			Handlers: []negroni.Handler{negroni.HandlerFunc(self.ServeRedoc)},
+			SubPath:  "/docs/redoc.standalone.js",
+			Response: Script,
+		},
+	}
+}
+
+func (self *Docs) ServeSpec(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	// the tree is complete by the time it's served, so the document is only generated once
+	self.once.Do(func() {
+		self.doc, self.err = json.Marshal(Spec(self.Info, self.Routers...))
+	})
+
+	if self.err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(self.doc)
+}
+
+func (self *Docs) ServeUI(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// redoc injects its styles & runs search in a blob worker; everything else comes from us
+	rw.Header().Set("Content-Security-Policy", "default-src 'none'; script-src 'self'; style-src 'self' 'unsafe-inline'; img-src 'self' data:; font-src 'self'; connect-src 'self'; worker-src blob:")
+	rw.WriteHeader(http.StatusOK)
+	rw.Write([]byte(docsPage))
+}
+
+// ServeRedoc serves the vendored redoc bundle, 404ing if it wasn't installed
+func (self *Docs) ServeRedoc(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if self.RedocPath == "" {
+		http.NotFound(rw, r)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/javascript")
+	http.ServeFile(rw, r, self.RedocPath)
+}
+
+// docsPage renders the spec next to it with redoc
+const docsPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>sharecrows api</title>
+</head>
+<body>
+<redoc spec-url="openapi.json"></redoc>
+<script src="docs/redoc.standalone.js"></script>
+</body>
+</html>
+`
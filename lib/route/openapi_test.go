@@ -0,0 +1,99 @@
+package route
+
+import (
+	"github.com/gocql/gocql"
+	"github.com/urfave/negroni"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type embedded struct {
+	Note string `json:"note"`
+}
+
+type widget struct {
+	embedded
+	Id       *gocql.UUID       `json:"id"`
+	Name     string            `json:"name,omitempty"`
+	Parts    []*widget         `json:"parts"`
+	Labels   map[string]string `json:"labels"`
+	Created  time.Time         `json:"created"`
+	Secret   string            `json:"-"`
+	internal string
+}
+
+func TestSchema(t *testing.T) {
+	doc := &OpenAPI{Components: &Components{Schemas: map[string]*Schema{}}}
+
+	ref := doc.schema(reflect.TypeOf(&widget{}))
+	if ref.Ref != "#/components/schemas/route.widget" {
+		t.Fatal("expected a reference, got", ref.Ref)
+	}
+
+	s := doc.Components.Schemas["route.widget"]
+	expected := map[string]string{"note": "string", "id": "string", "name": "string", "parts": "array", "labels": "object", "created": "string"}
+	if len(s.Properties) != len(expected) {
+		t.Errorf("expected %d properties, got %+v", len(expected), s.Properties)
+	}
+	for name, kind := range expected {
+		if p := s.Properties[name]; p == nil || p.Type != kind {
+			t.Errorf("expected %s to be a %s, got %+v", name, kind, p)
+		}
+	}
+	if s.Properties["parts"].Items.Ref != ref.Ref {
+		t.Error("expected recursive items to refer back to the component")
+	}
+}
+
+func TestUndocumented(t *testing.T) {
+	noop := negroni.HandlerFunc(func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {})
+	r := &Router{
+		Path: "/things",
+		Endpoints: []*Endpoint{
+			&Endpoint{Method: http.MethodGet, Handlers: []negroni.Handler{noop}, Response: &widget{}},
+			&Endpoint{Method: http.MethodPost, Handlers: []negroni.Handler{noop}, Response: &widget{}},
+			&Endpoint{Method: http.MethodDelete, Handlers: []negroni.Handler{noop}, SubPath: "/{id:[0-9]+}"},
+		},
+	}
+
+	missing := Undocumented(r)
+	if len(missing) != 2 || missing[0] != "POST /things" || missing[1] != "DELETE /things/{id:[0-9]+}" {
+		t.Error("unexpected undocumented endpoints:", missing)
+	}
+
+	if _, ok := Spec(&Info{}, r).Paths["/things/{id}"]; !ok {
+		t.Error("expected path patterns to be stripped")
+	}
+}
+
+func TestServeRedoc(t *testing.T) {
+	bundle := filepath.Join(t.TempDir(), "redoc.standalone.js")
+	if err := ioutil.WriteFile(bundle, []byte("/* redoc */"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	docs := &Docs{RedocPath: bundle}
+
+	rw := httptest.NewRecorder()
+	docs.ServeUI(rw, httptest.NewRequest(http.MethodGet, "/v1/docs", nil), nil)
+	if strings.Contains(rw.Body.String(), "https://") || !strings.Contains(rw.Header().Get("Content-Security-Policy"), "script-src 'self'") {
+		t.Error("expected the docs page to load scripts from us alone")
+	}
+
+	rw = httptest.NewRecorder()
+	docs.ServeRedoc(rw, httptest.NewRequest(http.MethodGet, "/v1/docs/redoc.standalone.js", nil), nil)
+	if rw.Code != http.StatusOK || rw.Body.String() != "/* redoc */" || rw.Header().Get("Content-Type") != "application/javascript" {
+		t.Errorf("expected the vendored bundle, got %d %s", rw.Code, rw.Body.String())
+	}
+
+	rw = httptest.NewRecorder()
+	(&Docs{RedocPath: filepath.Join(t.TempDir(), "missing.js")}).ServeRedoc(rw, httptest.NewRequest(http.MethodGet, "/v1/docs/redoc.standalone.js", nil), nil)
+	if rw.Code != http.StatusNotFound {
+		t.Error("expected a missing bundle to 404, got", rw.Code)
+	}
+}
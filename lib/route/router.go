@@ -3,18 +3,10 @@ package route
 import (
 	"fmt"
 	"github.com/gorilla/mux"
-	"github.com/rs/cors"
+	"github.com/owen-d/beacon-api/lib/idempotency"
+	"github.com/owen-d/beacon-api/lib/ratelimit"
 	"github.com/urfave/negroni"
-)
-
-var (
-	tenMinutesInSeconds = 60 * 10
-	CorsHandler         = cors.New(cors.Options{
-		AllowedOrigins: []string{"*"},
-		AllowedMethods: []string{"HEAD", "GET", "POST", "PUT", "PATCH", "DELETE"},
-		AllowedHeaders: []string{"*"},
-		MaxAge:         tenMinutesInSeconds,
-	})
+	"net/http"
 )
 
 type Endpoint struct {
@@ -22,6 +14,15 @@ type Endpoint struct {
 	Method   string
 	Handlers []negroni.Handler
 	SubPath  string
+	// Request & Response are values of the json bodies, documenting the endpoint in the openapi spec. See Body for others.
+	Request  interface{}
+	Response interface{}
+	// Status of a successful json response, defaulting to 200
+	Status int
+	// RateLimit overrides the router's limiter. It runs after the default middleware, so signed in users are limited individually.
+	RateLimit *ratelimit.Limiter
+	// Sensitive responses hold credentials, so they're never stored to replay idempotent requests
+	Sensitive bool
 }
 
 type Router struct {
@@ -31,9 +32,17 @@ type Router struct {
 	SubRoutes         []*Router
 	Endpoints         []*Endpoint
 	Name              string
+	// RateLimit applies to every endpoint without its own, & is inherited by subroutes
+	RateLimit *ratelimit.Limiter
+	// IPRateLimit limits by ip ahead of the default middleware, so requests failing authentication are limited too. It's inherited by subroutes.
+	IPRateLimit *ratelimit.Limiter
+	// Cors is inherited by subroutes as well. Without one, cross origin requests are refused.
+	Cors *Cors
+	// Idempotency replays retried POST & PUT requests sent with an Idempotency-Key, & is inherited by subroutes
+	Idempotency *idempotency.Keys
 }
 
-func (r *Router) build(rootRouter *mux.Router, prependMiddleware []negroni.Handler) {
+func (r *Router) build(rootRouter *mux.Router, prependMiddleware []negroni.Handler, limiter *ratelimit.Limiter, ipLimiter *ratelimit.Limiter, policy *Cors, keys *idempotency.Keys) {
 	// build a new router from the path prefix, upon which all subsequent method routs will be mounted
 	r.Router = rootRouter.PathPrefix(r.Path).Subrouter()
 
@@ -42,21 +51,64 @@ func (r *Router) build(rootRouter *mux.Router, prependMiddleware []negroni.Handl
 	concatedDefaultMiddleware = append(concatedDefaultMiddleware, prependMiddleware...)
 	r.DefaultMiddleware = append(concatedDefaultMiddleware, r.DefaultMiddleware...)
 
+	if r.RateLimit == nil {
+		r.RateLimit = limiter
+	}
+	if r.IPRateLimit == nil {
+		r.IPRateLimit = ipLimiter
+	}
+	overridesCors := r.Cors != nil && r.Cors != policy
+	if r.Cors == nil {
+		r.Cors = policy
+	}
+	if r.Idempotency == nil {
+		r.Idempotency = keys
+	}
+
 	// instantiate a new negroni middleware manageer,
 	// attach all the middleware functions to it, & bind those functions to a method on a subrouter
 	for _, endpoint := range r.Endpoints {
 		// allow a specified subpath to be handled on the same router for convenience, i.e. GET /item/:id can use a router on /item with a subpath /:id
 		sPath := endpoint.SubPath
 
-		handler := negroni.New(r.DefaultMiddleware...).With(endpoint.Handlers...)
+		// instrumented first, so the latency & status of rejected requests are recorded too
+		handler := negroni.New(endpoint.instrument(r))
+		// cors precedes auth, so browsers may read the errors of rejected requests
+		if r.Cors != nil {
+			handler = handler.With(r.Cors)
+		}
+		if r.IPRateLimit != nil {
+			handler = handler.With(negroni.HandlerFunc(r.IPRateLimit.IPMiddleware))
+		}
+		handler = handler.With(r.DefaultMiddleware...)
+		if limit := endpoint.limiter(r); limit != nil {
+			handler = handler.With(negroni.HandlerFunc(limit.Middleware))
+		}
+		// after the limiter, so replays count against it
+		if r.Idempotency != nil && !endpoint.Sensitive && (endpoint.Method == http.MethodPost || endpoint.Method == http.MethodPut) {
+			handler = handler.With(negroni.HandlerFunc(r.Idempotency.Middleware))
+		}
+		handler = handler.With(endpoint.Handlers...)
 		fmtStr := fmt.Sprintf("\n\trPath: %+v\n\trName: %+v\n\tsPath: %+v\n\tmethod: %+v\n\n", r.Path, r.Name, sPath, endpoint.Method)
 		r.Router.Handle(sPath, handler).Methods(endpoint.Method).Name(fmtStr)
 	}
 
 	//recursively build subroutes
 	for _, route := range r.SubRoutes {
-		route.build(r.Router, r.DefaultMiddleware)
+		route.build(r.Router, r.DefaultMiddleware, r.RateLimit, r.IPRateLimit, r.Cors, r.Idempotency)
+	}
+
+	// after subroutes, so those overriding the policy answer their own preflights
+	if overridesCors {
+		r.Router.PathPrefix("/").Methods(http.MethodOptions).HandlerFunc(r.Cors.preflight)
+	}
+}
+
+func (self *Endpoint) limiter(r *Router) *ratelimit.Limiter {
+	if self.RateLimit != nil {
+		return self.RateLimit
 	}
+	return r.RateLimit
 }
 
 // Inject recursively builds all routes & related middleware via endpoints, adding their routes onto the root mux router & returning it.
@@ -67,7 +119,7 @@ func Inject(router *Router, root *mux.Router) *mux.Router {
 	}
 
 	// recursive call to build all deps
-	router.build(root, nil)
+	router.build(root, nil, nil, nil, nil, nil)
 
 	return root
 
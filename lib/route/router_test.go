@@ -0,0 +1,43 @@
+package route
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/owen-d/beacon-api/lib/ratelimit"
+	"github.com/urfave/negroni"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPRateLimitPrecedesAuth(t *testing.T) {
+	// rejects every request, as failed sign ins would
+	deny := func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		rw.WriteHeader(http.StatusUnauthorized)
+	}
+
+	router := &Router{
+		Path:              "/api",
+		DefaultMiddleware: []negroni.Handler{negroni.HandlerFunc(deny)},
+		IPRateLimit:       ratelimit.New(60, 2, 0),
+		SubRoutes: []*Router{
+			&Router{
+				Path: "/inherited",
+				Endpoints: []*Endpoint{
+					&Endpoint{Method: http.MethodGet, Handlers: []negroni.Handler{negroni.HandlerFunc(Teapot)}, SubPath: "/pot"},
+				},
+			},
+		},
+	}
+	root := Inject(router, mux.NewRouter())
+
+	statuses := []int{}
+	for i := 0; i < 3; i++ {
+		rw := httptest.NewRecorder()
+		root.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/api/inherited/pot", nil))
+		statuses = append(statuses, rw.Code)
+	}
+
+	if statuses[0] != http.StatusUnauthorized || statuses[2] != http.StatusTooManyRequests {
+		t.Error("expected unauthenticated requests to be limited by ip, got", statuses)
+	}
+}
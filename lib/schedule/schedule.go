@@ -0,0 +1,194 @@
+// Package schedule describes recurring time windows in which a deployment should broadcast a given message
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"github.com/owen-d/beacon-api/lib/redirect"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	weekdays = map[string]time.Weekday{
+		"sun": time.Sunday,
+		"mon": time.Monday,
+		"tue": time.Tuesday,
+		"wed": time.Wednesday,
+		"thu": time.Thursday,
+		"fri": time.Friday,
+		"sat": time.Saturday,
+	}
+)
+
+// Schedule is a set of recurring windows evaluated in a single time zone. Earlier windows take precedence when they overlap.
+type Schedule struct {
+	TimeZone string `json:"time_zone"`
+	// DefaultMessageName is deployed outside of every window. When empty, the last deployed message is left in place.
+	DefaultMessageName string    `json:"default_message_name,omitempty"`
+	Windows            []*Window `json:"windows"`
+}
+
+// Window deploys MessageName between Start & End (formatted as "15:04"). Windows where End precedes Start wrap past midnight.
+type Window struct {
+	MessageName string `json:"message_name"`
+	// Days restricts the window to the weekdays it starts on, i.e. "mon". Empty means every day.
+	Days  []string `json:"days,omitempty"`
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+}
+
+// Transition is a moment at which the deployed message changes
+type Transition struct {
+	At          time.Time `json:"at"`
+	MessageName string    `json:"message_name"`
+}
+
+// Validate ensures a schedule can be evaluated
+func (self *Schedule) Validate() error {
+	if _, err := time.LoadLocation(self.TimeZone); err != nil {
+		return err
+	}
+
+	if len(self.Windows) == 0 {
+		return errors.New("schedule requires at least one window")
+	}
+
+	for i, w := range self.Windows {
+		if err := w.validate(); err != nil {
+			return fmt.Errorf("window %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// MessageNames lists every message the schedule may deploy
+func (self *Schedule) MessageNames() []string {
+	res := make([]string, 0, len(self.Windows)+1)
+	if self.DefaultMessageName != "" {
+		res = append(res, self.DefaultMessageName)
+	}
+	for _, w := range self.Windows {
+		res = append(res, w.MessageName)
+	}
+	return res
+}
+
+// MessageAt returns the message which should be deployed at t. An empty string means the deployment should be left as is.
+func (self *Schedule) MessageAt(t time.Time) string {
+	loc, err := time.LoadLocation(self.TimeZone)
+	if err != nil {
+		return ""
+	}
+
+	local := t.In(loc)
+	for _, w := range self.Windows {
+		if w.contains(local) {
+			return w.MessageName
+		}
+	}
+	return self.DefaultMessageName
+}
+
+// Transitions lists every change of message in (from, until], given the message deployed at from.
+func (self *Schedule) Transitions(current string, from time.Time, until time.Time) ([]*Transition, error) {
+	loc, err := time.LoadLocation(self.TimeZone)
+	if err != nil {
+		return nil, err
+	}
+
+	// every window boundary is a candidate transition. Begin a day early to catch windows wrapping past midnight.
+	candidates := make([]time.Time, 0)
+	start := from.In(loc).AddDate(0, 0, -1)
+	for day := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, loc); !day.After(until); day = day.AddDate(0, 0, 1) {
+		for _, w := range self.Windows {
+			startMin, _ := redirect.ParseClock(w.Start)
+			endMin, _ := redirect.ParseClock(w.End)
+			if endMin <= startMin {
+				endMin += 24 * 60
+			}
+
+			for _, min := range []int{startMin, endMin} {
+				// time.Date normalizes overflowing hours & handles daylight savings shifts
+				at := time.Date(day.Year(), day.Month(), day.Day(), min/60, min%60, 0, 0, loc)
+				if at.After(from) && !at.After(until) {
+					candidates = append(candidates, at)
+				}
+			}
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Before(candidates[j]) })
+
+	res := make([]*Transition, 0)
+	for _, at := range candidates {
+		next := self.MessageAt(at)
+		if next == "" || next == current {
+			continue
+		}
+		current = next
+		res = append(res, &Transition{At: at, MessageName: next})
+	}
+	return res, nil
+}
+
+func (self *Window) validate() error {
+	if self.MessageName == "" {
+		return errors.New("message_name required")
+	}
+
+	start, startErr := redirect.ParseClock(self.Start)
+	if startErr != nil {
+		return startErr
+	}
+
+	end, endErr := redirect.ParseClock(self.End)
+	if endErr != nil {
+		return endErr
+	}
+
+	if start == end {
+		return errors.New("window start & end must differ")
+	}
+
+	for _, day := range self.Days {
+		if _, ok := weekdays[strings.ToLower(day)]; !ok {
+			return errors.New("unknown day: " + day)
+		}
+	}
+	return nil
+}
+
+// contains expects local to already be in the schedule's time zone
+func (self *Window) contains(local time.Time) bool {
+	start, startErr := redirect.ParseClock(self.Start)
+	end, endErr := redirect.ParseClock(self.End)
+	if startErr != nil || endErr != nil {
+		return false
+	}
+
+	minute := local.Hour()*60 + local.Minute()
+
+	if start < end {
+		return minute >= start && minute < end && self.onDay(local.Weekday())
+	}
+
+	// wrapping windows belong to the day they start on
+	if minute >= start {
+		return self.onDay(local.Weekday())
+	}
+	return minute < end && self.onDay(local.AddDate(0, 0, -1).Weekday())
+}
+
+func (self *Window) onDay(day time.Weekday) bool {
+	if len(self.Days) == 0 {
+		return true
+	}
+	for _, d := range self.Days {
+		if weekdays[strings.ToLower(d)] == day {
+			return true
+		}
+	}
+	return false
+}
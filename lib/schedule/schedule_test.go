@@ -0,0 +1,97 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func menuSchedule() *Schedule {
+	return &Schedule{
+		TimeZone:           "America/New_York",
+		DefaultMessageName: "closed",
+		Windows: []*Window{
+			&Window{MessageName: "breakfast", Start: "06:00", End: "11:00"},
+			&Window{MessageName: "lunch", Start: "11:00", End: "15:00", Days: []string{"mon", "tue", "wed", "thu", "fri"}},
+			&Window{MessageName: "late-night", Start: "22:00", End: "02:00", Days: []string{"fri"}},
+		},
+	}
+}
+
+func TestMessageAt(t *testing.T) {
+	loc, _ := time.LoadLocation("America/New_York")
+	s := menuSchedule()
+
+	// August 7th 2017 was a Monday
+	cases := []struct {
+		name     string
+		at       time.Time
+		expected string
+	}{
+		{"breakfast", time.Date(2017, time.August, 7, 6, 0, 0, 0, loc), "breakfast"},
+		{"lunch", time.Date(2017, time.August, 7, 12, 30, 0, 0, loc), "lunch"},
+		{"no-weekend-lunch", time.Date(2017, time.August, 12, 12, 30, 0, 0, loc), "closed"},
+		{"late-night-start", time.Date(2017, time.August, 11, 23, 0, 0, 0, loc), "late-night"},
+		{"late-night-wraps", time.Date(2017, time.August, 12, 1, 0, 0, 0, loc), "late-night"},
+		{"late-night-other-day", time.Date(2017, time.August, 8, 1, 0, 0, 0, loc), "closed"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if res := s.MessageAt(c.at); res != c.expected {
+				t.Error("expected", c.expected, "got", res)
+			}
+		})
+	}
+}
+
+func TestTransitions(t *testing.T) {
+	loc, _ := time.LoadLocation("America/New_York")
+	s := menuSchedule()
+
+	from := time.Date(2017, time.August, 7, 0, 0, 0, 0, loc)
+	transitions, err := s.Transitions("closed", from, from.Add(24*time.Hour))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := []string{"breakfast", "lunch", "closed"}
+	if len(transitions) != len(expected) {
+		t.Error("expected", len(expected), "transitions, got", len(transitions))
+		return
+	}
+
+	for i, tr := range transitions {
+		if tr.MessageName != expected[i] {
+			t.Error("expected", expected[i], "got", tr.MessageName)
+		}
+	}
+
+	if !transitions[0].At.Equal(time.Date(2017, time.August, 7, 6, 0, 0, 0, loc)) {
+		t.Error("unexpected breakfast transition time:", transitions[0].At)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		if err := menuSchedule().Validate(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("bad-day", func(t *testing.T) {
+		s := menuSchedule()
+		s.Windows[0].Days = []string{"someday"}
+		if s.Validate() == nil {
+			t.Error("false positive: unknown day should be invalid")
+		}
+	})
+
+	t.Run("empty-window", func(t *testing.T) {
+		s := menuSchedule()
+		s.Windows[0].End = s.Windows[0].Start
+		if s.Validate() == nil {
+			t.Error("false positive: empty window should be invalid")
+		}
+	})
+}
@@ -0,0 +1,116 @@
+package validator
+
+import (
+	"encoding/json"
+	"net"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// MaxTitleLength is the longest title nearby will display in a notification
+	MaxTitleLength = 40
+	MaxUrlLength   = 2048
+	// MaxAttachmentSize is the proximity api's limit on attachment data, in bytes
+	MaxAttachmentSize = 1024
+	// attachments always link to our short url rather than the message url, so sizing uses a representative one
+	sampleAttachmentUrl = "https://our.sharecro.ws/bkn/000000000000"
+)
+
+// ContentRules validates user supplied content which is eventually broadcast by beacons
+type ContentRules struct {
+	// Blocklist holds domains which may not be linked to. Subdomains are blocked as well.
+	Blocklist []string
+}
+
+// CheckTitle ensures a title fits in a nearby notification & its proximity attachment
+func (self *ContentRules) CheckTitle(field string, title string) *FieldError {
+	if strings.TrimSpace(title) == "" {
+		return &FieldError{Field: field, Message: "required"}
+	}
+
+	if utf8.RuneCountInString(title) > MaxTitleLength {
+		return &FieldError{Field: field, Message: "must be at most 40 characters"}
+	}
+
+	for _, r := range title {
+		if unicode.IsControl(r) {
+			return &FieldError{Field: field, Message: "must not contain control characters"}
+		}
+	}
+
+	data, _ := json.Marshal(struct {
+		Title string `json:"title"`
+		Url   string `json:"url"`
+	}{title, sampleAttachmentUrl})
+
+	if len(data) > MaxAttachmentSize {
+		return &FieldError{Field: field, Message: "exceeds the attachment size limit"}
+	}
+
+	return nil
+}
+
+// CheckUrl ensures a url is an absolute http(s) link to a domain which isn't blocked
+func (self *ContentRules) CheckUrl(field string, rawUrl string) *FieldError {
+	if rawUrl == "" {
+		return &FieldError{Field: field, Message: "required"}
+	}
+
+	if len(rawUrl) > MaxUrlLength {
+		return &FieldError{Field: field, Message: "must be at most 2048 characters"}
+	}
+
+	parsed, parseErr := url.Parse(rawUrl)
+	if parseErr != nil {
+		return &FieldError{Field: field, Message: "invalid url"}
+	}
+
+	if scheme := strings.ToLower(parsed.Scheme); scheme != "http" && scheme != "https" {
+		return &FieldError{Field: field, Message: "must use http or https"}
+	}
+
+	host := strings.ToLower(parsed.Host)
+	if h, _, splitErr := net.SplitHostPort(host); splitErr == nil {
+		host = h
+	}
+
+	if host == "" || parsed.User != nil {
+		return &FieldError{Field: field, Message: "invalid url"}
+	}
+
+	if self.blocked(host) {
+		return &FieldError{Field: field, Message: "links to a blocked domain"}
+	}
+
+	return nil
+}
+
+func (self *ContentRules) blocked(host string) bool {
+	host = strings.TrimSuffix(host, ".")
+	for _, domain := range self.Blocklist {
+		domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// Collect drops nil field errors, returning a RequestErr if any remain
+func Collect(message string, errs ...*FieldError) *RequestErr {
+	res := make([]*FieldError, 0, len(errs))
+	for _, err := range errs {
+		if err != nil {
+			res = append(res, err)
+		}
+	}
+
+	if len(res) == 0 {
+		return nil
+	}
+
+	return &RequestErr{Status: 400, Message: message, Errors: res}
+}
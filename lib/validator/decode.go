@@ -0,0 +1,68 @@
+package validator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// MaxBodySize caps json request bodies, in bytes
+const MaxBodySize = 1 << 20
+
+// Decode strictly reads a json body into v, rejecting unknown fields & oversized bodies, then checks v's validate tags
+func Decode(r *http.Request, v interface{}) *RequestErr {
+	if r.Body == nil {
+		return &RequestErr{Status: http.StatusBadRequest, Message: "request body required"}
+	}
+
+	dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, MaxBodySize))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(v); err != nil {
+		return decodeErr(err)
+	}
+
+	// a single value is expected; anything after it is most likely a malformed request
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		return &RequestErr{Status: http.StatusBadRequest, Message: "invalid json"}
+	}
+
+	return Collect("invalid request", Struct(v)...)
+}
+
+// decodeErr converts a decoding failure into the field which caused it, where possible
+func decodeErr(err error) *RequestErr {
+	var tooLarge *http.MaxBytesError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &tooLarge):
+		return &RequestErr{Status: http.StatusRequestEntityTooLarge, Message: fmt.Sprintf("request body must be at most %d bytes", MaxBodySize)}
+	case err == io.EOF:
+		return &RequestErr{Status: http.StatusBadRequest, Message: "request body required"}
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return Collect("invalid json", &FieldError{Field: typeErr.Field, Message: "must be a " + jsonKind(typeErr.Type.Kind().String())})
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return Collect("invalid json", &FieldError{Field: field, Message: "unknown field"})
+	}
+
+	return &RequestErr{Status: http.StatusBadRequest, Message: "invalid json"}
+}
+
+func jsonKind(kind string) string {
+	switch {
+	case kind == "bool":
+		return "boolean"
+	case strings.HasPrefix(kind, "int"), strings.HasPrefix(kind, "uint"), strings.HasPrefix(kind, "float"):
+		return "number"
+	case kind == "slice", kind == "array":
+		return "list"
+	case kind == "struct", kind == "map", kind == "ptr":
+		return "object"
+	}
+	return kind
+}
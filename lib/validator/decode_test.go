@@ -0,0 +1,46 @@
+package validator
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	cases := []struct {
+		name   string
+		body   string
+		status int
+		field  string
+	}{
+		{"valid", `{"kind": "a", "names": ["beef"]}`, 0, ""},
+		{"empty", ``, 400, ""},
+		{"malformed", `{"kind": `, 400, ""},
+		{"trailing data", `{"kind": "a"} {}`, 400, ""},
+		{"unknown field", `{"kind": "a", "user_id": "x"}`, 400, "user_id"},
+		{"wrong type", `{"kind": 1}`, 400, "kind"},
+		{"invalid field", `{"kind": "c"}`, 400, "kind"},
+		{"too large", `{"kind": "` + strings.Repeat("a", MaxBodySize) + `"}`, 413, ""},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(c.body))
+		err := Decode(r, &form{})
+
+		if c.status == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error %+v", c.name, err)
+			}
+			continue
+		}
+
+		if err == nil || err.Status != c.status {
+			t.Errorf("%s: expected status %d, got %+v", c.name, c.status, err)
+			continue
+		}
+		if c.field != "" && (len(err.Errors) != 1 || err.Errors[0].Field != c.field) {
+			t.Errorf("%s: expected an error on %s, got %+v", c.name, c.field, err.Errors)
+		}
+	}
+}
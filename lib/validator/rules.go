@@ -0,0 +1,189 @@
+package validator
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// TagName holds a field's comma separated rules, i.e. `validate:"required,max=40"`.
+//
+//	required    must be present: non-blank strings, non-empty lists & non-nil pointers
+//	max=n       at most n characters, n items, or a value of at most n
+//	hex         a hex encoded string
+//	url         an absolute http(s) url
+//	enum=a|b    one of the listed values
+//
+// Apart from required, rules skip empty values. On lists, hex, url & enum check each item.
+// Nested structs & lists of structs are checked as well.
+const TagName = "validate"
+
+// Struct checks the validate tags of v, which must be a struct or a pointer to one
+func Struct(v interface{}) []*FieldError {
+	return checkValue(reflect.ValueOf(v), "")
+}
+
+func checkValue(v reflect.Value, prefix string) []*FieldError {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		return checkStruct(v, prefix)
+	case reflect.Slice, reflect.Array:
+		errs := []*FieldError{}
+		for i := 0; i < v.Len(); i++ {
+			errs = append(errs, checkValue(v.Index(i), fmt.Sprintf("%s[%d]", prefix, i))...)
+		}
+		return errs
+	}
+	return nil
+}
+
+func checkStruct(v reflect.Value, prefix string) []*FieldError {
+	errs := []*FieldError{}
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+
+		// embedded structs share their parent's namespace
+		if field.Anonymous && field.Tag.Get("json") == "" {
+			errs = append(errs, checkValue(v.Field(i), prefix)...)
+			continue
+		}
+
+		name := fieldName(field, prefix)
+		if rules := field.Tag.Get(TagName); rules != "" {
+			if err := checkRules(v.Field(i), name, rules); err != nil {
+				errs = append(errs, err)
+				// a missing or malformed field has nothing further to check
+				continue
+			}
+		}
+
+		errs = append(errs, checkValue(v.Field(i), name)...)
+	}
+
+	return errs
+}
+
+// fieldName is the json path to a field, which is how clients know it
+func fieldName(field reflect.StructField, prefix string) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" || name == "-" {
+		name = field.Name
+	}
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+func checkRules(v reflect.Value, field string, rules string) *FieldError {
+	for _, rule := range strings.Split(rules, ",") {
+		name, arg := rule, ""
+		if i := strings.Index(rule, "="); i != -1 {
+			name, arg = rule[:i], rule[i+1:]
+		}
+
+		if name == "required" {
+			if empty(v) {
+				return &FieldError{Field: field, Message: "required"}
+			}
+			continue
+		}
+
+		if empty(v) || empty(deref(v)) {
+			continue
+		}
+
+		target := deref(v)
+		// string rules apply to each item of a list
+		if name != "max" && target.Kind() == reflect.Slice {
+			for i := 0; i < target.Len(); i++ {
+				if message := checkRule(deref(target.Index(i)), name, arg); message != "" {
+					return &FieldError{Field: fmt.Sprintf("%s[%d]", field, i), Message: message}
+				}
+			}
+			continue
+		}
+
+		if message := checkRule(target, name, arg); message != "" {
+			return &FieldError{Field: field, Message: message}
+		}
+	}
+	return nil
+}
+
+func checkRule(v reflect.Value, name string, arg string) string {
+	switch name {
+	case "max":
+		limit, _ := strconv.Atoi(arg)
+		switch v.Kind() {
+		case reflect.String:
+			if utf8.RuneCountInString(v.String()) > limit {
+				return fmt.Sprintf("must be at most %d characters", limit)
+			}
+		case reflect.Slice, reflect.Array, reflect.Map:
+			if v.Len() > limit {
+				return fmt.Sprintf("must have at most %d items", limit)
+			}
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if v.Int() > int64(limit) {
+				return fmt.Sprintf("must be at most %d", limit)
+			}
+		}
+	case "hex":
+		if _, err := hex.DecodeString(v.String()); err != nil {
+			return "must be hex encoded"
+		}
+	case "url":
+		parsed, err := url.Parse(v.String())
+		if err != nil || parsed.Host == "" || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return "must be an http or https url"
+		}
+	case "enum":
+		options := strings.Split(arg, "|")
+		for _, option := range options {
+			if v.String() == option {
+				return ""
+			}
+		}
+		return "must be one of " + strings.Join(options, ", ")
+	default:
+		panic("validator: unknown rule " + name)
+	}
+	return ""
+}
+
+func deref(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	return v
+}
+
+// empty reports whether a value counts as missing. Blank strings are missing, as they're never meaningful input.
+func empty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	case reflect.String:
+		return strings.TrimSpace(v.String()) == ""
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
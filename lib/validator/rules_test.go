@@ -0,0 +1,54 @@
+package validator
+
+import (
+	"strings"
+	"testing"
+)
+
+type part struct {
+	Name string `json:"name" validate:"required,hex"`
+}
+
+type form struct {
+	Title   *string  `json:"title" validate:"max=5"`
+	Kind    string   `json:"kind" validate:"required,enum=a|b"`
+	Link    string   `json:"link" validate:"url"`
+	Names   []string `json:"names" validate:"max=2,hex"`
+	Parts   []*part  `json:"parts"`
+	Ignored string
+}
+
+func str(s string) *string {
+	return &s
+}
+
+func TestStruct(t *testing.T) {
+	cases := []struct {
+		name   string
+		form   *form
+		fields []string
+	}{
+		{"valid", &form{Title: str("hello"), Kind: "a", Link: "https://sharecro.ws", Names: []string{"beef"}}, nil},
+		{"empty optional fields", &form{Kind: "b", Title: str("")}, nil},
+		{"required", &form{Kind: "  "}, []string{"kind"}},
+		{"max characters", &form{Kind: "a", Title: str(strings.Repeat("é", 6))}, []string{"title"}},
+		{"enum", &form{Kind: "c"}, []string{"kind"}},
+		{"url", &form{Kind: "a", Link: "javascript:alert(1)"}, []string{"link"}},
+		{"max items", &form{Kind: "a", Names: []string{"aa", "bb", "cc"}}, []string{"names"}},
+		{"hex items", &form{Kind: "a", Names: []string{"aa", "zz"}}, []string{"names[1]"}},
+		{"nested", &form{Kind: "a", Parts: []*part{{"aa"}, {""}, {"x"}}}, []string{"parts[1].name", "parts[2].name"}},
+	}
+
+	for _, c := range cases {
+		errs := Struct(c.form)
+		if len(errs) != len(c.fields) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.fields, errs)
+			continue
+		}
+		for i, err := range errs {
+			if err.Field != c.fields[i] {
+				t.Errorf("%s: expected %s, got %s", c.name, c.fields[i], err.Field)
+			}
+		}
+	}
+}
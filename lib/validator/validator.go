@@ -1,7 +1,7 @@
 package validator
 
 import (
-	"encoding/json"
+	"github.com/owen-d/beacon-api/lib/apierr"
 	"net/http"
 )
 
@@ -10,18 +10,8 @@ type JSONValidator interface {
 	Validate(*http.Request) *RequestErr
 }
 
-type RequestErr struct {
-	Status  int    `json:-`
-	Message string `json:"message"`
-}
+// RequestErr is the error model of every response. See lib/apierr.
+type RequestErr = apierr.Error
 
-func (self *RequestErr) Flush(rw http.ResponseWriter) {
-	if self.Message == "" {
-		self.Message = http.StatusText(self.Status)
-	}
-	jsonData, _ := json.Marshal(self)
-	// Add headers to header map before flushing them with WriteHeader
-	rw.Header().Set("Content-Type", "application/json")
-	rw.WriteHeader(self.Status)
-	rw.Write(jsonData)
-}
+// FieldError pinpoints a single invalid input
+type FieldError = apierr.FieldError
@@ -1,15 +1,19 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/owen-d/beacon-api/api"
 	"github.com/owen-d/beacon-api/config"
 	"github.com/owen-d/beacon-api/lib/beaconclient"
+	"github.com/owen-d/beacon-api/lib/logging"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"strconv"
+	"syscall"
 )
 
 var (
@@ -37,15 +41,17 @@ func loadConf() *config.JsonConfig {
 func main() {
 	// init w/ google configs
 	conf := loadConf()
-	env := api.Env{conf}
+	logging.Setup(os.Stdout, conf.LogLevel)
+	env := &api.Env{Conf: conf}
 
-	// build router from bound env
-	handler := env.Init()
-
-	fmt.Printf("sharecrows api live on port %v\n", conf.Port)
-
-	http.ListenAndServe(":"+strconv.Itoa(conf.Port), handler)
+	// kubernetes sends SIGTERM before killing a pod
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
 
+	if err := env.Serve(ctx); err != nil && err != http.ErrServerClosed {
+		logging.Fatal("server failed", err)
+	}
+	slog.Info("shut down")
 }
 
 func listNamespaces(svc *beaconclient.BeaconClient) {